@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/internal/buildoptions"
 	"github.com/tetratelabs/wazero/internal/engine/compiler"
 	"github.com/tetratelabs/wazero/internal/engine/interpreter"
 	"github.com/tetratelabs/wazero/internal/platform"
@@ -138,6 +139,14 @@ type RuntimeConfig interface {
 	//
 	// See https://www.w3.org/TR/2022/WD-wasm-core-2-20220419/
 	WithWasmCore2() RuntimeConfig
+
+	// WithMaxCallDepth sets the maximum height of the WebAssembly call stack. Calls exceeding this fail with the
+	// error "callstack overflow" instead of overflowing the Go runtime. This defaults to 2000, or what was set via
+	// `go build -ldflags` on buildoptions.CallStackCeiling. A zero value is invalid and ignored.
+	//
+	// Lower this to bound untrusted guests, or raise it for deeply recursive ones. Resuming a snapshot whose call
+	// frames exceed this limit fails the same way, before any module state is restored.
+	WithMaxCallDepth(uint32) RuntimeConfig
 }
 
 // NewRuntimeConfig returns a RuntimeConfig using the compiler if it is supported in this environment,
@@ -147,13 +156,15 @@ func NewRuntimeConfig() RuntimeConfig {
 }
 
 type runtimeConfig struct {
-	enabledFeatures wasm.Features
-	newEngine       func(wasm.Features) wasm.Engine
+	enabledFeatures  wasm.Features
+	newEngine        func(wasm.Features, int) wasm.Engine
+	callStackCeiling int
 }
 
 // engineLessConfig helps avoid copy/pasting the wrong defaults.
 var engineLessConfig = &runtimeConfig{
-	enabledFeatures: wasm.Features20191205,
+	enabledFeatures:  wasm.Features20191205,
+	callStackCeiling: buildoptions.CallStackCeiling,
 }
 
 // NewRuntimeConfigCompiler compiles WebAssembly modules into
@@ -256,6 +267,16 @@ func (c *runtimeConfig) WithWasmCore2() RuntimeConfig {
 	return ret
 }
 
+// WithMaxCallDepth implements RuntimeConfig.WithMaxCallDepth
+func (c *runtimeConfig) WithMaxCallDepth(maxCallDepth uint32) RuntimeConfig {
+	if maxCallDepth == 0 {
+		return c
+	}
+	ret := c.clone()
+	ret.callStackCeiling = int(maxCallDepth)
+	return ret
+}
+
 // CompiledModule is a WebAssembly 1.0 module ready to be instantiated (Runtime.InstantiateModule) as an api.Module.
 //
 // In WebAssembly terminology, this is a decoded, validated, and possibly also compiled module. wazero avoids using
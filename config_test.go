@@ -104,6 +104,22 @@ func TestRuntimeConfig(t *testing.T) {
 				enabledFeatures: wasm.FeatureSIMD,
 			},
 		},
+		{
+			name: "max-call-depth",
+			with: func(c RuntimeConfig) RuntimeConfig {
+				return c.WithMaxCallDepth(100)
+			},
+			expected: &runtimeConfig{
+				callStackCeiling: 100,
+			},
+		},
+		{
+			name: "max-call-depth zero ignored",
+			with: func(c RuntimeConfig) RuntimeConfig {
+				return c.WithMaxCallDepth(0)
+			},
+			expected: &runtimeConfig{},
+		},
 	}
 	for _, tt := range tests {
 		tc := tt
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/internal/testing/replay"
+)
+
+// loopsWasm was generated by the following:
+//
+//	cd testdata; wat2wasm loops.wat
+//
+//go:embed testdata/loops.wasm
+var loopsWasm []byte
+
+// main verifies that resuming a snapshot taken after any instruction of a call, once exported and read back, finishes
+// the call with the same result and memory as running it without snapshots.
+func main() {
+	ctx := context.Background()
+
+	// Snapshots are only supported by the interpreter.
+	r := wazero.NewRuntimeWithConfig(wazero.NewRuntimeConfigInterpreter())
+	defer r.Close(ctx) // This closes everything this Runtime created.
+
+	code, err := r.CompileModule(ctx, loopsWasm, wazero.NewCompileConfig())
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	// Read the count of outer loops to run.
+	n, err := strconv.ParseUint(os.Args[1], 10, 32)
+	if err != nil {
+		log.Panicf("invalid arg %v: %v", os.Args[1], err)
+	}
+
+	// Verify resuming after each instruction, until the call finishes before the next.
+	var instructions uint64
+	for ; ; instructions++ {
+		if err = replay.Verify(ctx, r, code, "run", instructions+1, n); errors.Is(err, replay.ErrFinished) {
+			break
+		} else if err != nil {
+			log.Panicln(err)
+		}
+	}
+	fmt.Printf("verified resuming after each of %d instructions\n", instructions)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/maintester"
+	"github.com/tetratelabs/wazero/internal/testing/require"
+)
+
+// Test_main ensures the following will work:
+//
+//	go run replay.go 2
+func Test_main(t *testing.T) {
+	stdout, _ := maintester.TestMain(t, main, "replay", "2")
+	// Only the summary is checked, as it is the last line printed.
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	require.Equal(t, "verified resuming after each of 126 instructions", lines[len(lines)-1])
+}
@@ -0,0 +1,40 @@
+(module
+  (memory 1 1)
+
+  ;; run adds $j to the memory at offset zero in an inner loop of 3 iterations, nested in an outer loop of $n, and
+  ;; returns $i*10+$j.
+  (func (export "run") (param $n i32) (result i32) (local $i i32) (local $j i32)
+    (loop $outer
+      local.get $i
+      i32.const 1
+      i32.add
+      local.set $i   ;; $i++
+      i32.const 0
+      local.set $j   ;; $j = 0
+      (loop $inner
+        i32.const 0
+        i32.const 0
+        i32.load
+        local.get $j
+        i32.add
+        i32.store    ;; mem[0] += $j
+        local.get $j
+        i32.const 1
+        i32.add
+        local.tee $j ;; $j++
+        i32.const 3
+        i32.lt_u
+        br_if $inner ;; $j < 3
+        local.get $i
+        local.get $n
+        i32.lt_u
+        br_if $outer ;; $i < $n, from within $inner
+      )
+    )
+    local.get $i
+    i32.const 10
+    i32.mul
+    local.get $j
+    i32.add
+  )
+)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"embed"
 	_ "embed"
+	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
@@ -11,8 +12,8 @@ import (
 	"os"
 
 	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/internal/engine/interpreter"
 	"github.com/tetratelabs/wazero/internal/wasm"
-	"github.com/tetratelabs/wazero/internal/wasmruntime"
 	"github.com/tetratelabs/wazero/wasi_snapshot_preview1"
 )
 
@@ -33,7 +34,6 @@ func main() {
 	ctx := context.Background()
 
 	snapshot := &wasm.Snapshot{}
-	ctx = context.WithValue(ctx, "snapshot", snapshot)
 
 	alwaysSnapshot := flag.Bool("always-snapshot", false, "snapshot after every wasm instruction")
 	traceOnly := flag.Bool("trace", false, "trace execution, do not trap")
@@ -42,9 +42,14 @@ func main() {
 	snapshotFile := flag.String("from-snapshot", "none", "path to resume execution from a snapshot binary file")
 	flag.Parse()
 
-	ctx = context.WithValue(ctx, "always_snapshot", *traceOnly || *alwaysSnapshot)
-	ctx = context.WithValue(ctx, "trap_after_snapshot", !*traceOnly)
-	ctx = context.WithValue(ctx, "export_snapshot", *exportSnapshot)
+	always := *traceOnly || *alwaysSnapshot
+	ctx = wasm.WithSnapshotOptions(ctx, wasm.SnapshotOptions{
+		Snapshot:    snapshot,
+		Always:      always,
+		Cooperative: !always,
+		TrapAfter:   !*traceOnly,
+		Export:      *exportSnapshot,
+	})
 
 	// Create a new WebAssembly Runtime.
 	r := wazero.NewRuntimeWithConfig(wazero.NewRuntimeConfigInterpreter().
@@ -81,32 +86,48 @@ func main() {
 
 loop:
 	for {
-		modCtx := context.WithValue(ctx, "trap_after_snapshot", false)
-		modCtx = context.WithValue(modCtx, "always_snapshot", false)
+		modOpts := wasm.SnapshotOptionsFromContext(ctx)
+		modOpts.TrapAfter, modOpts.Always, modOpts.Cooperative = false, false, true
+		modCtx := wasm.WithSnapshotOptions(ctx, modOpts)
 		module, err := r.InstantiateModule(modCtx, code, config.WithArgs(("wasi")))
 		if err != nil {
 			log.Panicln(err)
 		}
 
-		entry := module.ExportedFunction("entry").(*wasm.FunctionInstance)
+		// resume from whichever export the snapshot was taken in
+		name := "entry"
+		if snapshot.Valid {
+			name, err = module.(*wasm.CallContext).SnapshotFunctionName(snapshot)
+		}
 		if err != nil {
 			log.Panicln(err)
 		}
+		entry := module.ExportedFunction(name).(*wasm.FunctionInstance)
 
 		var results []uint64
 		if snapshot.Valid {
-			results, err = entry.Resume(ctx, snapshot)
+			if err = snapshot.Validate(entry.Module); err != nil {
+				log.Panicln(err)
+			}
+			var next *wasm.Snapshot
+			if results, next, err = entry.Resume(ctx, snapshot); next != nil {
+				snapshot = next
+			}
 		} else {
 			results, err = entry.Call(ctx)
 		}
 
 		// print iteration
-		switch err {
-		case wasmruntime.ErrRuntimeSnapshot:
-			log.Printf("snapshot: %v\n", snapshot)
-		case nil:
+		var snapshotErr *wasm.SnapshotError
+		switch {
+		case err == nil:
 			fmt.Printf("result: %d\n", results[0])
 			break loop
+		case errors.As(err, &snapshotErr):
+			log.Printf("snapshot: %v\n", snapshot)
+			if !snapshotErr.Resumable {
+				break loop
+			}
 		default:
 			log.Panicln(err)
 		}
@@ -116,5 +137,18 @@ loop:
 }
 
 func readSnapshot(snapshotFile string, res *wasm.Snapshot) {
-	// not implemented.
+	log.Println("reading snapshot")
+	in, err := os.Open(snapshotFile)
+	if err != nil {
+		log.Fatalln("Error reading file:", err)
+	}
+	defer in.Close()
+	snapshot, err := interpreter.ReadSnapshot(in)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// keep the options the caller set before execution, as they aren't part of the exported snapshot.
+	snapshot.Mode, snapshot.Granularity, snapshot.ExternRefCodec = res.Mode, res.Granularity, res.ExternRefCodec
+	*res = *snapshot
 }
@@ -3,16 +3,15 @@ package main
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"os"
 
 	"github.com/tetratelabs/wazero"
-	"github.com/tetratelabs/wazero/internal/proto"
+	"github.com/tetratelabs/wazero/internal/engine/interpreter"
 	"github.com/tetratelabs/wazero/internal/wasm"
-	"github.com/tetratelabs/wazero/internal/wasmruntime"
-	pb "google.golang.org/protobuf/proto"
 )
 
 // stackWasm was generated by the following:
@@ -24,7 +23,6 @@ func main() {
 	ctx := context.Background()
 
 	snapshot := &wasm.Snapshot{}
-	ctx = context.WithValue(ctx, "snapshot", snapshot)
 
 	alwaysSnapshot := flag.Bool("always-snapshot", false, "snapshot after every wasm instruction")
 	traceOnly := flag.Bool("trace", false, "trace execution, do not trap")
@@ -33,9 +31,14 @@ func main() {
 	snapshotFile := flag.String("from-snapshot", "none", "path to resume execution from a snapshot binary file")
 	flag.Parse()
 
-	ctx = context.WithValue(ctx, "always_snapshot", *traceOnly || *alwaysSnapshot)
-	ctx = context.WithValue(ctx, "trap_after_snapshot", !*traceOnly)
-	ctx = context.WithValue(ctx, "export_snapshot", *exportSnapshot)
+	always := *traceOnly || *alwaysSnapshot
+	ctx = wasm.WithSnapshotOptions(ctx, wasm.SnapshotOptions{
+		Snapshot:    snapshot,
+		Always:      always,
+		Cooperative: !always,
+		TrapAfter:   !*traceOnly,
+		Export:      *exportSnapshot,
+	})
 
 	r := wazero.NewRuntimeWithConfig(wazero.NewRuntimeConfigInterpreter())
 	defer r.Close(ctx) // This closes everything this Runtime created.
@@ -49,103 +52,72 @@ func main() {
 		readSnapshot(*snapshotFile, snapshot)
 	}
 
+	// load module once: resuming resets it to each snapshot in place, so it needn't be instantiated again.
+	module, err := r.InstantiateModuleFromBinary(ctx, stackWasm)
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer module.Close(ctx)
+
 loop:
 	for {
-		// load module
-		module, err := r.InstantiateModuleFromBinary(ctx, stackWasm)
-		if err != nil {
-			log.Panicln(err)
+		// load function, resuming from whichever export the snapshot was taken in
+		name := "entry"
+		if snapshot.Valid {
+			if name, err = module.(*wasm.CallContext).SnapshotFunctionName(snapshot); err != nil {
+				log.Panicln(err)
+			}
 		}
-
-		// load function
-		add := module.ExportedFunction("entry").(*wasm.FunctionInstance)
+		add := module.ExportedFunction(name).(*wasm.FunctionInstance)
 
 		// execute
 		var results []uint64
 		if snapshot.Valid {
-			results, err = add.Resume(ctx, snapshot)
+			if err = snapshot.Validate(add.Module); err != nil {
+				log.Panicln(err)
+			}
+			var next *wasm.Snapshot
+			if results, next, err = add.Resume(ctx, snapshot); next != nil {
+				snapshot = next
+			}
 		} else {
 			results, err = add.Call(ctx, x, y)
 		}
 
-		// close module
-		module.Close(ctx)
-
 		// print iteration
-		switch err {
-		case wasmruntime.ErrRuntimeSnapshot:
-			//log.Printf("snapshot: %v\n", snapshot)
-		case nil:
+		var snapshotErr *wasm.SnapshotError
+		switch {
+		case err == nil:
 			fmt.Printf("result: %d\n", results[0])
 			break loop
+		case errors.As(err, &snapshotErr):
+			// stop once resuming wouldn't execute anything, or when asked to halt after the snapshot.
+			if !snapshotErr.Resumable || *haltAfterSnapshot {
+				break loop
+			}
 		default:
 			log.Panicln(err)
 		}
-
-		if *haltAfterSnapshot {
-			break loop
-		}
 	}
 
 }
 
 func readSnapshot(snapshotFile string, res *wasm.Snapshot) {
 	log.Println("reading snapshot")
-	in, err := ioutil.ReadFile(snapshotFile)
+	in, err := os.Open(snapshotFile)
 	if err != nil {
 		log.Fatalln("Error reading file:", err)
 	}
-	snapshotPb := &proto.Snapshot{}
-	if err := pb.Unmarshal(in, snapshotPb); err != nil {
-		log.Fatalln("Failed to parse snapshot:", err)
-	}
-
-	res.Valid = true
-	res.Stack = snapshotPb.GetStack()
-
-	// Globals
-	res.Globals = nil
-	for _, global := range snapshotPb.GetGlobals() {
-		globalInstance := &wasm.GlobalInstance{
-			Val:   global.GetValue(),
-			ValHi: global.GetValHi(),
-		}
-		globalType := &wasm.GlobalType{
-			Mutable: global.GetMutable(),
-		}
-		switch global.Type {
-		case proto.ValueType_I32:
-			globalType.ValType = wasm.ValueTypeI32
-		case proto.ValueType_I64:
-			globalType.ValType = wasm.ValueTypeI64
-		case proto.ValueType_F32:
-			globalType.ValType = wasm.ValueTypeF32
-		case proto.ValueType_F64:
-			globalType.ValType = wasm.ValueTypeF64
-		case proto.ValueType_V128:
-			globalType.ValType = wasm.ValueTypeV128
-		case proto.ValueType_FuncRef:
-			globalType.ValType = wasm.ValueTypeFuncref
-		case proto.ValueType_ExternRef:
-			globalType.ValType = wasm.ValueTypeExternref
-		}
-		globalInstance.Type = globalType
-		res.Globals = append(res.Globals, globalInstance)
+	defer in.Close()
+	snapshot, err := interpreter.ReadSnapshot(in)
+	if err != nil {
+		log.Fatalln(err)
 	}
 
-	res.Frames = nil
-	for _, frame := range snapshotPb.GetFrames() {
-		callFrame := wasm.CallFrame{
-			Pc:          frame.Pc,
-			FunctionIdx: frame.FunctionIndex,
-		}
-		res.Frames = append(res.Frames, callFrame)
-	}
+	// the snapshot read is left as is by resuming it, so it could be resumed again, e.g. to retry. Those taken while
+	// running share the module's state instead, so Clone one of those first.
 
-	res.Memory = &wasm.MemoryInstance{
-		Buffer: snapshotPb.GetMemory().GetBuffer(),
-		Min:    snapshotPb.GetMemory().GetMin(),
-		Max:    snapshotPb.GetMemory().GetMax(),
-		Cap:    snapshotPb.GetMemory().GetCap(),
-	}
+	// keep the options the caller set before execution, as they aren't part of the exported snapshot.
+	snapshot.Mode, snapshot.Granularity, snapshot.ExternRefCodec = res.Mode, res.Granularity, res.ExternRefCodec
+	*res = *snapshot
 }
@@ -0,0 +1,40 @@
+package experimental
+
+import (
+	"context"
+	"time"
+)
+
+// SnapshotObserverKey is a context.Context Value key. Its associated value should be a SnapshotObserver.
+//
+// Note: This is interpreter-only for now!
+type SnapshotObserverKey struct{}
+
+// SnapshotObserver is notified synchronously each time the engine takes a snapshot, before control returns to the
+// guest or, when trapping, before ErrRuntimeSnapshot is raised.
+//
+// Note: The observer must not block for long, as guest execution is paused while it runs.
+type SnapshotObserver interface {
+	// OnSnapshot is invoked with metadata about the snapshot that was just taken. ctx is the context of the call that
+	// took the snapshot.
+	OnSnapshot(ctx context.Context, event SnapshotEvent)
+}
+
+// SnapshotEvent describes a snapshot taken by the engine. It only holds copies of metadata, so the snapshot itself
+// cannot be mutated through it.
+type SnapshotEvent struct {
+	// InstructionCount is the number of instructions executed by the current call up to the snapshot.
+	InstructionCount uint64
+
+	// Time is the wall-clock time the snapshot was taken.
+	Time time.Time
+
+	// FunctionIndex is the index of the function in the top call frame, in the module's function index namespace.
+	FunctionIndex uint32
+
+	// Pc is the program counter of the top call frame.
+	Pc uint64
+
+	// Size is the length in bytes of the snapshot when encoded, e.g. by wasm.SnapshotOptions Export.
+	Size int
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"unsafe"
 
+	"github.com/tetratelabs/wazero/internal/buildoptions"
 	"github.com/tetratelabs/wazero/internal/platform"
 	"github.com/tetratelabs/wazero/internal/testing/require"
 	"github.com/tetratelabs/wazero/internal/wasm"
@@ -183,7 +184,7 @@ type compilerImpl interface {
 const defaultMemoryPageNumInTest = 1
 
 func newCompilerEnvironment() *compilerEnv {
-	me := &moduleEngine{}
+	me := &moduleEngine{callStackCeiling: uint64(buildoptions.CallStackCeiling)}
 	return &compilerEnv{
 		me: me,
 		moduleInstance: &wasm.ModuleInstance{
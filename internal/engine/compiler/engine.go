@@ -25,6 +25,8 @@ type (
 		mux             sync.RWMutex
 		// setFinalizer defaults to runtime.SetFinalizer, but overridable for tests.
 		setFinalizer func(obj interface{}, finalizer interface{})
+		// callStackCeiling is the maximum call stack height of a callEngine created by this engine.
+		callStackCeiling uint64
 	}
 
 	// moduleEngine implements wasm.ModuleEngine
@@ -39,6 +41,9 @@ type (
 		functions []*function
 
 		importedFunctionCount uint32
+
+		// callStackCeiling is inherited from the engine which created this.
+		callStackCeiling uint64
 	}
 
 	// callEngine holds context per moduleEngine.Call, and shared across all the
@@ -63,6 +68,9 @@ type (
 		// The currently executed function call frame lives at callFrameStack[callFrameStackPointer-1]
 		// and that is equivalent to  engine.callFrameTop().
 		callFrameStack []callFrame
+
+		// callStackCeiling is the maximum length of callFrameStack before ErrRuntimeCallStackOverflow is raised.
+		callStackCeiling uint64
 	}
 
 	// globalContext holds the data which is constant across multiple function calls.
@@ -433,7 +441,7 @@ func (e *engine) CompileModule(ctx context.Context, module *wasm.Module) error {
 			funcs = append(funcs, compiled)
 		}
 	} else {
-		irs, err := wazeroir.CompileFunctions(ctx, e.enabledFeatures, module)
+		irs, err := wazeroir.CompileFunctions(ctx, e.enabledFeatures, module, false)
 		if err != nil {
 			return err
 		}
@@ -464,6 +472,7 @@ func (e *engine) NewModuleEngine(name string, module *wasm.Module, importedFunct
 		name:                  name,
 		functions:             make([]*function, 0, imported+uint32(len(moduleFunctions))),
 		importedFunctionCount: imported,
+		callStackCeiling:      e.callStackCeiling,
 	}
 
 	for _, f := range importedFunctions {
@@ -551,6 +560,11 @@ func (e *moduleEngine) InitializeFuncrefGlobals(globals []*wasm.GlobalInstance)
 
 // Call implements the same method as documented on wasm.ModuleEngine.
 func (e *moduleEngine) Call(ctx context.Context, callCtx *wasm.CallContext, f *wasm.FunctionInstance, params ...uint64) (results []uint64, err error) {
+	// Fail loudly, as the caller would otherwise never see the snapshot it expects.
+	if ctx != nil && wasm.SnapshotConfigured(ctx) {
+		return nil, wasm.ErrSnapshotUnsupported
+	}
+
 	// Note: The input parameters are pre-validated, so a compiled function is only absent on close. Updates to
 	// code on close aren't locked, neither is this read.
 	compiled := e.functions[f.Idx]
@@ -606,19 +620,30 @@ func (e *moduleEngine) Call(ctx context.Context, callCtx *wasm.CallContext, f *w
 	return
 }
 
-func (e *moduleEngine) Resume(ctx context.Context, m *wasm.CallContext, f *wasm.FunctionInstance, snapshot *wasm.Snapshot) (results []uint64, err error) {
-	panic("Resume not implemented in compiler")
+// Resume implements the same method as documented on wasm.ModuleEngine.
+//
+// Note: This always returns wasm.ErrSnapshotUnsupported as native frames cannot yet be restored from a wasm.Snapshot.
+func (e *moduleEngine) Resume(context.Context, *wasm.CallContext, *wasm.FunctionInstance, *wasm.Snapshot) ([]uint64, error) {
+	return nil, wasm.ErrSnapshotUnsupported
 }
 
-func NewEngine(enabledFeatures wasm.Features) wasm.Engine {
-	return newEngine(enabledFeatures)
+// ValidateSnapshot implements the same method as documented on wasm.ModuleEngine.
+func (e *moduleEngine) ValidateSnapshot(*wasm.Snapshot) error {
+	return wasm.ErrSnapshotUnsupported
 }
 
-func newEngine(enabledFeatures wasm.Features) *engine {
+// NewEngine returns a compiler implementation of wasm.Engine. callStackCeiling is the maximum call depth before
+// wasmruntime.ErrRuntimeCallStackOverflow is raised, usually buildoptions.CallStackCeiling.
+func NewEngine(enabledFeatures wasm.Features, callStackCeiling int) wasm.Engine {
+	return newEngine(enabledFeatures, callStackCeiling)
+}
+
+func newEngine(enabledFeatures wasm.Features, callStackCeiling int) *engine {
 	return &engine{
-		enabledFeatures: enabledFeatures,
-		codes:           map[wasm.ModuleID][]*code{},
-		setFinalizer:    runtime.SetFinalizer,
+		enabledFeatures:  enabledFeatures,
+		codes:            map[wasm.ModuleID][]*code{},
+		setFinalizer:     runtime.SetFinalizer,
+		callStackCeiling: uint64(callStackCeiling),
 	}
 }
 
@@ -659,6 +684,8 @@ func (e *moduleEngine) newCallEngine() *callEngine {
 		valueStack:     make([]uint64, initialValueStackSize),
 		callFrameStack: make([]callFrame, initialCallFrameStackSize),
 		archContext:    newArchContext(),
+
+		callStackCeiling: e.callStackCeiling,
 	}
 
 	valueStackHeader := (*reflect.SliceHeader)(unsafe.Pointer(&ce.valueStack))
@@ -781,10 +808,8 @@ func (ce *callEngine) builtinFunctionGrowValueStack(stackPointerCeil uint64) {
 	ce.globalContext.valueStackLen = uint64(valueStackHeader.Len)
 }
 
-var callStackCeiling = uint64(buildoptions.CallStackCeiling)
-
 func (ce *callEngine) builtinFunctionGrowCallFrameStack() {
-	if callStackCeiling < uint64(len(ce.callFrameStack)+1) {
+	if ce.callStackCeiling < uint64(len(ce.callFrameStack)+1) {
 		panic(wasmruntime.ErrRuntimeCallStackOverflow)
 	}
 
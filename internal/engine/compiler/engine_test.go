@@ -8,6 +8,7 @@ import (
 	"testing"
 	"unsafe"
 
+	"github.com/tetratelabs/wazero/internal/buildoptions"
 	"github.com/tetratelabs/wazero/internal/platform"
 	"github.com/tetratelabs/wazero/internal/testing/enginetest"
 	"github.com/tetratelabs/wazero/internal/testing/require"
@@ -114,7 +115,7 @@ type engineTester struct{}
 
 // NewEngine implements enginetest.EngineTester NewEngine.
 func (e *engineTester) NewEngine(enabledFeatures wasm.Features) wasm.Engine {
-	return newEngine(enabledFeatures)
+	return newEngine(enabledFeatures, buildoptions.CallStackCeiling)
 }
 
 // InitTables implements enginetest.EngineTester InitTables.
@@ -169,6 +170,22 @@ func TestCompiler_ModuleEngine_Call_Errors(t *testing.T) {
 	enginetest.RunTestModuleEngine_Call_Errors(t, et)
 }
 
+func TestCompiler_ModuleEngine_SnapshotUnsupported(t *testing.T) {
+	me := &moduleEngine{}
+	f := &wasm.FunctionInstance{}
+
+	_, err := me.Resume(testCtx, &wasm.CallContext{}, f, &wasm.Snapshot{Valid: true})
+	require.ErrorIs(t, err, wasm.ErrSnapshotUnsupported)
+
+	for _, ctx := range []context.Context{
+		context.WithValue(testCtx, "snapshot", &wasm.Snapshot{}),
+		context.WithValue(testCtx, "snapshot_on_trap", true),
+	} {
+		_, err = me.Call(ctx, &wasm.CallContext{}, f)
+		require.ErrorIs(t, err, wasm.ErrSnapshotUnsupported)
+	}
+}
+
 func TestCompiler_ModuleEngine_Memory(t *testing.T) {
 	requireSupportedOSArch(t)
 	enginetest.RunTestModuleEngine_Memory(t, et)
@@ -265,7 +282,7 @@ func TestCompiler_Releasecode_Panic(t *testing.T) {
 // See comments on initialValueStackSize and initialCallFrameStackSize.
 func TestCompiler_SliceAllocatedOnHeap(t *testing.T) {
 	enabledFeatures := wasm.Features20191205
-	e := newEngine(enabledFeatures)
+	e := newEngine(enabledFeatures, buildoptions.CallStackCeiling)
 	s, ns := wasm.NewStore(enabledFeatures, e)
 
 	const hostModuleName = "env"
@@ -363,7 +380,7 @@ func TestCompiler_SliceAllocatedOnHeap(t *testing.T) {
 
 // TODO: move most of this logic to enginetest.go so that there is less drift between interpreter and compiler
 func TestEngine_Cachedcodes(t *testing.T) {
-	e := newEngine(wasm.Features20191205)
+	e := newEngine(wasm.Features20191205, buildoptions.CallStackCeiling)
 	exp := []*code{
 		{codeSegment: []byte{0x0}},
 		{codeSegment: []byte{0x0}},
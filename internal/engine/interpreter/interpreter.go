@@ -3,19 +3,24 @@ package interpreter
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"io/fs"
 	"log"
 	"math"
 	"math/bits"
+	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/tetratelabs/wazero/api"
 	"github.com/tetratelabs/wazero/experimental"
-	"github.com/tetratelabs/wazero/internal/buildoptions"
 	"github.com/tetratelabs/wazero/internal/moremath"
 	"github.com/tetratelabs/wazero/internal/proto"
 	"github.com/tetratelabs/wazero/internal/sys"
@@ -23,23 +28,24 @@ import (
 	"github.com/tetratelabs/wazero/internal/wasmdebug"
 	"github.com/tetratelabs/wazero/internal/wasmruntime"
 	"github.com/tetratelabs/wazero/internal/wazeroir"
-
-	pb "google.golang.org/protobuf/proto"
 )
 
-var callStackCeiling = buildoptions.CallStackCeiling
-
 // engine is an interpreter implementation of wasm.Engine
 type engine struct {
 	enabledFeatures wasm.Features
 	codes           map[wasm.ModuleID][]*code // guarded by mutex.
 	mux             sync.RWMutex
+	// callStackCeiling is the maximum call stack height of a callEngine created by this engine.
+	callStackCeiling int
 }
 
-func NewEngine(enabledFeatures wasm.Features) wasm.Engine {
+// NewEngine returns an interpreter implementation of wasm.Engine. callStackCeiling is the maximum call depth before
+// wasmruntime.ErrRuntimeCallStackOverflow is raised, usually buildoptions.CallStackCeiling.
+func NewEngine(enabledFeatures wasm.Features, callStackCeiling int) wasm.Engine {
 	return &engine{
-		enabledFeatures: enabledFeatures,
-		codes:           map[wasm.ModuleID][]*code{},
+		enabledFeatures:  enabledFeatures,
+		codes:            map[wasm.ModuleID][]*code{},
+		callStackCeiling: callStackCeiling,
 	}
 }
 
@@ -95,10 +101,20 @@ type callEngine struct {
 
 	// frames are the function call stack.
 	frames []*callFrame
+
+	// instructionCount is the number of operations executed so far by this call engine.
+	instructionCount uint64
+
+	// callStackCeiling is the maximum length of frames before ErrRuntimeCallStackOverflow is raised.
+	callStackCeiling int
+
+	// callDepth is the counter of wasm.ModuleInstance CallDepthCounter of the module called, which is kept equal to
+	// the length of frames. It is nil until the call starts.
+	callDepth *int64
 }
 
 func (e *moduleEngine) newCallEngine() *callEngine {
-	return &callEngine{}
+	return &callEngine{callStackCeiling: e.parentEngine.callStackCeiling}
 }
 
 func (ce *callEngine) pushValue(v uint64) {
@@ -157,10 +173,13 @@ func (ce *callEngine) drop(r *wazeroir.InclusiveRange) {
 }
 
 func (ce *callEngine) pushFrame(frame *callFrame) {
-	if callStackCeiling <= len(ce.frames) {
+	if ce.callStackCeiling <= len(ce.frames) {
 		panic(wasmruntime.ErrRuntimeCallStackOverflow)
 	}
 	ce.frames = append(ce.frames, frame)
+	if ce.callDepth != nil {
+		atomic.AddInt64(ce.callDepth, 1)
+	}
 }
 
 func (ce *callEngine) popFrame() (frame *callFrame) {
@@ -169,9 +188,20 @@ func (ce *callEngine) popFrame() (frame *callFrame) {
 	oneLess := len(ce.frames) - 1
 	frame = ce.frames[oneLess]
 	ce.frames = ce.frames[:oneLess]
+	if ce.callDepth != nil {
+		atomic.AddInt64(ce.callDepth, -1)
+	}
 	return
 }
 
+// releaseCallDepth removes the frames left when the call ended, e.g. on a snapshot, from the callDepth counter.
+func (ce *callEngine) releaseCallDepth() {
+	if ce.callDepth != nil {
+		atomic.AddInt64(ce.callDepth, -int64(len(ce.frames)))
+		ce.callDepth = nil
+	}
+}
+
 func (ce *callEngine) peekFrame() (frame *callFrame) {
 	if len(ce.frames) == 0 {
 		log.Panicln("cannot peek empty stack")
@@ -189,12 +219,16 @@ type callFrame struct {
 type code struct {
 	body   []*interpreterOp
 	hostFn *reflect.Value
+	// stackTypes maps the body pc at which a snapshot can be taken to the types of the values on the stack of the
+	// function. See wazeroir.CompilationResult StackTypes
+	stackTypes map[uint64][]wazeroir.UnsignedType
 }
 
 type function struct {
-	source *wasm.FunctionInstance
-	body   []*interpreterOp
-	hostFn *reflect.Value
+	source     *wasm.FunctionInstance
+	body       []*interpreterOp
+	hostFn     *reflect.Value
+	stackTypes map[uint64][]wazeroir.UnsignedType
 }
 
 // functionFromUintptr resurrects the original *function from the given uintptr
@@ -211,9 +245,10 @@ func functionFromUintptr(ptr uintptr) *function {
 
 func (c *code) instantiate(f *wasm.FunctionInstance) *function {
 	return &function{
-		source: f,
-		body:   c.body,
-		hostFn: c.hostFn,
+		source:     f,
+		body:       c.body,
+		hostFn:     c.hostFn,
+		stackTypes: c.stackTypes,
 	}
 }
 
@@ -248,7 +283,7 @@ func (e *engine) CompileModule(ctx context.Context, module *wasm.Module) error {
 			funcs = append(funcs, &code{hostFn: hf})
 		}
 	} else {
-		irs, err := wazeroir.CompileFunctions(ctx, e.enabledFeatures, module)
+		irs, err := wazeroir.CompileFunctions(ctx, e.enabledFeatures, module, true)
 		if err != nil {
 			return err
 		}
@@ -311,7 +346,17 @@ func (e *engine) lowerIR(ir *wazeroir.CompilationResult) (*code, error) {
 	ret := &code{}
 	labelAddress := map[string]uint64{}
 	onLabelAddressResolved := map[string][]func(addr uint64){}
-	for _, original := range ops {
+	for i, original := range ops {
+		if stackTypes, ok := ir.StackTypes[i]; ok {
+			pc := uint64(len(ret.body))
+			if original.Kind() == wazeroir.OperationKindNop {
+				pc++ // The snapshot is taken after the nop, which doesn't change the stack.
+			}
+			if ret.stackTypes == nil {
+				ret.stackTypes = map[uint64][]wazeroir.UnsignedType{}
+			}
+			ret.stackTypes[pc] = stackTypes
+		}
 		op := &interpreterOp{kind: original.Kind()}
 		switch o := original.(type) {
 		case *wazeroir.OperationUnreachable:
@@ -778,61 +823,314 @@ func (e *moduleEngine) InitializeFuncrefGlobals(globals []*wasm.GlobalInstance)
 	}
 }
 
-func makeSnapshot(ctx context.Context, fsContext *sys.FSContext, ce *callEngine, moduleInst *wasm.ModuleInstance) {
-	snapshot := ctx.Value("snapshot").(*wasm.Snapshot)
-	snapshot.Valid = true
+func makeSnapshot(ctx context.Context, callCtx *wasm.CallContext, ce *callEngine, moduleInst *wasm.ModuleInstance, reason wasm.SnapshotReason) {
+	opts := wasm.SnapshotOptionsFromContext(ctx)
+	snapshot := opts.Snapshot
+	snapshot.Valid, snapshot.Err = true, nil
+	snapshot.Reason = reason
+	snapshot.YieldTag = 0
+	if reason == wasm.SnapshotReasonYield {
+		snapshot.YieldTag = uint32(ce.stack[len(ce.stack)-1]) // the arg of the call to yield.
+	}
+	snapshot.InstructionCount = ce.instructionCount
 
 	snapshot.Frames = nil
-	frameCount := len(ce.frames)
-	for i := 0; i < frameCount; i++ {
-		frame := ce.frames[i]
-		callFrame := wasm.CallFrame{
-			Pc:          frame.pc,
-			FunctionIdx: frame.f.source.Idx,
+	snapshot.Stack = nil
+	snapshot.StackTypes = nil
+	if snapshot.Mode == wasm.SnapshotModeFull {
+		frameCount := len(ce.frames)
+		for i := 0; i < frameCount; i++ {
+			frame := ce.frames[i]
+			snapshot.Frames = append(snapshot.Frames, wasm.NewCallFrame(frame.f.source.Idx, frame.pc))
 		}
-		snapshot.Frames = append(snapshot.Frames, callFrame)
+		snapshot.Stack = ce.stack
+		snapshot.StackTypes = stackTypes(ce.frames)
 	}
 
-	snapshot.Stack = ce.stack
 	snapshot.Globals = moduleInst.Globals
-	snapshot.Memory = moduleInst.Memory
-
-	snapshot.LastFD = fsContext.GetLastFD()
-	snapshot.OpenedFiles = fsContext.GetOpenedFiles()
+	if err := snapshot.EncodeExternRefs(); err != nil {
+		// The embedder's codec failed, so record why rather than crash, and neither export nor observe the snapshot.
+		snapshot.Valid, snapshot.Err = false, err
+		return
+	}
+	snapshot.Memory = nil
+	if mem := moduleInst.Memory; mem != nil {
+		snapshot.ScrubMemory(ctx, callCtx, mem)
+		// Min records the current size, which exceeds the declared minimum once the guest grew the memory.
+		snapshot.Memory = &wasm.MemoryInstance{Buffer: mem.Buffer, Min: mem.PageSize(ctx), Cap: mem.Cap, Max: mem.Max, Shared: mem.Shared}
+	}
+	snapshot.Closed = callCtx.ClosedState()
+	snapshot.Generation = callCtx.Generation()
+
+	snapshot.Args, snapshot.Environ = nil, nil
+	snapshot.StdoutWritten, snapshot.StderrWritten, snapshot.StdinRead = 0, 0, 0
+	snapshot.RandRead, snapshot.Walltime, snapshot.Nanotime = 0, 0, 0
+	if callCtx.Sys != nil {
+		// Never nil once captured, even if empty, so that resuming restores them.
+		snapshot.Args = append([]string{}, callCtx.Sys.Args()...)
+		snapshot.Environ = append([]string{}, callCtx.Sys.Environ()...)
+		snapshot.StdoutWritten, snapshot.StderrWritten = callCtx.Sys.Written()
+		snapshot.StdinRead = callCtx.Sys.StdinRead()
+		snapshot.RandRead = callCtx.Sys.RandRead()
+		snapshot.Walltime, snapshot.Nanotime = callCtx.Sys.Clocks()
+		if fsContext := callCtx.Sys.FS(ctx); fsContext != nil {
+			snapshot.LastFD = fsContext.GetLastFD()
+			// Copy the entries, as closing the module removes them from its map.
+			openedFiles := fsContext.GetOpenedFiles()
+			snapshot.OpenedFiles = make(map[uint32]*sys.FileEntry, len(openedFiles))
+			for fd, entry := range openedFiles {
+				e := *entry
+				if e.File != nil {
+					e.Offset = sys.FileOffset(e.File)
+				}
+				snapshot.OpenedFiles[fd] = &e
+			}
+		}
+	}
 
 	fmt.Printf("snapshot: %v\n", snapshot)
 
-	if ctx.Value("export_snapshot").(bool) {
-		exportSnapshot(ctx)
+	if opts.Export {
+		if err := exportSnapshot(snapshot); err != nil {
+			// Record why rather than exit the host, and don't observe the snapshot, as with ExternRefCodec above.
+			snapshot.Valid, snapshot.Err = false, err
+			return
+		}
+		callCtx.SetGeneration(snapshot.Generation)
 		log.Println("exported snapshot")
 	}
 
+	if observer, ok := ctx.Value(experimental.SnapshotObserverKey{}).(experimental.SnapshotObserver); ok {
+		observer.OnSnapshot(ctx, newSnapshotEvent(ce, snapshot))
+	}
 }
 
-func exportSnapshot(ctx context.Context) {
-	snapshot := ctx.Value("snapshot").(*wasm.Snapshot)
-	globalsPb := []*proto.Global{}
-	for _, global := range snapshot.Globals {
-		globalType := proto.ValueType_I32
-		switch global.Type.ValType {
-		case wasm.ValueTypeI32:
-			globalType = proto.ValueType_I32
-		case wasm.ValueTypeI64:
-			globalType = proto.ValueType_I64
-		case wasm.ValueTypeF32:
-			globalType = proto.ValueType_F32
-		case wasm.ValueTypeF64:
-			globalType = proto.ValueType_F64
-		case 0x7b:
-			globalType = proto.ValueType_V128
-		case 0x70:
-			globalType = proto.ValueType_FuncRef
-		case api.ValueTypeExternref:
-			globalType = proto.ValueType_ExternRef
+// snapshotTrap returns a snapshot of the state at the trap recovered as v, if wasm.SnapshotOptions OnTrap is set in
+// ctx, or nil. It must be called before the frames are popped.
+func (ce *callEngine) snapshotTrap(ctx context.Context, callCtx *wasm.CallContext, moduleInst *wasm.ModuleInstance, v interface{}) *wasm.Snapshot {
+	opts := wasm.SnapshotOptionsFromContext(ctx)
+	if !opts.OnTrap {
+		return nil
+	}
+	if _, ok := v.(*wasmruntime.Error); !ok {
+		return nil // not a trap, e.g. a host function panic or context cancellation.
+	}
+	snapshot := &wasm.Snapshot{}
+	// Neither overwrite the snapshot of the call, if any, nor export this one.
+	opts.Snapshot, opts.Export = snapshot, false
+	makeSnapshot(wasm.WithSnapshotOptions(ctx, opts), callCtx, ce, moduleInst, wasm.SnapshotReasonTrap)
+	return snapshot
+}
+
+// yieldSignature is the signature of wasm.Yield.
+var yieldSignature = &wasm.FunctionType{Params: []wasm.ValueType{wasm.ValueTypeI32}, Results: []wasm.ValueType{wasm.ValueTypeI32}}
+
+// yield takes the snapshot for the call to wasm.Yield that panicked with signal, returning false if it can't, e.g. as
+// no snapshot is configured. It must be called before the frames are popped.
+//
+// The snapshot is of the state before the call, as if interrupted there, so its arg is pushed back. Resuming replaces
+// it with the Snapshot.ResumeValue and continues after the call.
+func (ce *callEngine) yield(ctx context.Context, callCtx *wasm.CallContext, moduleInst *wasm.ModuleInstance, signal *wasm.YieldSignal) bool {
+	if wasm.SnapshotOptionsFromContext(ctx).Snapshot == nil || len(ce.frames) < 2 {
+		return false
+	}
+	if f := ce.peekFrame().f; f.hostFn == nil || !f.source.Type.EqualsSignature(yieldSignature.Params, yieldSignature.Results) {
+		return false
+	}
+	ce.popFrame()
+	ce.pushValue(uint64(signal.Tag))
+	makeSnapshot(ctx, callCtx, ce, moduleInst, wasm.SnapshotReasonYield)
+	return true
+}
+
+// stackTypes returns the types of the values on the stack of the given frames, or nil if they aren't known at the pc
+// of any frame. The pc of the top frame is where the snapshot was taken, and that of the others is at a call.
+func stackTypes(frames []*callFrame) (ret []api.ValueType) {
+	if len(frames) == 0 {
+		return nil // after the outermost function returned, the stack holds its results, whose types aren't known here.
+	}
+	for i, frame := range frames {
+		types, ok := frame.f.stackTypes[frame.pc]
+		if !ok {
+			return nil
+		}
+		if i < len(frames)-1 {
+			// The caller doesn't hold the args of the call: they are the params of the callee.
+			argCount := len(frames[i+1].f.source.Type.Params)
+			if frame.f.body[frame.pc].kind == wazeroir.OperationKindCallIndirect {
+				argCount++ // the table offset is popped before the call.
+			}
+			if argCount > len(types) {
+				return nil
+			}
+			types = types[:len(types)-argCount]
 		}
+		for _, t := range types {
+			ret = append(ret, unsignedTypeToValueType(t))
+		}
+	}
+	if ret == nil {
+		ret = []api.ValueType{}
+	}
+	return
+}
+
+func unsignedTypeToValueType(t wazeroir.UnsignedType) api.ValueType {
+	switch t {
+	case wazeroir.UnsignedTypeI32:
+		return api.ValueTypeI32
+	case wazeroir.UnsignedTypeF32:
+		return api.ValueTypeF32
+	case wazeroir.UnsignedTypeF64:
+		return api.ValueTypeF64
+	case wazeroir.UnsignedTypeV128:
+		return wasm.ValueTypeV128
+	default: // Reference types are opaque 64-bit pointers.
+		return api.ValueTypeI64
+	}
+}
 
+// ValidateSnapshot implements the same method as documented on wasm.ModuleEngine.
+//
+// Note: The top frame's pc can be the end of its function, e.g. when it was taken on return from a call. The pc of each
+// other frame must be at a call. No frame can be in a host function.
+func (e *moduleEngine) ValidateSnapshot(snapshot *wasm.Snapshot) error {
+	last := len(snapshot.Frames) - 1
+	for i, frame := range snapshot.Frames {
+		if err := frame.ValidateFunctionIndex(len(e.functions)); err != nil {
+			return fmt.Errorf("snapshot frame[%d] %w", i, err)
+		}
+		f := e.functions[frame.FunctionIdx]
+		if f.hostFn != nil {
+			// Snapshots are only taken between operations of wasm functions, so a host function, such as poll_oneoff
+			// with its pending subscriptions, is never in the middle of executing. Resuming one would call it again.
+			return fmt.Errorf("snapshot frame[%d] is in host function %s, whose state isn't captured", i, f.source.DebugName)
+		}
+		if err := frame.ValidatePc(uint64(len(f.body)), i == last, func(pc uint64) bool {
+			return isCall(f.body[pc].kind)
+		}); err != nil {
+			return fmt.Errorf("snapshot frame[%d] %w", i, err)
+		}
+	}
+	if snapshot.StackTypes != nil {
+		return e.validateStackTypes(snapshot)
+	}
+	return nil
+}
+
+// isCall returns true if the operation calls a function.
+func isCall(kind wazeroir.OperationKind) bool {
+	return kind == wazeroir.OperationKindCall || kind == wazeroir.OperationKindCallIndirect
+}
+
+// validateStackTypes returns an error unless the types of the snapshot stack match those known at the frames it
+// resumes, in the functions of the module engine.
+func (e *moduleEngine) validateStackTypes(snapshot *wasm.Snapshot) error {
+	words := 0
+	for _, t := range snapshot.StackTypes {
+		if t == wasm.ValueTypeV128 {
+			words += 2
+		} else {
+			words++
+		}
+	}
+	if words != len(snapshot.Stack) {
+		return fmt.Errorf("snapshot stack has %d values, but its types need %d", len(snapshot.Stack), words)
+	}
+
+	frames := make([]*callFrame, 0, len(snapshot.Frames))
+	for _, frame := range snapshot.Frames {
+		if int(frame.FunctionIdx) >= len(e.functions) {
+			return fmt.Errorf("snapshot frame has function index %d, but there are %d functions",
+				frame.FunctionIdx, len(e.functions))
+		}
+		frames = append(frames, &callFrame{f: e.functions[frame.FunctionIdx], pc: frame.Pc})
+	}
+	expected := stackTypes(frames)
+	if expected == nil {
+		return errors.New("snapshot stack types are unknown at its frames")
+	}
+	if len(expected) != len(snapshot.StackTypes) {
+		return fmt.Errorf("snapshot has %d stack types, but its frames have %d", len(snapshot.StackTypes), len(expected))
+	}
+	for i, t := range expected {
+		if snapshot.StackTypes[i] != t {
+			return fmt.Errorf("snapshot stack type[%d] is %s, but %s at its frames",
+				i, wasm.ValueTypeName(snapshot.StackTypes[i]), wasm.ValueTypeName(t))
+		}
+	}
+	return nil
+}
+
+// newSnapshotEvent returns the metadata passed to an experimental.SnapshotObserver for the given snapshot.
+func newSnapshotEvent(ce *callEngine, snapshot *wasm.Snapshot) experimental.SnapshotEvent {
+	event := experimental.SnapshotEvent{
+		InstructionCount: ce.instructionCount,
+		Time:             time.Now(),
+	}
+	if snapshotPb, err := snapshotProto(snapshot); err == nil {
+		event.Size = proto.EncodedSize(snapshotPb)
+	}
+	if frameCount := len(snapshot.Frames); frameCount > 0 {
+		top := snapshot.Frames[frameCount-1]
+		event.FunctionIndex = top.FunctionIdx
+		event.Pc = top.Pc
+	}
+	return event
+}
+
+// exportSnapshot writes the snapshot to snapshot.bin in the working directory. The snapshot is encoded before the file
+// is created, so that one which can't be, e.g. as a global has a value type unknown to the protobuf form, errs without
+// truncating a previous export.
+func exportSnapshot(snapshot *wasm.Snapshot) error {
+	if _, err := snapshotProto(snapshot); err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	// write to disk, streaming the memory instead of marshaling a copy of it.
+	f, err := os.Create("snapshot.bin")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer f.Close()
+	return WriteSnapshot(f, snapshot)
+}
+
+// WriteSnapshot encodes the snapshot as wasm.SnapshotOptions Export does, so that ReadSnapshot can decode it, e.g. in
+// another process. The memory is streamed to w, transformed per wasm.Snapshot PageTransform when set.
+//
+// Every export goes through this, so it counts the export in wasm.Snapshot Generation before encoding it, unless it
+// fails. Writing the same snapshot twice counts twice.
+func WriteSnapshot(w io.Writer, snapshot *wasm.Snapshot) (err error) {
+	snapshot.Generation++
+	defer func() {
+		if err != nil {
+			snapshot.Generation--
+		}
+	}()
+
+	snapshotPb, err := snapshotProto(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	return proto.WriteSnapshotTransform(w, snapshotPb, snapshot.PageTransform)
+}
+
+// snapshotProto converts the snapshot into its protobuf form. The memory buffer is shared, not copied.
+//
+// This errs if a global or stack value has a type unknown to the protobuf form, rather than writing it as the zero
+// value, i32.
+func snapshotProto(snapshot *wasm.Snapshot) (*proto.Snapshot, error) {
+	if snapshot.Memory != nil && snapshot.Memory.Shared {
+		return nil, wasm.ErrSnapshotSharedMemoryUnsupported
+	}
+	globalsPb := []*proto.Global{}
+	for i, global := range snapshot.Globals {
+		t, err := valueTypeProto(global.Type.ValType)
+		if err != nil {
+			return nil, fmt.Errorf("global[%d]: %w", i, err)
+		}
 		globalPb := &proto.Global{
-			Type:    globalType,
+			Type:    t,
 			Mutable: global.Type.Mutable,
 			Value:   global.Val,
 			ValHi:   global.ValHi,
@@ -849,8 +1147,51 @@ func exportSnapshot(ctx context.Context) {
 		framesPb = append(framesPb, framePb)
 	}
 
+	var stackTypesPb []proto.ValueType
+	for i, t := range snapshot.StackTypes {
+		tPb, err := valueTypeProto(t)
+		if err != nil {
+			return nil, fmt.Errorf("stack type[%d]: %w", i, err)
+		}
+		stackTypesPb = append(stackTypesPb, tPb)
+	}
+
+	preopensPb, err := preopensProto(snapshot.OpenedFiles)
+	if err != nil {
+		return nil, err
+	}
+	openFilesPb := openFilesProto(snapshot.OpenedFiles)
+
 	var memoryPb *proto.Memory = nil
+	var memoryAlignment uint32
+	var features proto.Feature
+	if snapshot.PageTransform != nil {
+		features |= proto.FeatureMemoryTransform
+	}
+	if snapshot.RandRead != 0 {
+		features |= proto.FeatureRand
+	}
+	if snapshot.Walltime != 0 || snapshot.Nanotime != 0 {
+		features |= proto.FeatureClocks
+	}
+	if len(preopensPb) > 0 {
+		features |= proto.FeaturePreopens
+	}
+	if len(openFilesPb) > 0 {
+		features |= proto.FeatureFDs
+	}
+	if snapshot.Generation != 0 {
+		features |= proto.FeatureGeneration
+	}
+	if snapshot.Args != nil || snapshot.Environ != nil {
+		features |= proto.FeatureArgsEnviron
+	}
 	if snapshot.Memory != nil {
+		if snapshot.AlignMemory && snapshot.PageTransform == nil {
+			// Align the buffer in the file to a page, so that MapSnapshot can map it rather than read it.
+			memoryAlignment = wasm.MemoryPageSize
+			features |= proto.FeatureMemoryAlignment
+		}
 		memoryPb = &proto.Memory{
 			Buffer: snapshot.Memory.Buffer,
 			Min:    snapshot.Memory.Min,
@@ -858,26 +1199,274 @@ func exportSnapshot(ctx context.Context) {
 			Cap:    snapshot.Memory.Cap,
 		}
 	}
-	// protobuf
-	snapshotPb := &proto.Snapshot{
-		Valid:   true,
-		Stack:   snapshot.Stack,
-		Globals: globalsPb,
-		Frames:  framesPb,
-		Memory:  memoryPb,
+	return &proto.Snapshot{
+		Valid:            true,
+		Stack:            snapshot.Stack,
+		StackTypes:       stackTypesPb,
+		Globals:          globalsPb,
+		Frames:           framesPb,
+		Memory:           memoryPb,
+		Closed:           snapshot.Closed,
+		InstructionCount: snapshot.InstructionCount,
+		StdoutWritten:    snapshot.StdoutWritten,
+		StderrWritten:    snapshot.StderrWritten,
+		StdinRead:        snapshot.StdinRead,
+		RandRead:         snapshot.RandRead,
+		Walltime:         snapshot.Walltime,
+		Nanotime:         snapshot.Nanotime,
+		Preopens:         preopensPb,
+		OpenFiles:        openFilesPb,
+		Generation:       snapshot.Generation,
+		Args:             snapshot.Args,
+		Environ:          snapshot.Environ,
+		Reason:           uint32(snapshot.Reason),
+		YieldTag:         snapshot.YieldTag,
+		MemoryAlignment:  memoryAlignment,
+		Features:         features,
+	}, nil
+}
+
+// preopensProto returns the directories preopened for WASI among openedFiles, ordered by file descriptor. These are
+// the entries without a File, such as the root "/" at fd 3, which are resolved to the root of the file system the
+// snapshot resumes with. This errs on any other path, as it would be resumed as the root.
+func preopensProto(openedFiles map[uint32]*sys.FileEntry) ([]*proto.Preopen, error) {
+	var preopensPb []*proto.Preopen
+	for fd, entry := range openedFiles {
+		if entry.File == nil {
+			if entry.Path != "/" {
+				return nil, fmt.Errorf("preopen at fd %d is %q, but only the root \"/\" is supported", fd, entry.Path)
+			}
+			preopensPb = append(preopensPb, &proto.Preopen{Fd: fd, Path: entry.Path})
+		}
+	}
+	sort.Slice(preopensPb, func(i, j int) bool { return preopensPb[i].Fd < preopensPb[j].Fd })
+	return preopensPb, nil
+}
+
+// openFilesProto returns the files the guest opened among openedFiles, ordered by file descriptor. These are the entries
+// with a File, which is reopened by path and moved to the offset recorded in the entry.
+func openFilesProto(openedFiles map[uint32]*sys.FileEntry) []*proto.OpenFile {
+	var openFilesPb []*proto.OpenFile
+	for fd, entry := range openedFiles {
+		if entry.File != nil {
+			openFilesPb = append(openFilesPb, &proto.OpenFile{Fd: fd, Path: entry.Path, Offset: entry.Offset})
+		}
+	}
+	sort.Slice(openFilesPb, func(i, j int) bool { return openFilesPb[i].Fd < openFilesPb[j].Fd })
+	return openFilesPb
+}
+
+// ReadSnapshot decodes a snapshot exported per wasm.SnapshotOptions Export, so that it can be resumed.
+//
+// The snapshot read can be resumed more than once, e.g. to retry from the same state, as resuming doesn't modify it.
+//
+// Note: Only the fields snapshotProto writes are read. Notably, open files are exported as their path and offset, with
+// sys.UnopenedFile in place of the file. Resuming reopens them in the file system the module is configured with, e.g.
+// via wazero.ModuleConfig WithFS, which may differ from the one the snapshot was taken with.
+func ReadSnapshot(r io.Reader) (*wasm.Snapshot, error) {
+	snapshotPb, err := proto.ReadSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotFromProto(snapshotPb)
+}
+
+// ReadSnapshotTransform is like ReadSnapshot, except it inverts the transform of each memory page of a snapshot
+// exported with wasm.Snapshot PageTransform. This errs if the snapshot wasn't exported with a transform, so that one
+// that wasn't, e.g. as it isn't encrypted, can't be substituted for one that was.
+func ReadSnapshotTransform(r io.Reader, transform wasm.PageTransform) (*wasm.Snapshot, error) {
+	snapshotPb, err := proto.ReadSnapshotTransform(r, transform)
+	if err != nil {
+		return nil, err
 	}
-	// write to disk
-	out, err := pb.Marshal(snapshotPb)
+	return snapshotFromProto(snapshotPb)
+}
+
+// LoadSnapshotFS is like ReadSnapshot, except it reads the snapshot from the file name in fsys, e.g. an embed.FS, so
+// that snapshots can ship with the embedder rather than be read from the host file system.
+func LoadSnapshotFS(fsys fs.FS, name string) (*wasm.Snapshot, error) {
+	f, err := fsys.Open(name)
 	if err != nil {
-		log.Fatalln("Failed to encode snapshot:", err)
+		return nil, err
 	}
-	if err := ioutil.WriteFile("snapshot.bin", out, 0644); err != nil {
-		log.Fatalln("Failed to write snapshot:", err)
+	defer f.Close()
+	return ReadSnapshot(f)
+}
+
+// MapSnapshot is like ReadSnapshot, except it maps the memory of the snapshot from f copy-on-write, instead of reading
+// it, where the platform supports it and the snapshot was exported with wasm.Snapshot AlignMemory. Resuming then
+// adopts the mapping as the module's memory, so that only the pages the guest accesses are read from f, and its writes
+// don't reach f.
+//
+// Unlike one ReadSnapshot returns, the snapshot shares its memory with the module it resumes, so Clone it to resume it
+// more than once.
+//
+// The returned release unmaps the memory. Call it only after the module resumed from the snapshot is closed, as
+// accessing the memory afterwards crashes the process.
+func MapSnapshot(f *os.File) (snapshot *wasm.Snapshot, release func() error, err error) {
+	snapshotPb, mapped, release, err := proto.MapSnapshot(f)
+	if err != nil {
+		return nil, nil, err
 	}
+	if snapshot, err = snapshotFromProto(snapshotPb); err != nil {
+		_ = release()
+		return nil, nil, err
+	}
+	snapshot.ShareMemory = mapped
+	return snapshot, release, nil
 }
 
-func applySnapshot(snapshot *wasm.Snapshot, fsContext *sys.FSContext, e *moduleEngine, ce *callEngine, moduleInst *wasm.ModuleInstance) {
-	//log.Panicln("ohnonono")
+// snapshotFromProto is the inverse of snapshotProto. The memory buffer is shared, not copied, as resuming copies it
+// into the module's memory, unless wasm.Snapshot ShareMemory is set.
+//
+// This errs on a value type unknown to this version, such as one added to the protobuf enum later, rather than reading
+// it as the zero value, i32.
+func snapshotFromProto(snapshotPb *proto.Snapshot) (*wasm.Snapshot, error) {
+	snapshot := &wasm.Snapshot{
+		Valid:            snapshotPb.GetValid(),
+		Stack:            snapshotPb.GetStack(),
+		Closed:           snapshotPb.GetClosed(),
+		InstructionCount: snapshotPb.GetInstructionCount(),
+		StdoutWritten:    snapshotPb.GetStdoutWritten(),
+		StderrWritten:    snapshotPb.GetStderrWritten(),
+		StdinRead:        snapshotPb.GetStdinRead(),
+		RandRead:         snapshotPb.GetRandRead(),
+		Walltime:         snapshotPb.GetWalltime(),
+		Nanotime:         snapshotPb.GetNanotime(),
+		Generation:       snapshotPb.GetGeneration(),
+		Reason:           wasm.SnapshotReason(snapshotPb.GetReason()),
+		YieldTag:         snapshotPb.GetYieldTag(),
+	}
+
+	if snapshotPb.GetFeatures()&proto.FeatureArgsEnviron != 0 {
+		// Never nil when captured, even if empty, so that resuming restores them.
+		snapshot.Args = append([]string{}, snapshotPb.GetArgs()...)
+		snapshot.Environ = append([]string{}, snapshotPb.GetEnviron()...)
+	}
+
+	if stackTypesPb := snapshotPb.GetStackTypes(); stackTypesPb != nil {
+		snapshot.StackTypes = make([]wasm.ValueType, 0, len(stackTypesPb))
+		for i, tPb := range stackTypesPb {
+			t, err := valueTypeFromProto(tPb)
+			if err != nil {
+				return nil, fmt.Errorf("stack type[%d]: %w", i, err)
+			}
+			snapshot.StackTypes = append(snapshot.StackTypes, t)
+		}
+	}
+
+	for i, globalPb := range snapshotPb.GetGlobals() {
+		t, err := valueTypeFromProto(globalPb.GetType())
+		if err != nil {
+			return nil, fmt.Errorf("global[%d]: %w", i, err)
+		}
+		snapshot.Globals = append(snapshot.Globals, &wasm.GlobalInstance{
+			Type:  &wasm.GlobalType{ValType: t, Mutable: globalPb.GetMutable()},
+			Val:   globalPb.GetValue(),
+			ValHi: globalPb.GetValHi(),
+		})
+	}
+
+	for i, framePb := range snapshotPb.GetFrames() {
+		frame := wasm.NewCallFrame(framePb.GetFunctionIndex(), framePb.GetPc())
+		if err := frame.Validate(); err != nil {
+			return nil, fmt.Errorf("frame[%d] %w", i, err)
+		}
+		snapshot.Frames = append(snapshot.Frames, frame)
+	}
+
+	for i, preopenPb := range snapshotPb.GetPreopens() {
+		fd := preopenPb.GetFd()
+		if fd <= 2 {
+			return nil, fmt.Errorf("preopen[%d] has fd %d, which is reserved for stdio", i, fd)
+		}
+		if snapshot.OpenedFiles == nil {
+			snapshot.OpenedFiles = map[uint32]*sys.FileEntry{}
+		} else if _, ok := snapshot.OpenedFiles[fd]; ok {
+			return nil, fmt.Errorf("preopen[%d] has fd %d, which is already preopened", i, fd)
+		}
+		// Only the root is resolved, so reject other paths rather than resume them as the root.
+		if path := preopenPb.GetPath(); path != "/" {
+			return nil, fmt.Errorf("preopen[%d] is %q, but only the root \"/\" is supported", i, path)
+		}
+		// Without a File, the entry is reopened in the file system the snapshot resumes with.
+		snapshot.OpenedFiles[fd] = &sys.FileEntry{Path: preopenPb.GetPath()}
+		if fd > snapshot.LastFD {
+			snapshot.LastFD = fd
+		}
+	}
+
+	for i, openFilePb := range snapshotPb.GetOpenFiles() {
+		fd := openFilePb.GetFd()
+		if fd <= 2 {
+			return nil, fmt.Errorf("open file[%d] has fd %d, which is reserved for stdio", i, fd)
+		}
+		if snapshot.OpenedFiles == nil {
+			snapshot.OpenedFiles = map[uint32]*sys.FileEntry{}
+		} else if _, ok := snapshot.OpenedFiles[fd]; ok {
+			return nil, fmt.Errorf("open file[%d] has fd %d, which is already open", i, fd)
+		}
+		snapshot.OpenedFiles[fd] = &sys.FileEntry{Path: openFilePb.GetPath(), File: sys.UnopenedFile, Offset: openFilePb.GetOffset()}
+		if fd > snapshot.LastFD {
+			snapshot.LastFD = fd
+		}
+	}
+
+	if memoryPb := snapshotPb.GetMemory(); memoryPb != nil {
+		if memoryPb.GetShared() {
+			return nil, wasm.ErrSnapshotSharedMemoryUnsupported
+		}
+		snapshot.Memory = &wasm.MemoryInstance{
+			Buffer: memoryPb.GetBuffer(),
+			Min:    memoryPb.GetMin(),
+			Max:    memoryPb.GetMax(),
+			Cap:    memoryPb.GetCap(),
+		}
+	}
+	return snapshot, nil
+}
+
+// valueTypesProto maps each value type to its protobuf form. This is the only place to edit when adding a value type
+// to snapshots, as valueTypeFromProto uses its inverse.
+var valueTypesProto = map[api.ValueType]proto.ValueType{
+	wasm.ValueTypeI32:       proto.ValueType_I32,
+	wasm.ValueTypeI64:       proto.ValueType_I64,
+	wasm.ValueTypeF32:       proto.ValueType_F32,
+	wasm.ValueTypeF64:       proto.ValueType_F64,
+	wasm.ValueTypeV128:      proto.ValueType_V128,
+	wasm.ValueTypeFuncref:   proto.ValueType_FuncRef,
+	wasm.ValueTypeExternref: proto.ValueType_ExternRef,
+}
+
+// valueTypesFromProto is the inverse of valueTypesProto.
+var valueTypesFromProto = func() map[proto.ValueType]api.ValueType {
+	ret := make(map[proto.ValueType]api.ValueType, len(valueTypesProto))
+	for t, tPb := range valueTypesProto {
+		ret[tPb] = t
+	}
+	return ret
+}()
+
+func valueTypeProto(t api.ValueType) (proto.ValueType, error) {
+	if tPb, ok := valueTypesProto[t]; ok {
+		return tPb, nil
+	}
+	return 0, fmt.Errorf("unknown value type 0x%x", t)
+}
+
+// valueTypeFromProto errs on a type unknown to this version, such as one added to the protobuf enum later, rather than
+// reading it as the zero value, i32.
+func valueTypeFromProto(t proto.ValueType) (api.ValueType, error) {
+	if vt, ok := valueTypesFromProto[t]; ok {
+		return vt, nil
+	}
+	return 0, fmt.Errorf("unknown value type %d", t)
+}
+
+// applySnapshot restores the call stack of the snapshot into ce, once the module is reset to it. The stack is copied, as
+// executing pops and pushes it in place, which would otherwise overwrite the snapshot, e.g. one read once to be resumed
+// more than once.
+func applySnapshot(snapshot *wasm.Snapshot, e *moduleEngine, ce *callEngine) {
 	ce.frames = nil
 	frameCount := len(snapshot.Frames)
 	for i := 0; i < frameCount; i++ {
@@ -886,11 +1475,8 @@ func applySnapshot(snapshot *wasm.Snapshot, fsContext *sys.FSContext, e *moduleE
 		ce.pushFrame(&callFrame{f: f, pc: frame.Pc})
 	}
 
-	ce.stack = snapshot.Stack
-	moduleInst.Globals = snapshot.Globals
-	moduleInst.Memory = snapshot.Memory
-	fsContext.SetLastFD(snapshot.LastFD)
-	fsContext.SetOpenedFiles(snapshot.OpenedFiles)
+	ce.stack = append([]uint64(nil), snapshot.Stack...)
+	ce.instructionCount = snapshot.InstructionCount
 }
 
 // Call implements the same method as documented on wasm.ModuleEngine.
@@ -911,8 +1497,16 @@ func (e *moduleEngine) Call(ctx context.Context, m *wasm.CallContext, f *wasm.Fu
 		return nil, fmt.Errorf("expected %d params, but passed %d", paramSignature, paramCount)
 	}
 
+	// Fail before executing, rather than snapshot a view of the memory torn by other threads.
+	if mem := compiled.source.Module.Memory; mem != nil && mem.Shared && wasm.SnapshotConfigured(ctx) {
+		return nil, wasm.ErrSnapshotSharedMemoryUnsupported
+	}
+
 	ce := e.newCallEngine()
+	ce.callDepth = compiled.source.Module.CallDepthCounter()
 	defer func() {
+		defer ce.releaseCallDepth()
+
 		// If the module closed during the call, and the call didn't err for another reason, set an ExitError.
 		if err == nil {
 			err = m.FailIfClosed()
@@ -921,9 +1515,11 @@ func (e *moduleEngine) Call(ctx context.Context, m *wasm.CallContext, f *wasm.Fu
 
 		v := recover()
 		if v == wasmruntime.ErrRuntimeSnapshot {
-			//makeSnapshot(ctx, ce, compiled.source.Module)
-			err = wasmruntime.ErrRuntimeSnapshot
+			err = wasm.NewSnapshotError(wasm.SnapshotOptionsFromContext(ctx).Snapshot)
+		} else if signal, ok := v.(*wasm.YieldSignal); ok && ce.yield(ctx, m, compiled.source.Module, signal) {
+			err = wasm.NewSnapshotError(wasm.SnapshotOptionsFromContext(ctx).Snapshot)
 		} else if v != nil {
+			trapSnapshot := ce.snapshotTrap(ctx, m, compiled.source.Module, v) // before frames are popped.
 			builder := wasmdebug.NewErrorBuilder()
 			frameCount := len(ce.frames)
 			for i := 0; i < frameCount; i++ {
@@ -932,6 +1528,9 @@ func (e *moduleEngine) Call(ctx context.Context, m *wasm.CallContext, f *wasm.Fu
 				builder.AddFrame(fn.DebugName, fn.ParamTypes(), fn.ResultTypes())
 			}
 			err = builder.FromRecovered(v)
+			if trapSnapshot != nil {
+				err = &wasm.TrapSnapshotError{Err: err, Snapshot: trapSnapshot}
+			}
 		}
 	}()
 
@@ -963,7 +1562,10 @@ func (e *moduleEngine) Resume(ctx context.Context, m *wasm.CallContext, f *wasm.
 	*/
 
 	ce := e.newCallEngine()
+	ce.callDepth = compiled.source.Module.CallDepthCounter()
 	defer func() {
+		defer ce.releaseCallDepth()
+
 		// If the module closed during the call, and the call didn't err for another reason, set an ExitError.
 		if err == nil {
 			err = m.FailIfClosed()
@@ -972,9 +1574,11 @@ func (e *moduleEngine) Resume(ctx context.Context, m *wasm.CallContext, f *wasm.
 
 		v := recover()
 		if v == wasmruntime.ErrRuntimeSnapshot {
-			//makeSnapshot(ctx, ce, compiled.source.Module)
-			err = wasmruntime.ErrRuntimeSnapshot
+			err = wasm.NewSnapshotError(wasm.SnapshotOptionsFromContext(ctx).Snapshot)
+		} else if signal, ok := v.(*wasm.YieldSignal); ok && ce.yield(ctx, m, compiled.source.Module, signal) {
+			err = wasm.NewSnapshotError(wasm.SnapshotOptionsFromContext(ctx).Snapshot)
 		} else if v != nil {
+			trapSnapshot := ce.snapshotTrap(ctx, m, compiled.source.Module, v) // before frames are popped.
 			builder := wasmdebug.NewErrorBuilder()
 			frameCount := len(ce.frames)
 			for i := 0; i < frameCount; i++ {
@@ -983,6 +1587,9 @@ func (e *moduleEngine) Resume(ctx context.Context, m *wasm.CallContext, f *wasm.
 				builder.AddFrame(fn.DebugName, fn.ParamTypes(), fn.ResultTypes())
 			}
 			err = builder.FromRecovered(v)
+			if trapSnapshot != nil {
+				err = &wasm.TrapSnapshotError{Err: err, Snapshot: trapSnapshot}
+			}
 		}
 	}()
 
@@ -992,9 +1599,33 @@ func (e *moduleEngine) Resume(ctx context.Context, m *wasm.CallContext, f *wasm.
 		}
 	*/
 
-	moduleInst := compiled.source.Module
-	fsContext := m.Sys.FS(ctx)
-	applySnapshot(snapshot, fsContext, moduleInst.Engine.(*moduleEngine), ce, moduleInst)
+	// Validate before applying, so that a crafted snapshot can neither exceed the call stack nor partially overwrite
+	// the module state.
+	if frameCount := len(snapshot.Frames); frameCount > ce.callStackCeiling {
+		return nil, fmt.Errorf("snapshot has %d frames, exceeding %d: %w",
+			frameCount, ce.callStackCeiling, wasmruntime.ErrRuntimeCallStackOverflow)
+	}
+
+	if snapshot.Reason == wasm.SnapshotReasonYield && (len(snapshot.Frames) == 0 || len(snapshot.Stack) == 0) {
+		return nil, errors.New("yield snapshot has no caller frame or tag")
+	}
+
+	if err = e.ValidateSnapshot(snapshot); err != nil {
+		return nil, err
+	}
+	// Reset the module in place, so that exports and the CallContext see the restored globals and memory.
+	if err = m.Reset(ctx, snapshot); err != nil {
+		return nil, err
+	}
+
+	applySnapshot(snapshot, e, ce)
+
+	if snapshot.Reason == wasm.SnapshotReasonYield {
+		// Return the resume value from the call to yield, instead of calling it again.
+		ce.popValue()
+		ce.pushValue(uint64(snapshot.ResumeValue))
+		ce.peekFrame().pc++
+	}
 
 	for len(ce.frames) > 0 {
 		curFrame := ce.peekFrame()
@@ -1039,13 +1670,44 @@ func (ce *callEngine) callGoFunc(ctx context.Context, callCtx *wasm.CallContext,
 	return
 }
 
+// isInterruptible returns true if the operation at pc is a point where execution can be interrupted by context
+// cancellation. An unconditional branch is only one when it jumps backwards, i.e. to a loop, as forward ones terminate.
+func isInterruptible(op *interpreterOp, pc uint64) bool {
+	switch op.kind {
+	case wazeroir.OperationKindBr:
+		return op.us[0] <= pc
+	case wazeroir.OperationKindBrIf, wazeroir.OperationKindBrTable,
+		wazeroir.OperationKindCall, wazeroir.OperationKindCallIndirect:
+		return true
+	}
+	return false
+}
+
+// interrupt stops execution with the error of the done context. The operation at the current pc hasn't executed yet,
+// so if a snapshot is configured, it is taken first, in order to resume from this point later.
+func (ce *callEngine) interrupt(ctx context.Context, callCtx *wasm.CallContext, moduleInst *wasm.ModuleInstance) {
+	if wasm.SnapshotOptionsFromContext(ctx).Snapshot != nil {
+		makeSnapshot(ctx, callCtx, ce, moduleInst, wasm.SnapshotReasonInterrupt)
+	}
+	panic(ctx.Err())
+}
+
 func (ce *callEngine) callNativeFunc(ctx context.Context, callCtx *wasm.CallContext, f *function, createCallFrame bool) {
 	moduleInst := f.source.Module
+	opts := wasm.SnapshotOptionsFromContext(ctx)
 
 	if createCallFrame {
 		// create a new call frame
 		newFrame := &callFrame{f: f}
 		ce.pushFrame(newFrame)
+
+		// A resumed frame isn't created here, so this doesn't snapshot the entry it resumes from again.
+		if alwaysSnapshot(opts, wasm.SnapshotGranularityFunction) {
+			makeSnapshot(ctx, callCtx, ce, moduleInst, wasm.SnapshotReasonAlways)
+			if stopAfterSnapshot(opts) {
+				panic(wasmruntime.ErrRuntimeSnapshot)
+			}
+		}
 	}
 
 	// frame is the current call frame.
@@ -1059,12 +1721,31 @@ func (ce *callEngine) callNativeFunc(ctx context.Context, callCtx *wasm.CallCont
 	functions := f.source.Module.Engine.(*moduleEngine).functions
 	dataInstances := f.source.Module.DataInstances
 	elementInstances := f.source.Module.ElementInstances
-	fsContext := callCtx.Sys.FS(ctx)
+
+	// done is nil unless the context can be canceled, in which case it is checked before each call and each branch that
+	// can loop. As any loop or recursion passes one of these, this bounds the latency of interruption without checking
+	// every operation.
+	done := ctx.Done()
+
+	// coverage is nil unless wasm.SnapshotOptions Coverage is set, in which case each operation is counted.
+	coverage := opts.Coverage
+	funcIdx := frame.f.source.Idx
 
 	for frame.pc < bodyLen {
 		op := frame.f.body[frame.pc]
+		if done != nil && isInterruptible(op, frame.pc) {
+			select {
+			case <-done:
+				ce.interrupt(ctx, callCtx, moduleInst)
+			default:
+			}
+		}
+		ce.instructionCount++
+		if coverage != nil {
+			coverage.Add(funcIdx, frame.pc)
+		}
 
-		if ctx.Value("always_snapshot") == true {
+		if opts.Always {
 			fmt.Printf("%v %v\n", op.kind.String(), op.us)
 		}
 
@@ -1076,9 +1757,9 @@ func (ce *callEngine) callNativeFunc(ctx context.Context, callCtx *wasm.CallCont
 			panic(wasmruntime.ErrRuntimeUnreachable)
 		case 0x01:
 			frame.pc++
-			if ctx.Value("snapshot") != nil && ctx.Value("always_snapshot") == false {
-				makeSnapshot(ctx, fsContext, ce, moduleInst)
-				if ctx.Value("trap_after_snapshot") == true {
+			if opts.Snapshot != nil && opts.Cooperative {
+				makeSnapshot(ctx, callCtx, ce, moduleInst, wasm.SnapshotReasonCooperative)
+				if stopAfterSnapshot(opts) {
 					panic(wasmruntime.ErrRuntimeSnapshot)
 				}
 			}
@@ -4309,10 +4990,11 @@ func (ce *callEngine) callNativeFunc(ctx context.Context, callCtx *wasm.CallCont
 			frame.pc++
 		}
 
-		// snapshot after every instruction if this is true.
-		if frame.pc < bodyLen && (ctx.Value("always_snapshot") == true) {
-			makeSnapshot(ctx, fsContext, ce, moduleInst)
-			if ctx.Value("trap_after_snapshot") == true {
+		// snapshot after every instruction, or every branch, if wasm.SnapshotOptions Always is set.
+		if frame.pc < bodyLen && (alwaysSnapshot(opts, wasm.SnapshotGranularityInstruction) ||
+			(isBranch(op.kind) && alwaysSnapshot(opts, wasm.SnapshotGranularityBlock))) {
+			makeSnapshot(ctx, callCtx, ce, moduleInst, wasm.SnapshotReasonAlways)
+			if stopAfterSnapshot(opts) {
 				panic(wasmruntime.ErrRuntimeSnapshot)
 			}
 		}
@@ -4320,17 +5002,39 @@ func (ce *callEngine) callNativeFunc(ctx context.Context, callCtx *wasm.CallCont
 
 	ce.popFrame()
 
-	if ctx.Value("always_snapshot") == true {
-		if ctx.Value("trap_after_snapshot") == true {
-			if len(ce.frames) > 0 {
-				ce.peekFrame().pc++
-			}
-			makeSnapshot(ctx, fsContext, ce, moduleInst)
+	if alwaysSnapshot(opts, wasm.SnapshotGranularityInstruction) || alwaysSnapshot(opts, wasm.SnapshotGranularityFunction) {
+		// The caller is still at its call, so step over it in the snapshot, as resuming continues at the top pc.
+		if len(ce.frames) > 0 {
+			ce.peekFrame().pc++
+		}
+		makeSnapshot(ctx, callCtx, ce, moduleInst, wasm.SnapshotReasonAlways)
+		if stopAfterSnapshot(opts) {
 			panic(wasmruntime.ErrRuntimeSnapshot)
-		} else {
-			makeSnapshot(ctx, fsContext, ce, moduleInst)
 		}
+		if len(ce.frames) > 0 {
+			ce.peekFrame().pc--
+		}
+	}
+}
+
+// stopAfterSnapshot returns true if the call stops with a wasm.SnapshotError after taking a snapshot, either as opts has
+// TrapAfter set, or as the snapshot failed, e.g. to export, so that the call returns the failure.
+func stopAfterSnapshot(opts wasm.SnapshotOptions) bool {
+	return opts.TrapAfter || opts.Snapshot.Err != nil
+}
+
+// alwaysSnapshot returns true if opts has Always set, and the snapshot's granularity is the given one.
+func alwaysSnapshot(opts wasm.SnapshotOptions, granularity wasm.SnapshotGranularity) bool {
+	return opts.Always && opts.Snapshot != nil && opts.Snapshot.Granularity == granularity
+}
+
+// isBranch returns true if the operation ends a basic block by branching.
+func isBranch(kind wazeroir.OperationKind) bool {
+	switch kind {
+	case wazeroir.OperationKindBr, wazeroir.OperationKindBrIf, wazeroir.OperationKindBrTable:
+		return true
 	}
+	return false
 }
 
 func WasmCompatMax32bits(v1, v2 uint32) uint64 {
@@ -1,17 +1,27 @@
 package interpreter
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"math"
+	"os"
+	"path"
+	"reflect"
 	"strconv"
 	"testing"
+	"testing/fstest"
+	"time"
 	"unsafe"
 
+	"github.com/tetratelabs/wazero/experimental"
 	"github.com/tetratelabs/wazero/internal/buildoptions"
+	"github.com/tetratelabs/wazero/internal/proto"
+	"github.com/tetratelabs/wazero/internal/sys"
 	"github.com/tetratelabs/wazero/internal/testing/enginetest"
 	"github.com/tetratelabs/wazero/internal/testing/require"
 	"github.com/tetratelabs/wazero/internal/wasm"
+	"github.com/tetratelabs/wazero/internal/wasmruntime"
 	"github.com/tetratelabs/wazero/internal/wazeroir"
 )
 
@@ -31,7 +41,7 @@ func TestInterpreter_CallEngine_PushFrame(t *testing.T) {
 	f1 := &callFrame{}
 	f2 := &callFrame{}
 
-	ce := callEngine{}
+	ce := callEngine{callStackCeiling: buildoptions.CallStackCeiling}
 	require.Zero(t, len(ce.frames), "expected no frames")
 
 	ce.pushFrame(f1)
@@ -42,16 +52,12 @@ func TestInterpreter_CallEngine_PushFrame(t *testing.T) {
 }
 
 func TestInterpreter_CallEngine_PushFrame_StackOverflow(t *testing.T) {
-	defer func() { callStackCeiling = buildoptions.CallStackCeiling }()
-
-	callStackCeiling = 3
-
 	f1 := &callFrame{}
 	f2 := &callFrame{}
 	f3 := &callFrame{}
 	f4 := &callFrame{}
 
-	vm := callEngine{}
+	vm := callEngine{callStackCeiling: 3}
 	vm.pushFrame(f1)
 	vm.pushFrame(f2)
 	vm.pushFrame(f3)
@@ -60,6 +66,342 @@ func TestInterpreter_CallEngine_PushFrame_StackOverflow(t *testing.T) {
 	require.EqualError(t, captured, "callstack overflow")
 }
 
+func TestInterpreter_ModuleEngine_Resume_StackOverflow(t *testing.T) {
+	f := &function{source: &wasm.FunctionInstance{Module: &wasm.ModuleInstance{}}}
+	me := &moduleEngine{parentEngine: &engine{callStackCeiling: 3}, functions: []*function{f}}
+	f.source.Module.Engine = me
+
+	snapshot := &wasm.Snapshot{Valid: true, Frames: make([]wasm.CallFrame, 4)}
+	_, err := me.Resume(testCtx, &wasm.CallContext{}, f.source, snapshot)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeCallStackOverflow)
+	require.EqualError(t, err, "snapshot has 4 frames, exceeding 3: callstack overflow")
+}
+
+func TestInterpreter_ModuleEngine_Resume_GlobalMismatch(t *testing.T) {
+	declared := &wasm.GlobalInstance{Type: &wasm.GlobalType{ValType: wasm.ValueTypeF64}}
+	f := &function{source: &wasm.FunctionInstance{Module: &wasm.ModuleInstance{Globals: []*wasm.GlobalInstance{declared}}}}
+	me := &moduleEngine{parentEngine: &engine{callStackCeiling: 3}, functions: []*function{f}}
+	f.source.Module.Engine = me
+
+	snapshot := &wasm.Snapshot{
+		Valid:   true,
+		Globals: []*wasm.GlobalInstance{{Type: &wasm.GlobalType{ValType: wasm.ValueTypeI64}, Val: 1}},
+	}
+	_, err := me.Resume(testCtx, wasm.NewCallContext(nil, f.source.Module, nil), f.source, snapshot)
+	require.EqualError(t, err, "snapshot global[0] is i64, but module's is f64")
+
+	// The module state is untouched.
+	require.Equal(t, []*wasm.GlobalInstance{declared}, f.source.Module.Globals)
+}
+
+func TestInterpreter_ModuleEngine_ValidateSnapshot(t *testing.T) {
+	caller := &function{
+		source: &wasm.FunctionInstance{Type: &wasm.FunctionType{}},
+		body:   []*interpreterOp{{kind: wazeroir.OperationKindNop}, {kind: wazeroir.OperationKindCall, us: []uint64{1}}},
+	}
+	callee := &function{
+		source: &wasm.FunctionInstance{Type: &wasm.FunctionType{}},
+		body:   []*interpreterOp{{kind: wazeroir.OperationKindNop}},
+	}
+	host := &function{
+		source: &wasm.FunctionInstance{Type: &wasm.FunctionType{}, DebugName: "wasi_snapshot_preview1.poll_oneoff"},
+		hostFn: &reflect.Value{},
+	}
+	me := &moduleEngine{functions: []*function{caller, callee, host}}
+
+	tests := []struct {
+		name        string
+		frames      []wasm.CallFrame
+		expectedErr string
+	}{
+		{
+			name:   "valid",
+			frames: []wasm.CallFrame{{Pc: 1, FunctionIdx: 0}, {Pc: 0, FunctionIdx: 1}},
+		},
+		{
+			name:   "top at end",
+			frames: []wasm.CallFrame{{Pc: 2, FunctionIdx: 0}},
+		},
+		{
+			name:        "function index",
+			frames:      []wasm.CallFrame{{Pc: 0, FunctionIdx: 3}},
+			expectedErr: "snapshot frame[0] has function index 3, but there are 3 functions",
+		},
+		{
+			name:        "host function",
+			frames:      []wasm.CallFrame{{Pc: 1, FunctionIdx: 0}, {Pc: 0, FunctionIdx: 2}},
+			expectedErr: "snapshot frame[1] is in host function wasi_snapshot_preview1.poll_oneoff, whose state isn't captured",
+		},
+		{
+			name:        "top past end",
+			frames:      []wasm.CallFrame{{Pc: 1, FunctionIdx: 0}, {Pc: 2, FunctionIdx: 1}},
+			expectedErr: "snapshot frame[1] has pc 2, past the 1 operations of function[1]",
+		},
+		{
+			name:        "caller not at call",
+			frames:      []wasm.CallFrame{{Pc: 0, FunctionIdx: 0}, {Pc: 0, FunctionIdx: 1}},
+			expectedErr: "snapshot frame[0] has pc 0, which is not a call in function[0]",
+		},
+		{
+			name:        "caller past end",
+			frames:      []wasm.CallFrame{{Pc: 2, FunctionIdx: 0}, {Pc: 0, FunctionIdx: 1}},
+			expectedErr: "snapshot frame[0] has pc 2, which is not a call in function[0]",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			err := me.ValidateSnapshot(&wasm.Snapshot{Frames: tc.frames})
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestInterpreter_stackTypes(t *testing.T) {
+	caller := &function{
+		source: &wasm.FunctionInstance{Type: &wasm.FunctionType{}},
+		body:   []*interpreterOp{{kind: wazeroir.OperationKindConstI32}, {kind: wazeroir.OperationKindCall}},
+		stackTypes: map[uint64][]wazeroir.UnsignedType{
+			1: {wazeroir.UnsignedTypeV128, wazeroir.UnsignedTypeI32}, // [$0, arg]
+		},
+	}
+	callee := &function{
+		source: &wasm.FunctionInstance{Type: &wasm.FunctionType{Params: []wasm.ValueType{wasm.ValueTypeI32}}},
+		body:   []*interpreterOp{{kind: wazeroir.OperationKindConstF64}, {kind: wazeroir.OperationKindNop}},
+		stackTypes: map[uint64][]wazeroir.UnsignedType{
+			2: {wazeroir.UnsignedTypeI32, wazeroir.UnsignedTypeF64}, // [$0, f64] after the nop
+		},
+	}
+
+	t.Run("known", func(t *testing.T) {
+		frames := []*callFrame{{f: caller, pc: 1}, {f: callee, pc: 2}}
+		require.Equal(t, []wasm.ValueType{wasm.ValueTypeV128, wasm.ValueTypeI32, wasm.ValueTypeF64}, stackTypes(frames))
+	})
+	t.Run("unknown", func(t *testing.T) {
+		frames := []*callFrame{{f: caller, pc: 1}, {f: callee, pc: 1}}
+		require.Nil(t, stackTypes(frames))
+	})
+	t.Run("no frames", func(t *testing.T) {
+		require.Nil(t, stackTypes(nil)) // the results of the outermost function are untyped.
+	})
+
+	me := &moduleEngine{functions: []*function{caller, callee}}
+	frames := []wasm.CallFrame{{Pc: 1, FunctionIdx: 0}, {Pc: 2, FunctionIdx: 1}}
+	tests := []struct {
+		name        string
+		snapshot    *wasm.Snapshot
+		expectedErr string
+	}{
+		{
+			name: "valid",
+			snapshot: &wasm.Snapshot{
+				Frames:     frames,
+				Stack:      []uint64{1, 2, 3, 4},
+				StackTypes: []wasm.ValueType{wasm.ValueTypeV128, wasm.ValueTypeI32, wasm.ValueTypeF64},
+			},
+		},
+		{
+			name: "stack length",
+			snapshot: &wasm.Snapshot{
+				Frames:     frames,
+				Stack:      []uint64{1, 2, 3},
+				StackTypes: []wasm.ValueType{wasm.ValueTypeV128, wasm.ValueTypeI32, wasm.ValueTypeF64},
+			},
+			expectedErr: "snapshot stack has 3 values, but its types need 4",
+		},
+		{
+			name: "type mismatch",
+			snapshot: &wasm.Snapshot{
+				Frames:     frames,
+				Stack:      []uint64{1, 2, 3, 4},
+				StackTypes: []wasm.ValueType{wasm.ValueTypeV128, wasm.ValueTypeF32, wasm.ValueTypeF64},
+			},
+			expectedErr: "snapshot stack type[1] is f32, but i32 at its frames",
+		},
+		{
+			name: "type count",
+			snapshot: &wasm.Snapshot{
+				Frames:     frames,
+				Stack:      []uint64{1, 2, 3, 4},
+				StackTypes: []wasm.ValueType{wasm.ValueTypeI64, wasm.ValueTypeI64, wasm.ValueTypeI64, wasm.ValueTypeI64},
+			},
+			expectedErr: "snapshot has 4 stack types, but its frames have 3",
+		},
+		{
+			name: "unknown function",
+			snapshot: &wasm.Snapshot{
+				Frames:     []wasm.CallFrame{{FunctionIdx: 2}},
+				StackTypes: []wasm.ValueType{},
+			},
+			expectedErr: "snapshot frame has function index 2, but there are 2 functions",
+		},
+		{
+			name: "unknown types",
+			snapshot: &wasm.Snapshot{
+				Frames:     []wasm.CallFrame{{Pc: 0, FunctionIdx: 1}},
+				StackTypes: []wasm.ValueType{},
+			},
+			expectedErr: "snapshot stack types are unknown at its frames",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			err := me.validateStackTypes(tc.snapshot)
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestInterpreter_ModuleEngine_Call_snapshotOnTrap(t *testing.T) {
+	memory := &wasm.MemoryInstance{Buffer: make([]byte, wasm.MemoryPageSize), Min: 1, Cap: 1, Max: 1}
+	moduleInst := &wasm.ModuleInstance{Memory: memory}
+	f := &function{
+		source: &wasm.FunctionInstance{Module: moduleInst, Type: &wasm.FunctionType{}, DebugName: "test.f"},
+		body: []*interpreterOp{
+			{kind: wazeroir.OperationKindConstI32, us: []uint64{42}},
+			{kind: wazeroir.OperationKindUnreachable},
+		},
+	}
+	me := &moduleEngine{parentEngine: &engine{callStackCeiling: buildoptions.CallStackCeiling}, functions: []*function{f}}
+	moduleInst.Engine = me
+	callCtx := wasm.NewCallContext(nil, moduleInst, nil)
+
+	t.Run("disabled", func(t *testing.T) {
+		_, err := me.Call(testCtx, callCtx, f.source)
+		require.ErrorIs(t, err, wasmruntime.ErrRuntimeUnreachable)
+		_, ok := err.(*wasm.TrapSnapshotError)
+		require.False(t, ok)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		ctx := context.WithValue(testCtx, "snapshot_on_trap", true)
+		_, err := me.Call(ctx, callCtx, f.source)
+		require.ErrorIs(t, err, wasmruntime.ErrRuntimeUnreachable)
+		require.EqualError(t, err, `wasm error: unreachable
+wasm stack trace:
+	test.f()`)
+
+		trapErr, ok := err.(*wasm.TrapSnapshotError)
+		require.True(t, ok)
+		snapshot := trapErr.Snapshot
+		require.True(t, snapshot.Valid)
+		require.Equal(t, wasm.SnapshotReasonTrap, snapshot.Reason)
+		require.Equal(t, []wasm.CallFrame{{Pc: 1}}, snapshot.Frames) // at the unreachable instruction
+		require.Equal(t, []uint64{42}, snapshot.Stack)
+		require.Equal(t, memory, snapshot.Memory)
+	})
+}
+
+// TestInterpreter_ModuleEngine_Call_exportError ensures a snapshot that can't be exported stops the call with an error,
+// rather than exit the host.
+func TestInterpreter_ModuleEngine_Call_exportError(t *testing.T) {
+	unknown := &wasm.GlobalType{ValType: 0x42}
+	moduleInst := &wasm.ModuleInstance{Globals: []*wasm.GlobalInstance{{Type: unknown}}}
+	f := &function{
+		source: &wasm.FunctionInstance{Module: moduleInst, Type: &wasm.FunctionType{}},
+		body:   []*interpreterOp{{kind: wazeroir.OperationKindNop}},
+	}
+	me := &moduleEngine{parentEngine: &engine{callStackCeiling: buildoptions.CallStackCeiling}, functions: []*function{f}}
+	moduleInst.Engine = me
+	callCtx := wasm.NewCallContext(nil, moduleInst, nil)
+
+	// The snapshot would be exported to the current directory.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd) //nolint
+
+	snapshot := &wasm.Snapshot{}
+	opts := wasm.SnapshotOptions{Snapshot: snapshot, Cooperative: true, Export: true}
+	_, err = me.Call(wasm.WithSnapshotOptions(testCtx, opts), callCtx, f.source)
+	require.EqualError(t, err, "snapshot (cooperative): failed to encode snapshot: global[0]: unknown value type 0x42")
+	snapshotErr, ok := err.(*wasm.SnapshotError)
+	require.True(t, ok)
+	require.False(t, snapshotErr.Resumable)
+	require.False(t, snapshot.Valid)
+	require.Zero(t, snapshot.Generation) // not exported
+
+	_, err = os.Stat("snapshot.bin")
+	require.True(t, os.IsNotExist(err))
+}
+
+// TestInterpreter_ModuleEngine_Call_exportPreopen ensures a module with a preopen other than the root stops with an
+// error once a snapshot is exported, as it can't be resumed, rather than exit the host.
+func TestInterpreter_ModuleEngine_Call_exportPreopen(t *testing.T) {
+	moduleInst := &wasm.ModuleInstance{}
+	f := &function{
+		source: &wasm.FunctionInstance{Module: moduleInst, Type: &wasm.FunctionType{}},
+		body:   []*interpreterOp{{kind: wazeroir.OperationKindNop}},
+	}
+	me := &moduleEngine{parentEngine: &engine{callStackCeiling: buildoptions.CallStackCeiling}, functions: []*function{f}}
+	moduleInst.Engine = me
+	sysCtx := sys.DefaultContext(fstest.MapFS{"tmp/a.txt": {}})
+	sysCtx.FS(testCtx).SetOpenedFiles(map[uint32]*sys.FileEntry{3: {Path: "/"}, 4: {Path: "/tmp"}})
+	callCtx := wasm.NewCallContext(nil, moduleInst, sysCtx)
+
+	// The snapshot would be exported to the current directory.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd) //nolint
+
+	snapshot := &wasm.Snapshot{}
+	opts := wasm.SnapshotOptions{Snapshot: snapshot, Cooperative: true, Export: true}
+	_, err = me.Call(wasm.WithSnapshotOptions(testCtx, opts), callCtx, f.source)
+	require.EqualError(t, err,
+		`snapshot (cooperative): failed to encode snapshot: preopen at fd 4 is "/tmp", but only the root "/" is supported`)
+	require.False(t, snapshot.Valid)
+
+	_, err = os.Stat("snapshot.bin")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestInterpreter_SharedMemory(t *testing.T) {
+	memory := wasm.NewMemoryInstance(&wasm.Memory{Min: 1, Cap: 1, Max: 1, IsShared: true})
+	require.True(t, memory.Shared)
+	moduleInst := &wasm.ModuleInstance{Memory: memory}
+	f := &function{
+		source: &wasm.FunctionInstance{Module: moduleInst, Type: &wasm.FunctionType{}},
+		body:   []*interpreterOp{{kind: wazeroir.OperationKindNop}},
+	}
+	me := &moduleEngine{parentEngine: &engine{callStackCeiling: buildoptions.CallStackCeiling}, functions: []*function{f}}
+	moduleInst.Engine = me
+	callCtx := wasm.NewCallContext(nil, moduleInst, nil)
+
+	t.Run("call without snapshots", func(t *testing.T) {
+		_, err := me.Call(testCtx, callCtx, f.source)
+		require.NoError(t, err)
+	})
+
+	// Neither a snapshot nor one on trap is taken, as either could capture a torn view of the memory.
+	for _, opts := range []wasm.SnapshotOptions{{Snapshot: &wasm.Snapshot{}, Cooperative: true}, {OnTrap: true}} {
+		_, err := me.Call(wasm.WithSnapshotOptions(testCtx, opts), callCtx, f.source)
+		require.ErrorIs(t, err, wasm.ErrSnapshotSharedMemoryUnsupported)
+	}
+
+	t.Run("export", func(t *testing.T) {
+		_, err := snapshotProto(&wasm.Snapshot{Valid: true, Memory: &wasm.MemoryInstance{Shared: true}})
+		require.ErrorIs(t, err, wasm.ErrSnapshotSharedMemoryUnsupported)
+	})
+
+	t.Run("read", func(t *testing.T) {
+		_, err := snapshotFromProto(&proto.Snapshot{Valid: true, Memory: &proto.Memory{Shared: true}})
+		require.ErrorIs(t, err, wasm.ErrSnapshotSharedMemoryUnsupported)
+	})
+}
+
 // et is used for tests defined in the enginetest package.
 var et = &engineTester{}
 
@@ -68,7 +410,7 @@ type engineTester struct{}
 
 // NewEngine implements enginetest.EngineTester NewEngine.
 func (e engineTester) NewEngine(enabledFeatures wasm.Features) wasm.Engine {
-	return NewEngine(enabledFeatures)
+	return NewEngine(enabledFeatures, buildoptions.CallStackCeiling)
 }
 
 // InitTables implements enginetest.EngineTester InitTables.
@@ -300,12 +642,12 @@ func TestInterpreter_NonTrappingFloatToIntConversion(t *testing.T) {
 						&interpreterOp{kind: wazeroir.OperationKindBr, us: []uint64{math.MaxUint64}},
 					)
 
-					ce := &callEngine{}
+					ce := &callEngine{callStackCeiling: buildoptions.CallStackCeiling}
 					f := &function{
 						source: &wasm.FunctionInstance{Module: &wasm.ModuleInstance{Engine: &moduleEngine{}}},
 						body:   body,
 					}
-					ce.callNativeFunc(testCtx, &wasm.CallContext{}, f)
+					ce.callNativeFunc(testCtx, &wasm.CallContext{}, f, true)
 
 					if len(tc.expected32bit) > 0 {
 						require.Equal(t, tc.expected32bit[i], int32(uint32(ce.popValue())))
@@ -363,7 +705,7 @@ func TestInterpreter_CallEngine_callNativeFunc_signExtend(t *testing.T) {
 		for _, tt := range tests {
 			tc := tt
 			t.Run(fmt.Sprintf("%s(i32.const(0x%x))", wasm.InstructionName(tc.opcode), tc.in), func(t *testing.T) {
-				ce := &callEngine{}
+				ce := &callEngine{callStackCeiling: buildoptions.CallStackCeiling}
 				f := &function{
 					source: &wasm.FunctionInstance{Module: &wasm.ModuleInstance{Engine: &moduleEngine{}}},
 					body: []*interpreterOp{
@@ -372,7 +714,7 @@ func TestInterpreter_CallEngine_callNativeFunc_signExtend(t *testing.T) {
 						{kind: wazeroir.OperationKindBr, us: []uint64{math.MaxUint64}},
 					},
 				}
-				ce.callNativeFunc(testCtx, &wasm.CallContext{}, f)
+				ce.callNativeFunc(testCtx, &wasm.CallContext{}, f, true)
 				require.Equal(t, tc.expected, int32(uint32(ce.popValue())))
 			})
 		}
@@ -417,7 +759,7 @@ func TestInterpreter_CallEngine_callNativeFunc_signExtend(t *testing.T) {
 		for _, tt := range tests {
 			tc := tt
 			t.Run(fmt.Sprintf("%s(i64.const(0x%x))", wasm.InstructionName(tc.opcode), tc.in), func(t *testing.T) {
-				ce := &callEngine{}
+				ce := &callEngine{callStackCeiling: buildoptions.CallStackCeiling}
 				f := &function{
 					source: &wasm.FunctionInstance{Module: &wasm.ModuleInstance{Engine: &moduleEngine{}}},
 					body: []*interpreterOp{
@@ -426,13 +768,317 @@ func TestInterpreter_CallEngine_callNativeFunc_signExtend(t *testing.T) {
 						{kind: wazeroir.OperationKindBr, us: []uint64{math.MaxUint64}},
 					},
 				}
-				ce.callNativeFunc(testCtx, &wasm.CallContext{}, f)
+				ce.callNativeFunc(testCtx, &wasm.CallContext{}, f, true)
 				require.Equal(t, tc.expected, int64(ce.popValue()))
 			})
 		}
 	})
 }
 
+// snapshotRecorder implements experimental.SnapshotObserver by recording each event.
+type snapshotRecorder struct {
+	events []experimental.SnapshotEvent
+}
+
+// OnSnapshot implements experimental.SnapshotObserver OnSnapshot.
+func (r *snapshotRecorder) OnSnapshot(_ context.Context, event experimental.SnapshotEvent) {
+	r.events = append(r.events, event)
+}
+
+// snapshotFramesRecorder implements experimental.SnapshotObserver by recording a copy of the frames of each snapshot.
+type snapshotFramesRecorder struct {
+	frames [][]wasm.CallFrame
+}
+
+// OnSnapshot implements experimental.SnapshotObserver OnSnapshot.
+func (r *snapshotFramesRecorder) OnSnapshot(ctx context.Context, _ experimental.SnapshotEvent) {
+	frames := ctx.Value("snapshot").(*wasm.Snapshot).Frames
+	r.frames = append(r.frames, append([]wasm.CallFrame(nil), frames...))
+}
+
+func TestInterpreter_CallEngine_callNativeFunc_snapshotGranularity(t *testing.T) {
+	tests := []struct {
+		name           string
+		granularity    wasm.SnapshotGranularity
+		expectedFrames [][]wasm.CallFrame
+	}{
+		{
+			name:        "instruction",
+			granularity: wasm.SnapshotGranularityInstruction,
+			expectedFrames: [][]wasm.CallFrame{
+				{{Pc: 0, FunctionIdx: 0}, {Pc: 1, FunctionIdx: 1}}, // after the br in the callee
+				{{Pc: 0, FunctionIdx: 0}, {Pc: 2, FunctionIdx: 1}}, // after the i32.const
+				{{Pc: 1, FunctionIdx: 0}},                          // on return from the callee
+				{{Pc: 1, FunctionIdx: 0}},                          // after the call
+				nil,                                                // on return from the caller
+			},
+		},
+		{
+			name:        "block",
+			granularity: wasm.SnapshotGranularityBlock,
+			expectedFrames: [][]wasm.CallFrame{
+				{{Pc: 0, FunctionIdx: 0}, {Pc: 1, FunctionIdx: 1}}, // after the br in the callee
+			},
+		},
+		{
+			name:        "function",
+			granularity: wasm.SnapshotGranularityFunction,
+			expectedFrames: [][]wasm.CallFrame{
+				{{Pc: 0, FunctionIdx: 0}},                          // on entry to the caller
+				{{Pc: 0, FunctionIdx: 0}, {Pc: 0, FunctionIdx: 1}}, // on entry to the callee
+				{{Pc: 1, FunctionIdx: 0}},                          // on return from the callee
+				nil,                                                // on return from the caller
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := &snapshotFramesRecorder{}
+			snapshot := &wasm.Snapshot{Granularity: tc.granularity}
+			ctx := context.WithValue(testCtx, "snapshot", snapshot)
+			ctx = context.WithValue(ctx, "always_snapshot", true)
+			ctx = context.WithValue(ctx, "trap_after_snapshot", false)
+			ctx = context.WithValue(ctx, "export_snapshot", false)
+			ctx = context.WithValue(ctx, experimental.SnapshotObserverKey{}, recorder)
+
+			me := &moduleEngine{}
+			moduleInst := &wasm.ModuleInstance{Engine: me}
+			caller := &function{
+				source: &wasm.FunctionInstance{Idx: 0, Module: moduleInst},
+				body: []*interpreterOp{
+					{kind: wazeroir.OperationKindCall, us: []uint64{1}},
+					{kind: wazeroir.OperationKindBr, us: []uint64{math.MaxUint64}},
+				},
+			}
+			callee := &function{
+				source: &wasm.FunctionInstance{Idx: 1, Module: moduleInst},
+				body: []*interpreterOp{
+					{kind: wazeroir.OperationKindBr, us: []uint64{1}},
+					{kind: wazeroir.OperationKindConstI32, us: []uint64{7}},
+					{kind: wazeroir.OperationKindBr, us: []uint64{math.MaxUint64}},
+				},
+			}
+			me.functions = []*function{caller, callee}
+
+			ce := &callEngine{callStackCeiling: buildoptions.CallStackCeiling}
+			ce.callNativeFunc(ctx, wasm.NewCallContext(nil, moduleInst, nil), caller, true)
+			require.Equal(t, uint64(7), ce.popValue())
+			require.Equal(t, tc.expectedFrames, recorder.frames)
+		})
+	}
+}
+
+func TestInterpreter_CallEngine_callNativeFunc_snapshotObserver(t *testing.T) {
+	recorder := &snapshotRecorder{}
+	snapshot := &wasm.Snapshot{}
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "trap_after_snapshot", false)
+	ctx = context.WithValue(ctx, "export_snapshot", false)
+	ctx = context.WithValue(ctx, experimental.SnapshotObserverKey{}, recorder)
+
+	ce := &callEngine{callStackCeiling: buildoptions.CallStackCeiling}
+	f := &function{
+		source: &wasm.FunctionInstance{Idx: 3, Module: &wasm.ModuleInstance{Engine: &moduleEngine{}}},
+		body: []*interpreterOp{
+			{kind: wazeroir.OperationKindConstI32, us: []uint64{42}},
+			{kind: wazeroir.OperationKindNop},
+			{kind: wazeroir.OperationKindBr, us: []uint64{math.MaxUint64}},
+		},
+	}
+	ce.callNativeFunc(ctx, wasm.NewCallContext(nil, f.source.Module, nil), f, true)
+	require.Equal(t, uint64(42), ce.popValue())
+
+	require.Equal(t, 1, len(recorder.events))
+	event := recorder.events[0]
+	require.Equal(t, uint64(2), event.InstructionCount)
+	require.Equal(t, uint32(3), event.FunctionIndex)
+	require.Equal(t, uint64(2), event.Pc)
+	require.False(t, event.Time.IsZero())
+	snapshotPb, err := snapshotProto(snapshot)
+	require.NoError(t, err)
+	require.Equal(t, proto.EncodedSize(snapshotPb), event.Size)
+
+	// The observer only receives metadata, so the snapshot is left as the engine captured it.
+	require.Equal(t, wasm.SnapshotReasonCooperative, snapshot.Reason)
+	require.Equal(t, []wasm.CallFrame{{Pc: 2, FunctionIdx: 3}}, snapshot.Frames)
+	require.Equal(t, []uint64{42}, snapshot.Stack)
+}
+
+func TestInterpreter_CallEngine_callNativeFunc_snapshotModeHeap(t *testing.T) {
+	snapshot := &wasm.Snapshot{Mode: wasm.SnapshotModeHeap}
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "trap_after_snapshot", false)
+	ctx = context.WithValue(ctx, "export_snapshot", false)
+
+	global := &wasm.GlobalInstance{Type: &wasm.GlobalType{ValType: wasm.ValueTypeI32}, Val: 7}
+	memory := &wasm.MemoryInstance{Buffer: make([]byte, wasm.MemoryPageSize), Min: 1, Cap: 1, Max: 1}
+	ce := &callEngine{callStackCeiling: buildoptions.CallStackCeiling}
+	f := &function{
+		source: &wasm.FunctionInstance{Module: &wasm.ModuleInstance{
+			Engine:  &moduleEngine{},
+			Globals: []*wasm.GlobalInstance{global},
+			Memory:  memory,
+		}},
+		body: []*interpreterOp{
+			{kind: wazeroir.OperationKindConstI32, us: []uint64{42}},
+			{kind: wazeroir.OperationKindNop},
+			{kind: wazeroir.OperationKindBr, us: []uint64{math.MaxUint64}},
+		},
+	}
+	ce.callNativeFunc(ctx, wasm.NewCallContext(nil, f.source.Module, nil), f, true)
+
+	require.True(t, snapshot.Valid)
+	require.Nil(t, snapshot.Frames)
+	require.Nil(t, snapshot.Stack)
+	require.Equal(t, []*wasm.GlobalInstance{global}, snapshot.Globals)
+	require.Equal(t, memory, snapshot.Memory)
+}
+
+// indexExternRefCodec implements wasm.ExternRefCodec by encoding each externref as its 1-based index in refs.
+type indexExternRefCodec struct {
+	refs []uintptr
+}
+
+// EncodeExternRef implements wasm.ExternRefCodec EncodeExternRef.
+func (c *indexExternRefCodec) EncodeExternRef(ref uintptr) (uint64, error) {
+	for i, r := range c.refs {
+		if r == ref {
+			return uint64(i + 1), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown externref %#x", ref)
+}
+
+// DecodeExternRef implements wasm.ExternRefCodec DecodeExternRef.
+func (c *indexExternRefCodec) DecodeExternRef(encoded uint64) (uintptr, error) {
+	return c.refs[encoded-1], nil
+}
+
+func TestInterpreter_CallEngine_callNativeFunc_snapshotExternRefs(t *testing.T) {
+	snapshot := &wasm.Snapshot{Mode: wasm.SnapshotModeHeap, ExternRefCodec: &indexExternRefCodec{refs: []uintptr{0xbeef}}}
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "trap_after_snapshot", false)
+	ctx = context.WithValue(ctx, "export_snapshot", false)
+
+	externref := &wasm.GlobalType{ValType: wasm.ValueTypeExternref, Mutable: true}
+	global := &wasm.GlobalInstance{Type: externref, Val: 0xbeef}
+	ce := &callEngine{callStackCeiling: buildoptions.CallStackCeiling}
+	f := &function{
+		source: &wasm.FunctionInstance{Module: &wasm.ModuleInstance{
+			Engine:  &moduleEngine{},
+			Globals: []*wasm.GlobalInstance{global},
+		}},
+		body: []*interpreterOp{
+			{kind: wazeroir.OperationKindNop},
+			{kind: wazeroir.OperationKindBr, us: []uint64{math.MaxUint64}},
+		},
+	}
+	ce.callNativeFunc(ctx, wasm.NewCallContext(nil, f.source.Module, nil), f, true)
+
+	require.True(t, snapshot.Valid)
+	require.Equal(t, []*wasm.GlobalInstance{{Type: externref, Val: 1}}, snapshot.Globals)
+	require.Equal(t, uint64(0xbeef), global.Val) // the module's global is not encoded.
+}
+
+func TestInterpreter_CallEngine_callNativeFunc_snapshotExternRefs_Error(t *testing.T) {
+	snapshot := &wasm.Snapshot{Mode: wasm.SnapshotModeHeap, ExternRefCodec: &indexExternRefCodec{}}
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "trap_after_snapshot", false)
+	ctx = context.WithValue(ctx, "export_snapshot", true)
+
+	externref := &wasm.GlobalType{ValType: wasm.ValueTypeExternref, Mutable: true}
+	ce := &callEngine{callStackCeiling: buildoptions.CallStackCeiling}
+	f := &function{
+		source: &wasm.FunctionInstance{Module: &wasm.ModuleInstance{
+			Engine:  &moduleEngine{},
+			Globals: []*wasm.GlobalInstance{{Type: externref, Val: 0xbeef}},
+		}},
+		body: []*interpreterOp{
+			{kind: wazeroir.OperationKindNop},
+			{kind: wazeroir.OperationKindBr, us: []uint64{math.MaxUint64}},
+		},
+	}
+	// The call stops with a snapshot error, rather than crash.
+	err := require.CapturePanic(func() {
+		ce.callNativeFunc(ctx, wasm.NewCallContext(nil, f.source.Module, nil), f, true)
+	})
+	require.Equal(t, wasmruntime.ErrRuntimeSnapshot, err)
+
+	require.False(t, snapshot.Valid)
+	require.EqualError(t, snapshot.Err, "failed to encode externref global[0]: unknown externref 0xbeef")
+	require.Zero(t, snapshot.Generation) // not exported
+	_, err = f.source.CallWithHeap(testCtx, snapshot)
+	require.ErrorIs(t, err, snapshot.Err)
+}
+
+func TestInterpreter_CallEngine_callNativeFunc_contextDone(t *testing.T) {
+	newLoop := func() *function {
+		return &function{
+			source: &wasm.FunctionInstance{Module: &wasm.ModuleInstance{Engine: &moduleEngine{}}},
+			body: []*interpreterOp{
+				{kind: wazeroir.OperationKindConstI32, us: []uint64{42}},
+				{kind: wazeroir.OperationKindDrop, rs: []*wazeroir.InclusiveRange{{Start: 0, End: 0}}},
+				{kind: wazeroir.OperationKindBr, us: []uint64{0}}, // infinite loop
+			},
+		}
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(testCtx)
+		cancel()
+
+		ce := &callEngine{callStackCeiling: buildoptions.CallStackCeiling}
+		f := newLoop()
+		err := require.CapturePanic(func() {
+			ce.callNativeFunc(ctx, wasm.NewCallContext(nil, f.source.Module, nil), f, true)
+		})
+		require.Equal(t, context.Canceled, err)
+		require.Equal(t, uint64(2), ce.instructionCount) // interrupted before the branch
+	})
+
+	t.Run("forward branch", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(testCtx)
+		cancel()
+
+		ce := &callEngine{callStackCeiling: buildoptions.CallStackCeiling}
+		f := newLoop()
+		f.body = append([]*interpreterOp{{kind: wazeroir.OperationKindBr, us: []uint64{1}}}, f.body...)
+		f.body[3].us[0] = 1 // loop back to the const
+		err := require.CapturePanic(func() {
+			ce.callNativeFunc(ctx, wasm.NewCallContext(nil, f.source.Module, nil), f, true)
+		})
+		require.Equal(t, context.Canceled, err)
+		require.Equal(t, uint64(3), ce.instructionCount) // not interrupted before the forward branch, as it terminates
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		snapshot := &wasm.Snapshot{}
+		ctx := context.WithValue(testCtx, "snapshot", snapshot)
+		ctx = context.WithValue(ctx, "export_snapshot", false)
+		ctx, cancel := context.WithTimeout(ctx, time.Nanosecond)
+		defer cancel()
+		<-ctx.Done()
+
+		ce := &callEngine{callStackCeiling: buildoptions.CallStackCeiling}
+		f := newLoop()
+		err := require.CapturePanic(func() {
+			ce.callNativeFunc(ctx, wasm.NewCallContext(nil, f.source.Module, nil), f, true)
+		})
+		require.Equal(t, context.DeadlineExceeded, err)
+		require.True(t, snapshot.Valid)
+		require.Equal(t, wasm.SnapshotReasonInterrupt, snapshot.Reason)
+		require.Equal(t, 1, len(snapshot.Frames))
+		require.Equal(t, uint64(2), snapshot.Frames[0].Pc) // resumes at the branch
+	})
+}
+
 func TestInterpreter_Compile(t *testing.T) {
 	t.Run("uncompiled", func(t *testing.T) {
 		e := et.NewEngine(wasm.Features20191205).(*engine)
@@ -513,3 +1159,492 @@ func TestEngine_CachedcodesPerModule(t *testing.T) {
 	_, ok = e.getCodes(m)
 	require.False(t, ok)
 }
+
+func TestInterpreter_snapshotProto_UnknownValueType(t *testing.T) {
+	t.Run("global", func(t *testing.T) {
+		_, err := snapshotProto(&wasm.Snapshot{Globals: []*wasm.GlobalInstance{
+			{Type: &wasm.GlobalType{ValType: wasm.ValueTypeI32}},
+			{Type: &wasm.GlobalType{ValType: 0x42}},
+		}})
+		require.EqualError(t, err, "global[1]: unknown value type 0x42")
+	})
+	t.Run("stack type", func(t *testing.T) {
+		_, err := snapshotProto(&wasm.Snapshot{StackTypes: []wasm.ValueType{0x42}})
+		require.EqualError(t, err, "stack type[0]: unknown value type 0x42")
+	})
+	t.Run("i32 is explicit", func(t *testing.T) {
+		snapshotPb, err := snapshotProto(&wasm.Snapshot{StackTypes: []wasm.ValueType{wasm.ValueTypeI32}})
+		require.NoError(t, err)
+		require.Equal(t, []proto.ValueType{proto.ValueType_I32}, snapshotPb.StackTypes)
+	})
+}
+
+// TestInterpreter_snapshotProto_RoundTrip ensures the snapshot read back from an export is the one written, so that
+// exporting and reading snapshots don't drift as fields are added.
+func TestInterpreter_snapshotProto_RoundTrip(t *testing.T) {
+	// sparse memory: only a few bytes are set, on pages apart from each other.
+	buffer := make([]byte, 3*wasm.MemoryPageSize)
+	buffer[1] = 0xa
+	buffer[2*wasm.MemoryPageSize+7] = 0xb
+
+	var globals []*wasm.GlobalInstance
+	for i, vt := range []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI64, wasm.ValueTypeF32, wasm.ValueTypeF64,
+		wasm.ValueTypeV128, wasm.ValueTypeFuncref, wasm.ValueTypeExternref} {
+		globals = append(globals, &wasm.GlobalInstance{
+			Type:  &wasm.GlobalType{ValType: vt, Mutable: i%2 == 0},
+			Val:   uint64(i + 1),
+			ValHi: uint64(i + 100),
+		})
+	}
+
+	tests := []struct {
+		name     string
+		snapshot *wasm.Snapshot
+	}{
+		{
+			name:     "empty",
+			snapshot: &wasm.Snapshot{Valid: true},
+		},
+		{
+			name:     "empty args and environ",
+			snapshot: &wasm.Snapshot{Valid: true, Args: []string{}, Environ: []string{}},
+		},
+		{
+			name: "representative",
+			snapshot: &wasm.Snapshot{
+				Valid:            true,
+				Stack:            []uint64{1, 2, 3, 4},
+				StackTypes:       []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeV128, wasm.ValueTypeF64},
+				Globals:          globals,
+				Frames:           []wasm.CallFrame{{Pc: 3, FunctionIdx: 0}, {Pc: 0, FunctionIdx: 2}, {Pc: 17, FunctionIdx: 1}},
+				Memory:           &wasm.MemoryInstance{Buffer: buffer, Min: 3, Cap: 4, Max: 10},
+				Closed:           1 + 2<<32,
+				InstructionCount: 1234,
+				StdoutWritten:    5,
+				StderrWritten:    6,
+				StdinRead:        7,
+				RandRead:         9,
+				Walltime:         -10, // before the epoch
+				Nanotime:         11,
+				Generation:       12,
+				Args:             []string{"wasi", "-v"},
+				Environ:          []string{"a=b"},
+				LastFD:           6, // the last open file, as closed ones aren't exported.
+				Reason:           wasm.SnapshotReasonYield,
+				YieldTag:         8,
+				OpenedFiles: map[uint32]*sys.FileEntry{
+					3: {Path: "/"}, 5: {Path: "/"}, 6: {Path: "/tmp/a.txt", File: sys.UnopenedFile, Offset: 42},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			snapshotPb, err := snapshotProto(tc.snapshot)
+			require.NoError(t, err)
+			var out bytes.Buffer
+			require.NoError(t, proto.WriteSnapshot(&out, snapshotPb))
+
+			snapshot, err := ReadSnapshot(&out)
+			require.NoError(t, err)
+			require.SnapshotEqual(t, tc.snapshot, snapshot)
+		})
+	}
+
+	t.Run("not exported", func(t *testing.T) {
+		// These are set by the caller before execution, or not exported yet, so are expected to be zero once read.
+		snapshotPb, err := snapshotProto(&wasm.Snapshot{
+			Mode:         wasm.SnapshotModeHeap,
+			Granularity:  wasm.SnapshotGranularityFunction,
+			Valid:        true,
+			ResumeValue:  3,
+			LastFD:       4,
+			MemoryRanges: []*wasm.MemoryRange{{Offset: 1, Data: []byte{2}}},
+		})
+		require.NoError(t, err)
+		snapshot, err := snapshotFromProto(snapshotPb)
+		require.NoError(t, err)
+		require.SnapshotEqual(t, &wasm.Snapshot{Valid: true}, snapshot)
+	})
+}
+
+func TestInterpreter_snapshotFromProto_Preopens(t *testing.T) {
+	t.Run("feature", func(t *testing.T) {
+		file, err := fstest.MapFS{"a.txt": {}}.Open("a.txt")
+		require.NoError(t, err)
+
+		snapshotPb, err := snapshotProto(&wasm.Snapshot{OpenedFiles: map[uint32]*sys.FileEntry{
+			4: {Path: "/"}, 3: {Path: "/"}, 5: {Path: "a.txt", File: file},
+		}})
+		require.NoError(t, err)
+		require.Equal(t, proto.FeaturePreopens, snapshotPb.Features&proto.FeaturePreopens)
+		require.Equal(t, 2, len(snapshotPb.Preopens))
+		require.Equal(t, uint32(3), snapshotPb.Preopens[0].Fd) // ordered by fd
+		require.Equal(t, uint32(4), snapshotPb.Preopens[1].Fd)
+
+		snapshotPb, err = snapshotProto(&wasm.Snapshot{})
+		require.NoError(t, err)
+		require.Zero(t, snapshotPb.Features&proto.FeaturePreopens)
+	})
+
+	t.Run("not root", func(t *testing.T) {
+		_, err := snapshotProto(&wasm.Snapshot{OpenedFiles: map[uint32]*sys.FileEntry{4: {Path: "/tmp"}}})
+		require.EqualError(t, err, `preopen at fd 4 is "/tmp", but only the root "/" is supported`)
+	})
+
+	tests := []struct {
+		name        string
+		preopens    []*proto.Preopen
+		expectedErr string
+	}{
+		{
+			name:        "stdio",
+			preopens:    []*proto.Preopen{{Fd: 2, Path: "/"}},
+			expectedErr: "preopen[0] has fd 2, which is reserved for stdio",
+		},
+		{
+			name:        "duplicate",
+			preopens:    []*proto.Preopen{{Fd: 3, Path: "/"}, {Fd: 3, Path: "/"}},
+			expectedErr: "preopen[1] has fd 3, which is already preopened",
+		},
+		{
+			name:        "not root",
+			preopens:    []*proto.Preopen{{Fd: 3, Path: "/"}, {Fd: 4, Path: "/tmp"}},
+			expectedErr: `preopen[1] is "/tmp", but only the root "/" is supported`,
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := snapshotFromProto(&proto.Snapshot{Valid: true, Preopens: tc.preopens})
+			require.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestInterpreter_snapshotFromProto_OpenFiles(t *testing.T) {
+	t.Run("feature", func(t *testing.T) {
+		file, err := fstest.MapFS{"a.txt": {Data: []byte("abc")}}.Open("a.txt")
+		require.NoError(t, err)
+
+		snapshotPb, err := snapshotProto(&wasm.Snapshot{OpenedFiles: map[uint32]*sys.FileEntry{
+			3: {Path: "/"}, 5: {Path: "a.txt", File: file, Offset: 2}, 4: {Path: "a.txt", File: file},
+		}})
+		require.NoError(t, err)
+		require.Equal(t, proto.FeatureFDs, snapshotPb.Features&proto.FeatureFDs)
+		require.Equal(t, 2, len(snapshotPb.OpenFiles))
+		require.Equal(t, uint32(4), snapshotPb.OpenFiles[0].Fd) // ordered by fd
+		require.Equal(t, int64(2), snapshotPb.OpenFiles[1].Offset)
+
+		snapshotPb, err = snapshotProto(&wasm.Snapshot{OpenedFiles: map[uint32]*sys.FileEntry{3: {Path: "/"}}})
+		require.NoError(t, err)
+		require.Zero(t, snapshotPb.Features&proto.FeatureFDs)
+	})
+
+	tests := []struct {
+		name        string
+		snapshotPb  *proto.Snapshot
+		expectedErr string
+	}{
+		{
+			name:        "stdio",
+			snapshotPb:  &proto.Snapshot{OpenFiles: []*proto.OpenFile{{Fd: 0, Path: "a.txt"}}},
+			expectedErr: "open file[0] has fd 0, which is reserved for stdio",
+		},
+		{
+			name: "preopened",
+			snapshotPb: &proto.Snapshot{
+				Preopens:  []*proto.Preopen{{Fd: 3, Path: "/"}},
+				OpenFiles: []*proto.OpenFile{{Fd: 4, Path: "a.txt"}, {Fd: 3, Path: "b.txt"}},
+			},
+			expectedErr: "open file[1] has fd 3, which is already open",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			tc.snapshotPb.Valid = true
+			_, err := snapshotFromProto(tc.snapshotPb)
+			require.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+// TestInterpreter_valueTypeProto_Complete ensures each value type of the protobuf enum maps to a value type and back,
+// so that a type added to one but not the other is noticed.
+func TestInterpreter_valueTypeProto_Complete(t *testing.T) {
+	require.Equal(t, len(proto.ValueType_name), len(valueTypesProto))
+	for v, name := range proto.ValueType_name {
+		tPb := proto.ValueType(v)
+		vt, err := valueTypeFromProto(tPb)
+		require.NoError(t, err, name)
+		actual, err := valueTypeProto(vt)
+		require.NoError(t, err, name)
+		require.Equal(t, tPb, actual, name)
+	}
+}
+
+func TestInterpreter_LoadSnapshotFS(t *testing.T) {
+	snapshot := &wasm.Snapshot{
+		Valid:  true,
+		Stack:  []uint64{1},
+		Frames: []wasm.CallFrame{{Pc: 2, FunctionIdx: 3}},
+		Memory: &wasm.MemoryInstance{Buffer: make([]byte, wasm.MemoryPageSize), Min: 1, Cap: 1, Max: 1},
+	}
+	snapshotPb, err := snapshotProto(snapshot)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, proto.WriteSnapshot(&out, snapshotPb))
+	fsys := fstest.MapFS{"snapshots/snapshot.bin": {Data: out.Bytes()}, "empty.bin": {}}
+
+	loaded, err := LoadSnapshotFS(fsys, "snapshots/snapshot.bin")
+	require.NoError(t, err)
+	require.SnapshotEqual(t, snapshot, loaded)
+
+	_, err = LoadSnapshotFS(fsys, "missing.bin")
+	require.EqualError(t, err, "open missing.bin: file does not exist")
+
+	_, err = LoadSnapshotFS(fsys, "empty.bin")
+	require.EqualError(t, err, "failed to read snapshot: EOF")
+}
+
+// TestInterpreter_ReadSnapshot_ResumeTwice ensures resuming doesn't mutate the snapshot, so that one read once can be
+// resumed again from the same state, e.g. to retry.
+func TestInterpreter_ReadSnapshot_ResumeTwice(t *testing.T) {
+	memory := wasm.NewMemoryInstance(&wasm.Memory{Min: 1, Cap: 1, Max: 1})
+	moduleInst := &wasm.ModuleInstance{Memory: memory}
+	f := &function{
+		source: &wasm.FunctionInstance{
+			Module: moduleInst,
+			Type:   &wasm.FunctionType{Results: []wasm.ValueType{wasm.ValueTypeI32}, ResultNumInUint64: 1},
+		},
+		body: []*interpreterOp{
+			{kind: wazeroir.OperationKindAdd, b1: byte(wazeroir.UnsignedTypeI32)}, // pushes the sum where 10 was.
+			{kind: wazeroir.OperationKindBr, us: []uint64{math.MaxUint64}},
+		},
+	}
+	me := &moduleEngine{parentEngine: &engine{callStackCeiling: buildoptions.CallStackCeiling}, functions: []*function{f}}
+	moduleInst.Engine = me
+	callCtx := wasm.NewCallContext(nil, moduleInst, sys.DefaultContext(nil))
+
+	var out bytes.Buffer
+	require.NoError(t, WriteSnapshot(&out, &wasm.Snapshot{
+		Valid:  true,
+		Stack:  []uint64{10, 5},
+		Frames: []wasm.CallFrame{wasm.NewCallFrame(0, 0)},
+		Memory: &wasm.MemoryInstance{Buffer: make([]byte, wasm.MemoryPageSize), Min: 1, Cap: 1, Max: 1},
+	}))
+	snapshot, err := ReadSnapshot(&out)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		results, err := me.Resume(testCtx, callCtx, f.source, snapshot)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{15}, results)
+		require.Equal(t, byte(0), memory.Buffer[0])
+
+		memory.Buffer[0] = 0xff // as the guest could, after the snapshot.
+	}
+	require.Equal(t, []uint64{10, 5}, snapshot.Stack)
+	require.Equal(t, byte(0), snapshot.Memory.Buffer[0])
+}
+
+func TestInterpreter_WriteSnapshot(t *testing.T) {
+	buffer := make([]byte, wasm.MemoryPageSize)
+	buffer[1] = 0xa
+	snapshot := &wasm.Snapshot{
+		Valid:  true,
+		Stack:  []uint64{1},
+		Frames: []wasm.CallFrame{wasm.NewCallFrame(3, 2)},
+		Memory: &wasm.MemoryInstance{Buffer: buffer, Min: 1, Cap: 1, Max: 1},
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, WriteSnapshot(&out, snapshot))
+		require.Equal(t, uint64(1), snapshot.Generation) // counts the export
+		read, err := ReadSnapshot(&out)
+		require.NoError(t, err)
+		require.SnapshotEqual(t, snapshot, read)
+	})
+
+	t.Run("transformed", func(t *testing.T) {
+		transformed := snapshot.Clone()
+		transformed.PageTransform = xorPageTransform(0xff)
+		var out bytes.Buffer
+		require.NoError(t, WriteSnapshot(&out, transformed))
+		read, err := ReadSnapshotTransform(&out, xorPageTransform(0xff))
+		require.NoError(t, err)
+		transformed.PageTransform = nil // set by the caller, so not read.
+		require.SnapshotEqual(t, transformed, read)
+	})
+
+	t.Run("unknown value type", func(t *testing.T) {
+		unknown := &wasm.Snapshot{Valid: true, StackTypes: []wasm.ValueType{0x42}}
+		err := WriteSnapshot(&bytes.Buffer{}, unknown)
+		require.EqualError(t, err, "failed to encode snapshot: stack type[0]: unknown value type 0x42")
+		require.Zero(t, unknown.Generation) // not exported
+	})
+}
+
+// xorPageTransform xors each byte of a page with key.
+type xorPageTransform byte
+
+func (x xorPageTransform) Transform(_ int, data []byte) ([]byte, error) {
+	ret := make([]byte, len(data))
+	for i, b := range data {
+		ret[i] = b ^ byte(x)
+	}
+	return ret, nil
+}
+
+func (x xorPageTransform) Invert(pageIndex int, data []byte) ([]byte, error) {
+	return x.Transform(pageIndex, data)
+}
+
+func TestInterpreter_ReadSnapshotTransform(t *testing.T) {
+	buffer := make([]byte, 2*wasm.MemoryPageSize)
+	buffer[wasm.MemoryPageSize+1] = 0xa
+	snapshot := &wasm.Snapshot{
+		Valid:         true,
+		Stack:         []uint64{1},
+		Frames:        []wasm.CallFrame{{Pc: 2, FunctionIdx: 3}},
+		Memory:        &wasm.MemoryInstance{Buffer: buffer, Min: 2, Cap: 2, Max: 2},
+		PageTransform: xorPageTransform(0xff),
+	}
+	snapshotPb, err := snapshotProto(snapshot)
+	require.NoError(t, err)
+	// The transformed memory is not aligned, as it can't be mapped.
+	require.Equal(t, proto.FeatureMemoryTransform, snapshotPb.Features)
+	require.Equal(t, uint32(0), snapshotPb.MemoryAlignment)
+	var out bytes.Buffer
+	require.NoError(t, proto.WriteSnapshotTransform(&out, snapshotPb, snapshot.PageTransform))
+	encoded := out.Bytes()
+	require.False(t, bytes.Contains(encoded, make([]byte, 16))) // zero pages are written xored.
+
+	read, err := ReadSnapshotTransform(bytes.NewReader(encoded), xorPageTransform(0xff))
+	require.NoError(t, err)
+	snapshot.PageTransform = nil // set by the caller, so not read.
+	require.SnapshotEqual(t, snapshot, read)
+
+	_, err = ReadSnapshot(bytes.NewReader(encoded))
+	require.EqualError(t, err, "snapshot memory is transformed, but no transform is given")
+}
+
+func TestInterpreter_MapSnapshot(t *testing.T) {
+	buffer := make([]byte, 2*wasm.MemoryPageSize)
+	buffer[wasm.MemoryPageSize+1] = 0xa
+	snapshot := &wasm.Snapshot{
+		Valid:  true,
+		Stack:  []uint64{1},
+		Frames: []wasm.CallFrame{{Pc: 2, FunctionIdx: 3}},
+		Memory: &wasm.MemoryInstance{Buffer: buffer, Min: 2, Cap: 2, Max: 2},
+	}
+	// The memory isn't aligned by default, so the layout of an export doesn't change unless asked to.
+	snapshotPb, err := snapshotProto(snapshot)
+	require.NoError(t, err)
+	require.Equal(t, proto.Feature(0), snapshotPb.Features)
+	require.Equal(t, uint32(0), snapshotPb.MemoryAlignment)
+
+	snapshot.AlignMemory = true
+	snapshotPb, err = snapshotProto(snapshot)
+	require.NoError(t, err)
+	require.Equal(t, proto.FeatureMemoryAlignment, snapshotPb.Features)
+	f, err := os.Create(path.Join(t.TempDir(), "snapshot.bin"))
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, proto.WriteSnapshot(f, snapshotPb))
+
+	mapped, release, err := MapSnapshot(f)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, release()) }()
+
+	// The memory is shared on resume when it was mapped, which depends on the platform.
+	snapshot.ShareMemory = mapped.ShareMemory
+	snapshot.AlignMemory = false // set by the caller, so not read.
+	require.SnapshotEqual(t, snapshot, mapped)
+}
+
+// TestInterpreter_Snapshot_EstimateSize ensures wasm.Snapshot EstimateSize stays within a small factor of the size the
+// snapshot takes once exported.
+func TestInterpreter_Snapshot_EstimateSize(t *testing.T) {
+	i64 := &wasm.GlobalType{ValType: wasm.ValueTypeI64, Mutable: true}
+	var largeStack, frames []uint64
+	for i := 0; i < 1000; i++ {
+		largeStack = append(largeStack, math.MaxUint64-uint64(i))
+		frames = append(frames, uint64(i))
+	}
+	var manyFrames []wasm.CallFrame
+	for i := range frames {
+		manyFrames = append(manyFrames, wasm.CallFrame{Pc: uint64(i) * 1000, FunctionIdx: uint32(i)})
+	}
+
+	tests := []struct {
+		name     string
+		snapshot *wasm.Snapshot
+	}{
+		{name: "empty", snapshot: &wasm.Snapshot{Valid: true}},
+		{name: "small stack", snapshot: &wasm.Snapshot{Valid: true, Stack: frames}},
+		{name: "large stack", snapshot: &wasm.Snapshot{Valid: true, Stack: largeStack}},
+		{name: "frames", snapshot: &wasm.Snapshot{Valid: true, Frames: manyFrames}},
+		{
+			name: "globals",
+			snapshot: &wasm.Snapshot{Valid: true, Globals: []*wasm.GlobalInstance{
+				{Type: i64, Val: 1}, {Type: i64, Val: math.MaxUint64}, {Type: i64, Val: 1 << 40, ValHi: 3},
+			}},
+		},
+		{
+			name:     "preopens",
+			snapshot: &wasm.Snapshot{Valid: true, LastFD: 3, OpenedFiles: map[uint32]*sys.FileEntry{3: {Path: "/"}}},
+		},
+		{
+			name: "memory",
+			snapshot: &wasm.Snapshot{
+				Valid:            true,
+				Stack:            []uint64{1, 2},
+				Frames:           []wasm.CallFrame{{Pc: 3}},
+				Memory:           &wasm.MemoryInstance{Buffer: make([]byte, 2*wasm.MemoryPageSize), Min: 2, Cap: 4, Max: 100},
+				Closed:           1,
+				InstructionCount: 123456,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			snapshotPb, err := snapshotProto(tc.snapshot)
+			require.NoError(t, err)
+			actual := proto.EncodedSize(snapshotPb)
+			estimate := tc.snapshot.EstimateSize()
+			require.True(t, estimate >= actual/2 && estimate <= actual*2, "estimated %d bytes, but was %d", estimate, actual)
+		})
+	}
+}
+
+func TestInterpreter_snapshotFromProto_UnknownValueType(t *testing.T) {
+	t.Run("global", func(t *testing.T) {
+		_, err := snapshotFromProto(&proto.Snapshot{Globals: []*proto.Global{{Type: proto.ValueType_I32}, {Type: 42}}})
+		require.EqualError(t, err, "global[1]: unknown value type 42")
+	})
+	t.Run("stack type", func(t *testing.T) {
+		_, err := snapshotFromProto(&proto.Snapshot{StackTypes: []proto.ValueType{42}})
+		require.EqualError(t, err, "stack type[0]: unknown value type 42")
+	})
+}
+
+func TestInterpreter_snapshotFromProto_ImplausibleFrame(t *testing.T) {
+	_, err := snapshotFromProto(&proto.Snapshot{Frames: []*proto.Frame{
+		{FunctionIndex: 1, Pc: 2},
+		{FunctionIndex: wasm.MaximumFunctionIndex},
+	}})
+	require.EqualError(t, err, "frame[1] has function index 134217728, past the maximum of 134217727")
+}
@@ -0,0 +1,102 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/internal/engine/interpreter"
+	"github.com/tetratelabs/wazero/internal/testing/require"
+	"github.com/tetratelabs/wazero/internal/wasm"
+	binaryformat "github.com/tetratelabs/wazero/internal/wasm/binary"
+	"github.com/tetratelabs/wazero/internal/wasmruntime"
+)
+
+// Non-canonical NaNs: signaling, with a payload, which canonicalizing would replace with the quiet NaN.
+const (
+	nanF32 uint32 = 0x7fa0_0001
+	nanF64 uint64 = 0x7ff4_0000_0000_0001
+	// negNanF64 is also non-canonical for its sign bit.
+	negNanF64 uint64 = 0xfff8_0000_0000_0123
+)
+
+// TestResume_NaNBits ensures the bit patterns of NaNs in float globals and on the stack are those the guest stored once
+// a snapshot is exported, read back and resumed, as resuming isn't bit-exact otherwise.
+func TestResume_NaNBits(t *testing.T) {
+	f32, f64, i32 := wasm.ValueTypeF32, wasm.ValueTypeF64, wasm.ValueTypeI32
+
+	var body []byte
+	body = append(body, f32Const(nanF32)...)
+	body = append(body, wasm.OpcodeGlobalSet, 0)
+	body = append(body, f64Const(negNanF64)...)
+	body = append(body, wasm.OpcodeGlobalSet, 1)
+	body = append(body, f64Const(nanF64)...) // the result, on the stack at the snapshot.
+	body = append(body, wasm.OpcodeI32Const, 0, wasm.OpcodeCall, 0, wasm.OpcodeDrop, wasm.OpcodeEnd)
+
+	r := wazero.NewRuntimeWithConfig(wazero.NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	_, err := r.NewModuleBuilder(wasm.YieldModuleName).
+		ExportFunction(wasm.YieldFunctionName, wasm.Yield).
+		Instantiate(testCtx, r)
+	require.NoError(t, err)
+
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection: []*wasm.FunctionType{{Params: []wasm.ValueType{i32}, Results: []wasm.ValueType{i32}}, {Results: []wasm.ValueType{f64}}},
+		ImportSection: []*wasm.Import{
+			{Type: wasm.ExternTypeFunc, Module: wasm.YieldModuleName, Name: wasm.YieldFunctionName, DescFunc: 0},
+		},
+		FunctionSection: []wasm.Index{1},
+		CodeSection:     []*wasm.Code{{Body: body}},
+		GlobalSection: []*wasm.Global{
+			{Type: &wasm.GlobalType{ValType: f32, Mutable: true}, Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeF32Const, Data: make([]byte, 4)}},
+			{Type: &wasm.GlobalType{ValType: f64, Mutable: true}, Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeF64Const, Data: make([]byte, 8)}},
+		},
+		ExportSection: []*wasm.Export{
+			{Type: wasm.ExternTypeFunc, Name: "run", Index: 1},
+			{Type: wasm.ExternTypeGlobal, Name: "f32", Index: 0},
+			{Type: wasm.ExternTypeGlobal, Name: "f64", Index: 1},
+		},
+	}), wazero.NewCompileConfig())
+	require.NoError(t, err)
+
+	snapshot := &wasm.Snapshot{}
+	ctx := wasm.WithSnapshotOptions(testCtx, wasm.SnapshotOptions{Snapshot: snapshot})
+	m, err := r.InstantiateModule(ctx, code, wazero.NewModuleConfig().WithName("snapshot"))
+	require.NoError(t, err)
+	_, err = m.ExportedFunction("run").Call(ctx)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+	require.NoError(t, m.Close(ctx))
+
+	var exported bytes.Buffer
+	require.NoError(t, interpreter.WriteSnapshot(&exported, snapshot))
+	read, err := interpreter.ReadSnapshot(&exported)
+	require.NoError(t, err)
+	require.Equal(t, uint64(nanF32), read.Globals[0].Val)
+	require.Equal(t, negNanF64, read.Globals[1].Val)
+	require.Equal(t, nanF64, read.Stack[0])
+
+	m, err = r.InstantiateModule(testCtx, code, wazero.NewModuleConfig().WithName("resumed"))
+	require.NoError(t, err)
+	defer m.Close(testCtx)
+	results, _, err := m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(testCtx, read)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{nanF64}, results)
+	require.Equal(t, uint64(nanF32), m.ExportedGlobal("f32").Get(testCtx))
+	require.Equal(t, negNanF64, m.ExportedGlobal("f64").Get(testCtx))
+}
+
+// f32Const returns the f32.const instruction of the bits.
+func f32Const(bits uint32) []byte {
+	ret := []byte{wasm.OpcodeF32Const, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(ret[1:], bits)
+	return ret
+}
+
+// f64Const returns the f64.const instruction of the bits.
+func f64Const(bits uint64) []byte {
+	ret := []byte{wasm.OpcodeF64Const, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint64(ret[1:], bits)
+	return ret
+}
@@ -0,0 +1,130 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/internal/testing/replay"
+	"github.com/tetratelabs/wazero/internal/testing/require"
+	"github.com/tetratelabs/wazero/internal/wasm"
+	binaryformat "github.com/tetratelabs/wazero/internal/wasm/binary"
+)
+
+// testCtx is an arbitrary, non-default context. Non-nil also prevents linter errors.
+var testCtx = context.WithValue(context.Background(), struct{}{}, "arbitrary")
+
+var i32 = wasm.ValueTypeI32
+
+// loops adds j to the memory at offset zero in an inner loop of 3 iterations, nested in an outer loop of as many as its
+// param, and returns i*10+j.
+var loops = &wasm.Module{
+	TypeSection:     []*wasm.FunctionType{{Params: []wasm.ValueType{i32}, Results: []wasm.ValueType{i32}, ParamNumInUint64: 1, ResultNumInUint64: 1}},
+	FunctionSection: []wasm.Index{0},
+	CodeSection: []*wasm.Code{{LocalTypes: []wasm.ValueType{i32, i32}, Body: []byte{
+		wasm.OpcodeLoop, 0x40,
+		wasm.OpcodeLocalGet, 1, wasm.OpcodeI32Const, 1, wasm.OpcodeI32Add, wasm.OpcodeLocalSet, 1, // i++
+		wasm.OpcodeI32Const, 0, wasm.OpcodeLocalSet, 2, // j = 0
+		wasm.OpcodeLoop, 0x40,
+		wasm.OpcodeI32Const, 0,
+		wasm.OpcodeI32Const, 0, wasm.OpcodeI32Load, 0x2, 0x0, wasm.OpcodeLocalGet, 2, wasm.OpcodeI32Add,
+		wasm.OpcodeI32Store, 0x2, 0x0, // mem[0] += j
+		wasm.OpcodeLocalGet, 2, wasm.OpcodeI32Const, 1, wasm.OpcodeI32Add, wasm.OpcodeLocalTee, 2, // j++
+		wasm.OpcodeI32Const, 3, wasm.OpcodeI32LtU, wasm.OpcodeBrIf, 0, // j < 3
+		wasm.OpcodeLocalGet, 1, wasm.OpcodeLocalGet, 0, wasm.OpcodeI32LtU, wasm.OpcodeBrIf, 1, // i < n
+		wasm.OpcodeEnd,
+		wasm.OpcodeEnd,
+		wasm.OpcodeLocalGet, 1, wasm.OpcodeI32Const, 10, wasm.OpcodeI32Mul, wasm.OpcodeLocalGet, 2, wasm.OpcodeI32Add,
+		wasm.OpcodeEnd,
+	}}},
+	MemorySection: &wasm.Memory{Min: 1, Cap: 1, Max: 1, IsMaxEncoded: true},
+	ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 0}},
+}
+
+// recursion returns the factorial of its param, computed recursively, plus 1000 times the count of recursive calls,
+// which it counts in a global.
+var recursion = &wasm.Module{
+	TypeSection:     []*wasm.FunctionType{{Params: []wasm.ValueType{i32}, Results: []wasm.ValueType{i32}, ParamNumInUint64: 1, ResultNumInUint64: 1}},
+	FunctionSection: []wasm.Index{0, 0},
+	CodeSection: []*wasm.Code{
+		{Body: []byte{
+			wasm.OpcodeLocalGet, 0, wasm.OpcodeCall, 1,
+			wasm.OpcodeGlobalGet, 0, wasm.OpcodeI32Const, 0xe8, 0x07, wasm.OpcodeI32Mul, wasm.OpcodeI32Add, // + calls*1000
+			wasm.OpcodeEnd,
+		}},
+		{Body: []byte{
+			wasm.OpcodeGlobalGet, 0, wasm.OpcodeI32Const, 1, wasm.OpcodeI32Add, wasm.OpcodeGlobalSet, 0, // calls++
+			wasm.OpcodeLocalGet, 0, wasm.OpcodeI32Eqz,
+			wasm.OpcodeIf, 0x7f,
+			wasm.OpcodeI32Const, 1,
+			wasm.OpcodeElse,
+			wasm.OpcodeLocalGet, 0,
+			wasm.OpcodeLocalGet, 0, wasm.OpcodeI32Const, 1, wasm.OpcodeI32Sub, wasm.OpcodeCall, 1,
+			wasm.OpcodeI32Mul,
+			wasm.OpcodeEnd,
+			wasm.OpcodeEnd,
+		}},
+	},
+	GlobalSection: []*wasm.Global{
+		{Type: &wasm.GlobalType{ValType: i32, Mutable: true}, Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeI32Const, Data: []byte{0}}},
+	},
+	ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 0}},
+}
+
+// grow grows the memory by a page and stores its param there, then returns the value stored plus the memory size.
+var grow = &wasm.Module{
+	TypeSection:     []*wasm.FunctionType{{Params: []wasm.ValueType{i32}, Results: []wasm.ValueType{i32}, ParamNumInUint64: 1, ResultNumInUint64: 1}},
+	FunctionSection: []wasm.Index{0},
+	CodeSection: []*wasm.Code{{Body: []byte{
+		wasm.OpcodeI32Const, 1, wasm.OpcodeMemoryGrow, 0, wasm.OpcodeDrop,
+		wasm.OpcodeI32Const, 0x80, 0x80, 0x04, wasm.OpcodeLocalGet, 0, wasm.OpcodeI32Store, 0x2, 0x0, // mem[65536] = param
+		wasm.OpcodeI32Const, 0x80, 0x80, 0x04, wasm.OpcodeI32Load, 0x2, 0x0,
+		wasm.OpcodeMemorySize, 0, wasm.OpcodeI32Add,
+		wasm.OpcodeEnd,
+	}}},
+	MemorySection: &wasm.Memory{Min: 1, Cap: 1, Max: 2, IsMaxEncoded: true},
+	ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 0}},
+}
+
+// TestReplay ensures each program finishes the same when resumed from a snapshot taken after any of its instructions.
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name            string
+		module          *wasm.Module
+		param, expected uint64
+	}{
+		{name: "loops", module: loops, param: 4, expected: 43},
+		{name: "recursion", module: recursion, param: 5, expected: 120 + 6*1000},
+		{name: "grow", module: grow, param: 40, expected: 42},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			r := wazero.NewRuntimeWithConfig(wazero.NewRuntimeConfigInterpreter())
+			defer r.Close(testCtx)
+
+			code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(tc.module), wazero.NewCompileConfig())
+			require.NoError(t, err)
+
+			m, err := r.InstantiateModule(testCtx, code, wazero.NewModuleConfig())
+			require.NoError(t, err)
+			results, err := m.ExportedFunction("run").Call(testCtx, tc.param)
+			require.NoError(t, err)
+			require.Equal(t, []uint64{tc.expected}, results)
+			require.NoError(t, m.Close(testCtx))
+
+			n := uint64(1)
+			for ; ; n++ {
+				err = replay.Verify(testCtx, r, code, "run", n, tc.param)
+				if errors.Is(err, replay.ErrFinished) {
+					break
+				}
+				require.NoError(t, err)
+			}
+			require.True(t, n > 10, "finished after %d instructions", n)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package proto
+
+import "fmt"
+
+// Feature is a bit of Snapshot.Features, which describes the parts of the format a snapshot uses, so that the format
+// can be extended without breaking readers.
+//
+// Bits 0-31 are required: a reader that doesn't support one set must reject the snapshot, as ignoring it would misread
+// or misresume it. Bits 32-63 are optional: a reader that doesn't support one set ignores it, and the data it
+// describes, as the snapshot resumes correctly, if less faithfully, without it.
+//
+// Note: Assign a new part of the format the next free bit of its kind, and never reuse a bit.
+type Feature = uint64
+
+const (
+	// FeatureMemoryAlignment is set when the memory buffer is padded per MemoryAlignment, so a reader that ignored it
+	// would read the padding as memory.
+	FeatureMemoryAlignment Feature = 1 << 0
+	// FeatureTables is reserved for snapshots of table elements, without which indirect calls would resolve against
+	// the tables of the new instance. It isn't written yet.
+	FeatureTables Feature = 1 << 1
+	// FeatureFDs is set when OpenFiles lists the files the guest opened, without which the guest's descriptors would be
+	// invalid once resumed.
+	FeatureFDs Feature = 1 << 2
+	// FeatureMemoryTransform is set when each page of the memory buffer is written transformed by a PageTransform, so
+	// a reader that ignored it would read the transformed pages as memory.
+	FeatureMemoryTransform Feature = 1 << 3
+	// FeatureSharedMemory is reserved for snapshots of a shared memory, which need the threads accessing it quiesced
+	// to be consistent. Readers that don't support it must reject the snapshot, rather than resume the memory as
+	// unshared. It isn't written yet.
+	FeatureSharedMemory Feature = 1 << 4
+
+	// FeatureTimers is reserved for snapshots of pending timers, which a reader may drop like timers of a restarted
+	// host. It isn't written yet.
+	FeatureTimers Feature = 1 << 32
+	// FeatureRand is set when RandRead counts the bytes read from the random source, without which the guest reads
+	// different, but still random, values once resumed.
+	FeatureRand Feature = 1 << 33
+	// FeatureClocks is set when Walltime or Nanotime are the last readings of the clocks, without which the guest sees
+	// the clocks of the new instance, which may jump, or run backwards.
+	FeatureClocks Feature = 1 << 34
+	// FeaturePreopens is set when Preopens lists the directories preopened for WASI, without which the guest sees those
+	// of the new instance, which may be at other file descriptors.
+	FeaturePreopens Feature = 1 << 35
+	// FeatureGeneration is set when Generation counts the snapshots exported before this one, without which the guest
+	// sees the count start over once resumed.
+	FeatureGeneration Feature = 1 << 36
+	// FeatureArgsEnviron is set when Args and Environ are those the module was configured with, without which the
+	// guest sees those of the new instance.
+	FeatureArgsEnviron Feature = 1 << 37
+)
+
+const (
+	// requiredFeatures is the mask of the required bits of Feature.
+	requiredFeatures Feature = 0xffffffff
+
+	// SupportedFeatures are the required features this version reads.
+	SupportedFeatures = FeatureMemoryAlignment | FeatureFDs | FeatureMemoryTransform
+)
+
+// checkFeatures returns an error if the snapshot sets a required Feature this version doesn't support.
+func checkFeatures(snapshot *Snapshot) error {
+	if unsupported := snapshot.GetFeatures() & requiredFeatures &^ SupportedFeatures; unsupported != 0 {
+		return fmt.Errorf("snapshot requires unsupported features %#x", unsupported)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+// This uses syscall.Mmap, which Go's SDK supports on these platforms.
+//go:build darwin || linux || freebsd
+
+package proto
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapMemory maps size bytes of f at offset copy-on-write, returning false if offset isn't aligned to the OS page size.
+func mmapMemory(f *os.File, offset int64, size int) (buffer []byte, unmap func() error, ok bool, err error) {
+	if offset%int64(os.Getpagesize()) != 0 {
+		return nil, nil, false, nil
+	}
+	// Private, so writes are copied to anonymous pages rather than reaching the file.
+	buffer, err = syscall.Mmap(int(f.Fd()), offset, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return buffer, func() error { return syscall.Munmap(buffer) }, true, nil
+}
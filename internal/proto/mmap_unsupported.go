@@ -0,0 +1,10 @@
+//go:build !(darwin || linux || freebsd)
+
+package proto
+
+import "os"
+
+// mmapMemory returns false, as mapping isn't supported on this platform, so the memory is read instead.
+func mmapMemory(*os.File, int64, int) (buffer []byte, unmap func() error, ok bool, err error) {
+	return nil, nil, false, nil
+}
@@ -216,6 +216,7 @@ type Memory struct {
 	Min    uint32 `protobuf:"varint,2,opt,name=min,proto3" json:"min,omitempty"`
 	Cap    uint32 `protobuf:"varint,3,opt,name=cap,proto3" json:"cap,omitempty"`
 	Max    uint32 `protobuf:"varint,4,opt,name=max,proto3" json:"max,omitempty"`
+	Shared bool   `protobuf:"varint,5,opt,name=shared,proto3" json:"shared,omitempty"`
 }
 
 func (x *Memory) Reset() {
@@ -278,16 +279,41 @@ func (x *Memory) GetMax() uint32 {
 	return 0
 }
 
+func (x *Memory) GetShared() bool {
+	if x != nil {
+		return x.Shared
+	}
+	return false
+}
+
 type Snapshot struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Valid   bool      `protobuf:"varint,1,opt,name=valid,proto3" json:"valid,omitempty"`
-	Stack   []uint64  `protobuf:"varint,2,rep,packed,name=stack,proto3" json:"stack,omitempty"`
-	Globals []*Global `protobuf:"bytes,3,rep,name=globals,proto3" json:"globals,omitempty"`
-	Frames  []*Frame  `protobuf:"bytes,4,rep,name=frames,proto3" json:"frames,omitempty"`
-	Memory  *Memory   `protobuf:"bytes,5,opt,name=memory,proto3" json:"memory,omitempty"`
+	Valid            bool        `protobuf:"varint,1,opt,name=valid,proto3" json:"valid,omitempty"`
+	Stack            []uint64    `protobuf:"varint,2,rep,packed,name=stack,proto3" json:"stack,omitempty"`
+	Globals          []*Global   `protobuf:"bytes,3,rep,name=globals,proto3" json:"globals,omitempty"`
+	Frames           []*Frame    `protobuf:"bytes,4,rep,name=frames,proto3" json:"frames,omitempty"`
+	Memory           *Memory     `protobuf:"bytes,5,opt,name=memory,proto3" json:"memory,omitempty"`
+	Closed           uint64      `protobuf:"varint,6,opt,name=closed,proto3" json:"closed,omitempty"`
+	StackTypes       []ValueType `protobuf:"varint,7,rep,packed,name=stackTypes,proto3,enum=main.ValueType" json:"stackTypes,omitempty"`
+	InstructionCount uint64      `protobuf:"varint,8,opt,name=instructionCount,proto3" json:"instructionCount,omitempty"`
+	StdoutWritten    uint64      `protobuf:"varint,9,opt,name=stdoutWritten,proto3" json:"stdoutWritten,omitempty"`
+	StderrWritten    uint64      `protobuf:"varint,10,opt,name=stderrWritten,proto3" json:"stderrWritten,omitempty"`
+	StdinRead        uint64      `protobuf:"varint,11,opt,name=stdinRead,proto3" json:"stdinRead,omitempty"`
+	Reason           uint32      `protobuf:"varint,12,opt,name=reason,proto3" json:"reason,omitempty"`
+	YieldTag         uint32      `protobuf:"varint,13,opt,name=yieldTag,proto3" json:"yieldTag,omitempty"`
+	MemoryAlignment  uint32      `protobuf:"varint,14,opt,name=memoryAlignment,proto3" json:"memoryAlignment,omitempty"`
+	Features         uint64      `protobuf:"varint,15,opt,name=features,proto3" json:"features,omitempty"`
+	RandRead         uint64      `protobuf:"varint,16,opt,name=randRead,proto3" json:"randRead,omitempty"`
+	Walltime         int64       `protobuf:"varint,17,opt,name=walltime,proto3" json:"walltime,omitempty"`
+	Nanotime         int64       `protobuf:"varint,18,opt,name=nanotime,proto3" json:"nanotime,omitempty"`
+	Preopens         []*Preopen  `protobuf:"bytes,19,rep,name=preopens,proto3" json:"preopens,omitempty"`
+	Generation       uint64      `protobuf:"varint,20,opt,name=generation,proto3" json:"generation,omitempty"`
+	OpenFiles        []*OpenFile `protobuf:"bytes,21,rep,name=openFiles,proto3" json:"openFiles,omitempty"`
+	Args             []string    `protobuf:"bytes,22,rep,name=args,proto3" json:"args,omitempty"`
+	Environ          []string    `protobuf:"bytes,23,rep,name=environ,proto3" json:"environ,omitempty"`
 }
 
 func (x *Snapshot) Reset() {
@@ -357,6 +383,250 @@ func (x *Snapshot) GetMemory() *Memory {
 	return nil
 }
 
+func (x *Snapshot) GetClosed() uint64 {
+	if x != nil {
+		return x.Closed
+	}
+	return 0
+}
+
+func (x *Snapshot) GetStackTypes() []ValueType {
+	if x != nil {
+		return x.StackTypes
+	}
+	return nil
+}
+
+func (x *Snapshot) GetInstructionCount() uint64 {
+	if x != nil {
+		return x.InstructionCount
+	}
+	return 0
+}
+
+func (x *Snapshot) GetStdoutWritten() uint64 {
+	if x != nil {
+		return x.StdoutWritten
+	}
+	return 0
+}
+
+func (x *Snapshot) GetStderrWritten() uint64 {
+	if x != nil {
+		return x.StderrWritten
+	}
+	return 0
+}
+
+func (x *Snapshot) GetStdinRead() uint64 {
+	if x != nil {
+		return x.StdinRead
+	}
+	return 0
+}
+
+func (x *Snapshot) GetReason() uint32 {
+	if x != nil {
+		return x.Reason
+	}
+	return 0
+}
+
+func (x *Snapshot) GetYieldTag() uint32 {
+	if x != nil {
+		return x.YieldTag
+	}
+	return 0
+}
+
+func (x *Snapshot) GetMemoryAlignment() uint32 {
+	if x != nil {
+		return x.MemoryAlignment
+	}
+	return 0
+}
+
+func (x *Snapshot) GetFeatures() uint64 {
+	if x != nil {
+		return x.Features
+	}
+	return 0
+}
+
+func (x *Snapshot) GetRandRead() uint64 {
+	if x != nil {
+		return x.RandRead
+	}
+	return 0
+}
+
+func (x *Snapshot) GetWalltime() int64 {
+	if x != nil {
+		return x.Walltime
+	}
+	return 0
+}
+
+func (x *Snapshot) GetNanotime() int64 {
+	if x != nil {
+		return x.Nanotime
+	}
+	return 0
+}
+
+func (x *Snapshot) GetPreopens() []*Preopen {
+	if x != nil {
+		return x.Preopens
+	}
+	return nil
+}
+
+func (x *Snapshot) GetGeneration() uint64 {
+	if x != nil {
+		return x.Generation
+	}
+	return 0
+}
+
+func (x *Snapshot) GetOpenFiles() []*OpenFile {
+	if x != nil {
+		return x.OpenFiles
+	}
+	return nil
+}
+
+func (x *Snapshot) GetArgs() []string {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *Snapshot) GetEnviron() []string {
+	if x != nil {
+		return x.Environ
+	}
+	return nil
+}
+
+type Preopen struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Fd   uint32 `protobuf:"varint,1,opt,name=fd,proto3" json:"fd,omitempty"`
+	Path string `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+}
+
+func (x *Preopen) Reset() {
+	*x = Preopen{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_snapshot_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Preopen) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Preopen) ProtoMessage() {}
+
+func (x *Preopen) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Preopen.ProtoReflect.Descriptor instead.
+func (*Preopen) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Preopen) GetFd() uint32 {
+	if x != nil {
+		return x.Fd
+	}
+	return 0
+}
+
+func (x *Preopen) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type OpenFile struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Fd     uint32 `protobuf:"varint,1,opt,name=fd,proto3" json:"fd,omitempty"`
+	Path   string `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	Offset int64  `protobuf:"varint,3,opt,name=offset,proto3" json:"offset,omitempty"`
+}
+
+func (x *OpenFile) Reset() {
+	*x = OpenFile{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_snapshot_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *OpenFile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OpenFile) ProtoMessage() {}
+
+func (x *OpenFile) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OpenFile.ProtoReflect.Descriptor instead.
+func (*OpenFile) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *OpenFile) GetFd() uint32 {
+	if x != nil {
+		return x.Fd
+	}
+	return 0
+}
+
+func (x *OpenFile) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *OpenFile) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
 var File_snapshot_proto protoreflect.FileDescriptor
 
 var file_snapshot_proto_rawDesc = []byte{
@@ -372,30 +642,76 @@ var file_snapshot_proto_rawDesc = []byte{
 	0x72, 0x61, 0x6d, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x70, 0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04,
 	0x52, 0x02, 0x70, 0x63, 0x12, 0x24, 0x0a, 0x0d, 0x66, 0x75, 0x6e, 0x63, 0x74, 0x69, 0x6f, 0x6e,
 	0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0d, 0x66, 0x75, 0x6e,
-	0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x22, 0x56, 0x0a, 0x06, 0x4d, 0x65,
+	0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x22, 0x6e, 0x0a, 0x06, 0x4d, 0x65,
 	0x6d, 0x6f, 0x72, 0x79, 0x12, 0x16, 0x0a, 0x06, 0x62, 0x75, 0x66, 0x66, 0x65, 0x72, 0x18, 0x01,
 	0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x62, 0x75, 0x66, 0x66, 0x65, 0x72, 0x12, 0x10, 0x0a, 0x03,
 	0x6d, 0x69, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x6d, 0x69, 0x6e, 0x12, 0x10,
 	0x0a, 0x03, 0x63, 0x61, 0x70, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x63, 0x61, 0x70,
 	0x12, 0x10, 0x0a, 0x03, 0x6d, 0x61, 0x78, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x6d,
-	0x61, 0x78, 0x22, 0xa9, 0x01, 0x0a, 0x08, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x12,
-	0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05,
-	0x76, 0x61, 0x6c, 0x69, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x63, 0x6b, 0x18, 0x02,
-	0x20, 0x03, 0x28, 0x04, 0x52, 0x05, 0x73, 0x74, 0x61, 0x63, 0x6b, 0x12, 0x26, 0x0a, 0x07, 0x67,
-	0x6c, 0x6f, 0x62, 0x61, 0x6c, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x6d,
-	0x61, 0x69, 0x6e, 0x2e, 0x47, 0x6c, 0x6f, 0x62, 0x61, 0x6c, 0x52, 0x07, 0x67, 0x6c, 0x6f, 0x62,
-	0x61, 0x6c, 0x73, 0x12, 0x23, 0x0a, 0x06, 0x66, 0x72, 0x61, 0x6d, 0x65, 0x73, 0x18, 0x04, 0x20,
-	0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x6d, 0x61, 0x69, 0x6e, 0x2e, 0x46, 0x72, 0x61, 0x6d, 0x65,
-	0x52, 0x06, 0x66, 0x72, 0x61, 0x6d, 0x65, 0x73, 0x12, 0x24, 0x0a, 0x06, 0x6d, 0x65, 0x6d, 0x6f,
-	0x72, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x6d, 0x61, 0x69, 0x6e, 0x2e,
-	0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x52, 0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x2a, 0x55,
-	0x0a, 0x09, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x07, 0x0a, 0x03, 0x49,
-	0x33, 0x32, 0x10, 0x00, 0x12, 0x07, 0x0a, 0x03, 0x49, 0x36, 0x34, 0x10, 0x01, 0x12, 0x07, 0x0a,
-	0x03, 0x46, 0x33, 0x32, 0x10, 0x02, 0x12, 0x07, 0x0a, 0x03, 0x46, 0x36, 0x34, 0x10, 0x03, 0x12,
-	0x08, 0x0a, 0x04, 0x56, 0x31, 0x32, 0x38, 0x10, 0x04, 0x12, 0x0b, 0x0a, 0x07, 0x46, 0x75, 0x6e,
-	0x63, 0x52, 0x65, 0x66, 0x10, 0x05, 0x12, 0x0d, 0x0a, 0x09, 0x45, 0x78, 0x74, 0x65, 0x72, 0x6e,
-	0x52, 0x65, 0x66, 0x10, 0x06, 0x42, 0x09, 0x5a, 0x07, 0x2e, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x61, 0x78, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x68, 0x61, 0x72, 0x65, 0x64, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x06, 0x73, 0x68, 0x61, 0x72, 0x65, 0x64, 0x22, 0xfd, 0x05, 0x0a, 0x08, 0x53,
+	0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x69, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x12, 0x14, 0x0a,
+	0x05, 0x73, 0x74, 0x61, 0x63, 0x6b, 0x18, 0x02, 0x20, 0x03, 0x28, 0x04, 0x52, 0x05, 0x73, 0x74,
+	0x61, 0x63, 0x6b, 0x12, 0x26, 0x0a, 0x07, 0x67, 0x6c, 0x6f, 0x62, 0x61, 0x6c, 0x73, 0x18, 0x03,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x6d, 0x61, 0x69, 0x6e, 0x2e, 0x47, 0x6c, 0x6f, 0x62,
+	0x61, 0x6c, 0x52, 0x07, 0x67, 0x6c, 0x6f, 0x62, 0x61, 0x6c, 0x73, 0x12, 0x23, 0x0a, 0x06, 0x66,
+	0x72, 0x61, 0x6d, 0x65, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x6d, 0x61,
+	0x69, 0x6e, 0x2e, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x52, 0x06, 0x66, 0x72, 0x61, 0x6d, 0x65, 0x73,
+	0x12, 0x24, 0x0a, 0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x0c, 0x2e, 0x6d, 0x61, 0x69, 0x6e, 0x2e, 0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x52, 0x06,
+	0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x6c, 0x6f, 0x73, 0x65, 0x64,
+	0x18, 0x06, 0x20, 0x01, 0x28, 0x04, 0x52, 0x06, 0x63, 0x6c, 0x6f, 0x73, 0x65, 0x64, 0x12, 0x2f,
+	0x0a, 0x0a, 0x73, 0x74, 0x61, 0x63, 0x6b, 0x54, 0x79, 0x70, 0x65, 0x73, 0x18, 0x07, 0x20, 0x03,
+	0x28, 0x0e, 0x32, 0x0f, 0x2e, 0x6d, 0x61, 0x69, 0x6e, 0x2e, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x54,
+	0x79, 0x70, 0x65, 0x52, 0x0a, 0x73, 0x74, 0x61, 0x63, 0x6b, 0x54, 0x79, 0x70, 0x65, 0x73, 0x12,
+	0x2a, 0x0a, 0x10, 0x69, 0x6e, 0x73, 0x74, 0x72, 0x75, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x43, 0x6f,
+	0x75, 0x6e, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x04, 0x52, 0x10, 0x69, 0x6e, 0x73, 0x74, 0x72,
+	0x75, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x24, 0x0a, 0x0d, 0x73,
+	0x74, 0x64, 0x6f, 0x75, 0x74, 0x57, 0x72, 0x69, 0x74, 0x74, 0x65, 0x6e, 0x18, 0x09, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x0d, 0x73, 0x74, 0x64, 0x6f, 0x75, 0x74, 0x57, 0x72, 0x69, 0x74, 0x74, 0x65,
+	0x6e, 0x12, 0x24, 0x0a, 0x0d, 0x73, 0x74, 0x64, 0x65, 0x72, 0x72, 0x57, 0x72, 0x69, 0x74, 0x74,
+	0x65, 0x6e, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0d, 0x73, 0x74, 0x64, 0x65, 0x72, 0x72,
+	0x57, 0x72, 0x69, 0x74, 0x74, 0x65, 0x6e, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x74, 0x64, 0x69, 0x6e,
+	0x52, 0x65, 0x61, 0x64, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x73, 0x74, 0x64, 0x69,
+	0x6e, 0x52, 0x65, 0x61, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18,
+	0x0c, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x1a, 0x0a,
+	0x08, 0x79, 0x69, 0x65, 0x6c, 0x64, 0x54, 0x61, 0x67, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x08, 0x79, 0x69, 0x65, 0x6c, 0x64, 0x54, 0x61, 0x67, 0x12, 0x28, 0x0a, 0x0f, 0x6d, 0x65, 0x6d,
+	0x6f, 0x72, 0x79, 0x41, 0x6c, 0x69, 0x67, 0x6e, 0x6d, 0x65, 0x6e, 0x74, 0x18, 0x0e, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x0f, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x41, 0x6c, 0x69, 0x67, 0x6e, 0x6d,
+	0x65, 0x6e, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x73, 0x18,
+	0x0f, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x73, 0x12,
+	0x1a, 0x0a, 0x08, 0x72, 0x61, 0x6e, 0x64, 0x52, 0x65, 0x61, 0x64, 0x18, 0x10, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x08, 0x72, 0x61, 0x6e, 0x64, 0x52, 0x65, 0x61, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x77,
+	0x61, 0x6c, 0x6c, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x11, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x77,
+	0x61, 0x6c, 0x6c, 0x74, 0x69, 0x6d, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x6e, 0x61, 0x6e, 0x6f, 0x74,
+	0x69, 0x6d, 0x65, 0x18, 0x12, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x6e, 0x61, 0x6e, 0x6f, 0x74,
+	0x69, 0x6d, 0x65, 0x12, 0x29, 0x0a, 0x08, 0x70, 0x72, 0x65, 0x6f, 0x70, 0x65, 0x6e, 0x73, 0x18,
+	0x13, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0d, 0x2e, 0x6d, 0x61, 0x69, 0x6e, 0x2e, 0x50, 0x72, 0x65,
+	0x6f, 0x70, 0x65, 0x6e, 0x52, 0x08, 0x70, 0x72, 0x65, 0x6f, 0x70, 0x65, 0x6e, 0x73, 0x12, 0x1e,
+	0x0a, 0x0a, 0x67, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x14, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x0a, 0x67, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x2c,
+	0x0a, 0x09, 0x6f, 0x70, 0x65, 0x6e, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x18, 0x15, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x0e, 0x2e, 0x6d, 0x61, 0x69, 0x6e, 0x2e, 0x4f, 0x70, 0x65, 0x6e, 0x46, 0x69, 0x6c,
+	0x65, 0x52, 0x09, 0x6f, 0x70, 0x65, 0x6e, 0x46, 0x69, 0x6c, 0x65, 0x73, 0x12, 0x12, 0x0a, 0x04,
+	0x61, 0x72, 0x67, 0x73, 0x18, 0x16, 0x20, 0x03, 0x28, 0x09, 0x52, 0x04, 0x61, 0x72, 0x67, 0x73,
+	0x12, 0x18, 0x0a, 0x07, 0x65, 0x6e, 0x76, 0x69, 0x72, 0x6f, 0x6e, 0x18, 0x17, 0x20, 0x03, 0x28,
+	0x09, 0x52, 0x07, 0x65, 0x6e, 0x76, 0x69, 0x72, 0x6f, 0x6e, 0x22, 0x2d, 0x0a, 0x07, 0x50, 0x72,
+	0x65, 0x6f, 0x70, 0x65, 0x6e, 0x12, 0x0e, 0x0a, 0x02, 0x66, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x02, 0x66, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x22, 0x46, 0x0a, 0x08, 0x4f, 0x70, 0x65,
+	0x6e, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x66, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x02, 0x66, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x66, 0x66,
+	0x73, 0x65, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x6f, 0x66, 0x66, 0x73, 0x65,
+	0x74, 0x2a, 0x55, 0x0a, 0x09, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x07,
+	0x0a, 0x03, 0x49, 0x33, 0x32, 0x10, 0x00, 0x12, 0x07, 0x0a, 0x03, 0x49, 0x36, 0x34, 0x10, 0x01,
+	0x12, 0x07, 0x0a, 0x03, 0x46, 0x33, 0x32, 0x10, 0x02, 0x12, 0x07, 0x0a, 0x03, 0x46, 0x36, 0x34,
+	0x10, 0x03, 0x12, 0x08, 0x0a, 0x04, 0x56, 0x31, 0x32, 0x38, 0x10, 0x04, 0x12, 0x0b, 0x0a, 0x07,
+	0x46, 0x75, 0x6e, 0x63, 0x52, 0x65, 0x66, 0x10, 0x05, 0x12, 0x0d, 0x0a, 0x09, 0x45, 0x78, 0x74,
+	0x65, 0x72, 0x6e, 0x52, 0x65, 0x66, 0x10, 0x06, 0x42, 0x09, 0x5a, 0x07, 0x2e, 0x2f, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -411,24 +727,29 @@ func file_snapshot_proto_rawDescGZIP() []byte {
 }
 
 var file_snapshot_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_snapshot_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_snapshot_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_snapshot_proto_goTypes = []interface{}{
 	(ValueType)(0),   // 0: main.ValueType
 	(*Global)(nil),   // 1: main.Global
 	(*Frame)(nil),    // 2: main.Frame
 	(*Memory)(nil),   // 3: main.Memory
 	(*Snapshot)(nil), // 4: main.Snapshot
+	(*Preopen)(nil),  // 5: main.Preopen
+	(*OpenFile)(nil), // 6: main.OpenFile
 }
 var file_snapshot_proto_depIdxs = []int32{
 	0, // 0: main.Global.type:type_name -> main.ValueType
 	1, // 1: main.Snapshot.globals:type_name -> main.Global
 	2, // 2: main.Snapshot.frames:type_name -> main.Frame
 	3, // 3: main.Snapshot.memory:type_name -> main.Memory
-	4, // [4:4] is the sub-list for method output_type
-	4, // [4:4] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+	0, // 4: main.Snapshot.stackTypes:type_name -> main.ValueType
+	5, // 5: main.Snapshot.preopens:type_name -> main.Preopen
+	6, // 6: main.Snapshot.openFiles:type_name -> main.OpenFile
+	7, // [7:7] is the sub-list for method output_type
+	7, // [7:7] is the sub-list for method input_type
+	7, // [7:7] is the sub-list for extension type_name
+	7, // [7:7] is the sub-list for extension extendee
+	0, // [0:7] is the sub-list for field type_name
 }
 
 func init() { file_snapshot_proto_init() }
@@ -485,6 +806,30 @@ func file_snapshot_proto_init() {
 				return nil
 			}
 		}
+		file_snapshot_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Preopen); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_snapshot_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*OpenFile); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -492,7 +837,7 @@ func file_snapshot_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_snapshot_proto_rawDesc,
 			NumEnums:      1,
-			NumMessages:   4,
+			NumMessages:   6,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -0,0 +1,342 @@
+package proto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	pb "google.golang.org/protobuf/proto"
+)
+
+// memoryPageSize is the size of the chunks the memory buffer is streamed in. This matches the WebAssembly page size.
+const memoryPageSize = 65536
+
+// WriteSnapshot encodes the snapshot to w without copying the memory buffer.
+//
+// The encoding is length-delimited: the uvarint length of the snapshot marshaled without Memory.Buffer, the snapshot
+// itself, then, only when Memory is set, the uvarint length of Memory.Buffer followed by the buffer, written page by
+// page directly from the source slice. This avoids a marshaled copy of the memory, which dominates the snapshot size.
+//
+// When MemoryAlignment is set, zero bytes are written before the buffer so that it starts at a multiple of it from the
+// start of w. Written to the start of a file, this allows MapSnapshot to map the buffer instead of reading it.
+func WriteSnapshot(w io.Writer, snapshot *Snapshot) error {
+	return WriteSnapshotTransform(w, snapshot, nil)
+}
+
+// WriteSnapshotTransform is like WriteSnapshot, except each page of the memory buffer is written transformed, prefixed
+// by its uvarint length, when transform is not nil. That must be exactly when the snapshot sets FeatureMemoryTransform,
+// and MemoryAlignment is ignored, as the transformed buffer can't be mapped anyway.
+func WriteSnapshotTransform(w io.Writer, snapshot *Snapshot, transform PageTransform) error {
+	if err := checkTransform(snapshot, transform); err != nil {
+		return err
+	}
+	var buffer []byte
+	if snapshot.Memory != nil {
+		buffer = snapshot.Memory.Buffer
+		snapshot.Memory.Buffer = nil
+		defer func() { snapshot.Memory.Buffer = buffer }()
+	}
+
+	header, err := pb.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err = writeUvarint(w, uint64(len(header))); err != nil {
+		return err
+	}
+	if _, err = w.Write(header); err != nil {
+		return err
+	}
+
+	if snapshot.Memory == nil {
+		return nil
+	}
+	if err = writeUvarint(w, uint64(len(buffer))); err != nil {
+		return err
+	}
+	if transform != nil {
+		return writeTransformed(w, buffer, transform)
+	}
+	if padding := memoryPadding(snapshot, len(header), len(buffer)); padding > 0 {
+		if _, err = w.Write(make([]byte, padding)); err != nil {
+			return err
+		}
+	}
+	for page := buffer; len(page) > 0; {
+		n := len(page)
+		if n > memoryPageSize {
+			n = memoryPageSize
+		}
+		if _, err = w.Write(page[:n]); err != nil {
+			return err
+		}
+		page = page[n:]
+	}
+	return nil
+}
+
+// ReadSnapshot decodes a snapshot written by WriteSnapshot, reading the memory buffer incrementally from r. This errs
+// if the snapshot sets a required Feature this version doesn't support, and ignores optional ones.
+//
+// When r is an io.Seeker, e.g. an *os.File, the memory buffer is allocated once and read in bulk, as its length can be
+// checked against the bytes left in r.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	return ReadSnapshotTransform(r, nil)
+}
+
+// ReadSnapshotTransform is like ReadSnapshot, except it inverts the transform of each page of a snapshot written by
+// WriteSnapshotTransform. This errs unless transform is nil exactly when the snapshot doesn't set
+// FeatureMemoryTransform.
+func ReadSnapshotTransform(r io.Reader, transform PageTransform) (*Snapshot, error) {
+	available, err := remaining(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	br := bufio.NewReader(r)
+
+	header, err := readDelimited(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	snapshot, err := parseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkTransform(snapshot, transform); err != nil {
+		return nil, err
+	}
+
+	if snapshot.Memory == nil {
+		return snapshot, nil
+	}
+	if snapshot.Memory.Buffer, err = readMemory(br, snapshot, len(header), transform, available); err != nil {
+		return nil, fmt.Errorf("failed to read memory: %w", err)
+	}
+	return snapshot, nil
+}
+
+// readMemory reads the memory buffer that follows the header of the given length, skipping any padding, and inverting
+// transform when not nil. available is as documented on readBuffer.
+func readMemory(
+	br *bufio.Reader, snapshot *Snapshot, headerSize int, transform PageTransform, available int64,
+) ([]byte, error) {
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if transform != nil {
+		return readTransformed(br, size, transform)
+	}
+	if padding := memoryPadding(snapshot, headerSize, int(size)); padding > 0 {
+		if _, err = br.Discard(padding); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	return readBuffer(br, size, available)
+}
+
+// readBuffer reads size bytes. When the stream is known to hold at most available bytes, a size within it is backed
+// by data, so the buffer is allocated once and read in bulk, rather than grown per page. available is negative when
+// unknown, in which case this falls back to readN.
+func readBuffer(br *bufio.Reader, size uint64, available int64) ([]byte, error) {
+	if available < 0 {
+		return readN(br, size)
+	}
+	if size > uint64(available) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buffer := make([]byte, size)
+	if _, err := io.ReadFull(br, buffer); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buffer, nil
+}
+
+// remaining returns the count of bytes left in r when it is an io.Seeker, or -1 if unknown, e.g. as r is a pipe.
+func remaining(r io.Reader) (int64, error) {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return -1, nil
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1, nil // not seekable after all.
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1, nil
+	}
+	if _, err = seeker.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return end - offset, nil
+}
+
+// memoryOffset returns the offset from the start of the stream of the memory buffer of the given size, which follows
+// the header of the given length.
+func memoryOffset(snapshot *Snapshot, headerSize, bufferSize int) int {
+	offset := uvarintSize(uint64(headerSize)) + headerSize + uvarintSize(uint64(bufferSize))
+	return offset + memoryPadding(snapshot, headerSize, bufferSize)
+}
+
+// memoryPadding returns the count of zero bytes before the memory buffer of the given size, so that it starts at a
+// multiple of MemoryAlignment.
+func memoryPadding(snapshot *Snapshot, headerSize, bufferSize int) int {
+	alignment := int(snapshot.GetMemoryAlignment())
+	if alignment == 0 {
+		return 0
+	}
+	offset := uvarintSize(uint64(headerSize)) + headerSize + uvarintSize(uint64(bufferSize))
+	return (alignment - offset%alignment) % alignment
+}
+
+// MapSnapshot is like ReadSnapshot, except it maps the memory buffer from the file copy-on-write, instead of reading
+// it, when the snapshot was written with MemoryAlignment at the start of the file. Only the pages accessed are then
+// read, and writes to the buffer don't affect the file. mapped is false when the buffer was read instead, e.g. as the
+// snapshot isn't aligned or mapping isn't supported on this platform.
+//
+// The returned release unmaps the buffer, after which it must no longer be used, e.g. by a module resumed from it.
+//
+// Note: This errs on a snapshot which sets FeatureMemoryTransform. Use ReadSnapshotTransform instead.
+func MapSnapshot(f *os.File) (snapshot *Snapshot, mapped bool, release func() error, err error) {
+	release = func() error { return nil }
+	br := bufio.NewReader(io.NewSectionReader(f, 0, math.MaxInt64)) // from the start regardless of the file offset.
+
+	header, err := readDelimited(br)
+	if err != nil {
+		return nil, false, nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if snapshot, err = parseHeader(header); err != nil {
+		return nil, false, nil, err
+	}
+	if err = checkTransform(snapshot, nil); err != nil {
+		return nil, false, nil, err
+	}
+	if snapshot.Memory == nil {
+		return snapshot, false, release, nil
+	}
+
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, false, nil, fmt.Errorf("failed to read memory: %w", unexpectedEOF(err))
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, false, nil, err
+	}
+	if snapshot.MemoryAlignment != 0 && size > 0 {
+		offset := int64(memoryOffset(snapshot, len(header), int(size)))
+		if size > uint64(fi.Size()) || offset > fi.Size()-int64(size) {
+			return nil, false, nil, fmt.Errorf("failed to read memory: %w", io.ErrUnexpectedEOF)
+		}
+		if buffer, unmap, ok, err := mmapMemory(f, offset, int(size)); err != nil {
+			return nil, false, nil, fmt.Errorf("failed to map memory: %w", err)
+		} else if ok {
+			snapshot.Memory.Buffer = buffer
+			return snapshot, true, unmap, nil
+		}
+	}
+
+	// Fall back to reading the memory.
+	if padding := memoryPadding(snapshot, len(header), int(size)); padding > 0 {
+		if _, err = br.Discard(padding); err != nil {
+			return nil, false, nil, fmt.Errorf("failed to read memory: %w", unexpectedEOF(err))
+		}
+	}
+	if snapshot.Memory.Buffer, err = readBuffer(br, size, fi.Size()); err != nil {
+		return nil, false, nil, fmt.Errorf("failed to read memory: %w", err)
+	}
+	return snapshot, false, release, nil
+}
+
+// parseHeader unmarshals the snapshot marshaled without Memory.Buffer, rejecting it if it requires unsupported
+// features.
+func parseHeader(header []byte) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	if err := pb.Unmarshal(header, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	if err := checkFeatures(snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// EncodedSize returns the length in bytes of the snapshot when encoded by WriteSnapshot. When the snapshot sets
+// FeatureMemoryTransform, this assumes the transform keeps the length of each page, so is only an estimate.
+func EncodedSize(snapshot *Snapshot) int {
+	var buffer []byte
+	if snapshot.Memory != nil {
+		buffer = snapshot.Memory.Buffer
+		snapshot.Memory.Buffer = nil
+		defer func() { snapshot.Memory.Buffer = buffer }()
+	}
+
+	headerSize := pb.Size(snapshot)
+	if snapshot.Memory == nil {
+		return uvarintSize(uint64(headerSize)) + headerSize
+	}
+	if snapshot.GetFeatures()&FeatureMemoryTransform != 0 {
+		size := uvarintSize(uint64(headerSize)) + headerSize + uvarintSize(uint64(len(buffer))) + len(buffer)
+		for page := len(buffer); page > 0; page -= memoryPageSize {
+			if page > memoryPageSize {
+				size += uvarintSize(memoryPageSize)
+			} else {
+				size += uvarintSize(uint64(page))
+			}
+		}
+		return size
+	}
+	return memoryOffset(snapshot, headerSize, len(buffer)) + len(buffer)
+}
+
+func writeUvarint(w io.Writer, v uint64) error {
+	var buf [binary.MaxVarintLen64]byte
+	_, err := w.Write(buf[:binary.PutUvarint(buf[:], v)])
+	return err
+}
+
+func uvarintSize(v uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], v)
+}
+
+// readDelimited reads a uvarint length followed by that many bytes, in chunks of memoryPageSize.
+func readDelimited(br *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	return readN(br, size)
+}
+
+// readN reads size bytes in chunks of memoryPageSize.
+func readN(br *bufio.Reader, size uint64) ([]byte, error) {
+	// Grow the result as data arrives, so that a corrupt length cannot force a huge allocation up front.
+	var ret []byte
+	for remaining := size; remaining > 0; {
+		n := remaining
+		if n > memoryPageSize {
+			n = memoryPageSize
+		}
+		offset := len(ret)
+		ret = append(ret, make([]byte, n)...)
+		if _, err := io.ReadFull(br, ret[offset:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		remaining -= n
+	}
+	return ret, nil
+}
+
+// unexpectedEOF returns io.ErrUnexpectedEOF instead of io.EOF, as data was expected.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,275 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+	pb "google.golang.org/protobuf/proto"
+)
+
+func TestWriteSnapshot_ReadSnapshot(t *testing.T) {
+	buffer := make([]byte, memoryPageSize*2+3)
+	for i := range buffer {
+		buffer[i] = byte(i)
+	}
+
+	tests := []struct {
+		name     string
+		snapshot *Snapshot
+	}{
+		{
+			name:     "no memory",
+			snapshot: &Snapshot{Valid: true, Stack: []uint64{1, 2}, Frames: []*Frame{{Pc: 3, FunctionIndex: 4}}},
+		},
+		{
+			name:     "empty memory",
+			snapshot: &Snapshot{Valid: true, Memory: &Memory{Min: 1}},
+		},
+		{
+			name: "memory spanning pages",
+			snapshot: &Snapshot{
+				Valid:   true,
+				Globals: []*Global{{Type: ValueType_I64, Mutable: true, Value: 5}},
+				Memory:  &Memory{Buffer: buffer, Min: 3, Cap: 3, Max: 10},
+				Closed:  1,
+			},
+		},
+		{
+			name: "aligned memory",
+			snapshot: &Snapshot{
+				Valid:           true,
+				Stack:           []uint64{1},
+				Memory:          &Memory{Buffer: buffer, Min: 3, Cap: 3, Max: 10},
+				MemoryAlignment: memoryPageSize,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			require.NoError(t, WriteSnapshot(&out, tc.snapshot))
+			require.Equal(t, EncodedSize(tc.snapshot), out.Len())
+
+			encoded := out.Bytes()
+			snapshot, err := ReadSnapshot(bytes.NewReader(encoded))
+			require.NoError(t, err)
+			require.True(t, pb.Equal(tc.snapshot, snapshot))
+
+			// A reader which can't seek grows the buffer as data arrives instead.
+			snapshot, err = ReadSnapshot(struct{ io.Reader }{bytes.NewReader(encoded)})
+			require.NoError(t, err)
+			require.True(t, pb.Equal(tc.snapshot, snapshot))
+		})
+	}
+}
+
+func TestReadSnapshot_Errors(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, WriteSnapshot(&out, &Snapshot{Valid: true, Memory: &Memory{Buffer: []byte{1, 2, 3}}}))
+	encoded := out.Bytes()
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := ReadSnapshot(bytes.NewReader(nil))
+		require.ErrorIs(t, err, io.EOF)
+	})
+	t.Run("truncated memory", func(t *testing.T) {
+		_, err := ReadSnapshot(bytes.NewReader(encoded[:len(encoded)-1]))
+		require.EqualError(t, err, "failed to read memory: unexpected EOF")
+
+		_, err = ReadSnapshot(struct{ io.Reader }{bytes.NewReader(encoded[:len(encoded)-1])})
+		require.EqualError(t, err, "failed to read memory: unexpected EOF")
+	})
+	t.Run("memory length beyond the stream", func(t *testing.T) {
+		// The length of a 4 GiB memory, which would be allocated up front if not checked against the stream.
+		corrupt := make([]byte, len(encoded)-4+binary.MaxVarintLen64)
+		n := copy(corrupt, encoded[:len(encoded)-4])
+		corrupt = corrupt[:n+binary.PutUvarint(corrupt[n:], 1<<32)]
+		_, err := ReadSnapshot(bytes.NewReader(corrupt))
+		require.EqualError(t, err, "failed to read memory: unexpected EOF")
+	})
+}
+
+func TestReadSnapshot_Features(t *testing.T) {
+	tests := []struct {
+		name        string
+		features    Feature
+		expectedErr string
+	}{
+		{name: "none"},
+		{name: "supported", features: FeatureMemoryAlignment | FeatureFDs},
+		{name: "optional", features: FeatureMemoryAlignment | FeatureTimers | FeatureRand | 1<<63},
+		{
+			name:        "required",
+			features:    FeatureMemoryAlignment | FeatureTables | FeatureFDs,
+			expectedErr: "snapshot requires unsupported features 0x2",
+		},
+		{
+			name:        "unknown required",
+			features:    1 << 31,
+			expectedErr: "snapshot requires unsupported features 0x80000000",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			snapshot := &Snapshot{Valid: true, Memory: &Memory{Buffer: []byte{1}}, Features: tc.features}
+			f := writeSnapshotFile(t, snapshot)
+
+			_, _, _, mapErr := MapSnapshot(f)
+			_, err := f.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+			read, err := ReadSnapshot(f)
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+				require.NoError(t, mapErr)
+				require.True(t, pb.Equal(snapshot, read))
+			} else {
+				require.EqualError(t, err, tc.expectedErr)
+				require.EqualError(t, mapErr, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestWriteSnapshot_Alignment(t *testing.T) {
+	snapshot := &Snapshot{Valid: true, Memory: &Memory{Buffer: []byte{1, 2, 3}}, MemoryAlignment: memoryPageSize}
+
+	var out bytes.Buffer
+	require.NoError(t, WriteSnapshot(&out, snapshot))
+	encoded := out.Bytes()
+	require.Equal(t, memoryPageSize+3, len(encoded))
+	require.Equal(t, []byte{1, 2, 3}, encoded[memoryPageSize:])
+}
+
+func TestMapSnapshot(t *testing.T) {
+	buffer := make([]byte, memoryPageSize*2)
+	for i := range buffer {
+		buffer[i] = byte(i)
+	}
+
+	tests := []struct {
+		name           string
+		snapshot       *Snapshot
+		expectedMapped bool
+	}{
+		{
+			name:     "no memory",
+			snapshot: &Snapshot{Valid: true, Stack: []uint64{1, 2}},
+		},
+		{
+			name:     "unaligned memory",
+			snapshot: &Snapshot{Valid: true, Memory: &Memory{Buffer: buffer, Min: 2, Cap: 2}},
+		},
+		{
+			name:     "aligned empty memory",
+			snapshot: &Snapshot{Valid: true, Memory: &Memory{}, MemoryAlignment: memoryPageSize},
+		},
+		{
+			name: "aligned memory",
+			snapshot: &Snapshot{
+				Valid:           true,
+				Memory:          &Memory{Buffer: buffer, Min: 2, Cap: 2},
+				MemoryAlignment: memoryPageSize,
+			},
+			expectedMapped: runtime.GOOS == "linux" || runtime.GOOS == "darwin" || runtime.GOOS == "freebsd",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			f := writeSnapshotFile(t, tc.snapshot)
+
+			snapshot, mapped, release, err := MapSnapshot(f)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, release()) }()
+
+			require.Equal(t, tc.expectedMapped, mapped)
+			require.True(t, pb.Equal(tc.snapshot, snapshot))
+		})
+	}
+
+	t.Run("writes don't reach the file", func(t *testing.T) {
+		expected := &Snapshot{Valid: true, Memory: &Memory{Buffer: buffer, Min: 2, Cap: 2}, MemoryAlignment: memoryPageSize}
+		f := writeSnapshotFile(t, expected)
+
+		snapshot, _, release, err := MapSnapshot(f)
+		require.NoError(t, err)
+		snapshot.Memory.Buffer[0] = 0xff
+		require.NoError(t, release())
+
+		_, err = f.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		snapshot, err = ReadSnapshot(f)
+		require.NoError(t, err)
+		require.True(t, pb.Equal(expected, snapshot))
+	})
+
+	t.Run("truncated memory", func(t *testing.T) {
+		f := writeSnapshotFile(t, &Snapshot{Valid: true, Memory: &Memory{Buffer: buffer}, MemoryAlignment: memoryPageSize})
+		fi, err := f.Stat()
+		require.NoError(t, err)
+		require.NoError(t, f.Truncate(fi.Size()-1))
+
+		_, _, _, err = MapSnapshot(f)
+		require.EqualError(t, err, "failed to read memory: unexpected EOF")
+	})
+}
+
+// benchmarkMemorySize is the size of a large memory, of which only every 16th page has data.
+const benchmarkMemorySize = 256 << 20
+
+// BenchmarkReadSnapshot compares reading the memory in one allocation, as done for an io.Seeker, to growing it as data
+// arrives, as done otherwise.
+func BenchmarkReadSnapshot(b *testing.B) {
+	buffer := make([]byte, benchmarkMemorySize)
+	for offset := 0; offset < len(buffer); offset += 16 * memoryPageSize {
+		buffer[offset] = 1
+	}
+	var out bytes.Buffer
+	snapshot := &Snapshot{Valid: true, Memory: &Memory{Buffer: buffer, Min: 4096, Cap: 4096}}
+	if err := WriteSnapshot(&out, snapshot); err != nil {
+		b.Fatal(err)
+	}
+	encoded := out.Bytes()
+
+	b.Run("seeker", func(b *testing.B) {
+		b.SetBytes(int64(len(encoded)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ReadSnapshot(bytes.NewReader(encoded)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("reader", func(b *testing.B) {
+		b.SetBytes(int64(len(encoded)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ReadSnapshot(struct{ io.Reader }{bytes.NewReader(encoded)}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// writeSnapshotFile writes the snapshot to a file, which is closed when the test completes.
+func writeSnapshotFile(t *testing.T, snapshot *Snapshot) *os.File {
+	f, err := os.Create(path.Join(t.TempDir(), "snapshot.bin"))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	require.NoError(t, WriteSnapshot(f, snapshot))
+	return f
+}
@@ -0,0 +1,80 @@
+package proto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// PageTransform transforms each page of the memory buffer as it is written, e.g. to encrypt it at rest with a key the
+// embedder holds, and inverts it as it is read. Pages are memoryPageSize bytes, except maybe the last, and are indexed
+// from zero, so that a transform can derive per-page state from pageIndex, e.g. a nonce.
+//
+// Note: Only FeatureMemoryTransform is recorded in the snapshot, not which transform, nor its key.
+type PageTransform interface {
+	// Transform returns the bytes to write for the page data, which may differ in length, e.g. to add a nonce and tag.
+	Transform(pageIndex int, data []byte) ([]byte, error)
+
+	// Invert is the inverse of Transform, returning the page data.
+	Invert(pageIndex int, data []byte) ([]byte, error)
+}
+
+// checkTransform returns an error unless a transform is given exactly when the snapshot's memory is transformed, as
+// memory read without inverting it would be garbage, and a snapshot that wasn't transformed may have been substituted
+// for one that was.
+func checkTransform(snapshot *Snapshot, transform PageTransform) error {
+	transformed := snapshot.GetFeatures()&FeatureMemoryTransform != 0
+	if transformed && transform == nil {
+		return errors.New("snapshot memory is transformed, but no transform is given")
+	} else if !transformed && transform != nil {
+		return errors.New("snapshot memory is not transformed, but a transform is given")
+	}
+	return nil
+}
+
+// writeTransformed writes each page of buffer transformed, prefixed by the uvarint length it was transformed to.
+func writeTransformed(w io.Writer, buffer []byte, transform PageTransform) error {
+	for pageIndex, page := 0, buffer; len(page) > 0; pageIndex++ {
+		n := len(page)
+		if n > memoryPageSize {
+			n = memoryPageSize
+		}
+		data, err := transform.Transform(pageIndex, page[:n])
+		if err != nil {
+			return fmt.Errorf("failed to transform page %d: %w", pageIndex, err)
+		}
+		if err = writeUvarint(w, uint64(len(data))); err != nil {
+			return err
+		}
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+		page = page[n:]
+	}
+	return nil
+}
+
+// readTransformed is the inverse of writeTransformed, reading a buffer of the given size.
+func readTransformed(br *bufio.Reader, size uint64, transform PageTransform) ([]byte, error) {
+	var ret []byte
+	for pageIndex := 0; uint64(len(ret)) < size; pageIndex++ {
+		data, err := readDelimited(br)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		page, err := transform.Invert(pageIndex, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to invert page %d: %w", pageIndex, err)
+		}
+		expected := size - uint64(len(ret))
+		if expected > memoryPageSize {
+			expected = memoryPageSize
+		}
+		if uint64(len(page)) != expected {
+			return nil, fmt.Errorf("page %d has %d bytes once inverted, but expected %d", pageIndex, len(page), expected)
+		}
+		ret = append(ret, page...)
+	}
+	return ret, nil
+}
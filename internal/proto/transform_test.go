@@ -0,0 +1,159 @@
+package proto
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+	pb "google.golang.org/protobuf/proto"
+)
+
+// xorTransform xors each byte of a page with key, prefixing it with the page index, so the transformed length differs.
+type xorTransform struct {
+	key byte
+	err error
+}
+
+func (x *xorTransform) Transform(pageIndex int, data []byte) ([]byte, error) {
+	if x.err != nil {
+		return nil, x.err
+	}
+	ret := []byte{byte(pageIndex)}
+	for _, b := range data {
+		ret = append(ret, b^x.key)
+	}
+	return ret, nil
+}
+
+func (x *xorTransform) Invert(pageIndex int, data []byte) ([]byte, error) {
+	if x.err != nil {
+		return nil, x.err
+	}
+	if len(data) == 0 || data[0] != byte(pageIndex) {
+		return nil, errors.New("wrong page")
+	}
+	ret := make([]byte, 0, len(data)-1)
+	for _, b := range data[1:] {
+		ret = append(ret, b^x.key)
+	}
+	return ret, nil
+}
+
+// identityTransform leaves pages as they are.
+type identityTransform struct{}
+
+func (identityTransform) Transform(_ int, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (identityTransform) Invert(_ int, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func TestWriteSnapshotTransform_ReadSnapshotTransform(t *testing.T) {
+	buffer := make([]byte, memoryPageSize*2+3)
+	for i := range buffer {
+		buffer[i] = byte(i)
+	}
+
+	tests := []struct {
+		name     string
+		snapshot *Snapshot
+	}{
+		{
+			name:     "no memory",
+			snapshot: &Snapshot{Valid: true, Stack: []uint64{1, 2}, Features: FeatureMemoryTransform},
+		},
+		{
+			name:     "empty memory",
+			snapshot: &Snapshot{Valid: true, Memory: &Memory{Min: 1}, Features: FeatureMemoryTransform},
+		},
+		{
+			name: "memory spanning pages",
+			snapshot: &Snapshot{
+				Valid:    true,
+				Memory:   &Memory{Buffer: buffer, Min: 3, Cap: 3, Max: 10},
+				Features: FeatureMemoryTransform,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			transform := &xorTransform{key: 0x5a}
+			var out bytes.Buffer
+			require.NoError(t, WriteSnapshotTransform(&out, tc.snapshot, transform))
+			// EncodedSize assumes the length of each page is kept, but xorTransform adds a byte to each.
+			require.Equal(t, EncodedSize(tc.snapshot)+pageCount(tc.snapshot), out.Len())
+			if len(tc.snapshot.GetMemory().GetBuffer()) > 0 {
+				require.False(t, bytes.Contains(out.Bytes(), buffer[:16]))
+			}
+
+			snapshot, err := ReadSnapshotTransform(&out, transform)
+			require.NoError(t, err)
+			require.True(t, pb.Equal(tc.snapshot, snapshot))
+		})
+	}
+}
+
+func TestReadSnapshotTransform_Errors(t *testing.T) {
+	snapshot := &Snapshot{Valid: true, Memory: &Memory{Buffer: []byte{1, 2, 3}}}
+	transformed := &Snapshot{Valid: true, Memory: &Memory{Buffer: []byte{1, 2, 3}}, Features: FeatureMemoryTransform}
+
+	var plain, out bytes.Buffer
+	require.NoError(t, WriteSnapshot(&plain, snapshot))
+	require.NoError(t, WriteSnapshotTransform(&out, transformed, &xorTransform{key: 1}))
+	encoded := out.Bytes()
+
+	t.Run("write without transform", func(t *testing.T) {
+		err := WriteSnapshot(&bytes.Buffer{}, transformed)
+		require.EqualError(t, err, "snapshot memory is transformed, but no transform is given")
+	})
+	t.Run("write without feature", func(t *testing.T) {
+		err := WriteSnapshotTransform(&bytes.Buffer{}, snapshot, &xorTransform{})
+		require.EqualError(t, err, "snapshot memory is not transformed, but a transform is given")
+	})
+	t.Run("transform errs", func(t *testing.T) {
+		err := WriteSnapshotTransform(&bytes.Buffer{}, transformed, &xorTransform{err: errors.New("boom")})
+		require.EqualError(t, err, "failed to transform page 0: boom")
+	})
+	t.Run("read without transform", func(t *testing.T) {
+		_, err := ReadSnapshot(bytes.NewReader(encoded))
+		require.EqualError(t, err, "snapshot memory is transformed, but no transform is given")
+	})
+	t.Run("map", func(t *testing.T) {
+		f, err := os.Create(path.Join(t.TempDir(), "snapshot.bin"))
+		require.NoError(t, err)
+		defer f.Close()
+		_, err = f.Write(encoded)
+		require.NoError(t, err)
+
+		_, _, _, err = MapSnapshot(f)
+		require.EqualError(t, err, "snapshot memory is transformed, but no transform is given")
+	})
+	t.Run("read plain with transform", func(t *testing.T) {
+		_, err := ReadSnapshotTransform(bytes.NewReader(plain.Bytes()), &xorTransform{})
+		require.EqualError(t, err, "snapshot memory is not transformed, but a transform is given")
+	})
+	t.Run("invert errs", func(t *testing.T) {
+		_, err := ReadSnapshotTransform(bytes.NewReader(encoded), &xorTransform{err: errors.New("boom")})
+		require.EqualError(t, err, "failed to read memory: failed to invert page 0: boom")
+	})
+	t.Run("inverted to wrong length", func(t *testing.T) {
+		_, err := ReadSnapshotTransform(bytes.NewReader(encoded), identityTransform{})
+		require.EqualError(t, err, "failed to read memory: page 0 has 4 bytes once inverted, but expected 3")
+	})
+	t.Run("truncated", func(t *testing.T) {
+		_, err := ReadSnapshotTransform(bytes.NewReader(encoded[:len(encoded)-1]), &xorTransform{key: 1})
+		require.EqualError(t, err, "failed to read memory: unexpected EOF")
+	})
+}
+
+func pageCount(snapshot *Snapshot) int {
+	return (len(snapshot.GetMemory().GetBuffer()) + memoryPageSize - 1) / memoryPageSize
+}
@@ -2,11 +2,14 @@ package sys
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"math"
 	"os"
+	"sort"
 	"sync/atomic"
 	"syscall"
 )
@@ -43,6 +46,32 @@ type FileEntry struct {
 	Path string
 	// File when nil this is the root "/" (fd=3)
 	File fs.File
+	// Offset is the offset in File when the entry was copied into a snapshot, per FileOffset, which ReopenFiles seeks
+	// the reopened file to. It is zero otherwise, as File tracks its own offset.
+	Offset int64
+}
+
+// UnopenedFile is the File of a FileEntry that has a path and offset, but no open file, such as one read from an
+// exported snapshot. ReopenFiles opens the path again, so it is never read.
+var UnopenedFile fs.File = unopenedFile{}
+
+type unopenedFile struct{}
+
+func (unopenedFile) Stat() (fs.FileInfo, error) { return nil, fs.ErrClosed }
+func (unopenedFile) Read([]byte) (int, error)   { return 0, fs.ErrClosed }
+func (unopenedFile) Close() error               { return nil }
+
+// FileOffset returns the current offset in the file, for FileEntry Offset, or -1 if it cannot be read as the file isn't
+// an io.Seeker. A directory that isn't one is at offset zero, as fd_readdir reads from a cookie instead.
+func FileOffset(f fs.File) int64 {
+	if seeker, ok := f.(io.Seeker); ok {
+		if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			return offset
+		}
+	} else if _, ok = f.(fs.ReadDirFile); ok {
+		return 0
+	}
+	return -1
 }
 
 type FSContext struct {
@@ -82,6 +111,115 @@ func (c *FSContext) SetLastFD(lastFD uint32) {
 	c.lastFD = lastFD
 }
 
+// ReopenFiles replaces the opened files with the given ones, such as those in a snapshot, opening each path again in
+// the file system of this context rather than reusing the recorded fs.File. This allows resuming against a different,
+// but equivalent, fs.FS, for example when an input file moved.
+//
+// Each reopened file is moved to the FileEntry Offset, so that a guest resumed in the middle of reading it continues
+// where it left off, rather than reading again what it already consumed.
+//
+// If a path cannot be opened, or the file cannot be moved to its offset, e.g. as it isn't an io.Seeker, this returns an
+// error identifying the file descriptor and path, leaving the opened files unchanged. Otherwise, any previously opened
+// files are closed.
+func (c *FSContext) ReopenFiles(openedFiles map[uint32]*FileEntry) error {
+	fds := make([]uint32, 0, len(openedFiles))
+	for fd := range openedFiles {
+		fds = append(fds, fd)
+	}
+	sort.Slice(fds, func(i, j int) bool { return fds[i] < fds[j] }) // for a deterministic error
+
+	if c == emptyFSContext { // This is shared, so must not be mutated.
+		if len(fds) == 0 {
+			return nil
+		}
+		return fmt.Errorf("failed to reopen fd %d (%s): no file system", fds[0], openedFiles[fds[0]].Path)
+	}
+
+	reopened := make(map[uint32]*FileEntry, len(openedFiles))
+	for _, fd := range fds {
+		entry := openedFiles[fd]
+		if entry.File == nil { // The root entry
+			reopened[fd] = &FileEntry{Path: entry.Path}
+			continue
+		}
+
+		f, err := c.reopen(entry)
+		if err != nil {
+			for _, e := range reopened {
+				if e.File != nil {
+					_ = e.File.Close()
+				}
+			}
+			return fmt.Errorf("failed to reopen fd %d (%s): %w", fd, entry.Path, err)
+		}
+		reopened[fd] = &FileEntry{Path: entry.Path, File: f}
+	}
+
+	_ = c.Close(context.Background())
+	c.openedFiles = reopened
+	return nil
+}
+
+// CheckFiles returns an error for each of the given opened files, such as those in a snapshot, which ReopenFiles would
+// fail to open or seek, ordered by file descriptor. Each file that can be opened is closed again, leaving this context as is.
+func (c *FSContext) CheckFiles(openedFiles map[uint32]*FileEntry) (errs []error) {
+	fds := make([]uint32, 0, len(openedFiles))
+	for fd := range openedFiles {
+		fds = append(fds, fd)
+	}
+	sort.Slice(fds, func(i, j int) bool { return fds[i] < fds[j] })
+
+	for _, fd := range fds {
+		entry := openedFiles[fd]
+		if c == emptyFSContext {
+			errs = append(errs, fmt.Errorf("fd %d (%s): no file system", fd, entry.Path))
+			continue
+		}
+		if entry.File == nil { // The root entry
+			continue
+		}
+		f, err := c.reopen(entry)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("fd %d (%s): %w", fd, entry.Path, err))
+			continue
+		}
+		_ = f.Close()
+	}
+	return
+}
+
+// reopen opens the path of the entry in the file system of this context and moves the file to the entry's offset.
+func (c *FSContext) reopen(entry *FileEntry) (fs.File, error) {
+	f, err := c.fs.Open(fsOpenPath(entry.Path))
+	if err != nil {
+		return nil, err
+	}
+	if entry.Offset == 0 {
+		return f, nil
+	}
+
+	if entry.Offset < 0 {
+		err = errors.New("offset is unknown, as the file wasn't an io.Seeker when snapshotted")
+	} else if seeker, ok := f.(io.Seeker); !ok {
+		err = fmt.Errorf("cannot seek to offset %d: %T is not an io.Seeker", entry.Offset, f)
+	} else if _, err = seeker.Seek(entry.Offset, io.SeekStart); err != nil {
+		err = fmt.Errorf("cannot seek to offset %d: %w", entry.Offset, err)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// fsOpenPath returns the name as an fs.ValidPath, which cannot start with '/'.
+func fsOpenPath(name string) string {
+	if name != "" && name[0] == '/' {
+		return name[1:]
+	}
+	return name
+}
+
 // NewFSContext returns a mutable context if the fs is not EmptyFS.
 func NewFSContext(fs fs.FS) *FSContext {
 	if fs == EmptyFS {
@@ -179,9 +317,9 @@ func (c *FSContext) Close(_ context.Context) (err error) {
 func FdWriter(ctx context.Context, sysCtx *Context, fd uint32) io.Writer {
 	switch fd {
 	case FdStdout:
-		return sysCtx.Stdout()
+		return &countingWriter{w: sysCtx.stdout, count: &sysCtx.stdoutWritten}
 	case FdStderr:
-		return sysCtx.Stderr()
+		return &countingWriter{w: sysCtx.stderr, count: &sysCtx.stderrWritten}
 	default:
 		// Check to see if the file descriptor is available
 		if f, ok := sysCtx.FS(ctx).OpenedFile(ctx, fd); !ok || f.File == nil {
@@ -195,10 +333,36 @@ func FdWriter(ctx context.Context, sysCtx *Context, fd uint32) io.Writer {
 	}
 }
 
+// countingWriter adds the count of bytes written to w to count. See Context.Written
+type countingWriter struct {
+	w     io.Writer
+	count *uint64
+}
+
+// Write implements io.Writer
+func (c *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	*c.count += uint64(n)
+	return
+}
+
+// countingReader adds the count of bytes read from r to count. See Context.StdinRead and Context.RandRead
+type countingReader struct {
+	r     io.Reader
+	count *uint64
+}
+
+// Read implements io.Reader
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	*c.count += uint64(n)
+	return
+}
+
 // FdReader returns a valid reader for the given file descriptor or nil if syscall.EBADF.
 func FdReader(ctx context.Context, sysCtx *Context, fd uint32) io.Reader {
 	if fd == FdStdin {
-		return sysCtx.Stdin()
+		return &countingReader{r: sysCtx.stdin, count: &sysCtx.stdinRead}
 	} else if f, ok := sysCtx.FS(ctx).OpenedFile(ctx, fd); !ok {
 		return nil
 	} else {
@@ -3,7 +3,9 @@ package sys
 import (
 	"context"
 	"errors"
+	"io"
 	"testing"
+	"testing/fstest"
 
 	testfs "github.com/tetratelabs/wazero/internal/testing/fs"
 	"github.com/tetratelabs/wazero/internal/testing/require"
@@ -83,3 +85,137 @@ func TestContext_Close_Error(t *testing.T) {
 	// Paths should clear even under error
 	require.Zero(t, len(fsc.openedFiles), "expected no opened files")
 }
+
+func TestFSContext_ReopenFiles(t *testing.T) {
+	moved := &testfs.File{}
+	fsc := NewFSContext(testfs.FS{"moved/foo": moved})
+
+	// The recorded file is from a different file system, so must not be reused.
+	err := fsc.ReopenFiles(map[uint32]*FileEntry{
+		3: {Path: "/"},
+		4: {Path: "/moved/foo", File: &testfs.File{}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[uint32]*FileEntry{
+		3: {Path: "/"},
+		4: {Path: "/moved/foo", File: moved},
+	}, fsc.openedFiles)
+}
+
+func TestFSContext_ReopenFiles_Offset(t *testing.T) {
+	fsc := NewFSContext(fstest.MapFS{"foo": {Data: []byte("abcdef")}})
+
+	err := fsc.ReopenFiles(map[uint32]*FileEntry{4: {Path: "/foo", File: UnopenedFile, Offset: 2}})
+	require.NoError(t, err)
+
+	// The guest continues reading where it left off.
+	buf, err := io.ReadAll(fsc.openedFiles[4].File)
+	require.NoError(t, err)
+	require.Equal(t, "cdef", string(buf))
+}
+
+func TestFileOffset(t *testing.T) {
+	fsys := fstest.MapFS{"foo": {Data: []byte("abcdef")}, "dir/bar": {}}
+
+	f, err := fsys.Open("foo")
+	require.NoError(t, err)
+	_, err = f.Read(make([]byte, 4))
+	require.NoError(t, err)
+	require.Equal(t, int64(4), FileOffset(f))
+
+	dir, err := fsys.Open("dir")
+	require.NoError(t, err)
+	require.Zero(t, FileOffset(dir))
+
+	require.Equal(t, int64(-1), FileOffset(UnopenedFile))
+}
+
+func TestFSContext_CheckFiles(t *testing.T) {
+	fsc := NewFSContext(testfs.FS{"foo": &testfs.File{}})
+	openedFiles := fsc.openedFiles
+
+	errs := fsc.CheckFiles(map[uint32]*FileEntry{
+		3: {Path: "/"},
+		4: {Path: "/foo", File: &testfs.File{}},
+		5: {Path: "/bar", File: &testfs.File{}},
+		6: {Path: "/baz", File: &testfs.File{}},
+	})
+	require.Equal(t, 2, len(errs))
+	require.EqualError(t, errs[0], "fd 5 (/bar): open bar: file does not exist")
+	require.EqualError(t, errs[1], "fd 6 (/baz): open baz: file does not exist")
+
+	// Checking doesn't change the opened files.
+	require.Equal(t, openedFiles, fsc.openedFiles)
+
+	t.Run("empty file system", func(t *testing.T) {
+		fsc := NewFSContext(EmptyFS)
+		require.Nil(t, fsc.CheckFiles(nil))
+
+		errs := fsc.CheckFiles(map[uint32]*FileEntry{3: {Path: "/"}})
+		require.Equal(t, 1, len(errs))
+		require.EqualError(t, errs[0], "fd 3 (/): no file system")
+	})
+}
+
+func TestFSContext_ReopenFiles_Errors(t *testing.T) {
+	t.Run("missing path", func(t *testing.T) {
+		fsc := NewFSContext(testfs.FS{"foo": &testfs.File{}})
+		openedFiles := fsc.openedFiles
+
+		err := fsc.ReopenFiles(map[uint32]*FileEntry{
+			3: {Path: "/"},
+			4: {Path: "/foo", File: &testfs.File{}},
+			5: {Path: "/bar", File: &testfs.File{}},
+		})
+		require.EqualError(t, err, "failed to reopen fd 5 (/bar): open bar: file does not exist")
+
+		// The opened files are unchanged on error.
+		require.Equal(t, openedFiles, fsc.openedFiles)
+	})
+
+	t.Run("offset", func(t *testing.T) {
+		fsc := NewFSContext(fstest.MapFS{"foo": {}, "dir/bar": {}})
+		openedFiles := fsc.openedFiles
+
+		tests := []struct {
+			name        string
+			entry       *FileEntry
+			expectedErr string
+		}{
+			{
+				name:        "unknown",
+				entry:       &FileEntry{Path: "/foo", File: UnopenedFile, Offset: -1},
+				expectedErr: "failed to reopen fd 4 (/foo): offset is unknown, as the file wasn't an io.Seeker when snapshotted",
+			},
+			{
+				name:        "not a seeker",
+				entry:       &FileEntry{Path: "/dir", File: UnopenedFile, Offset: 3},
+				expectedErr: "failed to reopen fd 4 (/dir): cannot seek to offset 3: *fstest.mapDir is not an io.Seeker",
+			},
+		}
+
+		for _, tt := range tests {
+			tc := tt
+
+			t.Run(tc.name, func(t *testing.T) {
+				err := fsc.ReopenFiles(map[uint32]*FileEntry{4: tc.entry})
+				require.EqualError(t, err, tc.expectedErr)
+
+				// The file isn't silently rewound, rather the opened files are unchanged.
+				require.Equal(t, openedFiles, fsc.openedFiles)
+
+				errs := fsc.CheckFiles(map[uint32]*FileEntry{4: tc.entry})
+				require.Equal(t, 1, len(errs))
+			})
+		}
+	})
+
+	t.Run("empty file system", func(t *testing.T) {
+		fsc := NewFSContext(EmptyFS)
+		require.NoError(t, fsc.ReopenFiles(nil))
+
+		err := fsc.ReopenFiles(map[uint32]*FileEntry{3: {Path: "/"}})
+		require.EqualError(t, err, "failed to reopen fd 3 (/): no file system")
+		require.Equal(t, map[uint32]*FileEntry{}, emptyFSContext.openedFiles)
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"time"
 
 	"github.com/tetratelabs/wazero/internal/platform"
@@ -20,6 +21,16 @@ type Context struct {
 	argsSize, environSize uint32
 	stdin                 io.Reader
 	stdout, stderr        io.Writer
+	// stdinRead counts the bytes read via FdReader.
+	stdinRead uint64
+	// stdoutWritten and stderrWritten count the bytes written via FdWriter.
+	stdoutWritten, stderrWritten uint64
+	// randRead counts the bytes read via RandSource.
+	randRead uint64
+	// walltimeOffset and nanotimeOffset are added to the readings of the clocks. See RestoreClocks
+	walltimeOffset, nanotimeOffset int64
+	// lastWalltime and lastNanotime are the last readings of the clocks, in nanoseconds. See Clocks
+	lastWalltime, lastNanotime int64
 
 	// Note: Using function pointers here keeps them stable for tests.
 
@@ -66,6 +77,22 @@ func (c *Context) EnvironSize() uint32 {
 	return c.environSize
 }
 
+// RestoreArgsEnviron replaces Args and Environ, e.g. with those captured in a snapshot, so that a resumed program sees
+// the ones it started with. This errs without replacing either if they are invalid, as validated by NewContext.
+func (c *Context) RestoreArgsEnviron(args, environ []string) error {
+	argsSize, err := nullTerminatedByteCount(math.MaxUint32, args)
+	if err != nil {
+		return fmt.Errorf("args invalid: %w", err)
+	}
+	environSize, err := nullTerminatedByteCount(math.MaxUint32, environ)
+	if err != nil {
+		return fmt.Errorf("environ invalid: %w", err)
+	}
+	c.args, c.argsSize = args, argsSize
+	c.environ, c.environSize = environ, environSize
+	return nil
+}
+
 // Stdin is like exec.Cmd Stdin and defaults to a reader of os.DevNull.
 // See wazero.ModuleConfig WithStdin
 func (c *Context) Stdin() io.Reader {
@@ -84,9 +111,68 @@ func (c *Context) Stderr() io.Writer {
 	return c.stderr
 }
 
-// Walltime implements sys.Walltime.
+// StdinRead returns the count of bytes read from Stdin via FdReader, e.g. by WASI fd_read. This includes those skipped
+// by SkipStdin, so a resumed program continues the count of the one that took the snapshot.
+func (c *Context) StdinRead() uint64 {
+	return c.stdinRead
+}
+
+// SkipStdin discards the first n bytes of Stdin, e.g. those a program read before the snapshot it is resumed from, so
+// they are neither delivered again nor lost. This is relative to the bytes StdinRead already, so when the program
+// read more since, e.g. as it is reset to an earlier snapshot in place, this seeks back. This errs if Stdin has to
+// move and isn't an io.Seeker, or seeking fails, e.g. as Stdin is a pipe.
+func (c *Context) SkipStdin(n uint64) error {
+	if n == c.stdinRead {
+		return nil
+	}
+	if err := c.CheckSkipStdin(n); err != nil {
+		return err
+	}
+	if _, err := c.stdin.(io.Seeker).Seek(int64(n)-int64(c.stdinRead), io.SeekCurrent); err != nil {
+		return fmt.Errorf("cannot skip %d bytes of stdin read before the snapshot: %w", n, err)
+	}
+	c.stdinRead = n
+	return nil
+}
+
+// CheckSkipStdin returns the error SkipStdin would before seeking, e.g. as Stdin isn't an io.Seeker, without skipping,
+// so that a caller can check a snapshot can be restored before overwriting any state.
+func (c *Context) CheckSkipStdin(n uint64) error {
+	if n == c.stdinRead {
+		return nil
+	}
+	if _, ok := c.stdin.(io.Seeker); !ok {
+		return fmt.Errorf("cannot skip %d bytes of stdin read before the snapshot: %T is not an io.Seeker", n, c.stdin)
+	}
+	if n > math.MaxInt64 || c.stdinRead > math.MaxInt64 {
+		return fmt.Errorf("cannot skip %d bytes of stdin read before the snapshot: offset overflows int64", n)
+	}
+	return nil
+}
+
+// Written returns the count of bytes written to Stdout and Stderr via FdWriter, e.g. by WASI fd_write. This includes
+// those restored by SetWritten, so a resumed program continues the count of the one that took the snapshot.
+//
+// Note: This counts bytes the writer accepted, so bytes buffered by it, e.g. by a bufio.Writer, count before they
+// are flushed.
+func (c *Context) Written() (stdout, stderr uint64) {
+	return c.stdoutWritten, c.stderrWritten
+}
+
+// SetWritten overwrites the counts returned by Written, e.g. with those captured in a snapshot.
+func (c *Context) SetWritten(stdout, stderr uint64) {
+	c.stdoutWritten, c.stderrWritten = stdout, stderr
+}
+
+// Walltime implements sys.Walltime, offset by RestoreClocks.
 func (c *Context) Walltime(ctx context.Context) (sec int64, nsec int32) {
-	return (*(c.walltime))(ctx)
+	sec, nsec = (*(c.walltime))(ctx)
+	if c.walltimeOffset != 0 {
+		ns := sec*1e9 + int64(nsec) + c.walltimeOffset
+		sec, nsec = ns/1e9, int32(ns%1e9)
+	}
+	c.lastWalltime = sec*1e9 + int64(nsec)
+	return
 }
 
 // WalltimeResolution returns resolution of Walltime.
@@ -94,9 +180,10 @@ func (c *Context) WalltimeResolution() sys.ClockResolution {
 	return c.walltimeResolution
 }
 
-// Nanotime implements sys.Nanotime.
+// Nanotime implements sys.Nanotime, offset by RestoreClocks.
 func (c *Context) Nanotime(ctx context.Context) int64 {
-	return (*(c.nanotime))(ctx)
+	c.lastNanotime = (*(c.nanotime))(ctx) + c.nanotimeOffset
+	return c.lastNanotime
 }
 
 // NanotimeResolution returns resolution of Nanotime.
@@ -104,6 +191,33 @@ func (c *Context) NanotimeResolution() sys.ClockResolution {
 	return c.nanotimeResolution
 }
 
+// Clocks returns the last readings of Walltime and Nanotime, in nanoseconds, or zero for a clock not read yet. Reading
+// them here doesn't read the clocks, so it doesn't advance a fake clock.
+func (c *Context) Clocks() (walltime, nanotime int64) {
+	return c.lastWalltime, c.lastNanotime
+}
+
+// RestoreClocks offsets Walltime and Nanotime so that they continue from the given readings, e.g. those captured in a
+// snapshot by Clocks, as if no time passed since. This reads each clock given a non-zero reading once, to compute its
+// offset. A clock given zero, i.e. not read before the snapshot, is reset to the configured one, clearing any offset
+// restored before, e.g. when a module is Reset in place more than once.
+//
+// Note: Offsetting Walltime keeps the clock a resumed program sees consistent, at the cost of it lagging the wall
+// clock of the host by the time the program was suspended.
+func (c *Context) RestoreClocks(ctx context.Context, walltime, nanotime int64) {
+	c.walltimeOffset, c.lastWalltime = 0, 0
+	if walltime != 0 {
+		sec, nsec := (*(c.walltime))(ctx)
+		c.walltimeOffset = walltime - (sec*1e9 + int64(nsec))
+		c.lastWalltime = walltime
+	}
+	c.nanotimeOffset, c.lastNanotime = 0, 0
+	if nanotime != 0 {
+		c.nanotimeOffset = nanotime - (*(c.nanotime))(ctx)
+		c.lastNanotime = nanotime
+	}
+}
+
 // Nanosleep implements sys.Nanosleep.
 func (c *Context) Nanosleep(ctx context.Context, ns int64) {
 	(*(c.nanosleep))(ctx, ns)
@@ -118,10 +232,54 @@ func (c *Context) FS(ctx context.Context) *FSContext {
 	return c.fsc
 }
 
-// RandSource is a source of random bytes and defaults to crypto/rand.Reader.
+// RandSource is a source of random bytes and defaults to crypto/rand.Reader. Bytes read from it count towards RandRead.
 // see wazero.ModuleConfig WithRandSource
 func (c *Context) RandSource() io.Reader {
-	return c.randSource
+	return &countingReader{r: c.randSource, count: &c.randRead}
+}
+
+// RandRead returns the count of bytes read from RandSource, e.g. by WASI random_get. This includes those skipped by
+// SkipRand, so a resumed program continues the count of the one that took the snapshot.
+func (c *Context) RandRead() uint64 {
+	return c.randRead
+}
+
+// SkipRand discards the first n bytes of RandSource, e.g. those a program read before the snapshot it is resumed from,
+// so that a deterministic source continues where it left off. Like SkipStdin, this is relative to the bytes RandRead
+// already, so when the program read more since, this seeks back if the source is an io.Seeker. This errs if the
+// source ends or errs before n bytes.
+//
+// Note: This reads the bytes to discard, as a source can't generally seek. Skipping them from a source that isn't
+// deterministic, e.g. crypto/rand.Reader, is harmless, but wasted, and such a source isn't rewound, but continues.
+func (c *Context) SkipRand(n uint64) error {
+	if n == c.randRead {
+		return nil
+	}
+	if err := c.CheckSkipRand(n); err != nil {
+		return err
+	}
+	if n < c.randRead {
+		if seeker, ok := c.randSource.(io.Seeker); ok {
+			if _, err := seeker.Seek(int64(n)-int64(c.randRead), io.SeekCurrent); err != nil {
+				return fmt.Errorf("cannot skip %d random bytes read before the snapshot: %w", n, err)
+			}
+		}
+	} else if _, err := io.CopyN(io.Discard, c.randSource, int64(n-c.randRead)); err != nil {
+		return fmt.Errorf("cannot skip %d random bytes read before the snapshot: %w", n, err)
+	}
+	c.randRead = n
+	return nil
+}
+
+// CheckSkipRand returns the error SkipRand would before reading, i.e. if the count overflows, without skipping. Like
+// CheckSkipStdin, this allows checking a snapshot can be restored before overwriting any state.
+//
+// Note: Whether the source ends before n bytes can only be known by reading it, so SkipRand can still err.
+func (c *Context) CheckSkipRand(n uint64) error {
+	if n > math.MaxInt64 || c.randRead > math.MaxInt64 {
+		return fmt.Errorf("cannot skip %d random bytes read before the snapshot: count overflows int64", n)
+	}
+	return nil
 }
 
 // eofReader is safer than reading from os.DevNull as it can never overrun operating system file descriptors.
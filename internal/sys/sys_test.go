@@ -5,6 +5,8 @@ import (
 	"context"
 	"crypto/rand"
 	"io"
+	"math"
+	"strings"
 	"testing"
 	"time"
 
@@ -45,7 +47,7 @@ func TestDefaultSysContext(t *testing.T) {
 	require.Zero(t, sysCtx.Nanotime(testCtx)) // See above on functions.
 	require.Equal(t, sys.ClockResolution(1), sysCtx.NanotimeResolution())
 	require.Equal(t, &ns, sysCtx.nanosleep)
-	require.Equal(t, rand.Reader, sysCtx.RandSource())
+	require.Equal(t, &countingReader{r: rand.Reader, count: &sysCtx.randRead}, sysCtx.RandSource())
 	require.Equal(t, NewFSContext(testfs.FS{}), sysCtx.FS(testCtx))
 }
 
@@ -173,6 +175,281 @@ func TestNewContext_Environ(t *testing.T) {
 	}
 }
 
+func TestContext_RestoreArgsEnviron(t *testing.T) {
+	sysCtx, err := NewContext(math.MaxUint32, []string{"a"}, []string{"b=c"}, nil, nil, nil, nil, nil, 0, nil, 0, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, sysCtx.RestoreArgsEnviron([]string{"wasi", "x"}, nil))
+	require.Equal(t, []string{"wasi", "x"}, sysCtx.Args())
+	require.Equal(t, uint32(7), sysCtx.ArgsSize())
+	require.Nil(t, sysCtx.Environ())
+	require.Equal(t, uint32(0), sysCtx.EnvironSize())
+
+	err = sysCtx.RestoreArgsEnviron([]string{"y"}, []string{string([]byte{'a', 0})})
+	require.EqualError(t, err, "environ invalid: contains NUL character")
+	require.Equal(t, []string{"wasi", "x"}, sysCtx.Args()) // unchanged
+}
+
+func TestContext_Written(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	sysCtx, err := NewContext(0, nil, nil, nil, &stdout, &stderr, nil, nil, 0, nil, 0, nil, nil)
+	require.NoError(t, err)
+
+	_, err = FdWriter(testCtx, sysCtx, FdStdout).Write([]byte("abc"))
+	require.NoError(t, err)
+	_, err = FdWriter(testCtx, sysCtx, FdStderr).Write([]byte("de"))
+	require.NoError(t, err)
+	_, err = FdWriter(testCtx, sysCtx, FdStdout).Write([]byte("f"))
+	require.NoError(t, err)
+
+	require.Equal(t, "abcf", stdout.String())
+	require.Equal(t, "de", stderr.String())
+	stdoutWritten, stderrWritten := sysCtx.Written()
+	require.Equal(t, uint64(4), stdoutWritten)
+	require.Equal(t, uint64(2), stderrWritten)
+
+	sysCtx.SetWritten(10, 20)
+	_, err = FdWriter(testCtx, sysCtx, FdStderr).Write([]byte("g"))
+	require.NoError(t, err)
+	stdoutWritten, stderrWritten = sysCtx.Written()
+	require.Equal(t, uint64(10), stdoutWritten)
+	require.Equal(t, uint64(21), stderrWritten)
+}
+
+func TestContext_StdinRead(t *testing.T) {
+	sysCtx, err := NewContext(0, nil, nil, strings.NewReader("abcdef"), nil, nil, nil, nil, 0, nil, 0, nil, nil)
+	require.NoError(t, err)
+
+	buf := make([]byte, 4)
+	n, err := FdReader(testCtx, sysCtx, FdStdin).Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	require.Equal(t, uint64(4), sysCtx.StdinRead())
+
+	_, err = FdReader(testCtx, sysCtx, FdStdin).Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), sysCtx.StdinRead())
+}
+
+func TestContext_SkipStdin(t *testing.T) {
+	tests := []struct {
+		name          string
+		stdin         io.Reader
+		read, n       uint64
+		expected      string
+		expectedErr   string
+		expectedCount uint64
+	}{
+		{
+			name:     "nothing to skip",
+			stdin:    bytes.NewBufferString("abc"),
+			expected: "abc",
+		},
+		{
+			name:          "seekable",
+			stdin:         strings.NewReader("abcdef"),
+			n:             4,
+			expected:      "ef",
+			expectedCount: 4,
+		},
+		{
+			name:          "seekable, read since",
+			stdin:         strings.NewReader("abcdef"),
+			read:          5,
+			n:             2,
+			expected:      "cdef",
+			expectedCount: 2,
+		},
+		{
+			name:          "read as many",
+			stdin:         bytes.NewBufferString("abc"),
+			read:          2,
+			n:             2,
+			expected:      "c",
+			expectedCount: 2,
+		},
+		{
+			name:        "not seekable",
+			stdin:       bytes.NewBufferString("abc"),
+			n:           2,
+			expected:    "abc",
+			expectedErr: "cannot skip 2 bytes of stdin read before the snapshot: *bytes.Buffer is not an io.Seeker",
+		},
+		{
+			name:        "overflow",
+			stdin:       strings.NewReader("abc"),
+			n:           math.MaxUint64,
+			expected:    "abc",
+			expectedErr: "cannot skip 18446744073709551615 bytes of stdin read before the snapshot: offset overflows int64",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			sysCtx, err := NewContext(0, nil, nil, tc.stdin, nil, nil, nil, nil, 0, nil, 0, nil, nil)
+			require.NoError(t, err)
+			_, err = io.ReadFull(FdReader(testCtx, sysCtx, FdStdin), make([]byte, tc.read))
+			require.NoError(t, err)
+
+			err = sysCtx.SkipStdin(tc.n)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.expectedCount, sysCtx.StdinRead())
+
+			rest, err := io.ReadAll(FdReader(testCtx, sysCtx, FdStdin))
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(rest))
+		})
+	}
+}
+
+func TestContext_RandRead(t *testing.T) {
+	sysCtx, err := NewContext(0, nil, nil, nil, nil, nil, strings.NewReader("abcdef"), nil, 0, nil, 0, nil, nil)
+	require.NoError(t, err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(sysCtx.RandSource(), buf)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), sysCtx.RandRead())
+
+	_, err = io.ReadFull(sysCtx.RandSource(), buf[:2])
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), sysCtx.RandRead())
+}
+
+func TestContext_SkipRand(t *testing.T) {
+	tests := []struct {
+		name          string
+		source        io.Reader
+		read, n       uint64
+		expected      string
+		expectedErr   string
+		expectedCount uint64
+	}{
+		{
+			name:     "nothing to skip",
+			source:   strings.NewReader("abcdef"),
+			expected: "abcdef",
+		},
+		{
+			name:          "skip",
+			source:        strings.NewReader("abcdef"),
+			n:             4,
+			expected:      "ef",
+			expectedCount: 4,
+		},
+		{
+			name:          "skip the rest of those read",
+			source:        bytes.NewBufferString("abcdef"),
+			read:          1,
+			n:             4,
+			expected:      "ef",
+			expectedCount: 4,
+		},
+		{
+			name:          "seekable, read since",
+			source:        strings.NewReader("abcdef"),
+			read:          5,
+			n:             2,
+			expected:      "cdef",
+			expectedCount: 2,
+		},
+		{
+			name:          "not seekable, read since",
+			source:        bytes.NewBufferString("abcdef"),
+			read:          5,
+			n:             2,
+			expected:      "f",
+			expectedCount: 2,
+		},
+		{
+			name:        "source ends",
+			source:      strings.NewReader("abcdef"),
+			n:           7,
+			expectedErr: "cannot skip 7 random bytes read before the snapshot: EOF",
+		},
+		{
+			name:        "overflow",
+			source:      strings.NewReader("abcdef"),
+			n:           math.MaxUint64,
+			expected:    "abcdef",
+			expectedErr: "cannot skip 18446744073709551615 random bytes read before the snapshot: count overflows int64",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			sysCtx, err := NewContext(0, nil, nil, nil, nil, nil, tc.source, nil, 0, nil, 0, nil, nil)
+			require.NoError(t, err)
+			_, err = io.ReadFull(sysCtx.RandSource(), make([]byte, tc.read))
+			require.NoError(t, err)
+
+			err = sysCtx.SkipRand(tc.n)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.expectedCount, sysCtx.RandRead())
+
+			rest, err := io.ReadAll(sysCtx.RandSource())
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(rest))
+		})
+	}
+}
+
+func TestContext_RestoreClocks(t *testing.T) {
+	sysCtx, err := NewContext(0, nil, nil, nil, nil, nil, nil, nil, 0, nil, 0, nil, nil)
+	require.NoError(t, err)
+
+	walltime, nanotime := sysCtx.Clocks()
+	require.Zero(t, walltime)
+	require.Zero(t, nanotime)
+
+	// The fake clocks tick 1ms each reading.
+	sec, nsec := sysCtx.Walltime(testCtx)
+	require.Equal(t, platform.FakeEpochNanos, sec*1e9+int64(nsec))
+	require.Zero(t, sysCtx.Nanotime(testCtx))
+	require.Equal(t, int64(time.Millisecond), sysCtx.Nanotime(testCtx))
+
+	walltime, nanotime = sysCtx.Clocks()
+	require.Equal(t, platform.FakeEpochNanos, walltime)
+	require.Equal(t, int64(time.Millisecond), nanotime)
+
+	t.Run("restored", func(t *testing.T) {
+		resumed, err := NewContext(0, nil, nil, nil, nil, nil, nil, nil, 0, nil, 0, nil, nil)
+		require.NoError(t, err)
+
+		resumed.RestoreClocks(testCtx, walltime+int64(time.Second), nanotime+int64(time.Second))
+		walltime, nanotime := resumed.Clocks()
+		require.Equal(t, platform.FakeEpochNanos+int64(time.Second), walltime)
+		require.Equal(t, int64(time.Second+time.Millisecond), nanotime)
+
+		// The clocks continue from the restored readings, as one reading was taken to compute each offset.
+		sec, nsec := resumed.Walltime(testCtx)
+		require.Equal(t, walltime+int64(time.Millisecond), sec*1e9+int64(nsec))
+		require.Equal(t, nanotime+int64(time.Millisecond), resumed.Nanotime(testCtx))
+	})
+
+	t.Run("zero keeps the configured clocks", func(t *testing.T) {
+		resumed, err := NewContext(0, nil, nil, nil, nil, nil, nil, nil, 0, nil, 0, nil, nil)
+		require.NoError(t, err)
+
+		resumed.RestoreClocks(testCtx, 0, 0)
+		sec, nsec := resumed.Walltime(testCtx)
+		require.Equal(t, platform.FakeEpochNanos, sec*1e9+int64(nsec))
+		require.Zero(t, resumed.Nanotime(testCtx))
+	})
+}
+
 func TestNewContext_Walltime(t *testing.T) {
 	tests := []struct {
 		name        string
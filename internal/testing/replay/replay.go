@@ -0,0 +1,164 @@
+// Package replay verifies the snapshot machinery end to end: that resuming a snapshot taken part way through a call,
+// once exported and read back, finishes the call as it would have finished without the snapshot.
+package replay
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/internal/engine/interpreter"
+	"github.com/tetratelabs/wazero/internal/wasm"
+)
+
+// ErrFinished is returned by Verify when the call finishes before executing the instruction to snapshot after, so that
+// a caller verifying each instruction in turn knows when to stop.
+var ErrFinished = errors.New("call finished before the instruction to snapshot after")
+
+// Outcome is how a call ended.
+type Outcome struct {
+	// Results are the results of the call.
+	Results []uint64
+	// MemoryDigest is the sha256 of the memory of the module once the call ended, or zero if it has none.
+	MemoryDigest [sha256.Size]byte
+}
+
+// String implements fmt.Stringer
+func (o *Outcome) String() string {
+	return fmt.Sprintf("results %v, memory %x", o.Results, o.MemoryDigest)
+}
+
+// Verify calls the function exported as name by a module instantiated from code twice, each time in a new module: once
+// to completion, and once snapshotting after the call executed n instructions, per wasm.Snapshot InstructionCount. The
+// snapshot is exported and read back, as it would be by another process, then resumed to completion in a third module.
+// This errs unless both calls end with the same Outcome, or with ErrFinished if the call executes fewer than n
+// instructions.
+//
+// Ex. To verify resuming after each instruction of a call:
+//
+//	for n := uint64(1); ; n++ {
+//		if err := replay.Verify(ctx, r, code, "run", n); errors.Is(err, replay.ErrFinished) {
+//			break
+//		} else {
+//			require.NoError(t, err)
+//		}
+//	}
+//
+// Note: r must use the interpreter, as it is the only engine that snapshots, and have any modules code imports
+// instantiated already.
+func Verify(ctx context.Context, r wazero.Runtime, code wazero.CompiledModule, name string, n uint64, params ...uint64) error {
+	expected, err := call(ctx, r, code, name, params)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := snapshotAfter(ctx, r, code, name, n, params)
+	if err != nil {
+		return err
+	}
+
+	var exported bytes.Buffer
+	if err = interpreter.WriteSnapshot(&exported, snapshot); err != nil {
+		return err
+	}
+	if snapshot, err = interpreter.ReadSnapshot(&exported); err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	actual, err := resume(ctx, r, code, name, snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to resume after %d instructions: %w", n, err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		return fmt.Errorf("resumed after %d instructions, the call ended with %s, but expected %s", n, actual, expected)
+	}
+	return nil
+}
+
+// call calls the function exported as name in a new module to completion.
+func call(ctx context.Context, r wazero.Runtime, code wazero.CompiledModule, name string, params []uint64) (*Outcome, error) {
+	m, err := r.InstantiateModule(ctx, code, wazero.NewModuleConfig().WithName("replay.call"))
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close(ctx)
+
+	fn := exportedFunction(m, name)
+	results, err := fn.Call(ctx, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s: %w", name, err)
+	}
+	return outcome(ctx, fn.Module, results), nil
+}
+
+// snapshotAfter calls the function exported as name in a new module, stepping an instruction at a time until it
+// executed n, and returns the snapshot taken then.
+func snapshotAfter(ctx context.Context, r wazero.Runtime, code wazero.CompiledModule, name string, n uint64, params []uint64) (*wasm.Snapshot, error) {
+	m, err := r.InstantiateModule(ctx, code, wazero.NewModuleConfig().WithName("replay.snapshot"))
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close(ctx)
+
+	snapshot := &wasm.Snapshot{Granularity: wasm.SnapshotGranularityInstruction}
+	ctx = wasm.WithSnapshotOptions(ctx, wasm.SnapshotOptions{Snapshot: snapshot, Always: true, TrapAfter: true})
+	fn := exportedFunction(m, name)
+	_, err = fn.Call(ctx, params...)
+	for {
+		var snapshotErr *wasm.SnapshotError
+		if err == nil || (errors.As(err, &snapshotErr) && !snapshotErr.Resumable) {
+			return nil, fmt.Errorf("%w: finished after %d instructions, before %d", ErrFinished, snapshot.InstructionCount, n)
+		} else if snapshotErr == nil {
+			return nil, fmt.Errorf("failed to snapshot %s: %w", name, err)
+		}
+		if snapshot.InstructionCount >= n {
+			return snapshot, nil
+		}
+
+		var next *wasm.Snapshot
+		if _, next, err = fn.Resume(ctx, snapshot); next != nil {
+			snapshot = next
+		}
+	}
+}
+
+// resume resumes the snapshot in a new module to completion.
+func resume(ctx context.Context, r wazero.Runtime, code wazero.CompiledModule, name string, snapshot *wasm.Snapshot) (*Outcome, error) {
+	m, err := r.InstantiateModule(ctx, code, wazero.NewModuleConfig().WithName("replay.resume"))
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close(ctx)
+
+	fn := exportedFunction(m, name)
+	if err = snapshot.Validate(fn.Module); err != nil {
+		return nil, err
+	}
+	results, _, err := fn.Resume(ctx, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return outcome(ctx, fn.Module, results), nil
+}
+
+// exportedFunction returns the function exported as name, which resumes with a snapshot.
+func exportedFunction(m api.Module, name string) *wasm.FunctionInstance {
+	return m.ExportedFunction(name).(*wasm.FunctionInstance)
+}
+
+// outcome returns the Outcome of a call that ended with results in module.
+//
+// Note: This reads the memory of the module instance, as api.Module Memory isn't nil when there is none.
+func outcome(ctx context.Context, module *wasm.ModuleInstance, results []uint64) *Outcome {
+	ret := &Outcome{Results: results}
+	if mem := module.Memory; mem != nil {
+		buf, _ := mem.Read(ctx, 0, mem.Size(ctx))
+		ret.MemoryDigest = sha256.Sum256(buf)
+	}
+	return ret
+}
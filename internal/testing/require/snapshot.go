@@ -0,0 +1,166 @@
+package require
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// snapshotPageSize is the size of a WebAssembly page, which is the unit memory is compared in.
+const snapshotPageSize = 65536
+
+// SnapshotEqual fails if the actual wasm.Snapshot is not equal to the expected, listing each difference on its own line.
+// Globals are compared by value, not pointer, and the memory buffer is compared page by page, only reporting the pages
+// that differ, as printing whole buffers would be unreadable.
+//
+//  * formatWithArgs are optional. When the first is a string that contains '%', it is treated like fmt.Sprintf.
+//
+// Note: The snapshots are passed as interface{} as this package can't import wasm, because the tests of wasm import
+// this package.
+func SnapshotEqual(t TestingT, expected, actual interface{}, formatWithArgs ...interface{}) {
+	ev, av := reflect.ValueOf(expected), reflect.ValueOf(actual)
+	if ev.Kind() != reflect.Ptr || ev.Type().Elem().Kind() != reflect.Struct || ev.Type() != av.Type() {
+		fail(t, fmt.Sprintf("expected snapshots, but were %T and %T", expected, actual), "", formatWithArgs...)
+		return
+	}
+	if ev.IsNil() || av.IsNil() {
+		if ev.IsNil() != av.IsNil() {
+			fail(t, fmt.Sprintf("expected %s, but was %s", valueString(ev), valueString(av)), "", formatWithArgs...)
+		}
+		return
+	}
+
+	if diffs := snapshotDiffs(ev.Elem(), av.Elem()); len(diffs) > 0 {
+		fail(t, "unexpected snapshot", strings.Join(diffs, "\n"), formatWithArgs...)
+	}
+}
+
+// snapshotDiffs returns a line per exported field of the snapshot struct that differs.
+func snapshotDiffs(expected, actual reflect.Value) (diffs []string) {
+	typ := expected.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		e, a := expected.Field(i), actual.Field(i)
+		switch field.Name {
+		case "Globals":
+			diffs = append(diffs, globalsDiffs(e, a)...)
+		case "Memory":
+			diffs = append(diffs, memoryDiffs(e, a)...)
+		default:
+			if !reflect.DeepEqual(e.Interface(), a.Interface()) {
+				diffs = append(diffs, fmt.Sprintf("%s: expected %s, but was %s", field.Name, valueString(e), valueString(a)))
+			}
+		}
+	}
+	return
+}
+
+// globalsDiffs compares slices of global pointers by the values they point to.
+func globalsDiffs(expected, actual reflect.Value) (diffs []string) {
+	if expected.Len() != actual.Len() {
+		return []string{fmt.Sprintf("Globals: expected %d globals, but was %d", expected.Len(), actual.Len())}
+	}
+	for i := 0; i < expected.Len(); i++ {
+		e, a := expected.Index(i), actual.Index(i)
+		if !reflect.DeepEqual(e.Interface(), a.Interface()) {
+			diffs = append(diffs, fmt.Sprintf("Globals[%d]: expected %s, but was %s", i, valueString(e), valueString(a)))
+		}
+	}
+	return
+}
+
+// memoryDiffs compares the exported fields of a memory pointer, except Buffer which is compared page by page.
+func memoryDiffs(expected, actual reflect.Value) (diffs []string) {
+	if expected.IsNil() || actual.IsNil() {
+		if expected.IsNil() != actual.IsNil() {
+			diffs = append(diffs, fmt.Sprintf("Memory: expected %s, but was %s", memoryString(expected), memoryString(actual)))
+		}
+		return
+	}
+	expected, actual = expected.Elem(), actual.Elem()
+
+	typ := expected.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" || field.Name == "Buffer" {
+			continue
+		}
+		e, a := expected.Field(i), actual.Field(i)
+		if !reflect.DeepEqual(e.Interface(), a.Interface()) {
+			diffs = append(diffs, fmt.Sprintf("Memory.%s: expected %s, but was %s", field.Name, valueString(e), valueString(a)))
+		}
+	}
+
+	eb, ab := expected.FieldByName("Buffer").Bytes(), actual.FieldByName("Buffer").Bytes()
+	if len(eb) != len(ab) {
+		diffs = append(diffs, fmt.Sprintf("Memory.Buffer: expected %d bytes, but was %d", len(eb), len(ab)))
+	}
+	for offset := 0; offset < len(eb) && offset < len(ab); offset += snapshotPageSize {
+		ep, ap := memoryPage(eb, offset), memoryPage(ab, offset)
+		if bytes.Equal(ep, ap) {
+			continue
+		}
+		i := 0
+		for i < len(ep) && i < len(ap) && ep[i] == ap[i] {
+			i++
+		}
+		if i < len(ep) && i < len(ap) {
+			diffs = append(diffs, fmt.Sprintf("Memory.Buffer page %d: expected 0x%02x at offset %d, but was 0x%02x",
+				offset/snapshotPageSize, ep[i], offset+i, ap[i]))
+		}
+	}
+	return
+}
+
+// memoryPage returns the page of buf starting at offset, which is shorter than a page if buf ends within it.
+func memoryPage(buf []byte, offset int) []byte {
+	end := offset + snapshotPageSize
+	if end > len(buf) {
+		end = len(buf)
+	}
+	return buf[offset:end]
+}
+
+// memoryString describes a memory pointer without printing its buffer.
+func memoryString(v reflect.Value) string {
+	if v.IsNil() {
+		return "nil"
+	}
+	return fmt.Sprintf("%d bytes", v.Elem().FieldByName("Buffer").Len())
+}
+
+// valueString formats the value, following pointers and listing the exported fields of structs, so that values which
+// only differ behind a pointer print differently.
+func valueString(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "nil"
+		}
+		return valueString(v.Elem())
+	case reflect.Struct:
+		var fields []string
+		typ := v.Type()
+		for i := 0; i < typ.NumField(); i++ {
+			if field := typ.Field(i); field.PkgPath == "" {
+				fields = append(fields, field.Name+":"+valueString(v.Field(i)))
+			}
+		}
+		return "{" + strings.Join(fields, " ") + "}"
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Ptr {
+			return fmt.Sprint(v.Interface())
+		}
+		elems := make([]string, v.Len())
+		for i := range elems {
+			elems[i] = valueString(v.Index(i))
+		}
+		return "[" + strings.Join(elems, " ") + "]"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
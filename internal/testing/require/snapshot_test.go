@@ -0,0 +1,151 @@
+package require
+
+import "testing"
+
+// The types below mirror the shape of wasm.Snapshot, which this package can't import.
+type testGlobalType struct {
+	Mutable bool
+}
+
+type testGlobal struct {
+	Type *testGlobalType
+	Val  uint64
+}
+
+type testMemory struct {
+	Buffer   []byte
+	Min, Max uint32
+	mux      struct{} // unexported fields are ignored.
+}
+
+type testFrame struct {
+	Pc          uint64
+	FunctionIdx uint32
+}
+
+type testSnapshot struct {
+	Stack   []uint64
+	Globals []*testGlobal
+	Frames  []testFrame
+	Memory  *testMemory
+}
+
+func TestSnapshotEqual(t *testing.T) {
+	newSnapshot := func() *testSnapshot {
+		buffer := make([]byte, 3*snapshotPageSize)
+		buffer[snapshotPageSize+2] = 1
+		return &testSnapshot{
+			Stack:   []uint64{1, 2},
+			Globals: []*testGlobal{{Type: &testGlobalType{}, Val: 1}, {Type: &testGlobalType{Mutable: true}, Val: 2}},
+			Frames:  []testFrame{{Pc: 3, FunctionIdx: 1}},
+			Memory:  &testMemory{Buffer: buffer, Min: 3, Max: 4},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		modify      func(*testSnapshot)
+		expectedLog string
+	}{
+		{
+			name:   "equal",
+			modify: func(*testSnapshot) {},
+		},
+		{
+			name: "stack",
+			modify: func(s *testSnapshot) {
+				s.Stack = []uint64{1}
+			},
+			expectedLog: "unexpected snapshot\nStack: expected [1 2], but was [1]",
+		},
+		{
+			name: "global value",
+			modify: func(s *testSnapshot) {
+				s.Globals[1].Val = 3
+			},
+			expectedLog: "unexpected snapshot\nGlobals[1]: expected {Type:{Mutable:true} Val:2}, but was {Type:{Mutable:true} Val:3}",
+		},
+		{
+			name: "global type",
+			modify: func(s *testSnapshot) {
+				s.Globals[0].Type.Mutable = true
+			},
+			expectedLog: "unexpected snapshot\nGlobals[0]: expected {Type:{Mutable:false} Val:1}, but was {Type:{Mutable:true} Val:1}",
+		},
+		{
+			name: "global count",
+			modify: func(s *testSnapshot) {
+				s.Globals = s.Globals[:1]
+			},
+			expectedLog: "unexpected snapshot\nGlobals: expected 2 globals, but was 1",
+		},
+		{
+			name: "frames",
+			modify: func(s *testSnapshot) {
+				s.Frames[0].Pc = 4
+			},
+			expectedLog: "unexpected snapshot\nFrames: expected [{3 1}], but was [{4 1}]",
+		},
+		{
+			name: "memory page",
+			modify: func(s *testSnapshot) {
+				s.Memory.Buffer[2*snapshotPageSize+5] = 7
+			},
+			expectedLog: "unexpected snapshot\nMemory.Buffer page 2: expected 0x00 at offset 131077, but was 0x07",
+		},
+		{
+			name: "memory size",
+			modify: func(s *testSnapshot) {
+				s.Memory.Buffer = s.Memory.Buffer[:2*snapshotPageSize]
+				s.Memory.Min = 2
+			},
+			expectedLog: "unexpected snapshot\nMemory.Min: expected 3, but was 2\nMemory.Buffer: expected 196608 bytes, but was 131072",
+		},
+		{
+			name: "no memory",
+			modify: func(s *testSnapshot) {
+				s.Memory = nil
+			},
+			expectedLog: "unexpected snapshot\nMemory: expected 196608 bytes, but was nil",
+		},
+		{
+			name: "several",
+			modify: func(s *testSnapshot) {
+				s.Stack = nil
+				s.Memory.Buffer[snapshotPageSize+2] = 0
+			},
+			expectedLog: "unexpected snapshot\nStack: expected [1 2], but was []\nMemory.Buffer page 1: expected 0x01 at offset 65538, but was 0x00",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			actual := newSnapshot()
+			tc.modify(actual)
+
+			m := &mockT{t: t}
+			SnapshotEqual(m, newSnapshot(), actual)
+			m.require(tc.expectedLog)
+		})
+	}
+}
+
+func TestSnapshotEqual_Invalid(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		m := &mockT{t: t}
+		SnapshotEqual(m, (*testSnapshot)(nil), (*testSnapshot)(nil))
+		m.require("")
+
+		m = &mockT{t: t}
+		SnapshotEqual(m, &testSnapshot{}, (*testSnapshot)(nil))
+		m.require("expected {Stack:[] Globals:[] Frames:[] Memory:nil}, but was nil")
+	})
+
+	t.Run("not snapshots", func(t *testing.T) {
+		m := &mockT{t: t}
+		SnapshotEqual(m, &testSnapshot{}, testSnapshot{})
+		m.require("expected snapshots, but were *require.testSnapshot and require.testSnapshot")
+	})
+}
@@ -0,0 +1,26 @@
+package binary
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// isGoBuildIDSection returns true if the custom section name is where the Go compiler writes the build ID. This was
+// "go.buildid" in older versions of Go and "go:buildid" in newer ones.
+func isGoBuildIDSection(name string) bool {
+	return name == "go:buildid" || name == "go.buildid"
+}
+
+// decodeGoBuildID reads the build ID written by the Go compiler, which is the remaining content of the custom section.
+func decodeGoBuildID(r *bytes.Reader, limit uint32) (string, error) {
+	buf := make([]byte, limit)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", fmt.Errorf("failed to read go build ID: %w", err)
+	}
+	if !utf8.Valid(buf) {
+		return "", fmt.Errorf("go build ID is not valid UTF-8")
+	}
+	return string(buf), nil
+}
@@ -60,12 +60,17 @@ func DecodeModule(
 			} else if name == "name" && m.NameSection != nil {
 				err = fmt.Errorf("redundant custom section %s", name)
 				break
+			} else if isGoBuildIDSection(name) && m.GoBuildID != "" {
+				err = fmt.Errorf("redundant custom section %s", name)
+				break
 			}
 
-			// Now, either decode the NameSection or skip an unsupported one
+			// Now, either decode the NameSection or GoBuildID, or skip an unsupported one
 			limit := sectionSize - nameSize
 			if name == "name" {
 				m.NameSection, err = decodeNameSection(r, uint64(limit))
+			} else if isGoBuildIDSection(name) {
+				m.GoBuildID, err = decodeGoBuildID(r, limit)
 			} else {
 				// Note: Not Seek because it doesn't err when given an offset past EOF. Rather, it leads to undefined state.
 				if _, err = io.CopyN(io.Discard, r, int64(limit)); err != nil {
@@ -122,6 +122,18 @@ func TestDecodeModule(t *testing.T) {
 		require.NoError(t, e)
 		require.Equal(t, &wasm.Module{NameSection: &wasm.NameSection{ModuleName: "simple"}}, m)
 	})
+	t.Run("go build ID", func(t *testing.T) {
+		for _, name := range []string{"go:buildid", "go.buildid"} {
+			input := append(append(Magic, version...),
+				wasm.SectionIDCustom, 0x10, // 16 bytes in this section
+				0x0a) // the section name is 10 bytes long
+			input = append(input, name...)
+			input = append(input, 'a', 'b', '/', 'c', 'd')
+			m, e := DecodeModule(input, wasm.Features20191205, wasm.MemorySizer)
+			require.NoError(t, e)
+			require.Equal(t, &wasm.Module{GoBuildID: "ab/cd"}, m)
+		}
+	})
 	t.Run("data count section disabled", func(t *testing.T) {
 		input := append(append(Magic, version...),
 			wasm.SectionIDDataCount, 1, 0)
@@ -169,6 +181,17 @@ func TestDecodeModule_Errors(t *testing.T) {
 				subsectionIDModuleName, 0x02, 0x01, 'x'),
 			expectedErr: "section custom: redundant custom section name",
 		},
+		{
+			name: "redundant go build ID",
+			input: append(append(Magic, version...),
+				wasm.SectionIDCustom, 0x0c, // 12 bytes in this section
+				0x0a, 'g', 'o', ':', 'b', 'u', 'i', 'l', 'd', 'i', 'd',
+				'a',
+				wasm.SectionIDCustom, 0x0c, // 12 bytes in this section
+				0x0a, 'g', 'o', '.', 'b', 'u', 'i', 'l', 'd', 'i', 'd',
+				'b'),
+			expectedErr: "section custom: redundant custom section go.buildid",
+		},
 	}
 
 	for _, tt := range tests {
@@ -2,11 +2,13 @@ package wasm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync/atomic"
 
 	"github.com/tetratelabs/wazero/api"
 	internalsys "github.com/tetratelabs/wazero/internal/sys"
+	"github.com/tetratelabs/wazero/internal/wasmruntime"
 	"github.com/tetratelabs/wazero/sys"
 )
 
@@ -54,6 +56,9 @@ type CallContext struct {
 	// See /RATIONALE.md
 	closed *uint64
 
+	// generation is the Snapshot.Generation of the last snapshot exported or resumed, read by CheckpointGeneration.
+	generation uint64
+
 	// CodeCloser is non-nil when the code should be closed after this module.
 	CodeCloser api.Closer
 }
@@ -66,6 +71,95 @@ func (m *CallContext) FailIfClosed() error {
 	return nil
 }
 
+// ErrModuleClosed is matched by errors.Is when resuming a function of a module that was already closed, e.g. by
+// CloseWithExitCode, instead of restoring the snapshot into released state. The error also unwraps to the sys.ExitError
+// of the close.
+var ErrModuleClosed = errors.New("module closed")
+
+// failIfClosedBeforeCall is like FailIfClosed, except the error also matches ErrModuleClosed, to tell it from an exit
+// during the call.
+func (m *CallContext) failIfClosedBeforeCall() error {
+	if err := m.FailIfClosed(); err != nil {
+		return &moduleClosedError{exitErr: err}
+	}
+	return nil
+}
+
+// moduleClosedError is returned by failIfClosedBeforeCall.
+type moduleClosedError struct {
+	exitErr error
+}
+
+// Error implements error.
+func (e *moduleClosedError) Error() string {
+	return "cannot execute a function of a closed module: " + e.exitErr.Error()
+}
+
+// Is allows errors.Is to match ErrModuleClosed.
+func (e *moduleClosedError) Is(target error) bool {
+	return target == ErrModuleClosed
+}
+
+// Unwrap allows errors.As to match the sys.ExitError.
+func (e *moduleClosedError) Unwrap() error {
+	return e.exitErr
+}
+
+// ClosedState returns the packed exit state stored by CloseWithExitCode, or zero if the module wasn't closed. This is
+// used to capture the state in a Snapshot.
+func (m *CallContext) ClosedState() uint64 {
+	return atomic.LoadUint64(m.closed)
+}
+
+// Generation returns the Snapshot.Generation of the last snapshot the module exported or was resumed from, or zero if
+// neither.
+func (m *CallContext) Generation() uint64 {
+	return m.generation
+}
+
+// SetGeneration is called by the engine when it exports a snapshot with the given Snapshot.Generation.
+func (m *CallContext) SetGeneration(generation uint64) {
+	m.generation = generation
+}
+
+// MemorySizeBytes returns the current size in bytes of the module's memory, or zero if it has none. This is safe to
+// call while the module executes, e.g. to decide whether to take a snapshot, as it excludes a concurrent Grow.
+func (m *CallContext) MemorySizeBytes() uint64 {
+	mem := m.module.Memory
+	if mem == nil {
+		return 0
+	}
+	mem.mux.RLock()
+	defer mem.mux.RUnlock()
+	return uint64(len(mem.Buffer))
+}
+
+// GlobalsView returns a copy of the module's globals, in index order, so that they can be inspected without taking a
+// snapshot. Modifying the copies doesn't affect the module.
+//
+// Note: Values are read atomically, but a global the module writes concurrently may be read before or after the
+// write. Read them while the module doesn't execute for a consistent view, as a snapshot would.
+func (m *CallContext) GlobalsView() []GlobalInstance {
+	ret := make([]GlobalInstance, len(m.module.Globals))
+	for i, g := range m.module.Globals {
+		ret[i] = GlobalInstance{
+			Type:  g.Type,
+			Val:   atomic.LoadUint64(&g.Val),
+			ValHi: atomic.LoadUint64(&g.ValHi),
+		}
+	}
+	return ret
+}
+
+// CallDepth returns the count of frames on the call stacks of the calls in progress into this module, including those
+// of functions it imports, or zero when none is. This is the count of frames a snapshot taken now would hold, and is
+// safe to call from another goroutine, e.g. a host function or a monitor.
+//
+// Note: Only the interpreter keeps track of this, so this is zero with the compiler engine.
+func (m *CallContext) CallDepth() int {
+	return int(atomic.LoadInt64(m.module.CallDepthCounter()))
+}
+
 // Name implements the same method as documented on api.Module
 func (m *CallContext) Name() string {
 	return m.module.Name
@@ -149,6 +243,19 @@ func (m *CallContext) ExportedFunction(name string) api.Function {
 	}
 }
 
+// SnapshotFunctionName is like Snapshot.ExportedFunctionName, except it reads the exports of this module instance,
+// rather than the export section of its source, so the same name wins if the function is exported more than once.
+func (m *CallContext) SnapshotFunctionName(snap *Snapshot) (string, error) {
+	module := &Module{}
+	for name, exp := range m.module.Exports {
+		// Functions this module imports and re-exports are indexed in the module they are from.
+		if exp.Type == ExternTypeFunc && exp.Function.Module == m.module {
+			module.ExportSection = append(module.ExportSection, &Export{Type: ExternTypeFunc, Name: name, Index: exp.Function.Idx})
+		}
+	}
+	return snap.ExportedFunctionName(module)
+}
+
 // importedFn implements api.Function and ensures the call context of an imported function is the importing module.
 type importedFn struct {
 	importingModule *CallContext
@@ -190,19 +297,153 @@ func (f *FunctionInstance) Call(ctx context.Context, params ...uint64) (ret []ui
 		ctx = context.Background()
 	}
 	mod := f.Module
+	// Fail before executing against released state. Unlike Resume, this returns the sys.ExitError as is, as callers
+	// of Call type-assert it to read the exit code.
+	if err = mod.CallCtx.FailIfClosed(); err != nil {
+		return
+	}
 	ret, err = mod.Engine.Call(ctx, mod.CallCtx, f, params...)
 	return
 }
 
-func (f *FunctionInstance) Resume(ctx context.Context, snapshot *Snapshot) (ret []uint64, err error) {
+// Resume continues the execution captured in the snapshot, which must have been taken from a call to f.
+//
+// Note: If the module had already exited when the snapshot was taken, this closes the module with the same exit code
+// and returns the sys.ExitError, instead of executing it again.
+//
+// Note: If the module f is in is already closed, this errs with ErrModuleClosed without restoring anything. Resume into
+// a newly instantiated module instead.
+//
+// Note: This resets the module to the snapshot in place, per CallContext Reset, so the module needn't be newly
+// instantiated. Resuming the one the snapshot was taken in again and again, e.g. to checkpoint frequently, avoids
+// decoding and linking it each time.
+//
+// Note: The interpreter checks ctx.Done() before each call and each branch that can loop. When it is done, execution
+// stops with an error wrapping ctx.Err(). If a snapshot is configured in ctx, it is taken at that point, so the call
+// can be resumed later.
+//
+// Note: Files opened when the snapshot was taken are reopened by path in the file system currently configured, e.g. by
+// wazero.ModuleConfig WithFS, so it can differ from the original one. This errs if a path no longer exists.
+//
+// Note: Args and environment variables captured in the snapshot win over those configured by wazero.ModuleConfig
+// WithArgs and WithEnv, so that the resumed program sees the ones it started with.
+//
+// Note: The module can be instantiated with a different wazero.ModuleConfig than the one the snapshot was taken with,
+// e.g. WithStdout to write the output of the resumed execution elsewhere. See Snapshot.StdoutWritten
+//
+// Note: Bytes read from stdin before the snapshot are skipped in the stdin configured on resume, which errs unless it
+// is an io.Seeker. See Snapshot.StdinRead
+//
+// Note: When the snapshot was taken by Yield, the guest continues after its call to Yield, which returns
+// Snapshot.ResumeValue.
+//
+// Note: Frames refer to functions by index. If the module was edited in a way that shifts them, e.g. adding an import,
+// remap them first with Snapshot.RemapFunctions.
+//
+// When the resumed call stops with a snapshot again, next is that snapshot and err is a SnapshotError. When it is
+// interrupted as the context.Context is done, next is the snapshot at the interruption point and err is the context's,
+// i.e. context.Canceled or context.DeadlineExceeded, so that it can be resumed later. next is nil otherwise. Unlike Call, this doesn't take the snapshot in SnapshotOptions Snapshot, which only configures next, e.g.
+// its Mode, so neither it nor the snapshot resumed are modified. This keeps each snapshot of a chain of
+// checkpoints intact, e.g. to branch from one with Clone.
+func (f *FunctionInstance) Resume(ctx context.Context, snapshot *Snapshot) (ret []uint64, next *Snapshot, err error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
 	mod := f.Module
+	if err = mod.CallCtx.failIfClosedBeforeCall(); err != nil {
+		return
+	}
+	if closed := snapshot.Closed; closed != 0 {
+		if err = mod.CallCtx.CloseWithExitCode(ctx, uint32(closed>>32)); err != nil {
+			return
+		}
+		return nil, nil, mod.CallCtx.FailIfClosed()
+	}
+	if snapshot.Mode == SnapshotModeHeap {
+		return nil, nil, errors.New("cannot resume a heap snapshot: use CallWithHeap")
+	}
+	if opts := SnapshotOptionsFromContext(ctx); opts.Snapshot != nil {
+		next = opts.Snapshot.newNext()
+		opts.Snapshot = next
+		ctx = WithSnapshotOptions(ctx, opts)
+	}
 	ret, err = mod.Engine.Resume(ctx, mod.CallCtx, f, snapshot)
+	// An interrupted call snapshots where it was interrupted, if it can, so keep that snapshot to resume later.
+	interrupted := errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+	if !errors.Is(err, wasmruntime.ErrRuntimeSnapshot) && !(interrupted && next != nil && next.Valid) {
+		next = nil
+	}
 	return
 }
 
+// Reset overwrites the state of the module with that captured in the snapshot, in place: its globals and memory, its
+// Generation, and its system state, i.e. open files, args and environment variables, the counts of Snapshot
+// StdoutWritten and StderrWritten, and the position in stdin and the random source and the clocks per Snapshot
+// StdinRead, RandRead, Walltime and Nanotime. The instances are kept, so exports and the CallContext see the restored
+// values.
+//
+// FunctionInstance.Resume resets the module itself, so call this only to restore the state without executing, e.g. to
+// inspect it. Snapshot.Restore does the same, but validates the snapshot first. This doesn't restore a module closed
+// in the snapshot, nor one already closed, which errs with ErrModuleClosed.
+//
+// The files are checked to reopen, and stdin and the random source to skip, before anything is overwritten, so that a
+// Reset that errs leaves the module as it was.
+//
+// Note: A snapshot shares the globals and memory of the module it was taken in, so it only holds the state at the
+// snapshot until that module runs again. Resuming the chain of snapshots a module takes in itself is fine, but to
+// reset it to the same snapshot more than once, Clone the snapshot when taken.
+func (m *CallContext) Reset(ctx context.Context, snapshot *Snapshot) error {
+	if err := m.failIfClosedBeforeCall(); err != nil {
+		return err
+	}
+	if m.Sys != nil {
+		if errs := m.Sys.FS(ctx).CheckFiles(snapshot.OpenedFiles); len(errs) > 0 {
+			return fmt.Errorf("failed to reopen %w", errs[0])
+		}
+		if err := m.Sys.CheckSkipStdin(snapshot.StdinRead); err != nil {
+			return err
+		}
+		if err := m.Sys.CheckSkipRand(snapshot.RandRead); err != nil {
+			return err
+		}
+	}
+	if err := snapshot.restoreHeap(m.module); err != nil {
+		return err
+	}
+	m.generation = snapshot.Generation
+	if m.Sys == nil {
+		return nil
+	}
+
+	// Reopen the files in the currently configured file system, which may differ from the one at the snapshot.
+	fsContext := m.Sys.FS(ctx)
+	if err := fsContext.ReopenFiles(snapshot.OpenedFiles); err != nil {
+		return err
+	}
+	fsContext.SetLastFD(snapshot.LastFD)
+	m.Sys.SetWritten(snapshot.StdoutWritten, snapshot.StderrWritten)
+	if err := m.Sys.SkipStdin(snapshot.StdinRead); err != nil {
+		return err
+	}
+	if err := m.Sys.SkipRand(snapshot.RandRead); err != nil {
+		return err
+	}
+	m.Sys.RestoreClocks(ctx, snapshot.Walltime, snapshot.Nanotime)
+	if snapshot.Args != nil || snapshot.Environ != nil {
+		return m.Sys.RestoreArgsEnviron(snapshot.Args, snapshot.Environ)
+	}
+	return nil
+}
+
+// CallWithHeap restores the globals and memory captured in the snapshot, then calls f with the given parameters. This
+// is the entrypoint for snapshots taken with SnapshotModeHeap, but works for any snapshot, discarding its stack.
+func (f *FunctionInstance) CallWithHeap(ctx context.Context, snapshot *Snapshot, params ...uint64) (ret []uint64, err error) {
+	if err = snapshot.restoreHeap(f.Module); err != nil {
+		return
+	}
+	return f.Call(ctx, params...)
+}
+
 // ExportedGlobal implements the same method as documented on api.Module.
 func (m *CallContext) ExportedGlobal(name string) api.Global {
 	exp, err := m.module.getExport(name, ExternTypeGlobal)
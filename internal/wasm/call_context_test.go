@@ -4,11 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
-	"github.com/tetratelabs/wazero/internal/sys"
+	"github.com/tetratelabs/wazero/internal/platform"
+	internalsys "github.com/tetratelabs/wazero/internal/sys"
 	testfs "github.com/tetratelabs/wazero/internal/testing/fs"
 	"github.com/tetratelabs/wazero/internal/testing/require"
+	"github.com/tetratelabs/wazero/sys"
 )
 
 func TestCallContext_WithMemory(t *testing.T) {
@@ -143,7 +149,7 @@ func TestCallContext_Close(t *testing.T) {
 	}
 
 	t.Run("calls Context.Close()", func(t *testing.T) {
-		sysCtx := sys.DefaultContext(testfs.FS{"foo": &testfs.File{}})
+		sysCtx := internalsys.DefaultContext(testfs.FS{"foo": &testfs.File{}})
 		fsCtx := sysCtx.FS(testCtx)
 
 		_, err := fsCtx.OpenFile(testCtx, "/foo")
@@ -171,7 +177,7 @@ func TestCallContext_Close(t *testing.T) {
 	t.Run("error closing", func(t *testing.T) {
 		// Right now, the only way to err closing the sys context is if a File.Close erred.
 		testFS := testfs.FS{"foo": &testfs.File{CloseErr: errors.New("error closing")}}
-		sysCtx := sys.DefaultContext(testFS)
+		sysCtx := internalsys.DefaultContext(testFS)
 		fsCtx := sysCtx.FS(testCtx)
 
 		_, err := fsCtx.OpenFile(testCtx, "/foo")
@@ -187,3 +193,397 @@ func TestCallContext_Close(t *testing.T) {
 		require.False(t, ok, "expected no opened files")
 	})
 }
+
+func TestCallContext_SnapshotFunctionName(t *testing.T) {
+	s, ns := newStore()
+
+	module := &Module{
+		TypeSection:     []*FunctionType{{}},
+		FunctionSection: []Index{0, 0},
+		CodeSection:     []*Code{{Body: []byte{OpcodeEnd}}, {Body: []byte{OpcodeEnd}}},
+		ExportSection: []*Export{
+			{Type: ExternTypeFunc, Name: "run", Index: 1},
+			{Type: ExternTypeFunc, Name: "alias", Index: 1},
+		},
+	}
+	m, err := s.Instantiate(testCtx, ns, module, t.Name(), nil, nil)
+	require.NoError(t, err)
+
+	snapshot := &Snapshot{Frames: []CallFrame{{FunctionIdx: 1}, {FunctionIdx: 0}}}
+	name, err := m.SnapshotFunctionName(snapshot)
+	require.NoError(t, err)
+	require.Equal(t, "alias", name)
+
+	// The same alias as of the source module, so saving with one and resuming with the other agree.
+	sourceName, err := snapshot.ExportedFunctionName(module)
+	require.NoError(t, err)
+	require.Equal(t, name, sourceName)
+
+	_, err = m.SnapshotFunctionName(&Snapshot{Frames: []CallFrame{{FunctionIdx: 0}}})
+	require.EqualError(t, err, "snapshot function[0] is not exported")
+
+	_, err = m.SnapshotFunctionName(&Snapshot{})
+	require.EqualError(t, err, "snapshot has no frames")
+}
+
+func TestFunctionInstance_Resume_Closed(t *testing.T) {
+	s, ns := newStore()
+
+	m, err := s.Instantiate(testCtx, ns, &Module{
+		TypeSection:     []*FunctionType{{}},
+		FunctionSection: []Index{0},
+		CodeSection:     []*Code{{Body: []byte{OpcodeEnd}}},
+		ExportSection:   []*Export{{Type: ExternTypeFunc, Name: "main", Index: 0}},
+	}, t.Name(), nil, nil)
+	require.NoError(t, err)
+	fn := m.ExportedFunction("main").(*FunctionInstance)
+
+	// Capture the state of a module which exited with code 3.
+	exited := uint64(1) + uint64(3)<<32
+	_, _, err = fn.Resume(testCtx, &Snapshot{Valid: true, Closed: exited})
+	require.Equal(t, sys.NewExitError(t.Name(), 3), err)
+
+	// The resumed module observes that it exited, so it is closed instead of executed again.
+	require.Equal(t, exited, m.ClosedState())
+	require.Nil(t, ns.Module(t.Name()))
+}
+
+func TestFunctionInstance_Resume_ModuleClosed(t *testing.T) {
+	s, ns := newStore()
+
+	m, err := s.Instantiate(testCtx, ns, &Module{
+		TypeSection:     []*FunctionType{{}},
+		FunctionSection: []Index{0},
+		CodeSection:     []*Code{{Body: []byte{OpcodeEnd}}},
+		GlobalSection: []*Global{
+			{Type: &GlobalType{ValType: ValueTypeI32, Mutable: true}, Init: &ConstantExpression{Opcode: OpcodeI32Const, Data: []byte{1}}},
+		},
+		ExportSection: []*Export{{Type: ExternTypeFunc, Name: "main", Index: 0}},
+	}, t.Name(), nil, nil)
+	require.NoError(t, err)
+	fn := m.ExportedFunction("main").(*FunctionInstance)
+	require.NoError(t, m.CloseWithExitCode(testCtx, 2))
+
+	_, next, err := fn.Resume(testCtx, &Snapshot{
+		Valid:   true,
+		Globals: []*GlobalInstance{{Type: &GlobalType{ValType: ValueTypeI32, Mutable: true}, Val: 42}},
+	})
+	require.ErrorIs(t, err, ErrModuleClosed)
+	require.EqualError(t, err, "cannot execute a function of a closed module: module \""+t.Name()+"\" closed with exit_code(2)")
+	var exitErr *sys.ExitError
+	require.True(t, errors.As(err, &exitErr))
+	require.Equal(t, uint32(2), exitErr.ExitCode())
+	require.Nil(t, next)
+
+	// Nothing was restored into the closed module.
+	require.Equal(t, uint64(1), m.module.Globals[0].Val)
+
+	// Call keeps returning the sys.ExitError as is.
+	_, err = fn.Call(testCtx)
+	require.Equal(t, sys.NewExitError(t.Name(), 2), err)
+}
+
+func TestCallContext_Reset(t *testing.T) {
+	s, ns := newStore()
+
+	i32 := ValueTypeI32
+	module := &Module{
+		TypeSection:     []*FunctionType{{}},
+		FunctionSection: []Index{0},
+		CodeSection:     []*Code{{Body: []byte{OpcodeEnd}}},
+		GlobalSection: []*Global{
+			{Type: &GlobalType{ValType: i32, Mutable: true}, Init: &ConstantExpression{Opcode: OpcodeI32Const, Data: []byte{1}}},
+		},
+		MemorySection: &Memory{Min: 1, Cap: 1, Max: 2},
+		ExportSection: []*Export{{Type: ExternTypeGlobal, Name: "counter", Index: 0}},
+	}
+	sysCtx, err := internalsys.NewContext(0, nil, nil, strings.NewReader("abcdef"), nil, nil, nil, nil, 0, nil, 0, nil, nil)
+	require.NoError(t, err)
+	m, err := s.Instantiate(testCtx, ns, module, t.Name(), sysCtx, nil)
+	require.NoError(t, err)
+
+	// Read past the bytes of stdin read before the snapshot, as a module that continued executing would.
+	_, err = io.ReadFull(internalsys.FdReader(testCtx, sysCtx, internalsys.FdStdin), make([]byte, 4))
+	require.NoError(t, err)
+
+	buffer := make([]byte, MemoryPageSize)
+	buffer[0] = 0xff
+	snapshot := &Snapshot{
+		Valid:         true,
+		Globals:       []*GlobalInstance{{Type: &GlobalType{ValType: i32, Mutable: true}, Val: 42}},
+		Memory:        &MemoryInstance{Buffer: buffer, Min: 1, Cap: 1, Max: 2},
+		StdoutWritten: 5,
+		StdinRead:     2,
+		Generation:    3,
+		Args:          []string{"wasi"},
+	}
+	counter := m.ExportedGlobal("counter")
+	require.NoError(t, m.Reset(testCtx, snapshot))
+
+	// Exports see the restored values, as the instances are updated in place.
+	require.Equal(t, uint64(42), counter.Get(testCtx))
+	b, ok := m.Memory().ReadByte(testCtx, 0)
+	require.True(t, ok)
+	require.Equal(t, byte(0xff), b)
+	// The snapshot isn't aliased, so the module doesn't modify it.
+	require.NotSame(t, snapshot.Globals[0], m.module.Globals[0])
+
+	require.Equal(t, uint64(3), m.Generation())
+
+	stdoutWritten, _ := sysCtx.Written()
+	require.Equal(t, uint64(5), stdoutWritten)
+	require.Equal(t, []string{"wasi"}, sysCtx.Args())
+	rest, err := io.ReadAll(internalsys.FdReader(testCtx, sysCtx, internalsys.FdStdin))
+	require.NoError(t, err)
+	require.Equal(t, "cdef", string(rest))
+
+	t.Run("errs on mismatched globals", func(t *testing.T) {
+		err := m.Reset(testCtx, &Snapshot{Valid: true, Memory: snapshot.Memory})
+		require.EqualError(t, err, "snapshot has 0 globals, but module has 1")
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		require.NoError(t, m.CloseWithExitCode(testCtx, 2))
+		err := m.Reset(testCtx, snapshot)
+		require.ErrorIs(t, err, ErrModuleClosed)
+	})
+}
+
+// TestCallContext_Reset_Preopens ensures the directories preopened when the snapshot was taken are preopened at the same
+// file descriptors once reset, in the file system the module is configured with.
+// TestCallContext_Reset_Unchanged ensures a Reset that errs restoring the system state leaves the module as it was.
+func TestCallContext_Reset_Unchanged(t *testing.T) {
+	s, ns := newStore()
+
+	i32 := ValueTypeI32
+	module := &Module{
+		GlobalSection: []*Global{
+			{Type: &GlobalType{ValType: i32, Mutable: true}, Init: &ConstantExpression{Opcode: OpcodeI32Const, Data: []byte{1}}},
+		},
+		MemorySection: &Memory{Min: 1, Cap: 1, Max: 2},
+		ExportSection: []*Export{{Type: ExternTypeGlobal, Name: "counter", Index: 0}},
+	}
+	stdin := io.MultiReader(strings.NewReader("abcdef")) // not an io.Seeker
+	sysCtx, err := internalsys.NewContext(0, nil, nil, stdin, nil, nil, nil, nil, 0, nil, 0, nil, fstest.MapFS{})
+	require.NoError(t, err)
+	m, err := s.Instantiate(testCtx, ns, module, t.Name(), sysCtx, nil)
+	require.NoError(t, err)
+	counter := m.ExportedGlobal("counter")
+
+	buffer := make([]byte, MemoryPageSize)
+	buffer[0] = 0xff
+	valid := &Snapshot{
+		Valid:       true,
+		Globals:     []*GlobalInstance{{Type: &GlobalType{ValType: i32, Mutable: true}, Val: 42}},
+		Memory:      &MemoryInstance{Buffer: buffer, Min: 1, Cap: 1, Max: 2},
+		LastFD:      3,
+		OpenedFiles: map[uint32]*internalsys.FileEntry{3: {Path: "/"}},
+	}
+
+	tests := []struct {
+		name        string
+		modify      func(snapshot *Snapshot)
+		expectedErr string
+	}{
+		{
+			name: "missing file",
+			modify: func(snapshot *Snapshot) {
+				snapshot.OpenedFiles = map[uint32]*internalsys.FileEntry{4: {Path: "/foo", File: internalsys.UnopenedFile}}
+			},
+			expectedErr: "failed to reopen fd 4 (/foo): open foo: file does not exist",
+		},
+		{
+			name:        "stdin not seekable",
+			modify:      func(snapshot *Snapshot) { snapshot.StdinRead = 2 },
+			expectedErr: "cannot skip 2 bytes of stdin read before the snapshot: *io.multiReader is not an io.Seeker",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			snapshot := valid.Clone()
+			tc.modify(snapshot)
+			require.EqualError(t, m.Reset(testCtx, snapshot), tc.expectedErr)
+
+			require.Equal(t, uint64(1), counter.Get(testCtx))
+			b, ok := m.Memory().ReadByte(testCtx, 0)
+			require.True(t, ok)
+			require.Equal(t, byte(0), b)
+		})
+	}
+}
+
+// TestCallContext_Reset_Clocks ensures resetting to a snapshot taken before the clocks were read clears the offsets
+// restored by an earlier Reset, rather than keep their skew.
+func TestCallContext_Reset_Clocks(t *testing.T) {
+	s, ns := newStore()
+
+	sysCtx := internalsys.DefaultContext(nil)
+	m, err := s.Instantiate(testCtx, ns, &Module{}, t.Name(), sysCtx, nil)
+	require.NoError(t, err)
+
+	hour := int64(time.Hour)
+	require.NoError(t, m.Reset(testCtx, &Snapshot{Valid: true, Walltime: hour, Nanotime: hour}))
+	walltime, nanotime := sysCtx.Clocks()
+	require.Equal(t, hour, walltime)
+	require.Equal(t, hour, nanotime)
+
+	require.NoError(t, m.Reset(testCtx, &Snapshot{Valid: true})) // the clocks weren't read
+	walltime, nanotime = sysCtx.Clocks()
+	require.Zero(t, walltime)
+	require.Zero(t, nanotime)
+	// The fake clocks tick 1ms each reading, and were read once by the first Reset.
+	require.Equal(t, int64(time.Millisecond), sysCtx.Nanotime(testCtx))
+	sec, nsec := sysCtx.Walltime(testCtx)
+	require.Equal(t, platform.FakeEpochNanos+int64(time.Millisecond), sec*1e9+int64(nsec))
+}
+
+func TestCallContext_Reset_Preopens(t *testing.T) {
+	s, ns := newStore()
+
+	sysCtx := internalsys.DefaultContext(testfs.FS{"foo": &testfs.File{}})
+	m, err := s.Instantiate(testCtx, ns, &Module{}, t.Name(), sysCtx, nil)
+	require.NoError(t, err)
+
+	// As read from an export, which only has the preopens.
+	snapshot := &Snapshot{
+		Valid:       true,
+		LastFD:      3,
+		OpenedFiles: map[uint32]*internalsys.FileEntry{3: {Path: "/"}},
+	}
+	require.NoError(t, m.Reset(testCtx, snapshot))
+
+	fsc := sysCtx.FS(testCtx)
+	entry, ok := fsc.OpenedFile(testCtx, 3)
+	require.True(t, ok)
+	require.Equal(t, "/", entry.Path)
+	require.Nil(t, entry.File) // the root of the file system of the module
+	require.Equal(t, uint32(3), fsc.GetLastFD())
+}
+
+func TestCallContext_Reset_OpenFiles(t *testing.T) {
+	s, ns := newStore()
+
+	sysCtx := internalsys.DefaultContext(fstest.MapFS{"foo": {Data: []byte("abcdef")}})
+	m, err := s.Instantiate(testCtx, ns, &Module{}, t.Name(), sysCtx, nil)
+	require.NoError(t, err)
+
+	// As read from an export, taken after the guest read "ab" from the file it opened.
+	snapshot := &Snapshot{
+		Valid:  true,
+		LastFD: 4,
+		OpenedFiles: map[uint32]*internalsys.FileEntry{
+			3: {Path: "/"}, 4: {Path: "/foo", File: internalsys.UnopenedFile, Offset: 2},
+		},
+	}
+	require.NoError(t, m.Reset(testCtx, snapshot))
+
+	entry, ok := sysCtx.FS(testCtx).OpenedFile(testCtx, 4)
+	require.True(t, ok)
+	buf, err := io.ReadAll(entry.File)
+	require.NoError(t, err)
+	require.Equal(t, "cdef", string(buf)) // rather than read "ab" again
+}
+
+func TestFunctionInstance_CallWithHeap(t *testing.T) {
+	s, ns := newStore()
+
+	i32 := ValueTypeI32
+	m, err := s.Instantiate(testCtx, ns, &Module{
+		TypeSection:     []*FunctionType{{}},
+		FunctionSection: []Index{0},
+		CodeSection:     []*Code{{Body: []byte{OpcodeEnd}}},
+		GlobalSection: []*Global{
+			{Type: &GlobalType{ValType: i32, Mutable: true}, Init: &ConstantExpression{Opcode: OpcodeI32Const, Data: []byte{1}}},
+		},
+		MemorySection: &Memory{Min: 1, Cap: 1, Max: 2},
+		ExportSection: []*Export{
+			{Type: ExternTypeFunc, Name: "main", Index: 0},
+			{Type: ExternTypeGlobal, Name: "counter", Index: 0},
+		},
+	}, t.Name(), nil, nil)
+	require.NoError(t, err)
+	fn := m.ExportedFunction("main").(*FunctionInstance)
+
+	buffer := make([]byte, MemoryPageSize)
+	buffer[0] = 0xff
+	heap := &Snapshot{
+		Mode:    SnapshotModeHeap,
+		Valid:   true,
+		Globals: []*GlobalInstance{{Type: &GlobalType{ValType: i32, Mutable: true}, Val: 42}},
+		Memory:  &MemoryInstance{Buffer: buffer, Min: 1, Cap: 1, Max: 2},
+	}
+
+	t.Run("Resume errs", func(t *testing.T) {
+		_, _, err := fn.Resume(testCtx, heap)
+		require.EqualError(t, err, "cannot resume a heap snapshot: use CallWithHeap")
+	})
+
+	t.Run("restores globals and memory", func(t *testing.T) {
+		_, err := fn.CallWithHeap(testCtx, heap)
+		require.NoError(t, err)
+
+		// Exports see the restored values, as the instances are updated in place.
+		require.Equal(t, uint64(42), m.ExportedGlobal("counter").Get(testCtx))
+		b, ok := m.Memory().ReadByte(testCtx, 0)
+		require.True(t, ok)
+		require.Equal(t, byte(0xff), b)
+	})
+
+	t.Run("errs on mismatched globals", func(t *testing.T) {
+		_, err := fn.CallWithHeap(testCtx, &Snapshot{Valid: true, Memory: heap.Memory})
+		require.EqualError(t, err, "snapshot has 0 globals, but module has 1")
+	})
+
+	t.Run("errs on mismatched global type", func(t *testing.T) {
+		_, err := fn.CallWithHeap(testCtx, &Snapshot{
+			Valid:   true,
+			Globals: []*GlobalInstance{{Type: &GlobalType{ValType: ValueTypeI64}}},
+			Memory:  heap.Memory,
+		})
+		require.EqualError(t, err, "snapshot global[0] is i64, but module's is i32")
+	})
+
+	t.Run("errs on mismatched global mutability", func(t *testing.T) {
+		_, err := fn.CallWithHeap(testCtx, &Snapshot{
+			Valid:   true,
+			Globals: []*GlobalInstance{{Type: &GlobalType{ValType: i32}}},
+			Memory:  heap.Memory,
+		})
+		require.EqualError(t, err, "snapshot global[0] is immutable, but module's is mutable")
+	})
+}
+
+func TestCallContext_StateAccessors(t *testing.T) {
+	s, ns := newStore()
+
+	i32 := ValueTypeI32
+	m, err := s.Instantiate(testCtx, ns, &Module{
+		GlobalSection: []*Global{
+			{Type: &GlobalType{ValType: i32, Mutable: true}, Init: &ConstantExpression{Opcode: OpcodeI32Const, Data: []byte{1}}},
+		},
+		MemorySection: &Memory{Min: 1, Cap: 1, Max: 2},
+	}, t.Name(), nil, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, uint64(MemoryPageSize), m.MemorySizeBytes())
+	_, ok := m.Memory().Grow(testCtx, 1)
+	require.True(t, ok)
+	require.Equal(t, uint64(2*MemoryPageSize), m.MemorySizeBytes())
+
+	globals := m.GlobalsView()
+	require.Equal(t, []GlobalInstance{{Type: &GlobalType{ValType: i32, Mutable: true}, Val: 1}}, globals)
+	globals[0].Val = 2
+	require.Equal(t, uint64(1), m.module.Globals[0].Val) // a copy
+
+	require.Equal(t, 0, m.CallDepth())
+	*m.module.CallDepthCounter() = 3
+	require.Equal(t, 3, m.CallDepth())
+
+	noMemory, err := s.Instantiate(testCtx, ns, &Module{}, t.Name()+"-no-memory", nil, nil)
+	require.NoError(t, err)
+	require.Zero(t, noMemory.MemorySizeBytes())
+	require.Equal(t, []GlobalInstance{}, noMemory.GlobalsView())
+}
@@ -0,0 +1,18 @@
+package wasm
+
+import "github.com/tetratelabs/wazero/api"
+
+// CheckpointGenerationFunctionName is the name guests import CheckpointGeneration as, from the module named
+// YieldModuleName, with the signature (func (result i64)).
+const CheckpointGenerationFunctionName = "checkpoint_generation"
+
+// CheckpointGeneration is a host function that returns the Snapshot.Generation of the calling module: the count of
+// snapshots exported in the chain of resumes leading to it, or zero before the first. A guest can log it, e.g. as
+// "resumed from generation K", to correlate its logs across a chain of checkpoints. Export it as
+// CheckpointGenerationFunctionName next to Yield.
+func CheckpointGeneration(m api.Module) int64 {
+	if callCtx, ok := m.(*CallContext); ok {
+		return int64(callCtx.Generation())
+	}
+	return 0
+}
@@ -0,0 +1,64 @@
+package wasm
+
+import "sync"
+
+// Coverage counts how many times the interpreter executed each operation, keyed by CoverageKey. Set it as
+// SnapshotOptions Coverage of a call to collect it, e.g. to guide fuzzing of a module towards paths new inputs reach.
+// It isn't collected otherwise, so costs nothing when unused.
+//
+// Counts accumulate across calls and resumes given the same Coverage, so a call resumed from a snapshot carries forward
+// the counts of the run that took it. Use a new Coverage, or Reset, to count a run on its own.
+//
+// Note: This is safe to read while a call records to it, but recording takes a lock per operation, so only set it
+// when needed.
+type Coverage struct {
+	mux    sync.Mutex
+	counts map[uint64]uint64
+}
+
+// CoverageKey packs the function index and the pc of an operation in its body into the key of Coverage counts.
+//
+// Note: Only the low 32 bits of pc are kept, which is more than the operations of any function body.
+func CoverageKey(funcIdx Index, pc uint64) uint64 {
+	return uint64(funcIdx)<<32 | pc&0xffffffff
+}
+
+// UnpackCoverageKey is the inverse of CoverageKey.
+func UnpackCoverageKey(key uint64) (funcIdx Index, pc uint64) {
+	return Index(key >> 32), key & 0xffffffff
+}
+
+// Add increments the count of the operation at pc in the function funcIdx.
+func (c *Coverage) Add(funcIdx Index, pc uint64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.counts == nil {
+		c.counts = map[uint64]uint64{}
+	}
+	c.counts[CoverageKey(funcIdx, pc)]++
+}
+
+// Count returns the count of the operation at pc in the function funcIdx, which is zero if it never executed.
+func (c *Coverage) Count(funcIdx Index, pc uint64) uint64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.counts[CoverageKey(funcIdx, pc)]
+}
+
+// Snapshot returns a copy of the counts, keyed by CoverageKey, of each operation executed at least once.
+func (c *Coverage) Snapshot() map[uint64]uint64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	ret := make(map[uint64]uint64, len(c.counts))
+	for k, v := range c.counts {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Reset clears the counts.
+func (c *Coverage) Reset() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.counts = nil
+}
@@ -0,0 +1,54 @@
+package wasm
+
+import (
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+)
+
+func TestCoverageKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		funcIdx Index
+		pc      uint64
+	}{
+		{name: "zero"},
+		{name: "function", funcIdx: 3},
+		{name: "pc", pc: 5},
+		{name: "max", funcIdx: 0xffffffff, pc: 0xffffffff},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			funcIdx, pc := UnpackCoverageKey(CoverageKey(tc.funcIdx, tc.pc))
+			require.Equal(t, tc.funcIdx, funcIdx)
+			require.Equal(t, tc.pc, pc)
+		})
+	}
+	require.NotEqual(t, CoverageKey(1, 0), CoverageKey(0, 1))
+}
+
+func TestCoverage(t *testing.T) {
+	c := &Coverage{}
+	require.Equal(t, uint64(0), c.Count(1, 2))
+	require.Equal(t, map[uint64]uint64{}, c.Snapshot())
+
+	c.Add(1, 2)
+	c.Add(1, 2)
+	c.Add(2, 1)
+	require.Equal(t, uint64(2), c.Count(1, 2))
+	require.Equal(t, uint64(1), c.Count(2, 1))
+
+	snapshot := c.Snapshot()
+	require.Equal(t, map[uint64]uint64{CoverageKey(1, 2): 2, CoverageKey(2, 1): 1}, snapshot)
+
+	// The snapshot is a copy.
+	c.Add(1, 2)
+	require.Equal(t, uint64(2), snapshot[CoverageKey(1, 2)])
+
+	c.Reset()
+	require.Equal(t, uint64(0), c.Count(1, 2))
+	require.Equal(t, map[uint64]uint64{}, c.Snapshot())
+}
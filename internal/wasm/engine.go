@@ -47,8 +47,28 @@ type ModuleEngine interface {
 	// Call invokes a function instance f with given parameters.
 	Call(ctx context.Context, m *CallContext, f *FunctionInstance, params ...uint64) (results []uint64, err error)
 
+	// Resume continues the execution captured in the snapshot, which must have been taken from a call to f, and returns
+	// its results. An engine that cannot snapshot its call stack must return ErrSnapshotUnsupported, and must also fail
+	// Call with it when SnapshotConfigured, instead of silently not taking snapshots.
+	//
+	// To support snapshots, an engine captures its state in the engine-neutral terms of Snapshot when taking one, and
+	// restores it from those when resuming:
+	//   - Frames are bottom first. FunctionIdx is in the module's function index namespace, and Pc is the index of a
+	//     wazeroir operation in that function: the next one to execute in the top frame, and the call in its callers.
+	//   - Stack is the wazeroir value stack of all frames, bottom first, and StackTypes its types, if known.
+	//   - Globals and Memory are the instances of the module.
+	//
+	// Note: Only the interpreter supports this as of now. The compiler would need to map its native return addresses
+	// and stack layout to and from the above.
 	Resume(ctx context.Context, m *CallContext, f *FunctionInstance, snapshot *Snapshot) (results []uint64, err error)
 
+	// ValidateSnapshot returns an error if the engine-specific state of the snapshot is invalid for this module: the pc
+	// of each frame and, if known, the types of the stack. Frame function indices are already known to be in range.
+	// An engine that cannot resume returns ErrSnapshotUnsupported.
+	//
+	// See Snapshot.Validate
+	ValidateSnapshot(snapshot *Snapshot) error
+
 	// CreateFuncElementInstance creates an ElementInstance whose references are engine-specific function pointers
 	// corresponding to the given `indexes`.
 	CreateFuncElementInstance(indexes []*Index) *ElementInstance
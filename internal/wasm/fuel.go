@@ -0,0 +1,18 @@
+package wasm
+
+// FuelRemainingFunctionName is the name guests import FuelRemaining as, from the module named YieldModuleName, with the
+// signature (func (result i64)).
+const FuelRemainingFunctionName = "fuel_remaining"
+
+// FuelUnmetered is the result of FuelRemaining when execution isn't metered.
+const FuelUnmetered int64 = -1
+
+// FuelRemaining is a host function that returns the count of operations the guest may still execute before it traps
+// for exhausting its fuel, so that a cooperative guest can call Yield, or otherwise checkpoint, while the fuel is low
+// rather than be stopped at an arbitrary point. Export it as FuelRemainingFunctionName next to Yield.
+//
+// Note: The result is only meaningful when metering is enabled. As no engine meters fuel yet, this returns
+// FuelUnmetered, which a guest should treat as an unlimited budget.
+func FuelRemaining() int64 {
+	return FuelUnmetered
+}
@@ -44,6 +44,8 @@ var _ api.Memory = &MemoryInstance{}
 type MemoryInstance struct {
 	Buffer        []byte
 	Min, Cap, Max uint32
+	// Shared is true if the memory is shared between threads. See Memory.IsShared
+	Shared bool
 	// mux is used to prevent overlapping calls to Grow.
 	mux sync.RWMutex
 }
@@ -57,6 +59,7 @@ func NewMemoryInstance(memSec *Memory) *MemoryInstance {
 		Min:    memSec.Min,
 		Cap:    memSec.Cap,
 		Max:    memSec.Max,
+		Shared: memSec.IsShared,
 	}
 }
 
@@ -154,6 +154,13 @@ type Module struct {
 	// See https://www.w3.org/TR/2019/REC-wasm-core-1-20191205/#custom-section%E2%91%A0
 	NameSection *NameSection
 
+	// GoBuildID is set when the SectionIDCustom "go:buildid" or "go.buildid" was decoded from the binary format. The Go
+	// compiler (GOOS=js GOARCH=wasm) writes the build ID of the program into this section, so a non-empty value
+	// identifies a binary compiled by Go, as opposed to TinyGo, which doesn't write it.
+	//
+	// Note: This is not encodable, as it is only used to identify the producer of a binary.
+	GoBuildID string
+
 	// HostFunctionSection is index-correlated with FunctionSection and contains a host function defined in Go.
 	// When present, the CodeSection must be nil.
 	//
@@ -754,6 +761,11 @@ type Memory struct {
 	Min, Cap, Max uint32
 	// IsMaxEncoded true if the Max is encoded in the original source (binary or text).
 	IsMaxEncoded bool
+	// IsShared is true if the memory is shared between threads, per the threads proposal. Neither the binary nor the
+	// text format decodes it yet, so only a module defined in Go sets it.
+	//
+	// See https://github.com/WebAssembly/threads/blob/main/proposals/threads/Overview.md
+	IsShared bool
 }
 
 // Validate ensures values assigned to Min, Cap and Max are within valid thresholds.
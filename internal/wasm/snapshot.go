@@ -1,30 +1,809 @@
 package wasm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/tetratelabs/wazero/internal/sys"
+	"github.com/tetratelabs/wazero/internal/wasmruntime"
 )
 
+// ErrSnapshotUnsupported is returned by an engine, such as the compiler, which cannot take a snapshot or resume from one.
+var ErrSnapshotUnsupported = errors.New("snapshot is not supported by this engine: use the interpreter")
+
+// ErrSnapshotSharedMemoryUnsupported is returned when taking, exporting, reading or resuming a snapshot of a shared
+// memory, rather than capturing a view of it torn by threads accessing it concurrently. Capturing it consistently
+// requires quiescing those threads first, which isn't supported yet.
+var ErrSnapshotSharedMemoryUnsupported = errors.New("snapshot of a shared memory is not supported")
+
+// SnapshotConfigured returns true when ctx asks for snapshots to be taken, via SnapshotOptions Snapshot or OnTrap.
+func SnapshotConfigured(ctx context.Context) bool {
+	opts := SnapshotOptionsFromContext(ctx)
+	return opts.Snapshot != nil || opts.OnTrap
+}
+
+// CallFrame is a frame of the call stack of a Snapshot: the function at FunctionIdx, stopped at the operation at Pc.
+//
+// Note: There's no stack of control flow labels to restore with the frame, e.g. of the loops enclosing Pc, as the
+// engine resolves each branch to the pc of its target, and the values it drops, when compiling the function.
 type CallFrame struct {
 	Pc          uint64
 	FunctionIdx uint32 // function index
 }
 
+// NewCallFrame returns the frame of the function at functionIdx, stopped at the operation at pc.
+//
+// Note: This doesn't validate the frame, as that depends on where it is used. See Validate, ValidateFunctionIndex and
+// ValidatePc.
+func NewCallFrame(functionIdx uint32, pc uint64) CallFrame {
+	return CallFrame{Pc: pc, FunctionIdx: functionIdx}
+}
+
+// Validate returns an error if no module could have the function of the frame, e.g. as it was decoded from a corrupt
+// snapshot.
+//
+// Note: The errors of the methods validating a frame don't say which frame it is, e.g. "has function index 3, but
+// there are 2 functions", so prefix them, e.g. with "snapshot frame[0]".
+func (frame CallFrame) Validate() error {
+	if frame.FunctionIdx >= MaximumFunctionIndex {
+		return fmt.Errorf("has function index %d, past the maximum of %d", frame.FunctionIdx, MaximumFunctionIndex-1)
+	}
+	return nil
+}
+
+// ValidateFunctionIndex returns an error unless the function of the frame is one of functionCount functions.
+func (frame CallFrame) ValidateFunctionIndex(functionCount int) error {
+	if int(frame.FunctionIdx) >= functionCount {
+		return fmt.Errorf("has function index %d, but there are %d functions", frame.FunctionIdx, functionCount)
+	}
+	return nil
+}
+
+// ValidatePc returns an error unless the pc of the frame is within the operationCount operations of its function.
+// The top frame's pc can be the end of its function, e.g. when it was taken on return from a call, whereas the pc of
+// each other frame must be at a call, per isCall.
+//
+// Note: isCall is only called with a pc less than operationCount.
+func (frame CallFrame) ValidatePc(operationCount uint64, top bool, isCall func(pc uint64) bool) error {
+	if top {
+		if frame.Pc > operationCount {
+			return fmt.Errorf("has pc %d, past the %d operations of function[%d]",
+				frame.Pc, operationCount, frame.FunctionIdx)
+		}
+	} else if frame.Pc >= operationCount || !isCall(frame.Pc) {
+		return fmt.Errorf("has pc %d, which is not a call in function[%d]", frame.Pc, frame.FunctionIdx)
+	}
+	return nil
+}
+
+// SnapshotMode selects which state the engine captures in a Snapshot.
+type SnapshotMode uint8
+
+const (
+	// SnapshotModeFull captures the execution stack as well as globals and memory, so that
+	// FunctionInstance.Resume continues where the snapshot was taken.
+	SnapshotModeFull SnapshotMode = iota
+	// SnapshotModeHeap only captures globals and memory, leaving Stack and Frames empty. This is cheaper than
+	// SnapshotModeFull, and is restored with FunctionInstance.CallWithHeap, which re-enters the module from an export.
+	SnapshotModeHeap
+)
+
+// SnapshotGranularity selects where the engine takes snapshots when SnapshotOptions Always is set. Coarser
+// granularities leave the stack in a simpler state, and take fewer snapshots.
+type SnapshotGranularity uint8
+
+const (
+	// SnapshotGranularityInstruction snapshots after every instruction.
+	SnapshotGranularityInstruction SnapshotGranularity = iota
+	// SnapshotGranularityBlock snapshots after each branch, so the pc is at the start of a basic block.
+	SnapshotGranularityBlock
+	// SnapshotGranularityFunction snapshots on entry to and return from each function, so the pc of the top frame is
+	// either the first instruction of a function or the one after a call.
+	SnapshotGranularityFunction
+)
+
+// SnapshotReason is why the engine took a snapshot, so that a driver can decide whether to stop or continue after
+// persisting it, without out-of-band flags.
+type SnapshotReason uint8
+
+const (
+	// SnapshotReasonUnknown is the reason of a snapshot not taken by the engine, e.g. read from an export.
+	SnapshotReasonUnknown SnapshotReason = iota
+	// SnapshotReasonCooperative is when the guest asked for a snapshot by executing a nop.
+	SnapshotReasonCooperative
+	// SnapshotReasonAlways is when SnapshotOptions Always is set, e.g. to trace execution.
+	SnapshotReasonAlways
+	// SnapshotReasonInterrupt is when the context.Context was done, e.g. as its deadline passed.
+	SnapshotReasonInterrupt
+	// SnapshotReasonTrap is when SnapshotOptions OnTrap is set and the guest trapped.
+	SnapshotReasonTrap
+	// SnapshotReasonYield is when the guest called Yield. See Snapshot.YieldTag
+	SnapshotReasonYield
+)
+
+var snapshotReasonNames = [...]string{
+	SnapshotReasonUnknown:     "unknown",
+	SnapshotReasonCooperative: "cooperative",
+	SnapshotReasonAlways:      "always",
+	SnapshotReasonInterrupt:   "interrupt",
+	SnapshotReasonTrap:        "trap",
+	SnapshotReasonYield:       "yield",
+}
+
+// String returns the name of the reason. Ex. "cooperative"
+func (r SnapshotReason) String() string {
+	if int(r) < len(snapshotReasonNames) {
+		return snapshotReasonNames[r]
+	}
+	return snapshotReasonNames[SnapshotReasonUnknown]
+}
+
 type Snapshot struct {
-	Valid   bool
-	Stack   []uint64
-	Globals []*GlobalInstance
-	Frames  []CallFrame
-	Memory  *MemoryInstance
+	// Mode is set by the caller before execution to select what the engine captures. Defaults to SnapshotModeFull.
+	Mode SnapshotMode
+	// Granularity is set by the caller before execution to select where the engine snapshots when SnapshotOptions
+	// Always is set. Defaults to SnapshotGranularityInstruction.
+	Granularity SnapshotGranularity
+	// ExternRefCodec is optionally set by the caller before execution to encode the externref globals when a snapshot
+	// is taken, and decode them on resume. Without it, they hold the host pointers of the process that took the
+	// snapshot.
+	ExternRefCodec ExternRefCodec
+	// ScrubHeapEnd is optionally set by the caller before execution to zero the memory past the end of the guest's heap
+	// when a snapshot is taken, so that stale bytes, e.g. of allocations freed when the heap shrank, neither leak into
+	// the snapshot nor keep its pages from being sparse. See HeapEnd for the assumptions about the guest's allocator.
+	ScrubHeapEnd HeapEnd
+	// PageTransform is optionally set by the caller before execution to transform each memory page of the snapshots
+	// exported per SnapshotOptions Export, e.g. to encrypt them. The exported snapshot records
+	// that its memory is transformed, but not how, so it must be read with the same transform.
+	PageTransform PageTransform
+	// AlignMemory is optionally set by the caller before execution to pad the exported snapshots so that their memory
+	// starts at a page boundary of the file, which allows interpreter.MapSnapshot to map it rather than read it. It is
+	// ignored with PageTransform, as transformed pages can't be mapped.
+	AlignMemory bool
+
+	Valid bool
+	// Err is why the engine failed to take the snapshot, e.g. as ExternRefCodec couldn't encode a reference, or it
+	// couldn't be exported, in which case Valid is false, the snapshot can't be resumed, and the call stops with a
+	// SnapshotError holding it.
+	Err error
+	// Reason is why the engine took the snapshot.
+	Reason SnapshotReason
+	// YieldTag is the tag the guest passed to Yield when Reason is SnapshotReasonYield, e.g. to tell the host what it
+	// waits for. It is zero otherwise.
+	YieldTag uint32
+	// ResumeValue is optionally set by the caller before resuming a snapshot whose Reason is SnapshotReasonYield. It is
+	// returned to the guest as the result of its call to Yield.
+	ResumeValue uint32
+	// Stack is the value stack, bottom first. As with GlobalInstance Val and ValHi, each word holds the raw bits of the
+	// value, which neither taking, exporting nor resuming the snapshot converts, so a float NaN keeps its exact bit
+	// pattern, e.g. its payload, rather than being canonicalized.
+	Stack []uint64
+	// StackTypes are the types of the values in Stack, bottom first. There is one type per value, not per uint64, so
+	// a ValueTypeV128 covers two words. This is nil when the types are unknown at the point the snapshot was taken.
+	//
+	// Note: Reference types are recorded as ValueTypeI64 as they are opaque 64-bit pointers in the engine.
+	StackTypes []ValueType
+	Globals    []*GlobalInstance
+	Frames     []CallFrame
+	// Memory is the memory when the snapshot was taken. Min is its size in pages at that time, which exceeds the
+	// declared minimum once the guest grew it, and Cap is the allocated capacity in pages.
+	Memory *MemoryInstance
+	// ShareMemory is whether resuming adopts the buffer of Memory as the module's memory, instead of copying it. This
+	// avoids reading a memory mapped from a file, e.g. by interpreter.MapSnapshot, until its pages are accessed.
+	//
+	// Note: The buffer must not be reused, e.g. to resume the snapshot again, until the module is closed.
+	ShareMemory bool
+	// MaxMemoryBytes is optionally set by the caller before resuming to bound the memory resuming allocates, e.g. when
+	// the snapshot comes from an untrusted source. Resuming errs, without allocating, when the Min or Cap of Memory
+	// exceeds it, as a crafted snapshot could otherwise declare a capacity large enough to exhaust the host. Zero
+	// defaults to the max of the module's memory.
+	MaxMemoryBytes uint64
+
+	// InstructionCount is the count of operations the engine executed in the call so far, including before any
+	// snapshot it was resumed from, so that an instruction cadence continues across resumes rather than resetting.
+	InstructionCount uint64
+
+	// Generation is the count of snapshots exported, per SnapshotOptions Export or interpreter.WriteSnapshot, in the
+	// chain of resumes leading to this snapshot, including it if exported. Resuming restores it, so the guest can read
+	// which checkpoint it was resumed from via CheckpointGeneration, e.g. to correlate its logs across the chain.
+	Generation uint64
+
+	// Closed is the exit state of the module when the snapshot was taken, packed as documented on CallContext.closed.
+	// When non-zero, resuming returns the original sys.ExitError instead of executing the module again.
+	Closed uint64
 
 	// file system
 	LastFD      uint32
 	OpenedFiles map[uint32]*sys.FileEntry
+
+	// StdoutWritten and StderrWritten are the counts of bytes the module wrote to stdout and stderr via WASI when the
+	// snapshot was taken, including those of any snapshot it was resumed from. Resuming restores them, so a driver can
+	// tell how much output a previous run already emitted, e.g. to suppress it when replaying.
+	//
+	// Note: A writer which buffers, e.g. a bufio.Writer, counts bytes before they are flushed. Flush it before
+	// persisting the snapshot, or the bytes counted may have never reached their destination.
+	StdoutWritten, StderrWritten uint64
+
+	// StdinRead is the count of bytes the module read from stdin via WASI when the snapshot was taken, including those
+	// of any snapshot it was resumed from. Resuming skips as many bytes of the stdin configured then, so they are
+	// neither delivered again nor lost. This requires stdin to be an io.Seeker, e.g. a file or strings.Reader, when
+	// non-zero.
+	StdinRead uint64
+
+	// RandRead is the count of bytes the module read from its random source, e.g. via WASI random_get, when the snapshot
+	// was taken, including those of any snapshot it was resumed from. Resuming discards as many bytes of the random
+	// source configured then, so that a deterministic one, e.g. set with wazero.ModuleConfig WithRandSource, continues
+	// where it left off.
+	RandRead uint64
+
+	// Walltime and Nanotime are the last readings of the clocks by the module, in nanoseconds, when the snapshot was
+	// taken, or zero for a clock it didn't read. Resuming offsets the clocks configured then to continue from them, so
+	// that the resumed module sees neither time run backwards, nor jump by how long it was suspended.
+	Walltime, Nanotime int64
+
+	// Args and Environ are those the module was configured with, e.g. by wazero.ModuleConfig WithArgs, when the
+	// snapshot was taken. They are restored on resume, winning over the ones configured then, so that a program
+	// reading them lazily sees the same values. Both are nil when not captured, in which case the configured ones are
+	// kept.
+	Args, Environ []string
+
+	// MemoryRanges are regions of memory captured with CaptureMemoryRange, independently of Memory, e.g. by a
+	// debugger inspecting a known struct or buffer in a running guest.
+	MemoryRanges []*MemoryRange
+}
+
+// MemoryRange is a copy of Data, which was at Offset in memory when captured.
+type MemoryRange struct {
+	Offset uint32
+	Data   []byte
+}
+
+// ExternRefCodec converts externref values, which are opaque pointers to host objects, to and from values which stay
+// meaningful once the snapshot is resumed, e.g. indices into a table of host objects the embedder saves alongside it.
+// Zero is the null reference, so is neither encoded nor decoded.
+//
+// Note: Only externref globals are converted, as the stack records reference types as ValueTypeI64, and tables are not
+// part of a snapshot.
+type ExternRefCodec interface {
+	// EncodeExternRef is called for each non-null externref when a snapshot is taken, and must not return zero.
+	EncodeExternRef(ref uintptr) (uint64, error)
+
+	// DecodeExternRef is called on resume for each non-zero value EncodeExternRef returned.
+	DecodeExternRef(encoded uint64) (uintptr, error)
+}
+
+// PageTransform transforms each memory page of an exported snapshot, e.g. to encrypt guest data at rest with a key the
+// embedder holds, and inverts it when the snapshot is read, e.g. by interpreter.ReadSnapshotTransform. Pages are
+// MemoryPageSize bytes, except maybe the last, and indexed from zero, so per-page state, such as a nonce, can be
+// derived from pageIndex.
+type PageTransform interface {
+	// Transform returns the bytes to export for the page data, which may differ in length, e.g. to add a nonce and tag.
+	Transform(pageIndex int, data []byte) ([]byte, error)
+
+	// Invert is the inverse of Transform, returning the page data.
+	Invert(pageIndex int, data []byte) ([]byte, error)
+}
+
+// SnapshotError is returned by the interpreter when execution stops after a snapshot, as SnapshotOptions TrapAfter is
+// set. errors.Is matches wasmruntime.ErrRuntimeSnapshot.
+type SnapshotError struct {
+	// Reason is why the snapshot was taken.
+	Reason SnapshotReason
+	// Resumable is true when the call must be resumed from the snapshot to finish. It is false when the module had
+	// already exited, in which case resuming only returns its sys.ExitError, so a driver can stop.
+	Resumable bool
+	// YieldTag is the tag the guest passed to Yield when Reason is SnapshotReasonYield, so that the host can route the
+	// resumption without reading the snapshot. See Snapshot.YieldTag
+	YieldTag uint32
+	// Err is why the snapshot couldn't be taken, per Snapshot.Err, in which case it isn't Resumable.
+	Err error
+}
+
+// NewSnapshotError returns the error for stopping execution after taking the snapshot.
+func NewSnapshotError(snap *Snapshot) *SnapshotError {
+	return &SnapshotError{Reason: snap.Reason, Resumable: snap.Closed == 0 && snap.Err == nil, YieldTag: snap.YieldTag, Err: snap.Err}
+}
+
+// Error implements error.
+func (e *SnapshotError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s (%s): %v", wasmruntime.ErrRuntimeSnapshot, e.Reason, e.Err)
+	}
+	return fmt.Sprintf("%s (%s)", wasmruntime.ErrRuntimeSnapshot, e.Reason)
+}
+
+// Unwrap allows errors.Is to match wasmruntime.ErrRuntimeSnapshot.
+func (e *SnapshotError) Unwrap() error {
+	return wasmruntime.ErrRuntimeSnapshot
+}
+
+// TrapSnapshotError is returned by the interpreter instead of the error of a runtime trap, such as
+// wasmruntime.ErrRuntimeUnreachable, when SnapshotOptions OnTrap is set. The snapshot holds the
+// state at the faulting instruction for post-mortem debugging.
+//
+// Note: The snapshot shares the memory of the module, so it exposes guest memory to whoever receives the error.
+type TrapSnapshotError struct {
+	// Err is the error the call would have returned without the snapshot.
+	Err error
+	// Snapshot is the state when the trap occurred: the pc of the top frame is that of the faulting instruction.
+	Snapshot *Snapshot
+}
+
+// Error implements error.
+func (e *TrapSnapshotError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap allows errors.Is to match the trap, e.g. wasmruntime.ErrRuntimeUnreachable.
+func (e *TrapSnapshotError) Unwrap() error {
+	return e.Err
+}
+
+// newNext returns an empty snapshot with the configuration set by the caller, to take the next snapshot of a resumed
+// call in.
+func (snap *Snapshot) newNext() *Snapshot {
+	return &Snapshot{
+		Mode:           snap.Mode,
+		Granularity:    snap.Granularity,
+		ExternRefCodec: snap.ExternRefCodec,
+		ScrubHeapEnd:   snap.ScrubHeapEnd,
+		PageTransform:  snap.PageTransform,
+		AlignMemory:    snap.AlignMemory,
+		MaxMemoryBytes: snap.MaxMemoryBytes,
+	}
+}
+
+// Clone returns a deep copy of the snapshot, so that the engine or caller can keep mutating the original, e.g. on
+// resume. The memory buffer is copied as well.
+//
+// Note: File entries are copied, but the fs.File they hold is shared as an open file cannot be duplicated.
+func (snap *Snapshot) Clone() *Snapshot {
+	ret := *snap
+	ret.Stack = append([]uint64(nil), snap.Stack...)
+	ret.StackTypes = append([]ValueType(nil), snap.StackTypes...)
+	ret.Frames = append([]CallFrame(nil), snap.Frames...)
+
+	if snap.Globals != nil {
+		ret.Globals = make([]*GlobalInstance, len(snap.Globals))
+		for i, g := range snap.Globals {
+			global := *g
+			ret.Globals[i] = &global
+		}
+	}
+
+	if mem := snap.Memory; mem != nil {
+		buffer := make([]byte, len(mem.Buffer), cap(mem.Buffer))
+		copy(buffer, mem.Buffer)
+		ret.Memory = &MemoryInstance{Buffer: buffer, Min: mem.Min, Cap: mem.Cap, Max: mem.Max, Shared: mem.Shared}
+	}
+
+	if snap.Args != nil {
+		ret.Args = append([]string{}, snap.Args...)
+	}
+	if snap.Environ != nil {
+		ret.Environ = append([]string{}, snap.Environ...)
+	}
+
+	if snap.MemoryRanges != nil {
+		ret.MemoryRanges = make([]*MemoryRange, len(snap.MemoryRanges))
+		for i, r := range snap.MemoryRanges {
+			ret.MemoryRanges[i] = &MemoryRange{Offset: r.Offset, Data: append([]byte(nil), r.Data...)}
+		}
+	}
+
+	if snap.OpenedFiles != nil {
+		ret.OpenedFiles = make(map[uint32]*sys.FileEntry, len(snap.OpenedFiles))
+		for fd, entry := range snap.OpenedFiles {
+			e := *entry
+			ret.OpenedFiles[fd] = &e
+		}
+	}
+	return &ret
+}
+
+// MemorySize returns the length in bytes of the memory in the snapshot, or zero if there is none.
+func (snap *Snapshot) MemorySize() uint64 {
+	if snap.Memory == nil {
+		return 0
+	}
+	return uint64(len(snap.Memory.Buffer))
+}
+
+// NonZeroPageCount returns the count of memory pages, of MemoryPageSize bytes, that have at least one non-zero byte.
+// A trailing partial page counts as a page. As zero pages need not be stored, this bounds the memory a snapshot needs,
+// for example to enforce a quota before loading it.
+func (snap *Snapshot) NonZeroPageCount() int {
+	if snap.Memory == nil {
+		return 0
+	}
+	count := 0
+	for page := snap.Memory.Buffer; len(page) > 0; {
+		n := len(page)
+		if n > int(MemoryPageSize) {
+			n = int(MemoryPageSize)
+		}
+		for _, b := range page[:n] {
+			if b != 0 {
+				count++
+				break
+			}
+		}
+		page = page[n:]
+	}
+	return count
+}
+
+// ValidateGlobals returns an error unless the globals in the snapshot match the count, value types and mutability of
+// those declared by the module, identifying the first global that doesn't. This catches resuming with a snapshot of a
+// different module, which would otherwise reinterpret the global values as another type.
+func (snap *Snapshot) ValidateGlobals(module *ModuleInstance) error {
+	if len(snap.Globals) != len(module.Globals) {
+		return fmt.Errorf("snapshot has %d globals, but module has %d", len(snap.Globals), len(module.Globals))
+	}
+	for i, g := range snap.Globals {
+		declared := module.Globals[i].Type
+		if g.Type.ValType != declared.ValType {
+			return fmt.Errorf("snapshot global[%d] is %s, but module's is %s",
+				i, ValueTypeName(g.Type.ValType), ValueTypeName(declared.ValType))
+		}
+		if g.Type.Mutable != declared.Mutable {
+			return fmt.Errorf("snapshot global[%d] is %s, but module's is %s",
+				i, mutability(g.Type.Mutable), mutability(declared.Mutable))
+		}
+	}
+	return nil
+}
+
+// SetGlobal sets the value of the global at index in the snapshot, e.g. for a debugger to patch a loop counter before
+// resuming. valHi is the high 64 bits of a ValueTypeV128, and must be zero for other types. This errs, leaving the
+// snapshot as is, unless the index is in range and the value fits the declared type of a mutable global, as the
+// module could not have set an immutable global.
+//
+// Note: The global is replaced with a copy, so that the module the snapshot was taken from, which may share its
+// globals, is unaffected.
+func (snap *Snapshot) SetGlobal(index uint32, val, valHi uint64) error {
+	if int(index) >= len(snap.Globals) {
+		return fmt.Errorf("snapshot has %d globals, so no global[%d]", len(snap.Globals), index)
+	}
+	g := snap.Globals[index]
+	t := g.Type.ValType
+	if !g.Type.Mutable {
+		return fmt.Errorf("snapshot global[%d] is immutable", index)
+	}
+	switch t {
+	case ValueTypeI32, ValueTypeF32:
+		if val>>32 != 0 {
+			return fmt.Errorf("snapshot global[%d] is %s, but value %#x exceeds 32 bits", index, ValueTypeName(t), val)
+		}
+	case ValueTypeFuncref:
+		// The value is a pointer into the engine, which no caller could know.
+		return fmt.Errorf("snapshot global[%d] is %s, which can't be set", index, ValueTypeName(t))
+	}
+	if valHi != 0 && t != ValueTypeV128 {
+		return fmt.Errorf("snapshot global[%d] is %s, so has no high bits", index, ValueTypeName(t))
+	}
+
+	global := *g
+	global.Val, global.ValHi = val, valHi
+	globals := append([]*GlobalInstance(nil), snap.Globals...)
+	globals[index] = &global
+	snap.Globals = globals
+	return nil
+}
+
+// ExportedFunctionName returns the name under which the module exports the outermost function of the snapshot, which
+// is the function FunctionInstance.Resume must be called on. If the function is exported more than once, the
+// lexicographically first name wins, regardless of the order of the export section, so that this agrees with
+// CallContext.SnapshotFunctionName, which reads the unordered exports of a module instance.
+//
+// Note: Frames are ordered from the outermost call, so this reads Frames[0].
+func (snap *Snapshot) ExportedFunctionName(module *Module) (string, error) {
+	if len(snap.Frames) == 0 {
+		return "", errors.New("snapshot has no frames")
+	}
+	funcIdx := snap.Frames[0].FunctionIdx
+	var name string
+	for _, exp := range module.ExportSection {
+		if exp.Type == ExternTypeFunc && exp.Index == funcIdx && (name == "" || exp.Name < name) {
+			name = exp.Name
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("snapshot function[%d] is not exported", funcIdx)
+	}
+	return name, nil
+}
+
+func mutability(mutable bool) string {
+	if mutable {
+		return "mutable"
+	}
+	return "immutable"
+}
+
+// validateMemorySize returns an error unless the size of the snapshot's memory agrees with its buffer and capacity,
+// is at least the declared minimum of mem, as memory never shrinks, and fits in mem and MaxMemoryBytes. A snapshot
+// from a third-party tool, or corrupted in transit, could otherwise lead to out-of-bounds accesses or huge allocations
+// once resumed. Either memory being shared errs with ErrSnapshotSharedMemoryUnsupported.
+func (snap *Snapshot) validateMemorySize(mem *MemoryInstance) error {
+	if snap.Memory.Shared || mem.Shared {
+		return ErrSnapshotSharedMemoryUnsupported
+	}
+	pages := snap.Memory.Min
+	if limit := snap.MaxMemoryBytes; limit != 0 {
+		declared := pages
+		if c := snap.Memory.Cap; c > declared {
+			declared = c
+		}
+		if size := MemoryPagesToBytesNum(declared); size > limit {
+			return fmt.Errorf("snapshot memory declares %d bytes, exceeding the max of %d", size, limit)
+		}
+	}
+	if size := len(snap.Memory.Buffer); uint64(size) != MemoryPagesToBytesNum(pages) {
+		return fmt.Errorf("snapshot memory has %d pages, but %d bytes", pages, size)
+	}
+	if pages > mem.Max {
+		return fmt.Errorf("snapshot memory has %d pages, exceeding the module's max of %d", pages, mem.Max)
+	}
+	if pages < mem.Min {
+		return fmt.Errorf("snapshot memory has %d pages, below the module's min of %d", pages, mem.Min)
+	}
+	if c := snap.Memory.Cap; c < pages {
+		return fmt.Errorf("snapshot memory has %d pages, exceeding its capacity of %d", pages, c)
+	}
+	return nil
+}
+
+// SnapshotValidationError is returned by Snapshot.Validate, listing each reason the snapshot can't be resumed.
+type SnapshotValidationError struct {
+	Errs []error
+}
+
+// Error implements error.
+func (e *SnapshotValidationError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return "invalid snapshot: " + strings.Join(msgs, "; ")
+}
+
+// Validate returns a SnapshotValidationError listing every reason the snapshot can't be resumed in the module, or nil
+// if it can, so that a caller can check once before resuming. This checks that:
+//   - The function index of each frame is in range, then, via ModuleEngine.ValidateSnapshot, the pcs and stack types.
+//   - The globals match those of the module, per ValidateGlobals.
+//   - The memory is within its max and MaxMemoryBytes, and its size agrees with its buffer and the max of the module's
+//     memory.
+//   - The opened files can be reopened in the file system of the module, per sys.FSContext CheckFiles.
+//
+// Note: Resume performs the same checks, but stops at the first error.
+func (snap *Snapshot) Validate(module *ModuleInstance) error {
+	var errs []error
+	if snap.Err != nil {
+		errs = append(errs, fmt.Errorf("snapshot wasn't taken: %w", snap.Err))
+	}
+	for i, frame := range snap.Frames {
+		if err := frame.ValidateFunctionIndex(len(module.Functions)); err != nil {
+			errs = append(errs, fmt.Errorf("snapshot frame[%d] %w", i, err))
+		}
+	}
+	if len(errs) == 0 && module.Engine != nil { // the engine needs valid function indices.
+		if err := module.Engine.ValidateSnapshot(snap); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := snap.ValidateGlobals(module); err != nil {
+		errs = append(errs, err)
+	}
+
+	if (snap.Memory == nil) != (module.Memory == nil) {
+		errs = append(errs, errors.New("snapshot and module disagree on whether there is a memory"))
+	} else if mem := snap.Memory; mem != nil {
+		if mem.Min > mem.Max {
+			errs = append(errs, fmt.Errorf("snapshot memory has %d pages, exceeding its max of %d", mem.Min, mem.Max))
+		}
+		if err := snap.validateMemorySize(module.Memory); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if callCtx := module.CallCtx; callCtx != nil && callCtx.Sys != nil {
+		if fsc := callCtx.Sys.FS(context.Background()); fsc != nil {
+			errs = append(errs, fsc.CheckFiles(snap.OpenedFiles)...)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &SnapshotValidationError{Errs: errs}
+	}
+	return nil
+}
+
+// EncodeExternRefs replaces the externref globals of the snapshot with copies holding the values ExternRefCodec
+// encodes, leaving those of the module, which the snapshot may share, as they are. This does nothing without an
+// ExternRefCodec.
+func (snap *Snapshot) EncodeExternRefs() error {
+	if snap.ExternRefCodec == nil {
+		return nil
+	}
+	globals := make([]*GlobalInstance, len(snap.Globals))
+	for i, g := range snap.Globals {
+		globals[i] = g
+		if g.Type.ValType != ValueTypeExternref || g.Val == 0 {
+			continue
+		}
+		encoded, err := snap.ExternRefCodec.EncodeExternRef(uintptr(g.Val))
+		if err != nil {
+			return fmt.Errorf("failed to encode externref global[%d]: %w", i, err)
+		} else if encoded == 0 {
+			return fmt.Errorf("failed to encode externref global[%d]: zero is the null reference", i)
+		}
+		encodedGlobal := *g
+		encodedGlobal.Val = encoded
+		globals[i] = &encodedGlobal
+	}
+	snap.Globals = globals
+	return nil
+}
+
+// DecodedGlobals returns the globals of the snapshot to restore, which are copies holding the values ExternRefCodec
+// decodes for externref globals, so that the snapshot can be resumed again. Without an ExternRefCodec, this returns
+// Globals as is.
+func (snap *Snapshot) DecodedGlobals() ([]*GlobalInstance, error) {
+	if snap.ExternRefCodec == nil {
+		return snap.Globals, nil
+	}
+	globals := make([]*GlobalInstance, len(snap.Globals))
+	for i, g := range snap.Globals {
+		globals[i] = g
+		if g.Type.ValType != ValueTypeExternref || g.Val == 0 {
+			continue
+		}
+		ref, err := snap.ExternRefCodec.DecodeExternRef(g.Val)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode externref global[%d]: %w", i, err)
+		}
+		decodedGlobal := *g
+		decodedGlobal.Val = uint64(ref)
+		globals[i] = &decodedGlobal
+	}
+	return globals, nil
+}
+
+// Restore overwrites the state of the instantiated module with that of the snapshot, per CallContext Reset, without
+// executing it, e.g. to load a checkpoint on demand into a module instantiated ahead of time in a pool. Resume the
+// snapshot in the module to continue its execution.
+//
+// This calls Validate before overwriting anything, so a snapshot that can't be resumed in the module leaves it as is.
+// If restoring still fails, e.g. as stdin can't skip the bytes read before the snapshot, the module is closed instead
+// of left partially restored.
+//
+// Note: Like Validate, this uses the file system configured on the module, not one overriding it in a context.
+func (snap *Snapshot) Restore(module *ModuleInstance) error {
+	if err := snap.Validate(module); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if err := module.CallCtx.Reset(ctx, snap); err != nil {
+		if !errors.Is(err, ErrModuleClosed) {
+			_ = module.CallCtx.Close(ctx)
+		}
+		return err
+	}
+	return nil
+}
+
+// restoreHeap overwrites the globals and memory of the module with those in the snapshot, keeping the instances
+// themselves, so that exports and the CallContext see the restored values.
+func (snap *Snapshot) restoreHeap(module *ModuleInstance) error {
+	if snap.Err != nil {
+		return fmt.Errorf("snapshot wasn't taken: %w", snap.Err)
+	}
+	if err := snap.ValidateGlobals(module); err != nil {
+		return err
+	}
+
+	globals, err := snap.DecodedGlobals()
+	if err != nil {
+		return err
+	}
+	if err = snap.RestoreMemory(module.Memory); err != nil {
+		return err
+	}
+
+	for i, g := range globals {
+		module.Globals[i].Val = g.Val
+		module.Globals[i].ValHi = g.ValHi
+	}
+	return nil
+}
+
+// RestoreMemory resizes mem to the size recorded in the snapshot, then copies the snapshot's memory into it. This
+// restores the size a guest grew the memory to before the snapshot, rather than the module's declared minimum. The
+// allocation is grown to the recorded Cap, so that growing again after resume doesn't reallocate either. When
+// ShareMemory is set, the snapshot's buffer becomes the memory instead.
+//
+// Note: mem is updated in place as the CallContext and exports of the module refer to it.
+func (snap *Snapshot) RestoreMemory(mem *MemoryInstance) error {
+	if (snap.Memory == nil) != (mem == nil) {
+		return errors.New("snapshot and module disagree on whether there is a memory")
+	}
+	if mem == nil {
+		return nil
+	}
+
+	if err := snap.validateMemorySize(mem); err != nil {
+		return err
+	}
+	pages, buffer := snap.Memory.Min, snap.Memory.Buffer
+
+	mem.mux.Lock()
+	defer mem.mux.Unlock()
+
+	if snap.ShareMemory {
+		// Growing beyond the buffer copies it, as the module can't reallocate a mapping in place.
+		mem.Buffer, mem.Cap = buffer, pages
+		return nil
+	}
+
+	capacity := pages
+	if c := snap.Memory.Cap; c > capacity && c <= mem.Max {
+		capacity = c
+	}
+	if capacity > mem.Cap {
+		mem.Buffer = make([]byte, 0, MemoryPagesToBytesNum(capacity))
+		mem.Cap = capacity
+	}
+	restored := MemoryPagesToBytesNum(pages)
+	if written := uint64(len(mem.Buffer)); written > restored {
+		// Zero the pages past the restored size, so that growing the memory after resume exposes zero pages, as
+		// memory.grow must, rather than those the module wrote before the reset.
+		zeroBytes(mem.Buffer[restored:written])
+	}
+	mem.Buffer = mem.Buffer[:restored]
+	copy(mem.Buffer, buffer)
+	return nil
+}
+
+// CaptureMemoryRange appends a copy of length bytes of the memory at offset to MemoryRanges. Unlike Memory, this is
+// lightweight enough to capture a known region of a running guest for inspection, and patch it back with
+// RestoreMemoryRanges. This errs if the range is out of the bounds of the memory.
+func (snap *Snapshot) CaptureMemoryRange(mem *MemoryInstance, offset, length uint32) error {
+	if mem == nil {
+		return errors.New("cannot capture a memory range without a memory")
+	}
+	if !mem.hasSize(offset, length) {
+		return fmt.Errorf("memory range [%d, %d) is out of bounds of memory size %d",
+			offset, uint64(offset)+uint64(length), len(mem.Buffer))
+	}
+	data := make([]byte, length)
+	copy(data, mem.Buffer[offset:])
+	snap.MemoryRanges = append(snap.MemoryRanges, &MemoryRange{Offset: offset, Data: data})
+	return nil
 }
 
-func (snap *Snapshot) String() string {
-	return fmt.Sprintf("Call Frame: %v, Stack: %v, Globals: %v, LastFD: %v", snap.Frames, snap.Stack, snap.Globals, snap.LastFD)
+// RestoreMemoryRanges writes each of MemoryRanges back into the memory, in the order they were captured. This errs
+// without writing any range if one is out of the bounds of the memory, e.g. as it shrank to that of a new instance.
+func (snap *Snapshot) RestoreMemoryRanges(mem *MemoryInstance) error {
+	if len(snap.MemoryRanges) == 0 {
+		return nil
+	}
+	if mem == nil {
+		return errors.New("cannot restore memory ranges without a memory")
+	}
+	for i, r := range snap.MemoryRanges {
+		if !mem.hasSize(r.Offset, uint32(len(r.Data))) {
+			return fmt.Errorf("memory range[%d] [%d, %d) is out of bounds of memory size %d",
+				i, r.Offset, uint64(r.Offset)+uint64(len(r.Data)), len(mem.Buffer))
+		}
+	}
+	for _, r := range snap.MemoryRanges {
+		copy(mem.Buffer[r.Offset:], r.Data)
+	}
+	return nil
 }
 
 func (frame CallFrame) String() string {
@@ -0,0 +1,108 @@
+package wasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FrameNamer resolves the function index of a CallFrame to its name in the module's name section. The names are
+// indexed when it is built, so build it once per module and reuse it when formatting many snapshots, e.g. in a loop
+// that checkpoints often.
+//
+// Note: A nil FrameNamer, or one built from a module without a name section, formats frames numerically.
+type FrameNamer struct {
+	names map[Index]string
+}
+
+// NewFrameNamer indexes the function names in the name section of the module.
+func NewFrameNamer(m *Module) *FrameNamer {
+	if m.NameSection == nil {
+		return &FrameNamer{}
+	}
+	names := make(map[Index]string, len(m.NameSection.FunctionNames))
+	for _, na := range m.NameSection.FunctionNames {
+		names[na.Index] = na.Name
+	}
+	return &FrameNamer{names: names}
+}
+
+// FunctionName returns the name of the function at the index, or "" if it has none.
+func (n *FrameNamer) FunctionName(funcIdx Index) string {
+	if n == nil {
+		return ""
+	}
+	return n.names[funcIdx]
+}
+
+// FrameString is like CallFrame.String, except it includes the function name when known. Ex. "Fn 3 (fib)@12"
+func (n *FrameNamer) FrameString(frame CallFrame) string {
+	if name := n.FunctionName(frame.FunctionIdx); name != "" {
+		return fmt.Sprintf("Fn %d (%s)@%d", frame.FunctionIdx, name, frame.Pc)
+	}
+	return frame.String()
+}
+
+// Format returns the frames of the snapshot, outermost first, naming functions with the FrameNamer, which can be nil.
+// Ex. "Fn 0 (main)@5 -> Fn 3 (fib)@12"
+func (snap *Snapshot) Format(namer *FrameNamer) string {
+	if len(snap.Frames) == 0 {
+		return "no frames"
+	}
+	frames := make([]string, len(snap.Frames))
+	for i, frame := range snap.Frames {
+		frames[i] = namer.FrameString(frame)
+	}
+	return strings.Join(frames, " -> ")
+}
+
+// snapshotStringLimit is the count of frames, stack values and globals Snapshot.String includes of each, so that
+// logging a snapshot with a deep stack or many globals stays short.
+const snapshotStringLimit = 4
+
+// String returns a summary of the snapshot, bounded in length whatever its size: the counts of frames, stack values and
+// globals with the first few of each, the size of the memory and the last file descriptor. Use Verbose to include all.
+// Ex. "Call Frame: 2 [Fn 0@5 Fn 3@12], Stack: 6 [1 2 3 4 ...], Globals: 1 [i32(42)], Memory: 65536 bytes, LastFD: 3"
+func (snap *Snapshot) String() string {
+	return snap.format(snapshotStringLimit)
+}
+
+// Verbose is like String, except it includes every frame, stack value and global, so can be as large as the stack.
+func (snap *Snapshot) Verbose() string {
+	return snap.format(-1)
+}
+
+// format formats the snapshot, including as many as limit of its frames, stack values and globals, or all if negative.
+func (snap *Snapshot) format(limit int) string {
+	frames := formatList(len(snap.Frames), limit, func(i int) string { return snap.Frames[i].String() })
+	stack := formatList(len(snap.Stack), limit, func(i int) string { return fmt.Sprint(snap.Stack[i]) })
+	globals := formatList(len(snap.Globals), limit, func(i int) string {
+		g := snap.Globals[i]
+		if g.Type.ValType == ValueTypeV128 {
+			return fmt.Sprintf("%s(%d,%d)", ValueTypeName(g.Type.ValType), g.Val, g.ValHi)
+		}
+		return fmt.Sprintf("%s(%d)", ValueTypeName(g.Type.ValType), g.Val)
+	})
+	memory := "none"
+	if snap.Memory != nil {
+		memory = fmt.Sprintf("%d bytes", snap.MemorySize())
+	}
+	return fmt.Sprintf("Call Frame: %s, Stack: %s, Globals: %s, Memory: %s, LastFD: %d",
+		frames, stack, globals, memory, snap.LastFD)
+}
+
+// formatList returns the count n followed by as many as limit of the items, or all if limit is negative, and "..." if
+// any were left out. Ex. "6 [1 2 3 4 ...]"
+func formatList(n, limit int, item func(i int) string) string {
+	shown := n
+	if limit >= 0 && limit < n {
+		shown = limit
+	}
+	items := make([]string, 0, shown+1)
+	for i := 0; i < shown; i++ {
+		items = append(items, item(i))
+	}
+	if shown < n {
+		items = append(items, "...")
+	}
+	return fmt.Sprintf("%d [%s]", n, strings.Join(items, " "))
+}
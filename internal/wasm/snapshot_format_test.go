@@ -0,0 +1,113 @@
+package wasm
+
+import (
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+)
+
+func TestSnapshot_Format(t *testing.T) {
+	named := NewFrameNamer(&Module{
+		NameSection: &NameSection{FunctionNames: NameMap{{Index: 0, Name: "main"}, {Index: 3, Name: "fib"}}},
+	})
+	frames := []CallFrame{{Pc: 5, FunctionIdx: 0}, {Pc: 1, FunctionIdx: 2}, {Pc: 12, FunctionIdx: 3}}
+
+	tests := []struct {
+		name     string
+		namer    *FrameNamer
+		frames   []CallFrame
+		expected string
+	}{
+		{
+			name:     "names",
+			namer:    named,
+			frames:   frames,
+			expected: "Fn 0 (main)@5 -> Fn 2@1 -> Fn 3 (fib)@12",
+		},
+		{
+			name:     "no name section",
+			namer:    NewFrameNamer(&Module{}),
+			frames:   frames,
+			expected: "Fn 0@5 -> Fn 2@1 -> Fn 3@12",
+		},
+		{
+			name:     "nil namer",
+			frames:   frames,
+			expected: "Fn 0@5 -> Fn 2@1 -> Fn 3@12",
+		},
+		{
+			name:     "no frames",
+			namer:    named,
+			expected: "no frames",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			snapshot := &Snapshot{Frames: tc.frames}
+			require.Equal(t, tc.expected, snapshot.Format(tc.namer))
+		})
+	}
+}
+
+func TestSnapshot_String(t *testing.T) {
+	i32 := &GlobalType{ValType: ValueTypeI32}
+	v128 := &GlobalType{ValType: ValueTypeV128}
+	deep := &Snapshot{LastFD: 3, Memory: &MemoryInstance{Buffer: make([]byte, MemoryPageSize)}}
+	for i := 0; i < 10000; i++ {
+		deep.Frames = append(deep.Frames, CallFrame{FunctionIdx: 1, Pc: uint64(i)})
+		deep.Stack = append(deep.Stack, uint64(i))
+		deep.Globals = append(deep.Globals, &GlobalInstance{Type: i32, Val: uint64(i)})
+	}
+
+	tests := []struct {
+		name            string
+		snapshot        *Snapshot
+		expected        string
+		expectedVerbose string
+	}{
+		{
+			name:            "empty",
+			snapshot:        &Snapshot{},
+			expected:        "Call Frame: 0 [], Stack: 0 [], Globals: 0 [], Memory: none, LastFD: 0",
+			expectedVerbose: "Call Frame: 0 [], Stack: 0 [], Globals: 0 [], Memory: none, LastFD: 0",
+		},
+		{
+			name: "within the limit",
+			snapshot: &Snapshot{
+				Frames:  []CallFrame{{FunctionIdx: 0, Pc: 5}, {FunctionIdx: 3, Pc: 12}},
+				Stack:   []uint64{1, 2},
+				Globals: []*GlobalInstance{{Type: i32, Val: 42}, {Type: v128, Val: 1, ValHi: 2}},
+				LastFD:  3,
+			},
+			expected:        "Call Frame: 2 [Fn 0@5 Fn 3@12], Stack: 2 [1 2], Globals: 2 [i32(42) v128(1,2)], Memory: none, LastFD: 3",
+			expectedVerbose: "Call Frame: 2 [Fn 0@5 Fn 3@12], Stack: 2 [1 2], Globals: 2 [i32(42) v128(1,2)], Memory: none, LastFD: 3",
+		},
+		{
+			name: "past the limit",
+			snapshot: &Snapshot{
+				Stack:  []uint64{1, 2, 3, 4, 5, 6},
+				Memory: &MemoryInstance{Buffer: make([]byte, MemoryPageSize)},
+			},
+			expected:        "Call Frame: 0 [], Stack: 6 [1 2 3 4 ...], Globals: 0 [], Memory: 65536 bytes, LastFD: 0",
+			expectedVerbose: "Call Frame: 0 [], Stack: 6 [1 2 3 4 5 6], Globals: 0 [], Memory: 65536 bytes, LastFD: 0",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.snapshot.String())
+			require.Equal(t, tc.expectedVerbose, tc.snapshot.Verbose())
+		})
+	}
+
+	t.Run("bounded", func(t *testing.T) {
+		require.Equal(t, "Call Frame: 10000 [Fn 1@0 Fn 1@1 Fn 1@2 Fn 1@3 ...], Stack: 10000 [0 1 2 3 ...], "+
+			"Globals: 10000 [i32(0) i32(1) i32(2) i32(3) ...], Memory: 65536 bytes, LastFD: 3", deep.String())
+		require.True(t, len(deep.Verbose()) > 10000)
+	})
+}
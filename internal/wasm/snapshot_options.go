@@ -0,0 +1,59 @@
+package wasm
+
+import "context"
+
+// SnapshotOptions configures the snapshots the interpreter takes during a call. Set them with WithSnapshotOptions.
+//
+// Note: These replace the context.Context values with bare string keys, e.g. "snapshot", each noted on its field.
+// Those are still read when no SnapshotOptions are set, but are deprecated, as any package using the same strings
+// collides with them.
+type SnapshotOptions struct {
+	// Snapshot is where the engine takes snapshots, overwriting it each time. No snapshot is taken without it, except
+	// on trap per OnTrap. This was the value "snapshot".
+	Snapshot *Snapshot
+	// Always snapshots at each point of the Snapshot.Granularity, e.g. to trace execution. This was the value
+	// "always_snapshot" set to true.
+	Always bool
+	// Cooperative snapshots each time the guest executes a nop. This was the value "always_snapshot" set to false.
+	Cooperative bool
+	// TrapAfter stops the call with a SnapshotError after each snapshot, so that it can be resumed later. This was the
+	// value "trap_after_snapshot".
+	TrapAfter bool
+	// Export writes each snapshot to the file "snapshot.bin" in the working directory. A snapshot that can't be written,
+	// e.g. as it can't be encoded, stops the call with a SnapshotError holding why. See Snapshot.Err. This was the value
+	// "export_snapshot".
+	Export bool
+	// OnTrap snapshots when the guest traps, into the TrapSnapshotError returned instead of the trap. This was the
+	// value "snapshot_on_trap".
+	OnTrap bool
+	// Coverage counts each operation executed, when set. This was the value "coverage".
+	Coverage *Coverage
+}
+
+// snapshotOptionsKey is the context.Context value key of SnapshotOptions. It is unexported, so it can't collide.
+type snapshotOptionsKey struct{}
+
+// WithSnapshotOptions returns a context.Context holding the given options, which win over any set on ctx before,
+// including via the deprecated string keys.
+func WithSnapshotOptions(ctx context.Context, opts SnapshotOptions) context.Context {
+	return context.WithValue(ctx, snapshotOptionsKey{}, opts)
+}
+
+// SnapshotOptionsFromContext returns the options set by WithSnapshotOptions, or else those set via the deprecated
+// string keys, which are zero when unset.
+func SnapshotOptionsFromContext(ctx context.Context) SnapshotOptions {
+	if opts, ok := ctx.Value(snapshotOptionsKey{}).(SnapshotOptions); ok {
+		return opts
+	}
+	opts := SnapshotOptions{
+		TrapAfter: ctx.Value("trap_after_snapshot") == true,
+		Export:    ctx.Value("export_snapshot") == true,
+		OnTrap:    ctx.Value("snapshot_on_trap") == true,
+	}
+	opts.Snapshot, _ = ctx.Value("snapshot").(*Snapshot)
+	opts.Coverage, _ = ctx.Value("coverage").(*Coverage)
+	if always, ok := ctx.Value("always_snapshot").(bool); ok {
+		opts.Always, opts.Cooperative = always, !always
+	}
+	return opts
+}
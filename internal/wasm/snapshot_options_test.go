@@ -0,0 +1,86 @@
+package wasm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+)
+
+func TestSnapshotOptionsFromContext(t *testing.T) {
+	snapshot, coverage := &Snapshot{}, &Coverage{}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		expected SnapshotOptions
+	}{
+		{
+			name: "none",
+			ctx:  testCtx,
+		},
+		{
+			name: "WithSnapshotOptions",
+			ctx: WithSnapshotOptions(testCtx, SnapshotOptions{
+				Snapshot: snapshot, Always: true, TrapAfter: true, Export: true, OnTrap: true, Coverage: coverage,
+			}),
+			expected: SnapshotOptions{
+				Snapshot: snapshot, Always: true, TrapAfter: true, Export: true, OnTrap: true, Coverage: coverage,
+			},
+		},
+		{
+			name: "string keys",
+			ctx: withValues(testCtx, map[string]interface{}{
+				"snapshot":            snapshot,
+				"always_snapshot":     true,
+				"trap_after_snapshot": true,
+				"export_snapshot":     true,
+				"snapshot_on_trap":    true,
+				"coverage":            coverage,
+			}),
+			expected: SnapshotOptions{
+				Snapshot: snapshot, Always: true, TrapAfter: true, Export: true, OnTrap: true, Coverage: coverage,
+			},
+		},
+		{
+			name:     "always_snapshot false is cooperative",
+			ctx:      withValues(testCtx, map[string]interface{}{"snapshot": snapshot, "always_snapshot": false}),
+			expected: SnapshotOptions{Snapshot: snapshot, Cooperative: true},
+		},
+		{
+			name: "string keys of other types are ignored",
+			ctx: withValues(testCtx, map[string]interface{}{
+				"snapshot":            "other",
+				"always_snapshot":     "other",
+				"trap_after_snapshot": 1,
+				"coverage":            map[uint64]uint64{},
+			}),
+		},
+		{
+			name: "WithSnapshotOptions wins over string keys",
+			ctx: WithSnapshotOptions(withValues(testCtx, map[string]interface{}{
+				"snapshot":            &Snapshot{},
+				"always_snapshot":     true,
+				"trap_after_snapshot": true,
+			}), SnapshotOptions{Snapshot: snapshot, Cooperative: true}),
+			expected: SnapshotOptions{Snapshot: snapshot, Cooperative: true},
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			opts := SnapshotOptionsFromContext(tc.ctx)
+			require.Equal(t, tc.expected, opts)
+			require.Equal(t, opts.Snapshot != nil || opts.OnTrap, SnapshotConfigured(tc.ctx))
+		})
+	}
+}
+
+func withValues(ctx context.Context, values map[string]interface{}) context.Context {
+	for k, v := range values {
+		ctx = context.WithValue(ctx, k, v)
+	}
+	return ctx
+}
@@ -0,0 +1,79 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// PageHash returns a fast, non-cryptographic 64-bit hash of the page. It reads eight bytes at a time, so hashing a page
+// of MemoryPageSize bytes costs about as much as reading it once.
+//
+// Note: This is not collision resistant against input chosen to collide, such as memory written by an untrusted guest
+// that knows the hash. Don't use it where that matters.
+func PageHash(page []byte) uint64 {
+	h := uint64(len(page)) * hashPrime1
+	for ; len(page) >= 8; page = page[8:] {
+		h = hashRound(h, binary.LittleEndian.Uint64(page))
+	}
+	if len(page) > 0 {
+		var tail [8]byte
+		copy(tail[:], page)
+		h = hashRound(h, binary.LittleEndian.Uint64(tail[:]))
+	}
+	// Avalanche, so that each input bit affects each output bit.
+	h ^= h >> 33
+	h *= hashPrime2
+	h ^= h >> 29
+	return h
+}
+
+const (
+	hashPrime1 = 0x9e3779b185ebca87
+	hashPrime2 = 0xc2b2ae3d27d4eb4f
+)
+
+func hashRound(h, v uint64) uint64 {
+	return bits.RotateLeft64(h^(v*hashPrime2), 31) * hashPrime1
+}
+
+// PageHashes returns the PageHash of each page, of MemoryPageSize bytes, of buf. A trailing partial page is hashed as a
+// page.
+func PageHashes(buf []byte) []uint64 {
+	ret := make([]uint64, 0, (len(buf)+int(MemoryPageSize)-1)/int(MemoryPageSize))
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > int(MemoryPageSize) {
+			n = int(MemoryPageSize)
+		}
+		ret = append(ret, PageHash(buf[:n]))
+		buf = buf[n:]
+	}
+	return ret
+}
+
+// PageTracker finds the memory pages of a snapshot that changed since the previous one passed to Changed. It only
+// keeps the PageHash of each page, not a copy of the memory, so periodic delta exports read each snapshot's memory once
+// instead of comparing it byte by byte to the previous one.
+//
+// Note: A page whose hash is the same as before is considered unchanged. See PageHash for when that isn't safe.
+type PageTracker struct {
+	hashes []uint64
+}
+
+// Changed returns the index of each memory page of the snapshot that differs from the snapshot previously passed, in
+// ascending order, and records the snapshot's pages for the next call. All pages are changed on the first call, and
+// pages past the end of the previous memory are changed when it grew.
+func (t *PageTracker) Changed(snap *Snapshot) (changed []uint32) {
+	var buf []byte
+	if snap.Memory != nil {
+		buf = snap.Memory.Buffer
+	}
+	hashes := PageHashes(buf)
+	for i, h := range hashes {
+		if i >= len(t.hashes) || t.hashes[i] != h {
+			changed = append(changed, uint32(i))
+		}
+	}
+	t.hashes = hashes
+	return
+}
@@ -0,0 +1,96 @@
+package wasm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+)
+
+func TestPageHash(t *testing.T) {
+	page := make([]byte, MemoryPageSize)
+	zero := PageHash(page)
+
+	page[MemoryPageSize-1] = 1
+	require.NotEqual(t, zero, PageHash(page))
+
+	// Trailing bytes that aren't a whole word are hashed, as is the length.
+	require.NotEqual(t, PageHash([]byte{1, 2, 3}), PageHash([]byte{1, 2, 4}))
+	require.NotEqual(t, PageHash([]byte{0}), PageHash([]byte{0, 0}))
+}
+
+func TestPageHashes(t *testing.T) {
+	buf := make([]byte, 2*MemoryPageSize+3)
+	buf[MemoryPageSize] = 1
+
+	hashes := PageHashes(buf)
+	require.Equal(t, []uint64{
+		PageHash(buf[:MemoryPageSize]),
+		PageHash(buf[MemoryPageSize : 2*MemoryPageSize]),
+		PageHash(buf[2*MemoryPageSize:]),
+	}, hashes)
+	require.Equal(t, 0, len(PageHashes(nil)))
+}
+
+func TestPageTracker_Changed(t *testing.T) {
+	snap := &Snapshot{Memory: &MemoryInstance{Buffer: make([]byte, 3*MemoryPageSize)}}
+	tracker := &PageTracker{}
+
+	require.Equal(t, []uint32{0, 1, 2}, tracker.Changed(snap))
+	require.Nil(t, tracker.Changed(snap))
+
+	snap.Memory.Buffer[2*MemoryPageSize+7] = 1
+	require.Equal(t, []uint32{2}, tracker.Changed(snap))
+
+	// Grown pages are changed, even if zero.
+	snap.Memory.Buffer = append(snap.Memory.Buffer, make([]byte, MemoryPageSize)...)
+	snap.Memory.Buffer[5] = 1
+	require.Equal(t, []uint32{0, 3}, tracker.Changed(snap))
+
+	require.Nil(t, tracker.Changed(&Snapshot{}))
+}
+
+// benchmarkMemorySize is large enough for the comparison to dominate, but small enough to run quickly.
+const benchmarkMemorySize = 256 * int(MemoryPageSize)
+
+// BenchmarkPageTracker_Changed compares tracking page hashes to comparing each page to a copy of the previous memory,
+// for a snapshot where one page changes each time.
+func BenchmarkPageTracker_Changed(b *testing.B) {
+	b.Run("hash", func(b *testing.B) {
+		snap := &Snapshot{Memory: &MemoryInstance{Buffer: make([]byte, benchmarkMemorySize)}}
+		tracker := &PageTracker{}
+		tracker.Changed(snap)
+
+		b.SetBytes(int64(benchmarkMemorySize))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			snap.Memory.Buffer[i%benchmarkMemorySize]++
+			if len(tracker.Changed(snap)) != 1 {
+				b.Fatal("expected one changed page")
+			}
+		}
+	})
+	b.Run("copy", func(b *testing.B) {
+		buf := make([]byte, benchmarkMemorySize)
+		prev := make([]byte, benchmarkMemorySize)
+
+		b.SetBytes(int64(benchmarkMemorySize))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			buf[i%benchmarkMemorySize]++
+			var changed []uint32
+			for offset := 0; offset < len(buf); offset += int(MemoryPageSize) {
+				end := offset + int(MemoryPageSize)
+				if !bytes.Equal(buf[offset:end], prev[offset:end]) {
+					changed = append(changed, uint32(offset/int(MemoryPageSize)))
+				}
+			}
+			copy(prev, buf)
+			if len(changed) != 1 {
+				b.Fatal("expected one changed page")
+			}
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// FunctionIndexMap maps function indices of the module a snapshot was taken in to those of the module it is resumed
+// in. This allows resuming after minor edits to the module, e.g. adding a host import shifts the index of each defined
+// function, as imported functions precede them in the function index namespace.
+//
+// See Snapshot.RemapFunctions
+type FunctionIndexMap map[Index]Index
+
+// FunctionIndexMapByName returns a FunctionIndexMap matching the functions of both modules by the names in their name
+// section. Functions without a name, or whose name isn't in the other module, are left out.
+func FunctionIndexMapByName(from, to *Module) (FunctionIndexMap, error) {
+	if from.NameSection == nil || to.NameSection == nil {
+		return nil, errors.New("both modules need a name section to match functions by name")
+	}
+	toIndices := make(map[string]Index, len(to.NameSection.FunctionNames))
+	for _, na := range to.NameSection.FunctionNames {
+		toIndices[na.Name] = na.Index
+	}
+	ret := make(FunctionIndexMap, len(from.NameSection.FunctionNames))
+	for _, na := range from.NameSection.FunctionNames {
+		if idx, ok := toIndices[na.Name]; ok {
+			ret[na.Index] = idx
+		}
+	}
+	return ret, nil
+}
+
+// RemapFunctions rewrites the function index of each frame in the snapshot, taken in the module `from`, to the index in
+// the module `to` per the mapping. This errs without modifying the snapshot if a frame's function isn't mapped, or is
+// mapped to an imported function, which has no body to resume in, or to a function with a different signature.
+//
+// Note: The pc of each frame is kept, so the body of each function in a frame must be unchanged.
+func (snap *Snapshot) RemapFunctions(from, to *Module, mapping FunctionIndexMap) error {
+	importCount := to.ImportFuncCount()
+	frames := make([]CallFrame, len(snap.Frames))
+	for i, frame := range snap.Frames {
+		idx, ok := mapping[frame.FunctionIdx]
+		if !ok {
+			return fmt.Errorf("snapshot frame[%d] function[%d] is not mapped", i, frame.FunctionIdx)
+		}
+		fromType := from.TypeOfFunction(frame.FunctionIdx)
+		if fromType == nil {
+			return fmt.Errorf("snapshot frame[%d] function[%d] is not in the original module", i, frame.FunctionIdx)
+		}
+		if idx < importCount {
+			return fmt.Errorf("snapshot frame[%d] function[%d] is mapped to function[%d], which is imported",
+				i, frame.FunctionIdx, idx)
+		}
+		toType := to.TypeOfFunction(idx)
+		if toType == nil {
+			return fmt.Errorf("snapshot frame[%d] function[%d] is mapped to function[%d], which is not in the module",
+				i, frame.FunctionIdx, idx)
+		}
+		if !fromType.EqualsSignature(toType.Params, toType.Results) {
+			return fmt.Errorf("snapshot frame[%d] function[%d] has signature %s, but function[%d] it is mapped to has %s",
+				i, frame.FunctionIdx, fromType, idx, toType)
+		}
+		frames[i] = NewCallFrame(idx, frame.Pc)
+	}
+	snap.Frames = frames
+	return nil
+}
@@ -0,0 +1,86 @@
+package wasm
+
+import (
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+)
+
+func TestSnapshot_RemapFunctions(t *testing.T) {
+	i32 := ValueTypeI32
+	types := []*FunctionType{{}, {Params: []ValueType{i32}, Results: []ValueType{i32}}}
+	// from has two functions: "run" calls "inc".
+	from := &Module{
+		TypeSection:     types,
+		FunctionSection: []Index{0, 1},
+		NameSection:     &NameSection{FunctionNames: NameMap{{Index: 0, Name: "run"}, {Index: 1, Name: "inc"}}},
+	}
+	// to adds a host import, which shifts the index of both functions, and a new function.
+	to := &Module{
+		TypeSection:     types,
+		ImportSection:   []*Import{{Type: ExternTypeFunc, Module: "env", Name: "log", DescFunc: 1}},
+		FunctionSection: []Index{0, 0, 1},
+		NameSection: &NameSection{FunctionNames: NameMap{
+			{Index: 0, Name: "log"}, {Index: 1, Name: "run"}, {Index: 2, Name: "new"}, {Index: 3, Name: "inc"},
+		}},
+	}
+
+	mapping, err := FunctionIndexMapByName(from, to)
+	require.NoError(t, err)
+	require.Equal(t, FunctionIndexMap{0: 1, 1: 3}, mapping)
+
+	t.Run("remaps", func(t *testing.T) {
+		snapshot := &Snapshot{Frames: []CallFrame{{Pc: 5, FunctionIdx: 0}, {Pc: 2, FunctionIdx: 1}}}
+		require.NoError(t, snapshot.RemapFunctions(from, to, mapping))
+		require.Equal(t, []CallFrame{{Pc: 5, FunctionIdx: 1}, {Pc: 2, FunctionIdx: 3}}, snapshot.Frames)
+	})
+
+	tests := []struct {
+		name        string
+		mapping     FunctionIndexMap
+		expectedErr string
+	}{
+		{
+			name:        "not mapped",
+			mapping:     FunctionIndexMap{0: 1},
+			expectedErr: "snapshot frame[1] function[1] is not mapped",
+		},
+		{
+			name:        "mapped out of range",
+			mapping:     FunctionIndexMap{0: 1, 1: 4},
+			expectedErr: "snapshot frame[1] function[1] is mapped to function[4], which is not in the module",
+		},
+		{
+			name:        "mapped to an import",
+			mapping:     FunctionIndexMap{0: 1, 1: 0}, // "log" has the same signature as "inc"
+			expectedErr: "snapshot frame[1] function[1] is mapped to function[0], which is imported",
+		},
+		{
+			name:        "different signature",
+			mapping:     FunctionIndexMap{0: 1, 1: 2},
+			expectedErr: "snapshot frame[1] function[1] has signature i32_i32, but function[2] it is mapped to has v_v",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			frames := []CallFrame{{Pc: 5, FunctionIdx: 0}, {Pc: 2, FunctionIdx: 1}}
+			snapshot := &Snapshot{Frames: frames}
+			require.EqualError(t, snapshot.RemapFunctions(from, to, tc.mapping), tc.expectedErr)
+			require.Equal(t, frames, snapshot.Frames) // unchanged
+		})
+	}
+
+	t.Run("not in the original module", func(t *testing.T) {
+		snapshot := &Snapshot{Frames: []CallFrame{{FunctionIdx: 2}}}
+		err := snapshot.RemapFunctions(from, to, FunctionIndexMap{2: 1})
+		require.EqualError(t, err, "snapshot frame[0] function[2] is not in the original module")
+	})
+}
+
+func TestFunctionIndexMapByName_NoNames(t *testing.T) {
+	_, err := FunctionIndexMapByName(&Module{}, &Module{NameSection: &NameSection{}})
+	require.EqualError(t, err, "both modules need a name section to match functions by name")
+}
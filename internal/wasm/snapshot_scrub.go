@@ -0,0 +1,53 @@
+package wasm
+
+import (
+	"context"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// HeapEnd returns the offset in memory of the end of the guest's heap, or false if it isn't known, e.g. as the
+// allocator isn't initialized yet. See Snapshot.ScrubHeapEnd
+//
+// Note: The memory from the offset to the end is zeroed in place, not only in the snapshot, so it must be memory the
+// allocator neither reads before writing, nor uses to track free blocks, e.g. the memory past the break of an
+// sbrk-style or bump allocator, which grows its heap into it before using it. Freed blocks within the heap aren't
+// zeroed, as their bytes, such as free list links, may still belong to the allocator.
+type HeapEnd func(ctx context.Context, mod api.Module) (offset uint32, ok bool)
+
+// HeapEndGlobal returns a HeapEnd which reads the end of the heap from the i32 global the module exports as name, e.g.
+// the break of its allocator. It returns false if the module exports no such global.
+func HeapEndGlobal(name string) HeapEnd {
+	return func(ctx context.Context, mod api.Module) (uint32, bool) {
+		g := mod.ExportedGlobal(name)
+		if g == nil || g.Type() != api.ValueTypeI32 {
+			return 0, false
+		}
+		return uint32(g.Get(ctx)), true
+	}
+}
+
+// ScrubMemory zeroes mem past the offset Snapshot.ScrubHeapEnd returns for the module, before the snapshot captures it.
+// It does nothing if ScrubHeapEnd isn't set, or the offset isn't known or is past the end of mem.
+func (snap *Snapshot) ScrubMemory(ctx context.Context, mod api.Module, mem *MemoryInstance) {
+	if snap.ScrubHeapEnd == nil || mem == nil {
+		return
+	}
+	offset, ok := snap.ScrubHeapEnd(ctx, mod)
+	if !ok {
+		return
+	}
+
+	mem.mux.Lock()
+	defer mem.mux.Unlock()
+	if uint64(offset) < uint64(len(mem.Buffer)) {
+		zeroBytes(mem.Buffer[offset:])
+	}
+}
+
+// zeroBytes sets each byte of b to zero.
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
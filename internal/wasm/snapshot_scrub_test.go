@@ -0,0 +1,67 @@
+package wasm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/internal/testing/require"
+)
+
+func TestHeapEndGlobal(t *testing.T) {
+	s, ns := newStore()
+
+	i32, i64 := ValueTypeI32, ValueTypeI64
+	m, err := s.Instantiate(testCtx, ns, &Module{
+		GlobalSection: []*Global{
+			{Type: &GlobalType{ValType: i32, Mutable: true}, Init: &ConstantExpression{Opcode: OpcodeI32Const, Data: []byte{0x80, 0x01}}},
+			{Type: &GlobalType{ValType: i64}, Init: &ConstantExpression{Opcode: OpcodeI64Const, Data: []byte{1}}},
+		},
+		ExportSection: []*Export{
+			{Type: ExternTypeGlobal, Name: "heap_end", Index: 0},
+			{Type: ExternTypeGlobal, Name: "i64", Index: 1},
+		},
+	}, t.Name(), nil, nil)
+	require.NoError(t, err)
+
+	offset, ok := HeapEndGlobal("heap_end")(testCtx, m)
+	require.True(t, ok)
+	require.Equal(t, uint32(128), offset)
+
+	_, ok = HeapEndGlobal("missing")(testCtx, m)
+	require.False(t, ok)
+
+	_, ok = HeapEndGlobal("i64")(testCtx, m)
+	require.False(t, ok)
+}
+
+func TestSnapshot_ScrubMemory(t *testing.T) {
+	heapEnd := func(offset uint32, ok bool) HeapEnd {
+		return func(context.Context, api.Module) (uint32, bool) {
+			return offset, ok
+		}
+	}
+
+	tests := []struct {
+		name     string
+		heapEnd  HeapEnd
+		expected []byte
+	}{
+		{name: "not set", expected: []byte{1, 2, 3, 4}},
+		{name: "unknown", heapEnd: heapEnd(1, false), expected: []byte{1, 2, 3, 4}},
+		{name: "within memory", heapEnd: heapEnd(2, true), expected: []byte{1, 2, 0, 0}},
+		{name: "at start", heapEnd: heapEnd(0, true), expected: []byte{0, 0, 0, 0}},
+		{name: "at end", heapEnd: heapEnd(4, true), expected: []byte{1, 2, 3, 4}},
+		{name: "past end", heapEnd: heapEnd(5, true), expected: []byte{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			mem := &MemoryInstance{Buffer: []byte{1, 2, 3, 4}}
+			(&Snapshot{ScrubHeapEnd: tc.heapEnd}).ScrubMemory(testCtx, nil, mem)
+			require.Equal(t, tc.expected, mem.Buffer)
+		})
+	}
+}
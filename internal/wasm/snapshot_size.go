@@ -0,0 +1,80 @@
+package wasm
+
+// EstimateSize returns the approximate count of bytes the snapshot takes once exported, without marshaling it. This
+// allows deciding whether to persist a snapshot, or how to compress it, before paying for the export.
+//
+// The estimate sums the varint encoding of each stack value, global, frame and counter, with a few bytes of overhead
+// per field, and the memory buffer, which dominates the size of most snapshots. The buffer starts at a page boundary
+// when AlignMemory is set.
+//
+// Note: The memory is exported whole, so zero pages count. NonZeroPageCount bounds the memory of a sparse encoding.
+// Note: Memory ranges and the fields set by the caller aren't exported, so aren't counted.
+func (snap *Snapshot) EstimateSize() int {
+	size := 2 // valid
+
+	if len(snap.Stack) > 0 {
+		size += fieldOverhead
+		for _, v := range snap.Stack {
+			size += uvarintSize(v)
+		}
+	}
+	if len(snap.StackTypes) > 0 {
+		size += fieldOverhead + len(snap.StackTypes) // each type is a one byte enum.
+	}
+
+	for _, g := range snap.Globals {
+		size += fieldOverhead + 2*fieldOverhead + uvarintSize(g.Val) + uvarintSize(g.ValHi)
+	}
+	for _, f := range snap.Frames {
+		size += fieldOverhead + 2*fieldOverhead + uvarintSize(f.Pc) + uvarintSize(uint64(f.FunctionIdx))
+	}
+
+	for _, v := range []uint64{
+		snap.Closed, snap.InstructionCount, snap.StdoutWritten, snap.StderrWritten, snap.StdinRead,
+		uint64(snap.Reason), uint64(snap.YieldTag),
+	} {
+		if v != 0 {
+			size += 1 + uvarintSize(v)
+		}
+	}
+	// Fields numbered from 16 take a two byte tag.
+	for _, v := range []uint64{snap.RandRead, uint64(snap.Walltime), uint64(snap.Nanotime), snap.Generation} {
+		if v != 0 {
+			size += 2 + uvarintSize(v)
+		}
+	}
+
+	for fd, entry := range snap.OpenedFiles {
+		size += 2 + 1 + 2*fieldOverhead + uvarintSize(uint64(fd)) + len(entry.Path)
+		if entry.File != nil { // opened by the guest, rather than preopened
+			size += 1 + uvarintSize(uint64(entry.Offset))
+		}
+	}
+
+	for _, s := range append(append([]string(nil), snap.Args...), snap.Environ...) {
+		size += 2 + uvarintSize(uint64(len(s))) + len(s)
+	}
+
+	if mem := snap.Memory; mem != nil {
+		size += fieldOverhead + 3*(1+uvarintSize(uint64(mem.Max))) // min, cap and max are at most max.
+		size += uvarintSize(uint64(len(mem.Buffer)))
+		size += uvarintSize(uint64(size)) // length prefix
+		// The buffer is aligned to a page, so that it can be mapped.
+		if rem := size % int(MemoryPageSize); snap.AlignMemory && snap.PageTransform == nil && rem != 0 {
+			size += int(MemoryPageSize) - rem
+		}
+		return size + len(mem.Buffer)
+	}
+	return uvarintSize(uint64(size)) + size // length prefix
+}
+
+// fieldOverhead is the approximate count of bytes of the tag and length that precede a field or nested message.
+const fieldOverhead = 2
+
+// uvarintSize returns the count of bytes of v encoded as a varint.
+func uvarintSize(v uint64) (n int) {
+	for n = 1; v >= 0x80; n++ {
+		v >>= 7
+	}
+	return
+}
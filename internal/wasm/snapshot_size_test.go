@@ -0,0 +1,43 @@
+package wasm
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+)
+
+func TestSnapshot_EstimateSize(t *testing.T) {
+	require.Equal(t, 3, (&Snapshot{Valid: true}).EstimateSize())
+
+	// The memory buffer dominates, and zero pages count as they are exported.
+	withMemory := &Snapshot{Valid: true, Memory: &MemoryInstance{Buffer: make([]byte, 3*MemoryPageSize), Min: 3, Max: 3}}
+	require.True(t, withMemory.EstimateSize() > 3*int(MemoryPageSize))
+
+	// Each arg and variable takes its length, and a tag and length prefix.
+	withArgs := &Snapshot{Valid: true, Args: []string{"wasi"}, Environ: []string{"a=b"}}
+	require.Equal(t, 3+(3+4)+(3+3), withArgs.EstimateSize())
+
+	// Larger values take more bytes, as they are varints.
+	small := &Snapshot{Stack: []uint64{1, 2, 3}}
+	large := &Snapshot{Stack: []uint64{math.MaxUint64, math.MaxUint64, math.MaxUint64}}
+	require.Equal(t, small.EstimateSize()+27, large.EstimateSize())
+}
+
+func TestUvarintSize(t *testing.T) {
+	tests := []struct {
+		v        uint64
+		expected int
+	}{
+		{v: 0, expected: 1},
+		{v: 0x7f, expected: 1},
+		{v: 0x80, expected: 2},
+		{v: math.MaxUint32, expected: 5},
+		{v: math.MaxUint64, expected: 10},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+		require.Equal(t, tc.expected, uvarintSize(tc.v), "%d", tc.v)
+	}
+}
@@ -0,0 +1,76 @@
+package wasm
+
+import "sync"
+
+// SnapshotStore holds the most recently put snapshots in memory by ID, such as a checkpoint ID, evicting the oldest
+// when full. This allows an embedder to keep the last few snapshots around while persisting them asynchronously.
+//
+// Note: This is safe for concurrent use.
+type SnapshotStore struct {
+	mux sync.Mutex
+
+	// capacity is the maximum count of snapshots held.
+	capacity int
+
+	// ids are the IDs of the held snapshots, oldest first.
+	ids []string
+
+	snapshots map[string]*Snapshot
+}
+
+// NewSnapshotStore returns a SnapshotStore that holds up to capacity snapshots. A capacity less than one is treated
+// as one.
+func NewSnapshotStore(capacity int) *SnapshotStore {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &SnapshotStore{capacity: capacity, snapshots: make(map[string]*Snapshot, capacity)}
+}
+
+// Put stores a clone of the snapshot under the given ID, so the caller can keep mutating its own. Putting an existing
+// ID replaces its snapshot, which then counts as the newest. Otherwise, the oldest snapshot is evicted when full.
+func (s *SnapshotStore) Put(id string, snapshot *Snapshot) {
+	clone := snapshot.Clone()
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.snapshots[id]; ok {
+		s.remove(id)
+	} else if len(s.ids) == s.capacity {
+		delete(s.snapshots, s.ids[0])
+		s.remove(s.ids[0])
+	}
+	s.ids = append(s.ids, id)
+	s.snapshots[id] = clone
+}
+
+// Get returns a clone of the snapshot stored under the given ID, or false if there is none. A clone is returned as
+// resuming from a snapshot mutates it, e.g. its memory becomes that of the module.
+func (s *SnapshotStore) Get(id string) (*Snapshot, bool) {
+	s.mux.Lock()
+	snapshot, ok := s.snapshots[id]
+	s.mux.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+	return snapshot.Clone(), true
+}
+
+// Len returns the count of snapshots held.
+func (s *SnapshotStore) Len() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.ids)
+}
+
+// remove removes the ID from ids, preserving the order of the others.
+func (s *SnapshotStore) remove(id string) {
+	for i, v := range s.ids {
+		if v == id {
+			s.ids = append(s.ids[:i], s.ids[i+1:]...)
+			return
+		}
+	}
+}
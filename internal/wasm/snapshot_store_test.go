@@ -0,0 +1,60 @@
+package wasm
+
+import (
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+)
+
+func TestSnapshotStore_PutGet(t *testing.T) {
+	s := NewSnapshotStore(2)
+
+	snapshot := &Snapshot{Valid: true, Stack: []uint64{1}}
+	s.Put("a", snapshot)
+
+	// Mutating the live snapshot after Put must not affect the stored one.
+	snapshot.Stack[0] = 2
+
+	got, ok := s.Get("a")
+	require.True(t, ok)
+	require.Equal(t, []uint64{1}, got.Stack)
+
+	// Mutating what Get returned must not affect the stored one either.
+	got.Stack[0] = 3
+	got, ok = s.Get("a")
+	require.True(t, ok)
+	require.Equal(t, []uint64{1}, got.Stack)
+
+	_, ok = s.Get("b")
+	require.False(t, ok)
+}
+
+func TestSnapshotStore_Evicts(t *testing.T) {
+	s := NewSnapshotStore(2)
+	s.Put("a", &Snapshot{Closed: 1})
+	s.Put("b", &Snapshot{Closed: 2})
+	s.Put("a", &Snapshot{Closed: 3}) // replacing makes "a" the newest
+	require.Equal(t, 2, s.Len())
+
+	s.Put("c", &Snapshot{Closed: 4})
+	require.Equal(t, 2, s.Len())
+
+	_, ok := s.Get("b")
+	require.False(t, ok)
+	a, ok := s.Get("a")
+	require.True(t, ok)
+	require.Equal(t, uint64(3), a.Closed)
+	c, ok := s.Get("c")
+	require.True(t, ok)
+	require.Equal(t, uint64(4), c.Closed)
+}
+
+func TestNewSnapshotStore_MinCapacity(t *testing.T) {
+	s := NewSnapshotStore(0)
+	s.Put("a", &Snapshot{})
+	s.Put("b", &Snapshot{})
+	require.Equal(t, 1, s.Len())
+
+	_, ok := s.Get("b")
+	require.True(t, ok)
+}
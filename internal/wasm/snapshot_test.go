@@ -0,0 +1,791 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/sys"
+	testfs "github.com/tetratelabs/wazero/internal/testing/fs"
+	"github.com/tetratelabs/wazero/internal/testing/require"
+	"github.com/tetratelabs/wazero/internal/wasmruntime"
+)
+
+func TestSnapshot_Clone(t *testing.T) {
+	snapshot := &Snapshot{
+		Valid:        true,
+		Stack:        []uint64{1, 2},
+		StackTypes:   []ValueType{ValueTypeI32, ValueTypeI64},
+		Globals:      []*GlobalInstance{{Type: &GlobalType{ValType: ValueTypeI32, Mutable: true}, Val: 3}},
+		Frames:       []CallFrame{{Pc: 4, FunctionIdx: 5}},
+		Memory:       &MemoryInstance{Buffer: []byte{6, 7}, Min: 1, Cap: 1, Max: 2},
+		LastFD:       3,
+		OpenedFiles:  map[uint32]*sys.FileEntry{3: {Path: "/"}},
+		Args:         []string{"wasi"},
+		Environ:      []string{"a=b"},
+		MemoryRanges: []*MemoryRange{{Offset: 1, Data: []byte{7}}},
+	}
+
+	clone := snapshot.Clone()
+	require.SnapshotEqual(t, snapshot, clone)
+
+	// Mutating the original must not affect the clone.
+	snapshot.Stack[0] = 10
+	snapshot.StackTypes[0] = ValueTypeF32
+	snapshot.Globals[0].Val = 30
+	snapshot.Frames[0].Pc = 40
+	snapshot.Memory.Buffer[0] = 60
+	snapshot.OpenedFiles[3].Path = "/tmp"
+	snapshot.Args[0] = "other"
+	snapshot.Environ[0] = "c=d"
+	snapshot.MemoryRanges[0].Data[0] = 70
+
+	require.Equal(t, []uint64{1, 2}, clone.Stack)
+	require.Equal(t, []ValueType{ValueTypeI32, ValueTypeI64}, clone.StackTypes)
+	require.Equal(t, uint64(3), clone.Globals[0].Val)
+	require.Equal(t, uint64(4), clone.Frames[0].Pc)
+	require.Equal(t, []byte{6, 7}, clone.Memory.Buffer)
+	require.Equal(t, "/", clone.OpenedFiles[3].Path)
+	require.Equal(t, []string{"wasi"}, clone.Args)
+	require.Equal(t, []string{"a=b"}, clone.Environ)
+	require.Equal(t, []byte{7}, clone.MemoryRanges[0].Data)
+}
+
+func TestSnapshot_Clone_Empty(t *testing.T) {
+	snapshot := &Snapshot{Mode: SnapshotModeHeap}
+	require.SnapshotEqual(t, snapshot, snapshot.Clone())
+}
+
+func TestSnapshot_MemorySize_NonZeroPageCount(t *testing.T) {
+	buffer := make([]byte, 3*MemoryPageSize+10)
+	buffer[MemoryPageSize] = 1     // second page
+	buffer[3*MemoryPageSize-1] = 1 // last byte of the third page
+	buffer[3*MemoryPageSize+9] = 1 // trailing partial page
+
+	tests := []struct {
+		name                 string
+		snapshot             *Snapshot
+		expectedSize         uint64
+		expectedNonZeroPages int
+	}{
+		{name: "no memory", snapshot: &Snapshot{}},
+		{name: "empty memory", snapshot: &Snapshot{Memory: &MemoryInstance{}}},
+		{
+			name:         "all zero",
+			snapshot:     &Snapshot{Memory: &MemoryInstance{Buffer: make([]byte, 2*MemoryPageSize)}},
+			expectedSize: uint64(2 * MemoryPageSize),
+		},
+		{
+			name:                 "partially populated",
+			snapshot:             &Snapshot{Memory: &MemoryInstance{Buffer: buffer}},
+			expectedSize:         uint64(len(buffer)),
+			expectedNonZeroPages: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedSize, tc.snapshot.MemorySize())
+			require.Equal(t, tc.expectedNonZeroPages, tc.snapshot.NonZeroPageCount())
+		})
+	}
+}
+
+func TestSnapshot_ExportedFunctionName(t *testing.T) {
+	module := &Module{
+		ExportSection: []*Export{
+			{Type: ExternTypeMemory, Name: "memory", Index: 1},
+			{Type: ExternTypeFunc, Name: "run", Index: 1},
+			{Type: ExternTypeFunc, Name: "alias", Index: 1},
+			{Type: ExternTypeFunc, Name: "helper", Index: 2},
+		},
+	}
+
+	tests := []struct {
+		name                      string
+		frames                    []CallFrame
+		expectedName, expectedErr string
+	}{
+		{name: "outermost frame", frames: []CallFrame{{FunctionIdx: 1}, {FunctionIdx: 2}}, expectedName: "alias"}, // first of "run" and "alias"
+		{name: "single frame", frames: []CallFrame{{FunctionIdx: 2}}, expectedName: "helper"},
+		{name: "not exported", frames: []CallFrame{{FunctionIdx: 0}, {FunctionIdx: 1}}, expectedErr: "snapshot function[0] is not exported"},
+		{name: "no frames", expectedErr: "snapshot has no frames"},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			name, err := (&Snapshot{Frames: tc.frames}).ExportedFunctionName(module)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedName, name)
+			}
+		})
+	}
+}
+
+func TestSnapshot_RestoreMemory(t *testing.T) {
+	page := func(b byte) []byte {
+		buf := make([]byte, MemoryPageSize)
+		buf[0] = b
+		return buf
+	}
+	grown := append(page(1), page(2)...)
+
+	tests := []struct {
+		name          string
+		snapshot      *Snapshot
+		expectedPages uint32
+		expectedCap   uint32
+	}{
+		{
+			name:          "same size",
+			snapshot:      &Snapshot{Memory: &MemoryInstance{Buffer: page(1), Min: 1, Cap: 1}},
+			expectedPages: 1,
+			expectedCap:   1,
+		},
+		{
+			name:          "grown",
+			snapshot:      &Snapshot{Memory: &MemoryInstance{Buffer: grown, Min: 2, Cap: 2}},
+			expectedPages: 2,
+			expectedCap:   2,
+		},
+		{
+			name:          "grown with spare capacity",
+			snapshot:      &Snapshot{Memory: &MemoryInstance{Buffer: grown, Min: 2, Cap: 3}},
+			expectedPages: 2,
+			expectedCap:   3,
+		},
+		{
+			name: "within MaxMemoryBytes",
+			snapshot: &Snapshot{
+				Memory:         &MemoryInstance{Buffer: grown, Min: 2, Cap: 3},
+				MaxMemoryBytes: 3 * uint64(MemoryPageSize),
+			},
+			expectedPages: 2,
+			expectedCap:   3,
+		},
+		{
+			name:          "capacity beyond max",
+			snapshot:      &Snapshot{Memory: &MemoryInstance{Buffer: grown, Min: 2, Cap: 5}},
+			expectedPages: 2,
+			expectedCap:   2,
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			mem := NewMemoryInstance(&Memory{Min: 1, Cap: 1, Max: 3})
+			require.NoError(t, tc.snapshot.RestoreMemory(mem))
+			require.Equal(t, tc.expectedPages, mem.PageSize(testCtx))
+			require.Equal(t, tc.expectedCap, mem.Cap)
+			require.Equal(t, tc.snapshot.Memory.Buffer, mem.Buffer)
+			require.Equal(t, uint32(1), mem.Min) // the declared minimum is kept
+			if len(mem.Buffer) > 0 {
+				// The memory is copied, so the snapshot, e.g. the buffer it was decoded into, can be reused.
+				require.NotSame(t, &tc.snapshot.Memory.Buffer[0], &mem.Buffer[0])
+			}
+		})
+	}
+}
+
+// TestSnapshot_RestoreMemory_ZeroesPastSize ensures memory grown after restoring a smaller snapshot is zero, as
+// memory.grow requires, rather than exposing what the module wrote there before.
+func TestSnapshot_RestoreMemory_ZeroesPastSize(t *testing.T) {
+	mem := NewMemoryInstance(&Memory{Min: 1, Cap: 1, Max: 3})
+	_, ok := mem.Grow(testCtx, 2)
+	require.True(t, ok)
+	for i := range mem.Buffer {
+		mem.Buffer[i] = 0xff
+	}
+
+	snapshot := &Snapshot{Memory: &MemoryInstance{Buffer: make([]byte, MemoryPageSize), Min: 1, Cap: 1}}
+	require.NoError(t, snapshot.RestoreMemory(mem))
+	require.Equal(t, uint32(3), mem.Cap) // the allocation is kept.
+
+	_, ok = mem.Grow(testCtx, 2)
+	require.True(t, ok)
+	require.Equal(t, make([]byte, 3*MemoryPageSize), mem.Buffer)
+}
+
+func TestSnapshot_RestoreMemory_ShareMemory(t *testing.T) {
+	buffer := make([]byte, 2*MemoryPageSize)
+	snapshot := &Snapshot{Memory: &MemoryInstance{Buffer: buffer, Min: 2, Cap: 3}, ShareMemory: true}
+
+	mem := NewMemoryInstance(&Memory{Min: 1, Cap: 1, Max: 3})
+	require.NoError(t, snapshot.RestoreMemory(mem))
+	require.Equal(t, uint32(2), mem.PageSize(testCtx))
+	require.Equal(t, uint32(2), mem.Cap)
+
+	// Writes reach the snapshot's buffer, as it is adopted rather than copied.
+	require.True(t, mem.WriteByte(testCtx, 1, 1))
+	require.Equal(t, byte(1), buffer[1])
+
+	// Growing copies the buffer, as the capacity is that of the buffer.
+	_, ok := mem.Grow(testCtx, 1)
+	require.True(t, ok)
+	require.True(t, mem.WriteByte(testCtx, 2, 2))
+	require.Equal(t, byte(0), buffer[2])
+}
+
+func TestSnapshot_RestoreMemory_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		snapshot    *Snapshot
+		mem         *MemoryInstance
+		expectedErr string
+	}{
+		{
+			name:        "snapshot without memory",
+			snapshot:    &Snapshot{},
+			mem:         &MemoryInstance{},
+			expectedErr: "snapshot and module disagree on whether there is a memory",
+		},
+		{
+			name:        "module without memory",
+			snapshot:    &Snapshot{Memory: &MemoryInstance{}},
+			expectedErr: "snapshot and module disagree on whether there is a memory",
+		},
+		{
+			name:        "snapshot memory shared",
+			snapshot:    &Snapshot{Memory: &MemoryInstance{Shared: true}},
+			mem:         &MemoryInstance{Shared: true},
+			expectedErr: "snapshot of a shared memory is not supported",
+		},
+		{
+			name:        "module memory shared",
+			snapshot:    &Snapshot{Memory: &MemoryInstance{}},
+			mem:         &MemoryInstance{Shared: true},
+			expectedErr: "snapshot of a shared memory is not supported",
+		},
+		{
+			name:        "size disagrees with buffer",
+			snapshot:    &Snapshot{Memory: &MemoryInstance{Buffer: []byte{1}, Min: 1}},
+			mem:         &MemoryInstance{Max: 1},
+			expectedErr: "snapshot memory has 1 pages, but 1 bytes",
+		},
+		{
+			name:        "exceeds max",
+			snapshot:    &Snapshot{Memory: &MemoryInstance{Buffer: make([]byte, 2*MemoryPageSize), Min: 2}},
+			mem:         &MemoryInstance{Max: 1},
+			expectedErr: "snapshot memory has 2 pages, exceeding the module's max of 1",
+		},
+		{
+			name:        "below min",
+			snapshot:    &Snapshot{Memory: &MemoryInstance{Buffer: []byte{}}},
+			mem:         &MemoryInstance{Min: 1, Max: 1},
+			expectedErr: "snapshot memory has 0 pages, below the module's min of 1",
+		},
+		{
+			name:        "exceeds capacity",
+			snapshot:    &Snapshot{Memory: &MemoryInstance{Buffer: make([]byte, 2*MemoryPageSize), Min: 2, Cap: 1}},
+			mem:         &MemoryInstance{Max: 2},
+			expectedErr: "snapshot memory has 2 pages, exceeding its capacity of 1",
+		},
+		{
+			name: "size exceeds MaxMemoryBytes",
+			snapshot: &Snapshot{
+				Memory:         &MemoryInstance{Buffer: make([]byte, 2*MemoryPageSize), Min: 2, Cap: 2},
+				MaxMemoryBytes: uint64(MemoryPageSize),
+			},
+			mem:         &MemoryInstance{Max: 2},
+			expectedErr: "snapshot memory declares 131072 bytes, exceeding the max of 65536",
+		},
+		{
+			name: "capacity exceeds MaxMemoryBytes",
+			snapshot: &Snapshot{
+				// A small buffer declaring the capacity of the largest memory, which is 4 GiB.
+				Memory:         &MemoryInstance{Buffer: make([]byte, MemoryPageSize), Min: 1, Cap: MemoryLimitPages},
+				MaxMemoryBytes: 2 * uint64(MemoryPageSize),
+			},
+			mem:         &MemoryInstance{Max: MemoryLimitPages},
+			expectedErr: "snapshot memory declares 4294967296 bytes, exceeding the max of 131072",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			require.EqualError(t, tc.snapshot.RestoreMemory(tc.mem), tc.expectedErr)
+		})
+	}
+}
+
+func TestNewCallFrame(t *testing.T) {
+	require.Equal(t, CallFrame{Pc: 2, FunctionIdx: 1}, NewCallFrame(1, 2))
+}
+
+func TestCallFrame_Validate(t *testing.T) {
+	require.NoError(t, NewCallFrame(MaximumFunctionIndex-1, 0).Validate())
+	require.EqualError(t, NewCallFrame(MaximumFunctionIndex, 0).Validate(),
+		"has function index 134217728, past the maximum of 134217727")
+}
+
+func TestCallFrame_ValidateFunctionIndex(t *testing.T) {
+	require.NoError(t, NewCallFrame(1, 0).ValidateFunctionIndex(2))
+	require.EqualError(t, NewCallFrame(2, 0).ValidateFunctionIndex(2), "has function index 2, but there are 2 functions")
+}
+
+func TestCallFrame_ValidatePc(t *testing.T) {
+	// The operations at even pcs are calls.
+	isCall := func(pc uint64) bool { return pc%2 == 0 }
+
+	tests := []struct {
+		name        string
+		frame       CallFrame
+		top         bool
+		expectedErr string
+	}{
+		{
+			name:  "top at an operation",
+			frame: NewCallFrame(1, 1),
+			top:   true,
+		},
+		{
+			name:  "top at the end",
+			frame: NewCallFrame(1, 3),
+			top:   true,
+		},
+		{
+			name:        "top past the end",
+			frame:       NewCallFrame(1, 4),
+			top:         true,
+			expectedErr: "has pc 4, past the 3 operations of function[1]",
+		},
+		{
+			name:  "caller at a call",
+			frame: NewCallFrame(1, 2),
+		},
+		{
+			name:        "caller not at a call",
+			frame:       NewCallFrame(1, 1),
+			expectedErr: "has pc 1, which is not a call in function[1]",
+		},
+		{
+			name:        "caller at the end",
+			frame:       NewCallFrame(1, 3),
+			expectedErr: "has pc 3, which is not a call in function[1]",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.frame.ValidatePc(3, tc.top, isCall)
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestSnapshot_Validate(t *testing.T) {
+	s, ns := newStore()
+
+	i32 := ValueTypeI32
+	m, err := s.Instantiate(testCtx, ns, &Module{
+		TypeSection:     []*FunctionType{{}},
+		FunctionSection: []Index{0},
+		CodeSection:     []*Code{{Body: []byte{OpcodeEnd}}},
+		GlobalSection: []*Global{
+			{Type: &GlobalType{ValType: i32, Mutable: true}, Init: &ConstantExpression{Opcode: OpcodeI32Const, Data: []byte{1}}},
+		},
+		MemorySection: &Memory{Min: 1, Cap: 1, Max: 2},
+	}, t.Name(), sys.DefaultContext(testfs.FS{"foo": &testfs.File{}}), nil)
+	require.NoError(t, err)
+	module := m.module
+
+	valid := &Snapshot{
+		Valid:       true,
+		Frames:      []CallFrame{{FunctionIdx: 0}},
+		Globals:     []*GlobalInstance{{Type: &GlobalType{ValType: i32, Mutable: true}, Val: 42}},
+		Memory:      &MemoryInstance{Buffer: make([]byte, MemoryPageSize), Min: 1, Cap: 1, Max: 2},
+		OpenedFiles: map[uint32]*sys.FileEntry{3: {Path: "/"}, 4: {Path: "/foo", File: &testfs.File{}}},
+	}
+	require.NoError(t, valid.Validate(module))
+
+	invalid := &Snapshot{
+		Valid:       true,
+		Frames:      []CallFrame{{FunctionIdx: 0}, {FunctionIdx: 1}},
+		Memory:      &MemoryInstance{Buffer: make([]byte, 3*MemoryPageSize), Min: 3, Cap: 3, Max: 2},
+		OpenedFiles: map[uint32]*sys.FileEntry{4: {Path: "/bar", File: &testfs.File{}}},
+	}
+	err = invalid.Validate(module)
+	require.EqualError(t, err, "invalid snapshot: "+
+		"snapshot frame[1] has function index 1, but there are 1 functions; "+
+		"snapshot has 0 globals, but module has 1; "+
+		"snapshot memory has 3 pages, exceeding its max of 2; "+
+		"snapshot memory has 3 pages, exceeding the module's max of 2; "+
+		"fd 4 (/bar): open bar: file does not exist")
+	require.Equal(t, 5, len(err.(*SnapshotValidationError).Errs))
+}
+
+func TestSnapshot_Restore(t *testing.T) {
+	i32 := ValueTypeI32
+	instantiate := func(t *testing.T, stdin io.Reader) *CallContext {
+		s, ns := newStore()
+		sysCtx, err := sys.NewContext(0, nil, nil, stdin, nil, nil, nil, nil, 0, nil, 0, nil, testfs.FS{"foo": &testfs.File{}})
+		require.NoError(t, err)
+		m, err := s.Instantiate(testCtx, ns, &Module{
+			GlobalSection: []*Global{
+				{Type: &GlobalType{ValType: i32, Mutable: true}, Init: &ConstantExpression{Opcode: OpcodeI32Const, Data: []byte{1}}},
+			},
+			MemorySection: &Memory{Min: 1, Cap: 1, Max: 2},
+		}, t.Name(), sysCtx, nil)
+		require.NoError(t, err)
+		return m
+	}
+
+	buffer := make([]byte, MemoryPageSize)
+	buffer[0] = 0xff
+	snapshot := &Snapshot{
+		Valid:       true,
+		Globals:     []*GlobalInstance{{Type: &GlobalType{ValType: i32, Mutable: true}, Val: 42}},
+		Memory:      &MemoryInstance{Buffer: buffer, Min: 1, Cap: 1, Max: 2},
+		LastFD:      4,
+		OpenedFiles: map[uint32]*sys.FileEntry{3: {Path: "/"}, 4: {Path: "/foo", File: &testfs.File{}}},
+	}
+
+	t.Run("restores", func(t *testing.T) {
+		m := instantiate(t, nil)
+		require.NoError(t, snapshot.Restore(m.module))
+
+		require.Equal(t, uint64(42), m.module.Globals[0].Val)
+		require.Equal(t, byte(0xff), m.module.Memory.Buffer[0])
+		fsc := m.Sys.FS(testCtx)
+		require.Equal(t, uint32(4), fsc.GetLastFD())
+		_, ok := fsc.OpenedFile(testCtx, 4)
+		require.True(t, ok)
+		require.NoError(t, m.FailIfClosed())
+	})
+
+	t.Run("invalid leaves the module as is", func(t *testing.T) {
+		m := instantiate(t, nil)
+		invalid := snapshot.Clone()
+		invalid.Memory = &MemoryInstance{Buffer: make([]byte, 3*MemoryPageSize), Min: 3, Cap: 3, Max: 3}
+
+		err := invalid.Restore(m.module)
+		require.EqualError(t, err, "invalid snapshot: snapshot memory has 3 pages, exceeding the module's max of 2")
+		require.Equal(t, uint64(1), m.module.Globals[0].Val)
+		require.NoError(t, m.FailIfClosed())
+	})
+
+	t.Run("failure once restoring closes the module", func(t *testing.T) {
+		m := instantiate(t, bytes.NewBufferString("abc"))
+		unskippable := snapshot.Clone()
+		unskippable.StdinRead = 1
+
+		err := unskippable.Restore(m.module)
+		require.EqualError(t, err, "cannot skip 1 bytes of stdin read before the snapshot: *bytes.Buffer is not an io.Seeker")
+		require.Error(t, m.FailIfClosed())
+	})
+}
+
+func TestSnapshot_SetGlobal(t *testing.T) {
+	newGlobals := func() []*GlobalInstance {
+		return []*GlobalInstance{
+			{Type: &GlobalType{ValType: ValueTypeI32, Mutable: true}, Val: 1},
+			{Type: &GlobalType{ValType: ValueTypeI64, Mutable: true}, Val: 2},
+			{Type: &GlobalType{ValType: ValueTypeV128, Mutable: true}, Val: 3, ValHi: 4},
+			{Type: &GlobalType{ValType: ValueTypeI32}, Val: 5},
+			{Type: &GlobalType{ValType: ValueTypeFuncref, Mutable: true}},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		index       uint32
+		val, valHi  uint64
+		expectedErr string
+	}{
+		{name: "i32", index: 0, val: math.MaxUint32},
+		{name: "i64", index: 1, val: math.MaxUint64},
+		{name: "v128", index: 2, val: 5, valHi: 6},
+		{
+			name:        "out of range",
+			index:       5,
+			expectedErr: "snapshot has 5 globals, so no global[5]",
+		},
+		{
+			name:        "immutable",
+			index:       3,
+			val:         6,
+			expectedErr: "snapshot global[3] is immutable",
+		},
+		{
+			name:        "i32 exceeds 32 bits",
+			index:       0,
+			val:         math.MaxUint32 + 1,
+			expectedErr: "snapshot global[0] is i32, but value 0x100000000 exceeds 32 bits",
+		},
+		{
+			name:        "high bits",
+			index:       1,
+			valHi:       1,
+			expectedErr: "snapshot global[1] is i64, so has no high bits",
+		},
+		{
+			name:        "funcref",
+			index:       4,
+			val:         1,
+			expectedErr: "snapshot global[4] is funcref, which can't be set",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			// The snapshot shares its globals with the module, as when the engine takes it.
+			module := &ModuleInstance{Globals: newGlobals()}
+			snap := &Snapshot{Globals: module.Globals}
+
+			err := snap.SetGlobal(tc.index, tc.val, tc.valHi)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				require.Equal(t, newGlobals(), snap.Globals)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.val, snap.Globals[tc.index].Val)
+			require.Equal(t, tc.valHi, snap.Globals[tc.index].ValHi)
+			require.Equal(t, newGlobals(), module.Globals) // the module is unaffected until restored.
+
+			// Restoring observes the patched value.
+			require.NoError(t, snap.restoreHeap(module))
+			require.Equal(t, tc.val, module.Globals[tc.index].Val)
+			require.Equal(t, tc.valHi, module.Globals[tc.index].ValHi)
+		})
+	}
+}
+
+// offsetExternRefCodec encodes an externref as its distance to a base pointer, so that decoding with a different base
+// simulates resuming in another process.
+type offsetExternRefCodec struct {
+	base uintptr
+	err  error
+}
+
+// EncodeExternRef implements ExternRefCodec.EncodeExternRef
+func (c *offsetExternRefCodec) EncodeExternRef(ref uintptr) (uint64, error) {
+	return uint64(ref - c.base), c.err
+}
+
+// DecodeExternRef implements ExternRefCodec.DecodeExternRef
+func (c *offsetExternRefCodec) DecodeExternRef(encoded uint64) (uintptr, error) {
+	return c.base + uintptr(encoded), c.err
+}
+
+func TestSnapshot_ExternRefs(t *testing.T) {
+	i32, externref := &GlobalType{ValType: ValueTypeI32}, &GlobalType{ValType: ValueTypeExternref, Mutable: true}
+	newGlobals := func() []*GlobalInstance {
+		return []*GlobalInstance{{Type: i32, Val: 1000}, {Type: externref, Val: 1002}, {Type: externref}}
+	}
+
+	t.Run("no codec", func(t *testing.T) {
+		globals := newGlobals()
+		snapshot := &Snapshot{Globals: globals}
+		require.NoError(t, snapshot.EncodeExternRefs())
+		require.Equal(t, globals, snapshot.Globals)
+
+		decoded, err := snapshot.DecodedGlobals()
+		require.NoError(t, err)
+		require.Equal(t, globals, decoded)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		moduleGlobals := newGlobals()
+		snapshot := &Snapshot{Globals: moduleGlobals, ExternRefCodec: &offsetExternRefCodec{base: 1000}}
+		require.NoError(t, snapshot.EncodeExternRefs())
+		// Only the non-null externref is encoded, and the module's globals are untouched.
+		require.Equal(t, []*GlobalInstance{{Type: i32, Val: 1000}, {Type: externref, Val: 2}, {Type: externref}}, snapshot.Globals)
+		require.Equal(t, newGlobals(), moduleGlobals)
+
+		snapshot.ExternRefCodec = &offsetExternRefCodec{base: 5000}
+		decoded, err := snapshot.DecodedGlobals()
+		require.NoError(t, err)
+		require.Equal(t, []*GlobalInstance{{Type: i32, Val: 1000}, {Type: externref, Val: 5002}, {Type: externref}}, decoded)
+		require.Equal(t, uint64(2), snapshot.Globals[1].Val) // can be resumed again
+	})
+
+	tests := []struct {
+		name              string
+		codec             *offsetExternRefCodec
+		expectedEncodeErr string
+		expectedDecodeErr string
+	}{
+		{
+			name:              "error",
+			codec:             &offsetExternRefCodec{base: 1000, err: errors.New("unknown host object")},
+			expectedEncodeErr: "failed to encode externref global[1]: unknown host object",
+			expectedDecodeErr: "failed to decode externref global[1]: unknown host object",
+		},
+		{
+			name:              "encoded as null",
+			codec:             &offsetExternRefCodec{base: 1002},
+			expectedEncodeErr: "failed to encode externref global[1]: zero is the null reference",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			globals := newGlobals()
+			snapshot := &Snapshot{Globals: globals, ExternRefCodec: tc.codec}
+			require.EqualError(t, snapshot.EncodeExternRefs(), tc.expectedEncodeErr)
+			require.Equal(t, globals, snapshot.Globals) // unchanged
+
+			if tc.expectedDecodeErr != "" {
+				_, err := snapshot.DecodedGlobals()
+				require.EqualError(t, err, tc.expectedDecodeErr)
+			}
+		})
+	}
+}
+
+func TestSnapshot_MemoryRanges(t *testing.T) {
+	mem := &MemoryInstance{Buffer: make([]byte, MemoryPageSize), Min: 1, Cap: 1, Max: 1}
+	copy(mem.Buffer[8:], "hello")
+	copy(mem.Buffer[MemoryPageSize-2:], "!!")
+
+	snapshot := &Snapshot{}
+	require.NoError(t, snapshot.CaptureMemoryRange(mem, 8, 5))
+	require.NoError(t, snapshot.CaptureMemoryRange(mem, MemoryPageSize-2, 2))
+	require.NoError(t, snapshot.CaptureMemoryRange(mem, MemoryPageSize, 0))
+	require.Equal(t, []*MemoryRange{
+		{Offset: 8, Data: []byte("hello")},
+		{Offset: MemoryPageSize - 2, Data: []byte("!!")},
+		{Offset: MemoryPageSize, Data: []byte{}},
+	}, snapshot.MemoryRanges)
+
+	// The ranges are copies, so patching the memory doesn't affect them.
+	copy(mem.Buffer[8:], "jello")
+	require.Equal(t, []byte("hello"), snapshot.MemoryRanges[0].Data)
+
+	require.NoError(t, snapshot.RestoreMemoryRanges(mem))
+	require.Equal(t, []byte("hello"), mem.Buffer[8:13])
+}
+
+func TestSnapshot_MemoryRanges_Errors(t *testing.T) {
+	mem := &MemoryInstance{Buffer: make([]byte, MemoryPageSize), Min: 1, Cap: 1, Max: 1}
+
+	t.Run("capture", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			mem            *MemoryInstance
+			offset, length uint32
+			expectedErr    string
+		}{
+			{
+				name:        "no memory",
+				expectedErr: "cannot capture a memory range without a memory",
+			},
+			{
+				name:        "past the end",
+				mem:         mem,
+				offset:      MemoryPageSize - 1,
+				length:      2,
+				expectedErr: "memory range [65535, 65537) is out of bounds of memory size 65536",
+			},
+			{
+				name:        "overflow",
+				mem:         mem,
+				offset:      1,
+				length:      math.MaxUint32,
+				expectedErr: "memory range [1, 4294967296) is out of bounds of memory size 65536",
+			},
+		}
+
+		for _, tt := range tests {
+			tc := tt
+
+			t.Run(tc.name, func(t *testing.T) {
+				snapshot := &Snapshot{}
+				require.EqualError(t, snapshot.CaptureMemoryRange(tc.mem, tc.offset, tc.length), tc.expectedErr)
+				require.Nil(t, snapshot.MemoryRanges)
+			})
+		}
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		snapshot := &Snapshot{MemoryRanges: []*MemoryRange{
+			{Offset: 0, Data: []byte{1}},
+			{Offset: MemoryPageSize, Data: []byte{2}},
+		}}
+		require.EqualError(t, snapshot.RestoreMemoryRanges(nil), "cannot restore memory ranges without a memory")
+		require.EqualError(t, snapshot.RestoreMemoryRanges(mem),
+			"memory range[1] [65536, 65537) is out of bounds of memory size 65536")
+		require.Equal(t, byte(0), mem.Buffer[0]) // no range was written
+	})
+}
+
+func TestSnapshotError(t *testing.T) {
+	tests := []struct {
+		name          string
+		snapshot      *Snapshot
+		expected      *SnapshotError
+		expectedError string
+	}{
+		{
+			name:          "cooperative",
+			snapshot:      &Snapshot{Reason: SnapshotReasonCooperative},
+			expected:      &SnapshotError{Reason: SnapshotReasonCooperative, Resumable: true},
+			expectedError: "snapshot (cooperative)",
+		},
+		{
+			name:          "always",
+			snapshot:      &Snapshot{Reason: SnapshotReasonAlways},
+			expected:      &SnapshotError{Reason: SnapshotReasonAlways, Resumable: true},
+			expectedError: "snapshot (always)",
+		},
+		{
+			name:          "yield",
+			snapshot:      &Snapshot{Reason: SnapshotReasonYield, YieldTag: 3},
+			expected:      &SnapshotError{Reason: SnapshotReasonYield, Resumable: true, YieldTag: 3},
+			expectedError: "snapshot (yield)",
+		},
+		{
+			name:          "exited",
+			snapshot:      &Snapshot{Reason: SnapshotReasonAlways, Closed: 1},
+			expected:      &SnapshotError{Reason: SnapshotReasonAlways},
+			expectedError: "snapshot (always)",
+		},
+		{
+			name:          "not taken",
+			snapshot:      &Snapshot{Reason: SnapshotReasonAlways, Err: errors.New("unknown externref")},
+			expected:      &SnapshotError{Reason: SnapshotReasonAlways, Err: errors.New("unknown externref")},
+			expectedError: "snapshot (always): unknown externref",
+		},
+		{
+			name:          "unknown reason",
+			snapshot:      &Snapshot{Reason: 42},
+			expected:      &SnapshotError{Reason: 42, Resumable: true},
+			expectedError: "snapshot (unknown)",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewSnapshotError(tc.snapshot)
+			require.Equal(t, tc.expected, err)
+			require.EqualError(t, err, tc.expectedError)
+			require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+		})
+	}
+}
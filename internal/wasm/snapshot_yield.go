@@ -0,0 +1,35 @@
+package wasm
+
+import "fmt"
+
+const (
+	// YieldModuleName is the module name guests import Yield from.
+	YieldModuleName = "env"
+	// YieldFunctionName is the name guests import Yield as, with the signature (func (param i32) (result i32)).
+	YieldFunctionName = "yield"
+)
+
+// YieldSignal is the panic value of Yield, which the interpreter recovers to take the snapshot.
+type YieldSignal struct {
+	// Tag is the param of the call to Yield.
+	Tag uint32
+}
+
+// Error implements error, for when a YieldSignal isn't recovered as a yield, e.g. as no snapshot was configured.
+func (s *YieldSignal) Error() string {
+	return fmt.Sprintf("yield(%d) requires a snapshot to be configured, and must be called by a wasm function", s.Tag)
+}
+
+// Yield is a host function that turns the snapshot engine into a coroutine primitive. Export it as YieldFunctionName
+// in a host module named YieldModuleName, e.g. with wazero.ModuleBuilder ExportFunction.
+//
+// When the guest calls it, the interpreter takes a snapshot with the reason SnapshotReasonYield, and the call returns
+// a SnapshotError holding the tag, even if SnapshotOptions TrapAfter isn't set. The host can
+// then route on the tag, set Snapshot.ResumeValue and resume the snapshot, which returns that value to the guest as the
+// result of Yield, instead of calling it again.
+//
+// Note: This panics with a YieldSignal, so it only works when called by the guest via the interpreter, with a snapshot
+// configured in SnapshotOptions Snapshot. Otherwise, the call fails with that error.
+func Yield(tag uint32) uint32 {
+	panic(&YieldSignal{Tag: tag})
+}
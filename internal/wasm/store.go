@@ -85,6 +85,9 @@ type (
 		// ElementInstances holds the element instance, and each holds the references to either functions
 		// or external objects (unimplemented).
 		ElementInstances []ElementInstance
+
+		// callDepth is the count of frames of the calls in progress into this module. See CallContext.CallDepth
+		callDepth int64
 	}
 
 	// DataInstance holds bytes corresponding to the data segment in a module.
@@ -311,6 +314,12 @@ func (m *ModuleInstance) getExport(name string, et ExternType) (*ExportInstance,
 	return exp, nil
 }
 
+// CallDepthCounter returns the counter an engine keeps equal to the count of frames of the calls in progress into this
+// module, using atomics. See CallContext.CallDepth
+func (m *ModuleInstance) CallDepthCounter() *int64 {
+	return &m.callDepth
+}
+
 func NewStore(enabledFeatures Features, engine Engine) (*Store, *Namespace) {
 	ns := newNamespace()
 	return &Store{
@@ -408,6 +408,16 @@ func (e *mockModuleEngine) Call(ctx context.Context, callCtx *CallContext, f *Fu
 	return
 }
 
+// Resume implements the same method as documented on wasm.ModuleEngine.
+func (e *mockModuleEngine) Resume(ctx context.Context, callCtx *CallContext, f *FunctionInstance, _ *Snapshot) (results []uint64, err error) {
+	return e.Call(ctx, callCtx, f)
+}
+
+// ValidateSnapshot implements the same method as documented on wasm.ModuleEngine.
+func (e *mockModuleEngine) ValidateSnapshot(*Snapshot) error {
+	return nil
+}
+
 // Close implements the same method as documented on wasm.ModuleEngine.
 func (e *mockModuleEngine) Close(_ context.Context) {
 }
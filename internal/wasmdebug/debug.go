@@ -4,6 +4,7 @@
 package wasmdebug
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"runtime/debug"
@@ -127,6 +128,11 @@ func (s *stackTrace) FromRecovered(recovered interface{}) error {
 		return fmt.Errorf("wasm error: %w\nwasm stack trace:\n\t%s", wasmErr, stack)
 	}
 
+	// The engine interrupts execution with the context error when the context is done, so this wasn't recovered either.
+	if recovered == context.Canceled || recovered == context.DeadlineExceeded {
+		return fmt.Errorf("wasm error: %w\nwasm stack trace:\n\t%s", recovered.(error), stack)
+	}
+
 	// If we have a runtime.Error, something severe happened which should include the stack trace. This could be
 	// a nil pointer from wazero or a user-defined function from ModuleBuilder.
 	if runtimeErr, ok := recovered.(runtime.Error); ok {
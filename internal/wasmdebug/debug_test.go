@@ -1,6 +1,7 @@
 package wasmdebug
 
 import (
+	"context"
 	"errors"
 	"runtime"
 	"testing"
@@ -126,6 +127,17 @@ wasm stack trace:
 	x.y()`,
 			expectUnwrap: wasmruntime.ErrRuntimeCallStackOverflow,
 		},
+		{
+			name: "context.Canceled",
+			build: func(builder ErrorBuilder) error {
+				builder.AddFrame("x.y", nil, nil)
+				return builder.FromRecovered(context.Canceled)
+			},
+			expectedErr: `wasm error: context canceled
+wasm stack trace:
+	x.y()`,
+			expectUnwrap: context.Canceled,
+		},
 	}
 
 	for _, tt := range tests {
@@ -6,42 +6,104 @@ package wasmruntime
 var (
 	// ErrRuntimeCallStackOverflow indicates that there are too many function calls,
 	// and the Engine terminated the execution.
-	ErrRuntimeCallStackOverflow = New("callstack overflow")
+	ErrRuntimeCallStackOverflow = newError(KindStackOverflow, "callstack overflow")
 	// ErrRuntimeInvalidConversionToInteger indicates the Wasm function tries to
 	// convert NaN floating point value to integers during trunc variant instructions.
-	ErrRuntimeInvalidConversionToInteger = New("invalid conversion to integer")
+	ErrRuntimeInvalidConversionToInteger = newError(KindInvalidConversion, "invalid conversion to integer")
 	// ErrRuntimeIntegerOverflow indicates that an integer arithmetic resulted in
 	// overflow value. For example, when the program tried to truncate a float value
 	// which doesn't fit in the range of target integer.
-	ErrRuntimeIntegerOverflow = New("integer overflow")
+	ErrRuntimeIntegerOverflow = newError(KindIntegerOverflow, "integer overflow")
 	// ErrRuntimeIntegerDivideByZero indicates that an integer div or rem instructions
 	// was executed with 0 as the divisor.
-	ErrRuntimeIntegerDivideByZero = New("integer divide by zero")
+	ErrRuntimeIntegerDivideByZero = newError(KindDivByZero, "integer divide by zero")
 	// ErrRuntimeUnreachable means "unreachable" instruction was executed by the program.
-	ErrRuntimeUnreachable = New("unreachable")
+	ErrRuntimeUnreachable = newError(KindUnreachable, "unreachable")
 	// ErrRuntimeOutOfBoundsMemoryAccess indicates that the program tried to access the
 	// region beyond the linear memory.
-	ErrRuntimeOutOfBoundsMemoryAccess = New("out of bounds memory access")
+	ErrRuntimeOutOfBoundsMemoryAccess = newError(KindOutOfBoundsMemoryAccess, "out of bounds memory access")
 	// ErrRuntimeInvalidTableAccess means either offset to the table was out of bounds of table, or
 	// the target element in the table was uninitialized during call_indirect instruction.
-	ErrRuntimeInvalidTableAccess = New("invalid table access")
+	ErrRuntimeInvalidTableAccess = newError(KindInvalidTableAccess, "invalid table access")
 	// ErrRuntimeIndirectCallTypeMismatch indicates that the type check failed during call_indirect.
-	ErrRuntimeIndirectCallTypeMismatch = New("indirect call type mismatch")
+	ErrRuntimeIndirectCallTypeMismatch = newError(KindIndirectCallTypeMismatch, "indirect call type mismatch")
 
 	// Snapshot
-	ErrRuntimeSnapshot = New("snapshot")
+	ErrRuntimeSnapshot = newError(KindSnapshot, "snapshot")
 )
 
+// Kind classifies an Error, so that embedders can switch on it, e.g. to map traps to exit codes, instead of comparing
+// with each sentinel error. The values are stable: new kinds are only added at the end.
+type Kind uint8
+
+const (
+	// KindUnknown is the Kind of an Error created with New.
+	KindUnknown Kind = iota
+	// KindStackOverflow is the Kind of ErrRuntimeCallStackOverflow.
+	KindStackOverflow
+	// KindInvalidConversion is the Kind of ErrRuntimeInvalidConversionToInteger.
+	KindInvalidConversion
+	// KindIntegerOverflow is the Kind of ErrRuntimeIntegerOverflow.
+	KindIntegerOverflow
+	// KindDivByZero is the Kind of ErrRuntimeIntegerDivideByZero.
+	KindDivByZero
+	// KindUnreachable is the Kind of ErrRuntimeUnreachable.
+	KindUnreachable
+	// KindOutOfBoundsMemoryAccess is the Kind of ErrRuntimeOutOfBoundsMemoryAccess.
+	KindOutOfBoundsMemoryAccess
+	// KindInvalidTableAccess is the Kind of ErrRuntimeInvalidTableAccess.
+	KindInvalidTableAccess
+	// KindIndirectCallTypeMismatch is the Kind of ErrRuntimeIndirectCallTypeMismatch.
+	KindIndirectCallTypeMismatch
+	// KindSnapshot is the Kind of ErrRuntimeSnapshot, which isn't a trap, rather the execution stopping after a
+	// snapshot was taken.
+	KindSnapshot
+)
+
+var kindNames = [...]string{
+	KindUnknown:                  "unknown",
+	KindStackOverflow:            "stack overflow",
+	KindInvalidConversion:        "invalid conversion",
+	KindIntegerOverflow:          "integer overflow",
+	KindDivByZero:                "divide by zero",
+	KindUnreachable:              "unreachable",
+	KindOutOfBoundsMemoryAccess:  "out of bounds memory access",
+	KindInvalidTableAccess:       "invalid table access",
+	KindIndirectCallTypeMismatch: "indirect call type mismatch",
+	KindSnapshot:                 "snapshot",
+}
+
+// String returns a human-readable name of the Kind. Ex. "divide by zero"
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return kindNames[KindUnknown]
+}
+
 // Error is returned by a wasm.Engine during the execution of Wasm functions, and they indicate that the Wasm runtime
 // state is unrecoverable.
+//
+// Note: Errors are the sentinels above, so compare them with errors.Is or switch on Kind.
 type Error struct {
-	s string
+	kind Kind
+	s    string
 }
 
+// New returns an Error of KindUnknown.
 func New(text string) *Error {
 	return &Error{s: text}
 }
 
+func newError(kind Kind, text string) *Error {
+	return &Error{kind: kind, s: text}
+}
+
 func (e *Error) Error() string {
 	return e.s
 }
+
+// Kind classifies this error. Ex. ErrRuntimeIntegerDivideByZero.Kind() == KindDivByZero
+func (e *Error) Kind() Kind {
+	return e.kind
+}
@@ -0,0 +1,48 @@
+package wasmruntime
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+)
+
+func TestError_Kind(t *testing.T) {
+	tests := []struct {
+		err          *Error
+		expectedKind Kind
+		expectedName string
+	}{
+		{err: ErrRuntimeCallStackOverflow, expectedKind: KindStackOverflow, expectedName: "stack overflow"},
+		{err: ErrRuntimeInvalidConversionToInteger, expectedKind: KindInvalidConversion, expectedName: "invalid conversion"},
+		{err: ErrRuntimeIntegerOverflow, expectedKind: KindIntegerOverflow, expectedName: "integer overflow"},
+		{err: ErrRuntimeIntegerDivideByZero, expectedKind: KindDivByZero, expectedName: "divide by zero"},
+		{err: ErrRuntimeUnreachable, expectedKind: KindUnreachable, expectedName: "unreachable"},
+		{err: ErrRuntimeOutOfBoundsMemoryAccess, expectedKind: KindOutOfBoundsMemoryAccess, expectedName: "out of bounds memory access"},
+		{err: ErrRuntimeInvalidTableAccess, expectedKind: KindInvalidTableAccess, expectedName: "invalid table access"},
+		{err: ErrRuntimeIndirectCallTypeMismatch, expectedKind: KindIndirectCallTypeMismatch, expectedName: "indirect call type mismatch"},
+		{err: ErrRuntimeSnapshot, expectedKind: KindSnapshot, expectedName: "snapshot"},
+		{err: New("custom"), expectedKind: KindUnknown, expectedName: "unknown"},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			require.Equal(t, tc.expectedKind, tc.err.Kind())
+			require.Equal(t, tc.expectedName, tc.err.Kind().String())
+
+			// The sentinels still work with errors.Is, and the kind is reachable through wrapping.
+			wrapped := fmt.Errorf("wasm error: %w", tc.err)
+			require.True(t, errors.Is(wrapped, tc.err))
+			var e *Error
+			require.True(t, errors.As(wrapped, &e))
+			require.Equal(t, tc.expectedKind, e.Kind())
+		})
+	}
+}
+
+func TestKind_String_Unknown(t *testing.T) {
+	require.Equal(t, "unknown", Kind(255).String())
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/internal/leb128"
+	"github.com/tetratelabs/wazero/internal/u64"
+	"github.com/tetratelabs/wazero/internal/wasm"
+)
+
+// constExprOffset is the unresolvedIndex bodyOffset of a global index in a constant expression. This distinguishes it
+// from a function index in the same section, such as in the init of an element segment.
+const constExprOffset = math.MaxUint32
+
+func newConstExprParser(globalNamespace *indexNamespace) *constExprParser {
+	return &constExprParser{globalNamespace: globalNamespace}
+}
+
+type onConstExpr func(*wasm.ConstantExpression) tokenParser
+
+// constExprParser parses a folded constant expression, such as the offset of a data segment, and dispatches to
+// onConstExpr.
+//
+// Ex. `(module (data (global.get $base) "hello"))`
+//        begin here --^              ^
+//        onConstExpr resumes here --+
+//
+// Note: constExprParser is reusable. The caller resets via begin.
+// See https://www.w3.org/TR/2019/REC-wasm-core-1-20191205/#constant-expressions%E2%91%A0
+type constExprParser struct {
+	// globalNamespace is described by moduleParser.globalNamespace
+	globalNamespace *indexNamespace
+
+	// section is the section of the field that contains the constant expression, used to resolve a global index.
+	section wasm.SectionID
+
+	// onConstExpr is invoked on end
+	onConstExpr onConstExpr
+
+	// currentExpr is reset on begin and read onConstExpr
+	currentExpr *wasm.ConstantExpression
+}
+
+// begin should be called after reaching the '(' of a constant expression in a field of the given section. Parsing
+// continues until onConstExpr or error.
+func (p *constExprParser) begin(section wasm.SectionID, onConstExpr onConstExpr) tokenParser {
+	p.section = section
+	p.onConstExpr = onConstExpr
+	p.currentExpr = &wasm.ConstantExpression{}
+	return p.beginInstruction
+}
+
+// beginInstruction parses the instruction of the constant expression and dispatches to the parser of its immediate.
+func (p *constExprParser) beginInstruction(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	if tok != tokenKeyword {
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+	switch string(tokenBytes) {
+	case wasm.OpcodeI32ConstName:
+		p.currentExpr.Opcode = wasm.OpcodeI32Const
+		return p.parseI32, nil
+	case wasm.OpcodeI64ConstName:
+		p.currentExpr.Opcode = wasm.OpcodeI64Const
+		return p.parseI64, nil
+	case wasm.OpcodeF32ConstName:
+		p.currentExpr.Opcode = wasm.OpcodeF32Const
+		return p.parseF32, nil
+	case wasm.OpcodeF64ConstName:
+		p.currentExpr.Opcode = wasm.OpcodeF64Const
+		return p.parseF64, nil
+	case wasm.OpcodeGlobalGetName:
+		p.currentExpr.Opcode = wasm.OpcodeGlobalGet
+		return p.parseGlobalIndex, nil
+	default:
+		return nil, fmt.Errorf("unsupported instruction in constant expression: %s", tokenBytes)
+	}
+}
+
+// parseI32 parses a wasm.ValueTypeI32 into the current expression.
+func (p *constExprParser) parseI32(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	if tok != tokenUN {
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+	if i, overflow := decodeUint32(tokenBytes); overflow { // TODO: negative and hex
+		return nil, fmt.Errorf("i32 outside range of uint32: %s", tokenBytes)
+	} else { // See /RATIONALE.md we can't tell the signed interpretation of a constant, so default to signed.
+		p.currentExpr.Data = leb128.EncodeInt32(int32(i))
+	}
+	return p.end, nil
+}
+
+// parseI64 parses a wasm.ValueTypeI64 into the current expression.
+func (p *constExprParser) parseI64(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	if tok != tokenUN {
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+	if i, overflow := decodeUint64(tokenBytes); overflow { // TODO: negative and hex
+		return nil, fmt.Errorf("i64 outside range of uint64: %s", tokenBytes)
+	} else { // See /RATIONALE.md we can't tell the signed interpretation of a constant, so default to signed.
+		p.currentExpr.Data = leb128.EncodeInt64(int64(i))
+	}
+	return p.end, nil
+}
+
+// parseF32 parses a wasm.ValueTypeF32 into the current expression.
+func (p *constExprParser) parseF32(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	if tok != tokenUN {
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+	if i, overflow := decodeUint32(tokenBytes); overflow { // TODO: negative hex nan inf and actual float!
+		return nil, fmt.Errorf("f32 outside range of uint32: %s", tokenBytes)
+	} else {
+		p.currentExpr.Data = u64.LeBytes(api.EncodeF32(float32(i)))[:4]
+	}
+	return p.end, nil
+}
+
+// parseF64 parses a wasm.ValueTypeF64 into the current expression.
+func (p *constExprParser) parseF64(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	if tok != tokenUN {
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+	if i, overflow := decodeUint64(tokenBytes); overflow { // TODO: negative hex nan inf and actual float!
+		return nil, fmt.Errorf("f64 outside range of uint64: %s", tokenBytes)
+	} else {
+		p.currentExpr.Data = u64.LeBytes(api.EncodeF64(float64(i)))
+	}
+	return p.end, nil
+}
+
+// parseGlobalIndex parses an index in the global namespace into the current expression. If it was an ID that isn't
+// yet defined, Data is left empty and set when resolved later.
+func (p *constExprParser) parseGlobalIndex(tok tokenType, tokenBytes []byte, line, col uint32) (tokenParser, error) {
+	idx, resolved, err := p.globalNamespace.parseIndex(p.section, constExprOffset, tok, tokenBytes, line, col)
+	if err != nil {
+		return nil, err
+	}
+	if resolved || tok == tokenUN { // an out-of-range numeric index is set, but also verified later.
+		p.currentExpr.Data = leb128.EncodeUint32(idx)
+	}
+	return p.end, nil
+}
+
+// end calls onConstExpr with the current expression when the instruction is closed by ')'.
+func (p *constExprParser) end(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	if tok != tokenRParen {
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+	return p.onConstExpr(p.currentExpr), nil
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tetratelabs/wazero/internal/wasm"
+)
+
+func newDataParser(constExprParser *constExprParser, onData onData) *dataParser {
+	return &dataParser{constExprParser: constExprParser, onData: onData}
+}
+
+type onData func(*wasm.DataSegment) tokenParser
+
+// dataParser parses a wasm.DataSegment from and dispatches to onData.
+//
+// Ex. `(module (data (global.get $base) "hello" "world"))`
+//      starts here --^                                ^
+//                               onData resumes here --+
+//
+// Note: dataParser is reusable. The caller resets via begin.
+// See https://www.w3.org/TR/2019/REC-wasm-core-1-20191205/#data-segments%E2%91%A0
+type dataParser struct {
+	// constExprParser parses the offset expression of the data segment.
+	constExprParser *constExprParser
+
+	// onData is invoked on end
+	onData onData
+
+	// currentData is reset on begin and read onData
+	currentData *wasm.DataSegment
+}
+
+// begin should be called after reaching the "data" keyword in a module field. Parsing continues until onData or
+// error.
+func (p *dataParser) begin(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	p.currentData = &wasm.DataSegment{}
+	switch tok {
+	case tokenID: // Ex. $greeting
+		return nil, fmt.Errorf("TODO: data ID %s", tokenBytes)
+	case tokenLParen:
+		return p.beginOffset, nil
+	case tokenString:
+		return nil, errors.New("TODO: passive data segment")
+	case tokenRParen:
+		return nil, errors.New("missing offset")
+	default:
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+}
+
+// beginOffset passes control to the constExprParser until the offset expression is read, then returns onOffset.
+func (p *dataParser) beginOffset(tok tokenType, tokenBytes []byte, line, col uint32) (tokenParser, error) {
+	if tok == tokenKeyword {
+		switch string(tokenBytes) {
+		case wasm.ExternTypeMemoryName, "offset":
+			return nil, fmt.Errorf("TODO: %s", tokenBytes)
+		}
+	}
+	return p.constExprParser.begin(wasm.SectionIDData, p.onOffset)(tok, tokenBytes, line, col)
+}
+
+// onOffset records the offset expression of the current data segment and returns parseInit.
+func (p *dataParser) onOffset(expr *wasm.ConstantExpression) tokenParser {
+	p.currentData.OffsetExpression = expr
+	return p.parseInit
+}
+
+// parseInit appends each string to the init bytes of the current data segment until the field is closed.
+func (p *dataParser) parseInit(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	switch tok {
+	case tokenString: // Ex. "hello"
+		init, err := unescape(tokenBytes[1 : len(tokenBytes)-1]) // unquote
+		if err != nil {
+			return nil, err
+		}
+		p.currentData.Init = append(p.currentData.Init, init...)
+		return p.parseInit, nil
+	case tokenRParen:
+		return p.onData(p.currentData), nil
+	default:
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+}
+
+// unescape decodes the escape sequences in the contents of a string token, such as "\00" for the null byte.
+//
+// See https://www.w3.org/TR/2019/REC-wasm-core-1-20191205/#strings%E2%91%A0
+func unescape(s []byte) ([]byte, error) {
+	var ret []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			ret = append(ret, s[i])
+			continue
+		}
+		if i++; i == len(s) {
+			return nil, errors.New("incomplete escape")
+		}
+		switch ch := s[i]; ch {
+		case 't':
+			ret = append(ret, '\t')
+		case 'n':
+			ret = append(ret, '\n')
+		case 'r':
+			ret = append(ret, '\r')
+		case '"', '\'', '\\':
+			ret = append(ret, ch)
+		case 'u':
+			return nil, errors.New("TODO: unicode escape")
+		default:
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("invalid escape \\%c", ch)
+			}
+			hi, lo := hexValue(ch), hexValue(s[i+1])
+			if hi < 0 || lo < 0 {
+				return nil, fmt.Errorf("invalid escape \\%s", s[i:i+2])
+			}
+			ret = append(ret, byte(hi<<4|lo))
+			i++
+		}
+	}
+	return ret, nil
+}
+
+// hexValue returns the value of a hex digit or -1 if it isn't one.
+func hexValue(ch byte) int {
+	switch {
+	case ch >= '0' && ch <= '9':
+		return int(ch - '0')
+	case ch >= 'a' && ch <= 'f':
+		return int(ch-'a') + 10
+	case ch >= 'A' && ch <= 'F':
+		return int(ch-'A') + 10
+	}
+	return -1
+}
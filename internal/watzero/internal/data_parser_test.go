@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+)
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		name, input string
+		expected    []byte
+	}{
+		{name: "empty", input: "", expected: nil},
+		{name: "plain", input: "hi", expected: []byte("hi")},
+		{name: "named", input: `\t\n\r\'\\`, expected: []byte("\t\n\r'\\")},
+		{name: "hex", input: `\00\7f\FF`, expected: []byte{0, 0x7f, 0xff}},
+		{name: "mixed", input: `a\62c`, expected: []byte("abc")},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			unescaped, err := unescape([]byte(tc.input))
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, unescaped)
+		})
+	}
+}
+
+func TestUnescape_Errors(t *testing.T) {
+	tests := []struct{ name, input, expectedErr string }{
+		{name: "incomplete", input: `\`, expectedErr: "incomplete escape"},
+		{name: "one hex digit", input: `\0`, expectedErr: `invalid escape \0`},
+		{name: "not hex", input: `\0g`, expectedErr: `invalid escape \0g`},
+		{name: "unicode", input: `\u{41}`, expectedErr: "TODO: unicode escape"},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := unescape([]byte(tc.input))
+			require.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
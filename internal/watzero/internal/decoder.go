@@ -3,7 +3,10 @@ package internal
 import (
 	"errors"
 	"fmt"
+	"io"
+	"sort"
 
+	"github.com/tetratelabs/wazero/internal/leb128"
 	"github.com/tetratelabs/wazero/internal/wasm"
 )
 
@@ -25,6 +28,9 @@ const (
 	positionExportFunc
 	positionExportMemory
 	positionStart
+	positionGlobal
+	positionElem
+	positionData
 )
 
 type callbackPosition byte
@@ -48,9 +54,6 @@ type moduleParser struct {
 	// enabledFeatures ensure parsing errs at the correct line and column number when a feature is disabled.
 	enabledFeatures wasm.Features
 
-	// source is the entire WebAssembly text format source code being parsed.
-	source []byte
-
 	// module holds the fields incrementally parsed from tokens in the source.
 	module *wasm.Module
 
@@ -92,6 +95,22 @@ type moduleParser struct {
 	// memoryParser parses the MemorySection for a given module-defined memory.
 	memoryParser *memoryParser
 
+	// globalNamespace represents the global index namespace, which begins with any wasm.ExternTypeGlobal in the
+	// wasm.SectionIDImport followed by the wasm.SectionIDGlobal.
+	//
+	// Module-defined globals can declare symbolic IDs, such as "$base", which are resolved here (without the '$'
+	// prefix). These can be used before they are defined, ex. in the offset of a data segment.
+	globalNamespace *indexNamespace
+
+	// globalParser parses the GlobalSection for a given module-defined global.
+	globalParser *globalParser
+
+	// elemParser parses the ElementSection for a given element segment.
+	elemParser *elemParser
+
+	// dataParser parses the DataSection for a given data segment.
+	dataParser *dataParser
+
 	// unresolvedExports holds any exports whose type index wasn't resolvable when parsed.
 	unresolvedExports map[wasm.Index]*wasm.Export
 
@@ -100,6 +119,14 @@ type moduleParser struct {
 	fieldCountFunc uint32
 
 	exportedName map[string]struct{}
+
+	// collectErrors is true when each index that fails to resolve is recorded in resolveErrs, rather than failing on
+	// the first.
+	collectErrors bool
+	resolveErrs   FormatErrors
+
+	// progress is updated at the start of each module field, when not nil.
+	progress *Progress
 }
 
 // DecodeModule implements wasm.DecodeModule for the WebAssembly 1.0 (20191205) Text Format
@@ -108,6 +135,77 @@ func DecodeModule(
 	source []byte,
 	enabledFeatures wasm.Features,
 	memorySizer func(minPages uint32, maxPages *uint32) (min, capacity, max uint32),
+) (module *wasm.Module, err error) {
+	return decodeModule(func(parser tokenParser) (line, col uint32, err error) {
+		return lex(parser, source)
+	}, enabledFeatures, memorySizer, false, nil)
+}
+
+// DecodeModuleAllErrors is like DecodeModule, except it resolves each index it can, rather than stopping at the first
+// that is unknown or out of range, so that a module with several bad indices reports them at once. The error is then
+// FormatErrors, holding a FormatError per index in source order.
+//
+// Note: Other errors, such as a syntax error, still stop decoding and are returned alone.
+func DecodeModuleAllErrors(
+	source []byte,
+	enabledFeatures wasm.Features,
+	memorySizer func(minPages uint32, maxPages *uint32) (min, capacity, max uint32),
+) (module *wasm.Module, err error) {
+	return decodeModule(func(parser tokenParser) (line, col uint32, err error) {
+		return lex(parser, source)
+	}, enabledFeatures, memorySizer, true, nil)
+}
+
+// DecodeModuleMaxDepth is like DecodeModule, except parens may nest at most maxDepth deep, rather than DefaultMaxDepth,
+// e.g. lower for a source that isn't trusted. A '(' nested deeper is a FormatError at its position.
+func DecodeModuleMaxDepth(
+	source []byte,
+	enabledFeatures wasm.Features,
+	memorySizer func(minPages uint32, maxPages *uint32) (min, capacity, max uint32),
+	maxDepth int,
+) (module *wasm.Module, err error) {
+	return decodeModule(func(parser tokenParser) (line, col uint32, err error) {
+		return lexMaxDepth(parser, source, maxDepth)
+	}, enabledFeatures, memorySizer, false, nil)
+}
+
+// DecodeModuleReader is like DecodeModule, except it reads the source from r as it is parsed, rather than needing it
+// loaded whole, e.g. for a generated module of hundreds of megabytes. Only the longest line of the source is buffered.
+//
+// Note: A string containing an unescaped newline, which the specification disallows, is an error here even though
+// DecodeModule accepts it.
+func DecodeModuleReader(
+	r io.Reader,
+	enabledFeatures wasm.Features,
+	memorySizer func(minPages uint32, maxPages *uint32) (min, capacity, max uint32),
+) (module *wasm.Module, err error) {
+	return decodeModule(func(parser tokenParser) (line, col uint32, err error) {
+		return lexReader(parser, r, lexReaderBufferSize)
+	}, enabledFeatures, memorySizer, false, nil)
+}
+
+// DecodeModuleReaderProgress is like DecodeModuleReader, except it updates progress as it parses, so that a tool can
+// render the progress of a long parse while it runs. See Progress
+func DecodeModuleReaderProgress(
+	r io.Reader,
+	enabledFeatures wasm.Features,
+	memorySizer func(minPages uint32, maxPages *uint32) (min, capacity, max uint32),
+	progress *Progress,
+) (module *wasm.Module, err error) {
+	r = &progressReader{r: r, progress: progress}
+	return decodeModule(func(parser tokenParser) (line, col uint32, err error) {
+		return lexReader(parser, r, lexReaderBufferSize)
+	}, enabledFeatures, memorySizer, false, progress)
+}
+
+// decodeModule decodes the module from the tokens lexSource passes to the parser given to it. See DecodeModuleAllErrors
+// for collectErrors, and Progress for progress, which may be nil.
+func decodeModule(
+	lexSource func(parser tokenParser) (line, col uint32, err error),
+	enabledFeatures wasm.Features,
+	memorySizer func(minPages uint32, maxPages *uint32) (min, capacity, max uint32),
+	collectErrors bool,
+	progress *Progress,
 ) (module *wasm.Module, err error) {
 	// TODO: when globals are supported, err on global vars if disabled
 
@@ -119,11 +217,12 @@ func DecodeModule(
 	names := &wasm.NameSection{}
 	module = &wasm.Module{NameSection: names}
 	p := newModuleParser(module, enabledFeatures, memorySizer)
-	p.source = source
+	p.collectErrors = collectErrors
+	p.progress = progress
 
 	// A valid source must begin with the token '(', but it could be preceded by whitespace or comments. For this
 	// reason, we cannot enforce source[0] == '(', and instead need to start the lexer to check the first token.
-	line, col, err := lex(p.ensureLParen, p.source)
+	line, col, err := lexSource(p.ensureLParen)
 	if err != nil {
 		return nil, &FormatError{line, col, p.errorContext(), err}
 	}
@@ -138,6 +237,16 @@ func DecodeModule(
 	if err = p.resolveFunctionIndices(module); err != nil {
 		return nil, err
 	}
+	if err = p.resolveGlobalIndices(module); err != nil {
+		return nil, err
+	}
+	if len(p.resolveErrs) > 0 {
+		sort.SliceStable(p.resolveErrs, func(i, j int) bool {
+			a, b := p.resolveErrs[i], p.resolveErrs[j]
+			return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+		})
+		return nil, p.resolveErrs
+	}
 
 	// Don't set the name section unless we parsed a name!
 	if names.ModuleName == "" && names.FunctionNames == nil && names.LocalNames == nil {
@@ -159,11 +268,16 @@ func newModuleParser(
 		typeNamespace:   newIndexNamespace(module.SectionElementCount),
 		funcNamespace:   newIndexNamespace(module.SectionElementCount),
 		memoryNamespace: newIndexNamespace(module.SectionElementCount),
+		globalNamespace: newIndexNamespace(module.SectionElementCount),
 	}
 	p.typeParser = newTypeParser(enabledFeatures, p.typeNamespace, p.onTypeEnd)
 	p.typeUseParser = newTypeUseParser(enabledFeatures, module, p.typeNamespace)
 	p.funcParser = newFuncParser(enabledFeatures, p.typeUseParser, p.funcNamespace, p.endFunc)
 	p.memoryParser = newMemoryParser(memorySizer, p.memoryNamespace, p.endMemory)
+	constExprParser := newConstExprParser(p.globalNamespace)
+	p.globalParser = newGlobalParser(p.globalNamespace, constExprParser, p.endGlobal)
+	p.elemParser = newElemParser(p.funcNamespace, constExprParser, p.endElem)
+	p.dataParser = newDataParser(constExprParser, p.endData)
 	return &p
 }
 
@@ -190,17 +304,17 @@ func (p *moduleParser) beginSourceField(tok tokenType, tokenBytes []byte, _, _ u
 }
 
 // beginModuleField returns a parser according to the module field name (tokenKeyword), or errs if invalid.
-func (p *moduleParser) beginModuleField(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+func (p *moduleParser) beginModuleField(tok tokenType, tokenBytes []byte, line, _ uint32) (tokenParser, error) {
 	if tok == tokenKeyword {
 		switch string(tokenBytes) {
 		case "type":
-			p.pos = positionType
+			p.beginField(positionType, line)
 			return p.typeParser.begin, nil
 		case "import":
-			p.pos = positionImport
+			p.beginField(positionImport, line)
 			return p.parseImportModule, nil
 		case wasm.ExternTypeFuncName:
-			p.pos = positionFunc
+			p.beginField(positionFunc, line)
 			return p.funcParser.begin, nil
 		case wasm.ExternTypeTableName:
 			return nil, fmt.Errorf("TODO: %s", tokenBytes)
@@ -208,23 +322,26 @@ func (p *moduleParser) beginModuleField(tok tokenType, tokenBytes []byte, _, _ u
 			if p.module.SectionElementCount(wasm.SectionIDMemory) > 0 {
 				return nil, moreThanOneInvalidInSection(wasm.SectionIDMemory)
 			}
-			p.pos = positionMemory
+			p.beginField(positionMemory, line)
 			return p.memoryParser.begin, nil
 		case wasm.ExternTypeGlobalName:
-			return nil, fmt.Errorf("TODO: %s", tokenBytes)
+			p.beginField(positionGlobal, line)
+			return p.globalParser.begin, nil
 		case "export":
-			p.pos = positionExport
+			p.beginField(positionExport, line)
 			return p.parseExportName, nil
 		case "start":
 			if p.module.SectionElementCount(wasm.SectionIDStart) > 0 {
 				return nil, moreThanOneInvalidInSection(wasm.SectionIDStart)
 			}
-			p.pos = positionStart
+			p.beginField(positionStart, line)
 			return p.parseStart, nil
 		case "elem":
-			return nil, errors.New("TODO: elem")
+			p.beginField(positionElem, line)
+			return p.elemParser.begin, nil
 		case "data":
-			return nil, errors.New("TODO: data")
+			p.beginField(positionData, line)
+			return p.dataParser.begin, nil
 		default:
 			return nil, unexpectedFieldName(tokenBytes)
 		}
@@ -232,6 +349,42 @@ func (p *moduleParser) beginModuleField(tok tokenType, tokenBytes []byte, _, _ u
 	return nil, expectedField(tok)
 }
 
+// beginField sets pos to that of the module field beginning at line, and updates progress, if tracked.
+func (p *moduleParser) beginField(pos parserPosition, line uint32) {
+	p.pos = pos
+	if p.progress == nil {
+		return
+	}
+	var section wasm.SectionID
+	var index uint32
+	switch pos {
+	case positionType:
+		section = wasm.SectionIDType
+	case positionImport:
+		section = wasm.SectionIDImport
+	case positionFunc:
+		section, index = wasm.SectionIDFunction, p.fieldCountFunc
+	case positionMemory:
+		section = wasm.SectionIDMemory
+	case positionGlobal:
+		section = wasm.SectionIDGlobal
+	case positionExport:
+		section = wasm.SectionIDExport
+	case positionStart:
+		section = wasm.SectionIDStart
+	case positionElem:
+		section = wasm.SectionIDElement
+	case positionData:
+		section = wasm.SectionIDData
+	default: // only the above begin module fields.
+		panic(fmt.Errorf("BUG: unhandled module field position on beginField: %v", pos))
+	}
+	if pos != positionFunc {
+		index = p.module.SectionElementCount(section)
+	}
+	p.progress.beginField(section, index, line)
+}
+
 // parseModuleName records the wasm.NameSection ModuleName, if present, and resumes with parseModule.
 //
 // Ex. A module name is present `(module $math)`
@@ -468,6 +621,28 @@ func (p *moduleParser) endMemory(mem *wasm.Memory) tokenParser {
 	return p.parseModule
 }
 
+// endGlobal adds the current global into the GlobalSection and returns parseModule to prepare for the next field.
+func (p *moduleParser) endGlobal(g *wasm.Global) tokenParser {
+	p.module.GlobalSection = append(p.module.GlobalSection, g)
+	p.pos = positionModule
+	return p.parseModule
+}
+
+// endElem adds the current element segment into the ElementSection and returns parseModule to prepare for the next
+// field.
+func (p *moduleParser) endElem(e *wasm.ElementSegment) tokenParser {
+	p.module.ElementSection = append(p.module.ElementSection, e)
+	p.pos = positionModule
+	return p.parseModule
+}
+
+// endData adds the current data segment into the DataSection and returns parseModule to prepare for the next field.
+func (p *moduleParser) endData(d *wasm.DataSegment) tokenParser {
+	p.module.DataSection = append(p.module.DataSection, d)
+	p.pos = positionModule
+	return p.parseModule
+}
+
 // parseExportName returns parseExport after recording the export name, or errs if it couldn't be read.
 //
 // Ex. Export name is present `(export "PI" (func 0))`
@@ -617,12 +792,25 @@ func (p *moduleParser) parseUnexpectedTrailingCharacters(_ tokenType, tokenBytes
 	return nil, fmt.Errorf("unexpected trailing characters: %s", tokenBytes)
 }
 
+// resolveFailed returns the FormatError of an index that failed to resolve, unless collecting errors, in which case it
+// records it and returns nil, so that resolution continues with the next index.
+func (p *moduleParser) resolveFailed(err error) error {
+	if !p.collectErrors {
+		return err
+	}
+	p.resolveErrs = append(p.resolveErrs, err.(*FormatError))
+	return nil
+}
+
 // resolveTypeIndices ensures any indices point are numeric or returns a FormatError if they cannot be bound.
 func (p *moduleParser) resolveTypeIndices(module *wasm.Module) error {
 	for _, unresolved := range p.typeNamespace.unresolvedIndices {
 		target, err := p.typeNamespace.resolve(unresolved)
 		if err != nil {
-			return err
+			if err = p.resolveFailed(err); err != nil {
+				return err
+			}
+			continue
 		}
 		switch unresolved.section {
 		case wasm.SectionIDImport:
@@ -641,7 +829,10 @@ func (p *moduleParser) resolveFunctionIndices(module *wasm.Module) error {
 	for _, unresolved := range p.funcNamespace.unresolvedIndices {
 		target, err := p.funcNamespace.resolve(unresolved)
 		if err != nil {
-			return err
+			if err = p.resolveFailed(err); err != nil {
+				return err
+			}
+			continue
 		}
 		switch unresolved.section {
 		case wasm.SectionIDCode:
@@ -653,9 +844,37 @@ func (p *moduleParser) resolveFunctionIndices(module *wasm.Module) error {
 			p.unresolvedExports[unresolved.idx].Index = target
 		case wasm.SectionIDStart:
 			module.StartSection = &target
+		case wasm.SectionIDElement:
+			module.ElementSection[unresolved.idx].Init[unresolved.bodyOffset] = &target
+		default:
+			panic(unhandledSection(unresolved.section))
+		}
+	}
+	return nil
+}
+
+// resolveGlobalIndices ensures any indices point are numeric or returns a FormatError if they cannot be bound.
+func (p *moduleParser) resolveGlobalIndices(module *wasm.Module) error {
+	for _, unresolved := range p.globalNamespace.unresolvedIndices {
+		target, err := p.globalNamespace.resolve(unresolved)
+		if err != nil {
+			if err = p.resolveFailed(err); err != nil {
+				return err
+			}
+			continue
+		}
+		var expr *wasm.ConstantExpression
+		switch unresolved.section {
+		case wasm.SectionIDGlobal:
+			expr = module.GlobalSection[unresolved.idx].Init
+		case wasm.SectionIDElement:
+			expr = module.ElementSection[unresolved.idx].OffsetExpr
+		case wasm.SectionIDData:
+			expr = module.DataSection[unresolved.idx].OffsetExpression
 		default:
 			panic(unhandledSection(unresolved.section))
 		}
+		expr.Data = leb128.EncodeUint32(target)
 	}
 	return nil
 }
@@ -773,6 +992,13 @@ func (p *moduleParser) errorContext() string {
 		return fmt.Sprintf("module.export[%d].%s", idx, wasm.ExternTypeFuncName)
 	case positionStart:
 		return "module.start"
+	case positionGlobal:
+		idx := p.module.SectionElementCount(wasm.SectionIDGlobal)
+		return fmt.Sprintf("module.%s[%d]", wasm.ExternTypeGlobalName, idx)
+	case positionElem:
+		return fmt.Sprintf("module.elem[%d]", p.module.SectionElementCount(wasm.SectionIDElement))
+	case positionData:
+		return fmt.Sprintf("module.data[%d]", p.module.SectionElementCount(wasm.SectionIDData))
 	default: // parserPosition is an enum, we expect to have handled all cases above. panic if we didn't
 		panic(fmt.Errorf("BUG: unhandled parsing state on errorContext: %v", p.pos))
 	}
@@ -2,14 +2,18 @@ package internal
 
 import (
 	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/tetratelabs/wazero/internal/testing/require"
 	"github.com/tetratelabs/wazero/internal/wasm"
 )
 
 func TestDecodeModule(t *testing.T) {
-	zero := uint32(0)
+	zero, one := uint32(0), uint32(1)
 	localGet0End := []byte{wasm.OpcodeLocalGet, 0x00, wasm.OpcodeEnd}
 
 	tests := []struct {
@@ -1558,6 +1562,84 @@ func TestDecodeModule(t *testing.T) {
 				StartSection:    &zero,
 			},
 		},
+		{
+			name: "global",
+			input: `(module
+	(global $base i32 (i32.const 8))
+	(global (mut i64) (global.get $base))
+)`,
+			expected: &wasm.Module{
+				GlobalSection: []*wasm.Global{
+					{
+						Type: &wasm.GlobalType{ValType: wasm.ValueTypeI32},
+						Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeI32Const, Data: []byte{8}},
+					},
+					{
+						Type: &wasm.GlobalType{ValType: wasm.ValueTypeI64, Mutable: true},
+						Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeGlobalGet, Data: []byte{0}},
+					},
+				},
+			},
+		},
+		{
+			name: "data offset by global ID - late",
+			input: `(module
+	(memory 1)
+	(data (global.get $base) "hi" "\00")
+	(global $zero i32 (i32.const 0))
+	(global $base i32 (i32.const 8))
+)`,
+			expected: &wasm.Module{
+				MemorySection: &wasm.Memory{Min: 1, Cap: 1, Max: wasm.MemoryLimitPages},
+				GlobalSection: []*wasm.Global{
+					{
+						Type: &wasm.GlobalType{ValType: wasm.ValueTypeI32},
+						Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeI32Const, Data: []byte{0}},
+					},
+					{
+						Type: &wasm.GlobalType{ValType: wasm.ValueTypeI32},
+						Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeI32Const, Data: []byte{8}},
+					},
+				},
+				DataSection: []*wasm.DataSegment{
+					{
+						OffsetExpression: &wasm.ConstantExpression{Opcode: wasm.OpcodeGlobalGet, Data: []byte{1}},
+						Init:             []byte{'h', 'i', 0},
+					},
+				},
+			},
+		},
+		{
+			name: "elem offset and functions by ID - late",
+			input: `(module
+	(elem (global.get $base) func $b 0)
+	(func $a)
+	(func $b)
+	(global $base i32 (i32.const 2))
+)`,
+			expected: &wasm.Module{
+				TypeSection:     []*wasm.FunctionType{v_v},
+				FunctionSection: []wasm.Index{0, 0},
+				CodeSection:     []*wasm.Code{{Body: end}, {Body: end}},
+				GlobalSection: []*wasm.Global{
+					{
+						Type: &wasm.GlobalType{ValType: wasm.ValueTypeI32},
+						Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeI32Const, Data: []byte{2}},
+					},
+				},
+				ElementSection: []*wasm.ElementSegment{
+					{
+						OffsetExpr: &wasm.ConstantExpression{Opcode: wasm.OpcodeGlobalGet, Data: []byte{0}},
+						Init:       []*wasm.Index{&one, &zero},
+						Type:       wasm.RefTypeFuncref,
+						Mode:       wasm.ElementModeActive,
+					},
+				},
+				NameSection: &wasm.NameSection{
+					FunctionNames: wasm.NameMap{{Index: 0, Name: "a"}, {Index: 1, Name: "b"}},
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -1567,10 +1649,46 @@ func TestDecodeModule(t *testing.T) {
 			m, err := DecodeModule([]byte(tc.input), wasm.Features20220419, wasm.MemorySizer)
 			require.NoError(t, err)
 			require.Equal(t, tc.expected, m)
+
+			m, err = DecodeModuleReader(iotest.OneByteReader(strings.NewReader(tc.input)), wasm.Features20220419, wasm.MemorySizer)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, m)
 		})
 	}
 }
 
+// TestDecodeModuleReader_Large ensures a module larger than the buffer of DecodeModuleReader decodes the same as with
+// DecodeModule, including the position of errors.
+func TestDecodeModuleReader_Large(t *testing.T) {
+	var source strings.Builder
+	source.WriteString("(module\n")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&source, "  (func $f%d (param i32) (result i32) ;; function %d\n", i, i)
+		fmt.Fprintf(&source, "    local.get 0 i32.const %d i32.add\n", i)
+		fmt.Fprintf(&source, "  )\n")
+	}
+	valid := source.String() + ")"
+	require.True(t, len(valid) > 4*lexReaderBufferSize)
+
+	expected, err := DecodeModule([]byte(valid), wasm.Features20220419, wasm.MemorySizer)
+	require.NoError(t, err)
+	m, err := DecodeModuleReader(strings.NewReader(valid), wasm.Features20220419, wasm.MemorySizer)
+	require.NoError(t, err)
+	require.Equal(t, expected, m)
+
+	// Both a lexing error and an unresolved ID report the line and column of the last function.
+	for _, tc := range []struct{ name, body, expectedErr string }{
+		{name: "unsupported instruction", body: "    i32.nope", expectedErr: "30003:5: unsupported instruction: i32.nope in module.func[10000]"},
+		{name: "unknown ID", body: "    call $nope", expectedErr: "30003:10: unknown ID $nope in module.code[10000].body[1]"},
+	} {
+		invalid := source.String() + "  (func\n" + tc.body + ")\n)"
+		_, expectedErr := DecodeModule([]byte(invalid), wasm.Features20220419, wasm.MemorySizer)
+		require.EqualError(t, expectedErr, tc.expectedErr, tc.name)
+		_, err = DecodeModuleReader(strings.NewReader(invalid), wasm.Features20220419, wasm.MemorySizer)
+		require.EqualError(t, err, tc.expectedErr, tc.name)
+	}
+}
+
 func TestParseModule_Errors(t *testing.T) {
 	tests := []struct {
 		name, input string
@@ -1916,6 +2034,11 @@ func TestParseModule_Errors(t *testing.T) {
 			input:       "(module (func $main \"\"))",
 			expectedErr: "1:21: unexpected string: \"\" in module.func[0]",
 		},
+		{
+			name:        "empty func ID",
+			input:       "(module (func $ nop))",
+			expectedErr: "1:15: empty ID $ in module.func[0]",
+		},
 		{
 			name:        "clash on func ID",
 			input:       "(module (func $main) (func $main)))",
@@ -2137,6 +2260,41 @@ func TestParseModule_Errors(t *testing.T) {
 			input:       "(module (start $main))",
 			expectedErr: "1:16: unknown ID $main in module.start",
 		},
+		{
+			name:        "global init points nowhere",
+			input:       "(module (global i32 (global.get $base)))",
+			expectedErr: "1:33: unknown ID $base in module.global[0].init",
+		},
+		{
+			name:        "global missing init",
+			input:       "(module (global i32))",
+			expectedErr: "1:20: missing init in module.global[0]",
+		},
+		{
+			name:        "data offset points nowhere",
+			input:       "(module (memory 1) (data (global.get $base) \"hi\"))",
+			expectedErr: "1:38: unknown ID $base in module.data[0].offset",
+		},
+		{
+			name:        "data offset out of range",
+			input:       "(module (memory 1) (data (global.get 0) \"hi\"))",
+			expectedErr: "1:38: index 0 is not in range due to empty namespace in module.data[0].offset",
+		},
+		{
+			name:        "data invalid offset",
+			input:       "(module (memory 1) (data (i32.add) \"hi\"))",
+			expectedErr: "1:27: unsupported instruction in constant expression: i32.add in module.data[0]",
+		},
+		{
+			name:        "elem offset points nowhere",
+			input:       "(module (func) (elem (global.get $base) 0))",
+			expectedErr: "1:34: unknown ID $base in module.elem[0].offset",
+		},
+		{
+			name:        "elem function points nowhere",
+			input:       "(module (func) (elem (i32.const 0) 0 $main))",
+			expectedErr: "1:38: unknown ID $main in module.elem[0].init[1]",
+		},
 	}
 
 	for _, tt := range tests {
@@ -2145,8 +2303,82 @@ func TestParseModule_Errors(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			_, err := DecodeModule([]byte(tc.input), wasm.Features20191205, wasm.MemorySizer)
 			require.EqualError(t, err, tc.expectedErr)
+
+			_, err = DecodeModuleReader(iotest.OneByteReader(strings.NewReader(tc.input)), wasm.Features20191205, wasm.MemorySizer)
+			require.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestDecodeModuleAllErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expectedErr string
+	}{
+		{
+			name:        "one",
+			input:       "(module (start $main))",
+			expectedErr: `1:16: unknown ID $main in module.start`,
+		},
+		{
+			name: "each namespace in source order",
+			input: `(module
+  (global $g i32 (global.get $nope))
+  (func $main call $missing call 7)
+  (func (type $t))
+  (export "f" (func $gone))
+)`,
+			expectedErr: `2:30: unknown ID $nope in module.global[0].init
+3:20: unknown ID $missing in module.code[0].body[1]
+3:34: index 7 is out of range [0..1] in module.code[0].body[3]
+4:15: unknown ID $t
+5:21: unknown ID $gone in module.exports[0].func`,
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeModuleAllErrors([]byte(tc.input), wasm.Features20220419, wasm.MemorySizer)
+			require.EqualError(t, err, tc.expectedErr)
+			require.ErrorIs(t, err, ErrUnknownID)
+
+			var errs FormatErrors
+			require.True(t, errors.As(err, &errs))
 		})
 	}
+
+	t.Run("valid", func(t *testing.T) {
+		input := "(module (func $main call $main) (start $main))"
+		expected, err := DecodeModule([]byte(input), wasm.Features20220419, wasm.MemorySizer)
+		require.NoError(t, err)
+		m, err := DecodeModuleAllErrors([]byte(input), wasm.Features20220419, wasm.MemorySizer)
+		require.NoError(t, err)
+		require.Equal(t, expected, m)
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		_, err := DecodeModuleAllErrors([]byte("(module (start $main) (func $main $main))"), wasm.Features20220419, wasm.MemorySizer)
+		require.EqualError(t, err, "1:35: redundant ID $main in module.func[0]")
+		var formatErr *FormatError
+		require.True(t, errors.As(err, &formatErr))
+	})
+}
+
+func TestDecodeModuleMaxDepth(t *testing.T) {
+	input := "(module (memory 1) (func $main))"
+	expected, err := DecodeModule([]byte(input), wasm.Features20191205, wasm.MemorySizer)
+	require.NoError(t, err)
+	m, err := DecodeModuleMaxDepth([]byte(input), wasm.Features20191205, wasm.MemorySizer, 2)
+	require.NoError(t, err)
+	require.Equal(t, expected, m)
+
+	_, err = DecodeModuleMaxDepth([]byte(input), wasm.Features20191205, wasm.MemorySizer, 1)
+	require.EqualError(t, err, "1:9: found '(' nested deeper than the maximum depth of 1 in module")
+	var formatErr *FormatError
+	require.True(t, errors.As(err, &formatErr))
 }
 
 func TestModuleParser_ErrorContext(t *testing.T) {
@@ -2165,6 +2397,9 @@ func TestModuleParser_ErrorContext(t *testing.T) {
 		{input: "module export", pos: positionExport, expected: "module.export[0]"},
 		{input: "module export func", pos: positionExportFunc, expected: "module.export[0].func"},
 		{input: "start", pos: positionStart, expected: "module.start"},
+		{input: "global", pos: positionGlobal, expected: "module.global[0]"},
+		{input: "elem", pos: positionElem, expected: "module.elem[0]"},
+		{input: "data", pos: positionData, expected: "module.data[0]"},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tetratelabs/wazero/internal/wasm"
+)
+
+func newElemParser(funcNamespace *indexNamespace, constExprParser *constExprParser, onElem onElem) *elemParser {
+	return &elemParser{funcNamespace: funcNamespace, constExprParser: constExprParser, onElem: onElem}
+}
+
+type onElem func(*wasm.ElementSegment) tokenParser
+
+// elemParser parses an active wasm.ElementSegment from and dispatches to onElem.
+//
+// Ex. `(module (elem (global.get $base) func $add $sub))`
+//      starts here --^                               ^
+//                               onElem resumes here --+
+//
+// Note: elemParser is reusable. The caller resets via begin.
+// See https://www.w3.org/TR/2019/REC-wasm-core-1-20191205/#element-segments%E2%91%A0
+type elemParser struct {
+	// funcNamespace is described by moduleParser.funcNamespace
+	funcNamespace *indexNamespace
+
+	// constExprParser parses the offset expression of the element segment.
+	constExprParser *constExprParser
+
+	// onElem is invoked on end
+	onElem onElem
+
+	// currentElem is reset on begin and read onElem
+	currentElem *wasm.ElementSegment
+}
+
+// begin should be called after reaching the "elem" keyword in a module field. Parsing continues until onElem or
+// error.
+func (p *elemParser) begin(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	p.currentElem = &wasm.ElementSegment{Type: wasm.RefTypeFuncref, Mode: wasm.ElementModeActive}
+	switch tok {
+	case tokenID: // Ex. $table
+		return nil, fmt.Errorf("TODO: elem ID %s", tokenBytes)
+	case tokenLParen:
+		return p.beginOffset, nil
+	case tokenRParen:
+		return nil, errors.New("missing offset")
+	default:
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+}
+
+// beginOffset passes control to the constExprParser until the offset expression is read, then returns onOffset.
+func (p *elemParser) beginOffset(tok tokenType, tokenBytes []byte, line, col uint32) (tokenParser, error) {
+	if tok == tokenKeyword {
+		switch string(tokenBytes) {
+		case wasm.ExternTypeTableName, "offset":
+			return nil, fmt.Errorf("TODO: %s", tokenBytes)
+		}
+	}
+	return p.constExprParser.begin(wasm.SectionIDElement, p.onOffset)(tok, tokenBytes, line, col)
+}
+
+// onOffset records the offset expression of the current element segment and returns parseFunc.
+func (p *elemParser) onOffset(expr *wasm.ConstantExpression) tokenParser {
+	p.currentElem.OffsetExpr = expr
+	return p.parseFunc
+}
+
+// parseFunc skips the optional "func" keyword before the function indices.
+//
+// Ex. `(elem (i32.const 0) func $add)`
+//         skips here --^    ^
+//   parseInit resumes here --+
+func (p *elemParser) parseFunc(tok tokenType, tokenBytes []byte, line, col uint32) (tokenParser, error) {
+	if tok == tokenKeyword && string(tokenBytes) == wasm.ExternTypeFuncName {
+		return p.parseInit, nil
+	}
+	return p.parseInit(tok, tokenBytes, line, col)
+}
+
+// parseInit appends each function index to the init of the current element segment until the field is closed. If an
+// index was an ID that isn't yet defined, it is replaced when resolved later.
+func (p *elemParser) parseInit(tok tokenType, tokenBytes []byte, line, col uint32) (tokenParser, error) {
+	switch tok {
+	case tokenUN, tokenID:
+		bodyOffset := uint32(len(p.currentElem.Init))
+		idx, _, err := p.funcNamespace.parseIndex(wasm.SectionIDElement, bodyOffset, tok, tokenBytes, line, col)
+		if err != nil {
+			return nil, err
+		}
+		p.currentElem.Init = append(p.currentElem.Init, &idx)
+		return p.parseInit, nil
+	case tokenRParen:
+		return p.onElem(p.currentElem), nil
+	default:
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+}
@@ -1,11 +1,25 @@
 package internal
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/tetratelabs/wazero/internal/wasm"
 )
 
+var (
+	// ErrUnknownID is wrapped by the cause of a FormatError when a symbolic identifier, ex. "$main", isn't defined in its
+	// index namespace.
+	ErrUnknownID = errors.New("unknown ID")
+
+	// ErrIndexOutOfRange is wrapped by the cause of a FormatError when a numeric index is not less than the count of its
+	// index namespace.
+	ErrIndexOutOfRange = errors.New("index out of range")
+)
+
 // FormatError allows control over the format of errors parsing the WebAssembly Text Format.
 type FormatError struct {
 	// Line is the source line number determined by unescaped '\n' characters of the error or EOF
@@ -24,10 +38,80 @@ func (e *FormatError) Error() string {
 	return fmt.Sprintf("%d:%d: %v in %s", e.Line, e.Col, e.cause, e.Context)
 }
 
+// Unwrap returns the cause of the error, so that errors.Is and errors.As can match it. Ex. ErrUnknownID
 func (e *FormatError) Unwrap() error {
 	return e.cause
 }
 
+// FormatErrors is returned by DecodeModuleAllErrors when indices failed to resolve, holding a FormatError for each.
+type FormatErrors []*FormatError
+
+// Error returns the error of each FormatError, one per line.
+func (e FormatErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Is returns true if any FormatError matches target, so that errors.Is can match the cause of any. Ex. ErrUnknownID
+func (e FormatErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorWithSource is like Error, except it appends the offending line of source and a caret under Col, similar to
+// compiler diagnostics. This returns the same as Error when Line is not in source, such as when source is nil.
+//
+// Ex. Given the source "(module\n  (func $a $b)\n)" this returns the following:
+//	2:11: redundant ID $b in module.func[0]
+//	  (func $a $b)
+//	          ^
+func (e *FormatError) ErrorWithSource(source []byte) string {
+	line, ok := sourceLine(source, e.Line)
+	if !ok {
+		return e.Error()
+	}
+
+	// Pad using the same whitespace as the source, so that tabs don't shift the caret.
+	var caret strings.Builder
+	rest := line
+	for col := uint32(1); col < e.Col && len(rest) > 0; col++ {
+		r, size := utf8.DecodeRune(rest)
+		if r == '\t' {
+			caret.WriteByte('\t')
+		} else {
+			caret.WriteByte(' ')
+		}
+		rest = rest[size:]
+	}
+	caret.WriteByte('^')
+	return fmt.Sprintf("%s\n%s\n%s", e.Error(), line, caret.String())
+}
+
+// sourceLine returns the 1-based line in source, without its line terminator, or false if there is no such line.
+func sourceLine(source []byte, line uint32) ([]byte, bool) {
+	if line == 0 || len(source) == 0 {
+		return nil, false
+	}
+	for i := uint32(1); i < line; i++ {
+		n := bytes.IndexByte(source, '\n')
+		if n == -1 {
+			return nil, false
+		}
+		source = source[n+1:]
+	}
+	if n := bytes.IndexByte(source, '\n'); n != -1 {
+		source = source[:n]
+	}
+	return bytes.TrimSuffix(source, []byte{'\r'}), true
+}
+
 func unexpectedFieldName(tokenBytes []byte) error {
 	return fmt.Errorf("unexpected field: %s", tokenBytes)
 }
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/tetratelabs/wazero/internal/testing/require"
@@ -26,6 +27,54 @@ func TestFormatError_Error(t *testing.T) {
 	})
 }
 
+func TestFormatError_ErrorWithSource(t *testing.T) {
+	source := []byte("(module\r\n\t(func $a $b)\r\n  (memory) ;; ü\n)")
+	tests := []struct {
+		name     string
+		input    *FormatError
+		source   []byte
+		expected string
+	}{
+		{
+			name:     "caret under column",
+			input:    &FormatError{Line: 2, Col: 10, Context: "module.func[0]", cause: errors.New("redundant ID $b")},
+			source:   source,
+			expected: "2:10: redundant ID $b in module.func[0]\n\t(func $a $b)\n\t        ^",
+		},
+		{
+			name:     "first column",
+			input:    &FormatError{Line: 4, Col: 1, cause: errors.New("unexpected ')'")},
+			source:   source,
+			expected: "4:1: unexpected ')'\n)\n^",
+		},
+		{
+			name:     "column past end of line",
+			input:    &FormatError{Line: 3, Col: 17, cause: errors.New("unexpected EOF")},
+			source:   source,
+			expected: "3:17: unexpected EOF\n  (memory) ;; ü\n               ^",
+		},
+		{
+			name:     "no source",
+			input:    &FormatError{Line: 1, Col: 2, Context: "start", cause: errors.New("invalid token")},
+			expected: "1:2: invalid token in start",
+		},
+		{
+			name:     "line out of range",
+			input:    &FormatError{Line: 5, Col: 1, cause: errors.New("invalid token")},
+			source:   source,
+			expected: "5:1: invalid token",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.input.ErrorWithSource(tc.source))
+		})
+	}
+}
+
 func TestFormatError_Unwrap(t *testing.T) {
 	t.Run("cause", func(t *testing.T) {
 		cause := errors.New("invalid token")
@@ -47,6 +96,50 @@ func TestFormatError_Unwrap(t *testing.T) {
 	})
 }
 
+func TestFormatError_Sentinels(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected error
+	}{
+		{
+			name:     "unknown ID",
+			input:    "(module (start $main))",
+			expected: ErrUnknownID,
+		},
+		{
+			name:     "index out of range",
+			input:    "(module (import \"\" \"hello\" (func)) (start 1))",
+			expected: ErrIndexOutOfRange,
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeModule([]byte(tc.input), wasm.Features20191205, wasm.MemorySizer)
+
+			var formatErr *FormatError
+			require.True(t, errors.As(err, &formatErr))
+			require.Equal(t, "module.start", formatErr.Context)
+			require.ErrorIs(t, err, tc.expected)
+		})
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	errs := FormatErrors{
+		{Line: 1, Col: 2, Context: "module.start", cause: fmt.Errorf("%w $a", ErrUnknownID)},
+		{Line: 3, Col: 4, Context: "module.code[0].body[1]", cause: &indexOutOfRangeError{index: 5, count: 2}},
+	}
+	require.EqualError(t, errs, `1:2: unknown ID $a in module.start
+3:4: index 5 is out of range [0..1] in module.code[0].body[1]`)
+	require.ErrorIs(t, errs, ErrUnknownID)
+	require.ErrorIs(t, errs, ErrIndexOutOfRange)
+	require.False(t, errors.Is(errs[:1], ErrIndexOutOfRange))
+}
+
 func TestUnexpectedToken(t *testing.T) {
 	tests := []struct {
 		input      tokenType
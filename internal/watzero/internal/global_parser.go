@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tetratelabs/wazero/internal/wasm"
+)
+
+func newGlobalParser(globalNamespace *indexNamespace, constExprParser *constExprParser, onGlobal onGlobal) *globalParser {
+	return &globalParser{globalNamespace: globalNamespace, constExprParser: constExprParser, onGlobal: onGlobal}
+}
+
+type onGlobal func(*wasm.Global) tokenParser
+
+// globalParser parses a wasm.Global from and dispatches to onGlobal.
+//
+// Ex. `(module (global $base (mut i32) (i32.const 8)))`
+//        starts here --^                           ^
+//                          onGlobal resumes here --+
+//
+// Note: globalParser is reusable. The caller resets via begin.
+// See https://www.w3.org/TR/2019/REC-wasm-core-1-20191205/#globals%E2%91%A5
+type globalParser struct {
+	// globalNamespace is described by moduleParser.globalNamespace
+	globalNamespace *indexNamespace
+
+	// constExprParser parses the init expression of the global.
+	constExprParser *constExprParser
+
+	// onGlobal is invoked on end
+	onGlobal onGlobal
+
+	// currentGlobal is reset on begin and read onGlobal
+	currentGlobal *wasm.Global
+}
+
+// begin should be called after reaching the wasm.ExternTypeGlobalName keyword in a module field. Parsing
+// continues until onGlobal or error.
+//
+// This stage records the ID of the current global, if present, and resumes with beginType.
+//
+// Ex. A global ID is present `(global $base i32 (i32.const 8))`
+//                      records base --^     ^
+//                  beginType resumes here --+
+//
+// Ex. No global ID `(global i32 (i32.const 8))`
+//         calls beginType --^
+func (p *globalParser) begin(tok tokenType, tokenBytes []byte, line, col uint32) (tokenParser, error) {
+	p.currentGlobal = &wasm.Global{Type: &wasm.GlobalType{}}
+	if tok == tokenID { // Ex. $base
+		if _, err := p.globalNamespace.setID(tokenBytes); err != nil {
+			return nil, err
+		}
+		return p.beginType, nil
+	}
+	return p.beginType(tok, tokenBytes, line, col)
+}
+
+// beginType looks for an immutable value type, ex. "i32", or the start of a mutable one, ex. "(mut i32)".
+func (p *globalParser) beginType(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	switch tok {
+	case tokenID: // Ex.(global $base $base
+		return nil, fmt.Errorf("redundant ID %s", tokenBytes)
+	case tokenKeyword:
+		vt, err := parseValueType(tokenBytes)
+		if err != nil {
+			return nil, err
+		}
+		p.currentGlobal.Type.ValType = vt
+		return p.beginInit, nil
+	case tokenLParen:
+		return p.beginMut, nil
+	case tokenRParen:
+		return nil, errors.New("missing type")
+	default:
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+}
+
+// beginMut errs unless the field enclosing the type is "mut".
+func (p *globalParser) beginMut(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	if tok != tokenKeyword {
+		return nil, expectedField(tok)
+	}
+	if string(tokenBytes) != "mut" {
+		return nil, unexpectedFieldName(tokenBytes)
+	}
+	p.currentGlobal.Type.Mutable = true
+	return p.parseMutType, nil
+}
+
+// parseMutType records the value type of a mutable global, ex. "i32" in "(mut i32)".
+func (p *globalParser) parseMutType(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	switch tok {
+	case tokenKeyword:
+		vt, err := parseValueType(tokenBytes)
+		if err != nil {
+			return nil, err
+		}
+		p.currentGlobal.Type.ValType = vt
+		return p.parseMutEnd, nil
+	case tokenRParen:
+		return nil, errors.New("missing type")
+	default:
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+}
+
+// parseMutEnd returns beginInit when the "mut" field is closed.
+func (p *globalParser) parseMutEnd(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	if tok != tokenRParen {
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+	return p.beginInit, nil
+}
+
+// beginInit passes control to the constExprParser until the init expression is read, then returns onInit.
+func (p *globalParser) beginInit(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	switch tok {
+	case tokenLParen:
+		return p.constExprParser.begin(wasm.SectionIDGlobal, p.onInit), nil
+	case tokenRParen:
+		return nil, errors.New("missing init")
+	default:
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+}
+
+// onInit records the init expression of the current global and returns end.
+func (p *globalParser) onInit(expr *wasm.ConstantExpression) tokenParser {
+	p.currentGlobal.Init = expr
+	return p.end
+}
+
+// end increments the global namespace and calls onGlobal with the current global
+func (p *globalParser) end(tok tokenType, tokenBytes []byte, _, _ uint32) (tokenParser, error) {
+	if tok != tokenRParen {
+		return nil, unexpectedToken(tok, tokenBytes)
+	}
+	p.globalNamespace.count++
+	return p.onGlobal(p.currentGlobal), nil
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+	"github.com/tetratelabs/wazero/internal/wasm"
+)
+
+func TestGlobalParser(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		expected   *wasm.Global
+		expectedID string
+	}{
+		{
+			name:  "i32",
+			input: "(global i32 (i32.const 8))",
+			expected: &wasm.Global{
+				Type: &wasm.GlobalType{ValType: wasm.ValueTypeI32},
+				Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeI32Const, Data: []byte{8}},
+			},
+		},
+		{
+			name:  "mut i64",
+			input: "(global (mut i64) (i64.const 128))",
+			expected: &wasm.Global{
+				Type: &wasm.GlobalType{ValType: wasm.ValueTypeI64, Mutable: true},
+				Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeI64Const, Data: []byte{0x80, 0x01}},
+			},
+		},
+		{
+			name:  "f32",
+			input: "(global f32 (f32.const 1))",
+			expected: &wasm.Global{
+				Type: &wasm.GlobalType{ValType: wasm.ValueTypeF32},
+				Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeF32Const, Data: []byte{0, 0, 0x80, 0x3f}},
+			},
+		},
+		{
+			name:  "f64 ID",
+			input: "(global $pi f64 (f64.const 0))",
+			expected: &wasm.Global{
+				Type: &wasm.GlobalType{ValType: wasm.ValueTypeF64},
+				Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeF64Const, Data: []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+			},
+			expectedID: "pi",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			globalNamespace := newIndexNamespace(func(sectionID wasm.SectionID) uint32 {
+				require.Equal(t, wasm.SectionIDGlobal, sectionID)
+				return 0
+			})
+			parsed, tp, err := parseGlobal(globalNamespace, tc.input)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, parsed)
+			require.Equal(t, uint32(1), tp.globalNamespace.count)
+			if tc.expectedID == "" {
+				require.Zero(t, len(tp.globalNamespace.idToIdx), "expected no indices")
+			} else {
+				require.Equal(t, map[string]wasm.Index{tc.expectedID: wasm.Index(0)}, tp.globalNamespace.idToIdx)
+			}
+		})
+	}
+}
+
+func TestGlobalParser_Errors(t *testing.T) {
+	tests := []struct{ name, input, expectedErr string }{
+		{
+			name:        "missing type",
+			input:       "(global)",
+			expectedErr: "missing type",
+		},
+		{
+			name:        "redundant ID",
+			input:       "(global $a $b i32 (i32.const 0))",
+			expectedErr: "redundant ID $b",
+		},
+		{
+			name:        "unknown type",
+			input:       "(global i8 (i32.const 0))",
+			expectedErr: "unknown type: i8",
+		},
+		{
+			name:        "not mut",
+			input:       "(global (const i32) (i32.const 0))",
+			expectedErr: "unexpected field: const",
+		},
+		{
+			name:        "missing init",
+			input:       "(global i32)",
+			expectedErr: "missing init",
+		},
+		{
+			name:        "unsupported instruction",
+			input:       "(global i32 (i32.add))",
+			expectedErr: "unsupported instruction in constant expression: i32.add",
+		},
+		{
+			name:        "redundant init",
+			input:       "(global i32 (i32.const 0) (i32.const 1))",
+			expectedErr: "unexpected '('",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			globalNamespace := newIndexNamespace(func(sectionID wasm.SectionID) uint32 {
+				require.Equal(t, wasm.SectionIDGlobal, sectionID)
+				return 0
+			})
+			parsed, _, err := parseGlobal(globalNamespace, tc.input)
+			require.EqualError(t, err, tc.expectedErr)
+			require.Nil(t, parsed)
+		})
+	}
+}
+
+func parseGlobal(globalNamespace *indexNamespace, input string) (*wasm.Global, *globalParser, error) {
+	var parsed *wasm.Global
+	var setFunc onGlobal = func(g *wasm.Global) tokenParser {
+		parsed = g
+		return parseErr
+	}
+	tp := newGlobalParser(globalNamespace, newConstExprParser(globalNamespace), setFunc)
+	// globalParser starts after the '(global', so we need to eat it first!
+	_, _, err := lex(skipTokens(2, tp.begin), []byte(input))
+	return parsed, tp, err
+}
@@ -3,6 +3,7 @@ package internal
 import (
 	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/tetratelabs/wazero/internal/wasm"
 )
@@ -36,11 +37,86 @@ type indexNamespace struct {
 	//
 	// See https://www.w3.org/TR/2019/REC-wasm-core-1-20191205/#text-context
 	idToIdx map[string]wasm.Index
+
+	// referencedIDs are the symbolic identifiers used by parseIndex, without the '$' prefix. Ex. "main"
+	referencedIDs map[string]struct{}
+
+	// referencedIndices are the numeric indices used by parseIndex. Ex. 2
+	referencedIndices map[wasm.Index]struct{}
 }
 
-// setID ensures the given tokenID is unique within this context and raises an error if not. The resulting mapping is
-// stripped of the leading '$' to match other tools, as described in stripDollar.
+// Count returns the count of items in this namespace.
+func (i *indexNamespace) Count() uint32 {
+	return i.count
+}
+
+// IDs returns a copy of the association of symbolic IDs, without the '$' prefix, to their numeric index.
+func (i *indexNamespace) IDs() map[string]wasm.Index {
+	ret := make(map[string]wasm.Index, len(i.idToIdx))
+	for id, idx := range i.idToIdx {
+		ret[id] = idx
+	}
+	return ret
+}
+
+// UnresolvedReference is a use of an index, which is still unknown or out of range in its namespace.
+type UnresolvedReference struct {
+	// Section is the section of the field with the reference, ex. wasm.SectionIDCode for a call instruction.
+	Section wasm.SectionID
+	// Idx is the position of the field in Section.
+	Idx wasm.Index
+	// ID is the symbolic identifier referenced, without the '$' prefix, or empty if it was a numeric index.
+	ID string
+	// TargetIdx is the numeric index referenced. This is only valid when ID is empty.
+	TargetIdx wasm.Index
+	// Line is the line in the source where the index was referenced.
+	Line uint32
+	// Col is the column on the line where the index was referenced.
+	Col uint32
+}
+
+// Unresolved returns the references that are still unknown or out of range, in the order they were parsed. This is
+// useful after a parse attempt, to report all indices referenced but undefined, instead of only the first error.
+func (i *indexNamespace) Unresolved() []UnresolvedReference {
+	var ret []UnresolvedReference
+	for _, u := range i.unresolvedIndices {
+		if u.targetID == "" {
+			if u.targetIdx < i.count {
+				continue
+			}
+		} else if _, ok := i.idToIdx[u.targetID]; ok {
+			continue
+		}
+		ret = append(ret, UnresolvedReference{
+			Section: u.section, Idx: u.idx, ID: u.targetID, TargetIdx: u.targetIdx, Line: u.line, Col: u.col,
+		})
+	}
+	return ret
+}
+
+// UnusedIDs returns the symbolic IDs, without the '$' prefix, defined but never referenced either by ID or by their
+// numeric index, sorted by index.
+func (i *indexNamespace) UnusedIDs() []string {
+	var ret []string
+	for id, idx := range i.idToIdx {
+		if _, ok := i.referencedIDs[id]; ok {
+			continue
+		}
+		if _, ok := i.referencedIndices[idx]; ok {
+			continue
+		}
+		ret = append(ret, id)
+	}
+	sort.Slice(ret, func(a, b int) bool { return i.idToIdx[ret[a]] < i.idToIdx[ret[b]] })
+	return ret
+}
+
+// setID ensures the given tokenID is valid and unique within this context and raises an error if not. The resulting
+// mapping is stripped of the leading '$' to match other tools, as described in stripDollar.
 func (i *indexNamespace) setID(idToken []byte) (string, error) {
+	if err := requireValidID(idToken); err != nil {
+		return "", err
+	}
 	name, err := i.requireNoID(idToken)
 	if err != nil {
 		return name, err
@@ -49,6 +125,22 @@ func (i *indexNamespace) setID(idToken []byte) (string, error) {
 	return name, nil
 }
 
+// requireValidID returns an error unless the tokenID is a '$' followed by at least one idChar. Notably, this prevents
+// a bare '$' from mapping the empty string, which is indistinguishable from an unnamed index.
+//
+// See https://www.w3.org/TR/2019/REC-wasm-core-1-20191205/#text-id
+func requireValidID(idToken []byte) error {
+	if len(idToken) < 2 {
+		return fmt.Errorf("empty ID %s", idToken)
+	}
+	for _, ch := range stripDollar(idToken) {
+		if !idChar[ch] {
+			return fmt.Errorf("invalid ID %s", idToken)
+		}
+	}
+	return nil
+}
+
 // hasID checks to see if this tokenID is unique within this context and returns an error. The result string is
 // stripped of the leading '$' to match other tools, as described in stripDollar.
 func (i *indexNamespace) requireNoID(idToken []byte) (string, error) {
@@ -68,6 +160,10 @@ func (i *indexNamespace) parseIndex(section wasm.SectionID, bodyOffset uint32, t
 		} else {
 			targetIdx = i
 		}
+		if i.referencedIndices == nil {
+			i.referencedIndices = map[wasm.Index]struct{}{}
+		}
+		i.referencedIndices[targetIdx] = struct{}{}
 
 		if targetIdx < i.count {
 			resolved = true
@@ -76,6 +172,10 @@ func (i *indexNamespace) parseIndex(section wasm.SectionID, bodyOffset uint32, t
 		}
 	case tokenID: // Ex. $main
 		targetID := string(stripDollar(tokenBytes))
+		if i.referencedIDs == nil {
+			i.referencedIDs = map[string]struct{}{}
+		}
+		i.referencedIDs[targetID] = struct{}{}
 		if targetIdx, resolved = i.idToIdx[targetID]; !resolved {
 			i.recordUnresolved(section, bodyOffset, targetID, line, col)
 		}
@@ -117,7 +217,9 @@ type unresolvedIndex struct {
 	// idx is slice position in the section
 	idx wasm.Index
 
-	// bodyOffset is only used when section is wasm.SectionIDCode and identifies the offset in wasm.Code Body.
+	// bodyOffset is only used when section is wasm.SectionIDCode and identifies the offset in wasm.Code Body, or when
+	// section is wasm.SectionIDElement and identifies the position in wasm.ElementSegment Init. This is constExprOffset
+	// when the index is in a constant expression instead.
 	bodyOffset uint32
 
 	// id is set when its corresponding token is tokenID to a symbolic identifier index. Ex. main
@@ -165,19 +267,33 @@ func (i *indexNamespace) requireIndex(id string) (wasm.Index, error) {
 	if numeric, ok := i.idToIdx[id]; ok {
 		return numeric, nil
 	}
-	return 0, fmt.Errorf("unknown ID $%s", id) // re-attach '$' as that was in the text format!
+	return 0, fmt.Errorf("%w $%s", ErrUnknownID, id) // re-attach '$' as that was in the text format!
 }
 
 func requireIndexInRange(index wasm.Index, count uint32) error {
 	if index >= count {
-		if count == 0 {
-			return fmt.Errorf("index %d is not in range due to empty namespace", index)
-		}
-		return fmt.Errorf("index %d is out of range [0..%d]", index, count-1)
+		return &indexOutOfRangeError{index: index, count: count}
 	}
 	return nil
 }
 
+// indexOutOfRangeError is returned by requireIndexInRange and wraps ErrIndexOutOfRange.
+type indexOutOfRangeError struct {
+	index wasm.Index
+	count uint32
+}
+
+func (e *indexOutOfRangeError) Error() string {
+	if e.count == 0 {
+		return fmt.Sprintf("index %d is not in range due to empty namespace", e.index)
+	}
+	return fmt.Sprintf("index %d is out of range [0..%d]", e.index, e.count-1)
+}
+
+func (e *indexOutOfRangeError) Unwrap() error {
+	return ErrIndexOutOfRange
+}
+
 func (d *unresolvedIndex) formatErr(err error) error {
 	// This check allows us to defer Sprintf until there's an error, and reuse the same logic for non-indexed types.
 	var context string
@@ -188,6 +304,16 @@ func (d *unresolvedIndex) formatErr(err error) error {
 		context = fmt.Sprintf("module.exports[%d].func", d.idx)
 	case wasm.SectionIDStart:
 		context = "module.start"
+	case wasm.SectionIDGlobal:
+		context = fmt.Sprintf("module.global[%d].init", d.idx)
+	case wasm.SectionIDElement:
+		if d.bodyOffset == constExprOffset {
+			context = fmt.Sprintf("module.elem[%d].offset", d.idx)
+		} else {
+			context = fmt.Sprintf("module.elem[%d].init[%d]", d.idx, d.bodyOffset)
+		}
+	case wasm.SectionIDData:
+		context = fmt.Sprintf("module.data[%d].offset", d.idx)
 	}
 	return &FormatError{d.line, d.col, context, err}
 }
@@ -26,6 +26,16 @@ func TestIndexNamespace_SetId(t *testing.T) {
 		require.EqualError(t, err, "duplicate ID $x")
 		require.Equal(t, map[string]wasm.Index{"x": wasm.Index(0)}, in.idToIdx) // no change
 	})
+	t.Run("set empty fails", func(t *testing.T) {
+		_, err := in.setID([]byte("$"))
+		require.EqualError(t, err, "empty ID $")
+		require.Equal(t, map[string]wasm.Index{"x": wasm.Index(0)}, in.idToIdx) // no change
+	})
+	t.Run("set invalid fails", func(t *testing.T) {
+		_, err := in.setID([]byte("$a b"))
+		require.EqualError(t, err, "invalid ID $a b")
+		require.Equal(t, map[string]wasm.Index{"x": wasm.Index(0)}, in.idToIdx) // no change
+	})
 }
 
 func TestIndexNamespace_Resolve(t *testing.T) {
@@ -108,6 +118,7 @@ func TestIndexNamespace_RequireIndex(t *testing.T) {
 				require.Equal(t, tc.expected, index)
 			} else {
 				require.EqualError(t, err, tc.expectedErr)
+				require.ErrorIs(t, err, ErrUnknownID)
 			}
 		})
 	}
@@ -147,9 +158,55 @@ func TestRequireIndexInRange(t *testing.T) {
 			err := requireIndexInRange(tc.index, tc.count)
 			if tc.expectedErr != "" {
 				require.EqualError(t, err, tc.expectedErr)
+				require.ErrorIs(t, err, ErrIndexOutOfRange)
 			} else {
 				require.Nil(t, err)
 			}
 		})
 	}
 }
+
+func TestIndexNamespace_Introspection(t *testing.T) {
+	in := newIndexNamespace(func(sectionID wasm.SectionID) uint32 {
+		require.Equal(t, wasm.SectionIDCode, sectionID)
+		return 1
+	})
+	for _, id := range []string{"$a", "$b", "$c"} {
+		_, err := in.setID([]byte(id))
+		require.NoError(t, err)
+		in.count++
+	}
+
+	for _, ref := range []struct {
+		tok        tokenType
+		tokenBytes string
+	}{
+		{tokenID, "$a"},
+		{tokenUN, "1"},
+		{tokenID, "$missing"},
+		{tokenUN, "5"},
+	} {
+		_, _, err := in.parseIndex(wasm.SectionIDCode, 2, ref.tok, []byte(ref.tokenBytes), 3, 4)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, uint32(3), in.Count())
+	require.Equal(t, map[string]wasm.Index{"a": 0, "b": 1, "c": 2}, in.IDs())
+	require.Equal(t, []UnresolvedReference{
+		{Section: wasm.SectionIDCode, Idx: 1, ID: "missing", Line: 3, Col: 4},
+		{Section: wasm.SectionIDCode, Idx: 1, TargetIdx: 5, Line: 3, Col: 4},
+	}, in.Unresolved())
+	require.Equal(t, []string{"c"}, in.UnusedIDs())
+
+	// Defining the missing ID later resolves it.
+	_, err := in.setID([]byte("$missing"))
+	require.NoError(t, err)
+	in.count++
+	require.Equal(t, []UnresolvedReference{
+		{Section: wasm.SectionIDCode, Idx: 1, TargetIdx: 5, Line: 3, Col: 4},
+	}, in.Unresolved())
+
+	// IDs returns a copy.
+	in.IDs()["a"] = 10
+	require.Equal(t, wasm.Index(0), in.IDs()["a"])
+}
@@ -1,8 +1,10 @@
 package internal
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"unicode/utf8"
 )
 
@@ -36,21 +38,109 @@ var (
 // * col is the UTF-8 column number of the error or EOF
 // * err is an error invoking the parser, dangling block comments or unexpected characters.
 func lex(parser tokenParser, source []byte) (line, col uint32, err error) {
-	// i is the source index to begin reading, inclusive.
-	i := 0
-	// end is the source index to stop reading, exclusive.
-	end := len(source)
-	line = 1
-	col = 1
+	return lexMaxDepth(parser, source, DefaultMaxDepth)
+}
+
+// DefaultMaxDepth is the maximum nesting depth of parens, e.g. 2 for "(module (func))", unless overridden by
+// DecodeModuleMaxDepth. This is generous for any module written by hand or generated, but finite, so that an untrusted
+// source can't nest without bound.
+const DefaultMaxDepth = 10000
+
+// lexMaxDepth is like lex, except a '(' nested deeper than maxDepth is an error.
+func lexMaxDepth(parser tokenParser, source []byte, maxDepth int) (line, col uint32, err error) {
+	l := newLexer(parser)
+	l.maxParenDepth = maxDepth
+	if err = l.lex(source); err == nil {
+		err = l.end()
+	}
+	return l.line, l.col, err
+}
+
+// lexReaderBufferSize is the initial size of the buffer lexReader reads the source into.
+const lexReaderBufferSize = 64 * 1024
+
+// lexReader is like lex, except it reads the source from r, so that a large source needn't be loaded whole. The source
+// is read into a buffer of bufferSize bytes, which grows to fit the longest line, and lexed a run of whole lines at a
+// time, as no token spans lines. Parsers must not retain the tokenBytes passed, as the buffer is reused.
+//
+// Note: A string token containing an unescaped '\n', which the specification disallows, is an error here even though
+// lex accepts it.
+func lexReader(parser tokenParser, r io.Reader, bufferSize int) (line, col uint32, err error) {
+	l := newLexer(parser)
+	buf := make([]byte, bufferSize)
+	n := 0 // the count of bytes in buf not yet lexed, which are a partial line.
+	for {
+		read, readErr := r.Read(buf[n:])
+		n += read
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return l.line, l.col, readErr
+		}
+
+		if last := bytes.LastIndexByte(buf[:n], '\n'); last >= 0 {
+			if err = l.lex(buf[:last+1]); err != nil {
+				return l.line, l.col, err
+			}
+			n = copy(buf, buf[last+1:n])
+		} else if n == len(buf) { // grow to fit the line.
+			buf = append(buf, make([]byte, len(buf))...)
+		}
+	}
+
+	if err = l.lex(buf[:n]); err == nil {
+		err = l.end()
+	}
+	return l.line, l.col, err
+}
+
+// lexer holds the state of lexing a source, so that it can be lexed in runs of whole lines.
+type lexer struct {
+	// parser is the parser of the next token.
+	parser tokenParser
+
+	// line and col are the position after the last run lexed, or of the error.
+	line, col uint32
 
 	// Web assembly expressions are grouped by parenthesis, even the minimal example "(module)". We track nesting level
 	// to help report problems instead of bubbling to the parser layer.
-	parenDepth := 0
+	parenDepth int
+	// maxParenDepth is the maximum parenDepth, past which lexing fails. See DefaultMaxDepth
+	maxParenDepth int
 
 	// Block comments, ex. (; comment ;), can span multiple lines and also nest, ex. (; one (; two ;) ).
-	// There may be no block comments, but we declare the variable that tracks them here, as it is more efficient vs
-	// inline processing.
-	blockCommentDepth := 0
+	blockCommentDepth int
+}
+
+func newLexer(parser tokenParser) *lexer {
+	return &lexer{parser: parser, line: 1, col: 1, maxParenDepth: DefaultMaxDepth}
+}
+
+// end returns an error if the source ended in a block comment or before closing each paren.
+func (l *lexer) end() error {
+	if l.blockCommentDepth > 0 {
+		return errors.New("expected block comment end ';)', but reached end of input")
+	}
+	if l.parenDepth > 0 {
+		return errors.New("expected ')', but reached end of input")
+	}
+	return nil
+}
+
+// lex invokes the parser function for each token of source, which starts at the beginning of a line. This updates
+// the state of the lexer, including the position of the error, if any.
+func (l *lexer) lex(source []byte) (err error) {
+	parser, line, col := l.parser, l.line, uint32(1)
+	parenDepth, blockCommentDepth, maxParenDepth := l.parenDepth, l.blockCommentDepth, l.maxParenDepth
+	defer func() {
+		l.parser, l.line, l.col = parser, line, col
+		l.parenDepth, l.blockCommentDepth = parenDepth, blockCommentDepth
+	}()
+
+	// i is the source index to begin reading, inclusive.
+	i := 0
+	// end is the source index to stop reading, exclusive.
+	end := len(source)
 
 	for ; i < end; i, col = i+1, col+1 {
 		b1 := source[i]
@@ -72,7 +162,7 @@ func lex(parser tokenParser, source []byte) (line, col uint32, err error) {
 		case '(':
 			peek := i + 1
 			if peek == end { // invalid regardless of block comment or not. nothing opens at EOF!
-				return line, col, errors.New("found '(' at end of input")
+				return errors.New("found '(' at end of input")
 			}
 			if source[peek] == ';' { // next block comment
 				i = peek // continue after "(;"
@@ -80,8 +170,11 @@ func lex(parser tokenParser, source []byte) (line, col uint32, err error) {
 				blockCommentDepth++
 				continue
 			} else if blockCommentDepth == 0 { // Fast path left paren token at the expense of code duplication.
+				if parenDepth == maxParenDepth {
+					return fmt.Errorf("found '(' nested deeper than the maximum depth of %d", maxParenDepth)
+				}
 				if parser, err = parser(tokenLParen, constantLParen, line, col); err != nil {
-					return line, col, err
+					return err
 				}
 				parenDepth++
 				continue
@@ -89,10 +182,10 @@ func lex(parser tokenParser, source []byte) (line, col uint32, err error) {
 		case ')':
 			if blockCommentDepth == 0 { // Fast path right paren token at the expense of code duplication.
 				if parenDepth == 0 {
-					return line, col, errors.New("found ')' before '('")
+					return errors.New("found ')' before '('")
 				}
 				if parser, err = parser(tokenRParen, constantRParen, line, col); err != nil {
-					return line, col, err
+					return err
 				}
 				parenDepth--
 				continue
@@ -123,7 +216,7 @@ func lex(parser tokenParser, source []byte) (line, col uint32, err error) {
 						col++
 						s := utf8Size[peeked] // While unlikely, it is possible the byte peeked is invalid unicode
 						if s == 0 {
-							return line, col, fmt.Errorf("found an invalid byte in line comment: 0x%x", peeked)
+							return fmt.Errorf("found an invalid byte in line comment: 0x%x", peeked)
 						}
 						peek = peek + s
 					}
@@ -139,7 +232,7 @@ func lex(parser tokenParser, source []byte) (line, col uint32, err error) {
 		if blockCommentDepth > 0 {
 			s := utf8Size[b1] // While unlikely, it is possible the current byte is invalid unicode
 			if s == 0 {
-				return line, col, fmt.Errorf("found an invalid byte in block comment: 0x%x", b1)
+				return fmt.Errorf("found an invalid byte in block comment: 0x%x", b1)
 			}
 			i = i + s - 1 // -1 because for loop will + 1: This optimizes speed of tokenization over block comments.
 			continue
@@ -162,12 +255,13 @@ func lex(parser tokenParser, source []byte) (line, col uint32, err error) {
 		switch tok {
 		// case tokenLParen, tokenRParen: // min/max 1 byte
 		case tokenSN: // min 2 bytes for sign and number; ambiguous: could be tokenFN
-			return line, c, errors.New("TODO: signed")
+			col = c
+			return errors.New("TODO: signed")
 		case tokenUN: // min 1 byte; ambiguous when >=3 bytes as could be tokenFN
 			if peek < end {
 				peeked := source[peek]
 				if peeked == 'x' {
-					return line, col, errors.New("TODO: hex")
+					return errors.New("TODO: hex")
 				}
 			Number:
 				// Start after the number and run until the end. Note all allowed characters are single byte.
@@ -195,13 +289,13 @@ func lex(parser tokenParser, source []byte) (line, col uint32, err error) {
 				col++
 				s := utf8Size[peeked] // While unlikely, it is possible the current byte is invalid unicode
 				if s == 0 {
-					return line, col, fmt.Errorf("found an invalid byte in string token: 0x%x", peeked)
+					return fmt.Errorf("found an invalid byte in string token: 0x%x", peeked)
 				}
 				peek = peek + s
 			}
 
 			if !hitQuote {
-				return line, col, errors.New("expected end quote")
+				return errors.New("expected end quote")
 			}
 
 			i = peek
@@ -221,9 +315,9 @@ func lex(parser tokenParser, source []byte) (line, col uint32, err error) {
 		default:
 			if b1 > 0x7F { // non-ASCII
 				r, _ := utf8.DecodeRune(source[line:])
-				return line, col, fmt.Errorf("expected an ASCII character, not %s", string(r))
+				return fmt.Errorf("expected an ASCII character, not %s", string(r))
 			}
-			return line, col, fmt.Errorf("unexpected character %s", string(b1))
+			return fmt.Errorf("unexpected character %s", string(b1))
 		}
 
 		// Unsigned floating-point constants for infinity or canonical NaN (not a number) clash with keyword
@@ -233,17 +327,12 @@ func lex(parser tokenParser, source []byte) (line, col uint32, err error) {
 		// TODO: Ex. inf nan nan:0xfffffffffffff or nan:0x400000
 
 		if parser, err = parser(tok, source[b:peek], line, c); err != nil {
-			return line, c, err
+			col = c
+			return err
 		}
 	}
 
-	if blockCommentDepth > 0 {
-		return line, col, errors.New("expected block comment end ';)', but reached end of input")
-	}
-	if parenDepth > 0 {
-		return line, col, errors.New("expected ')', but reached end of input")
-	}
-	return line, col, nil
+	return nil
 }
 
 // utf8Size returns the size of the UTF-8 rune based on its first byte, or zero.
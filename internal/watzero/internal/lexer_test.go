@@ -1,8 +1,12 @@
 package internal
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/tetratelabs/wazero/internal/testing/require"
 )
@@ -187,6 +191,7 @@ func TestLex(t *testing.T) {
 		name     string
 		input    string
 		expected []*token
+		lexOnly  bool
 	}{
 		{
 			name:  "empty",
@@ -361,6 +366,7 @@ func TestLex(t *testing.T) {
 			name:     "string inside tokens with newline",
 			input:    "(\"\n\")", // TODO newline char isn't actually allowed unless escaped!
 			expected: []*token{{tokenLParen, 1, 1, "("}, {tokenString, 1, 2, "\"\n\""}, {tokenRParen, 1, 5, ")"}},
+			lexOnly:  true, // lexReader splits the source after the newline.
 		},
 		{
 			name:     "unsigned shortest - EOL",
@@ -430,6 +436,9 @@ func TestLex(t *testing.T) {
 		tc := tt
 		t.Run(tc.name, func(t *testing.T) {
 			require.Equal(t, tc.expected, lexTokens(t, tc.input))
+			if !tc.lexOnly {
+				require.Equal(t, tc.expected, lexReaderTokens(t, tc.input))
+			}
 		})
 	}
 }
@@ -585,10 +594,94 @@ func TestLex_Errors(t *testing.T) {
 			require.Equal(t, tc.expectedLine, line)
 			require.Equal(t, tc.expectedCol, col)
 			require.EqualError(t, err, tc.expectedErr)
+
+			line, col, err = lexReader(parser, iotest.OneByteReader(bytes.NewReader(tc.input)), 1)
+			require.Equal(t, tc.expectedLine, line)
+			require.Equal(t, tc.expectedCol, col)
+			require.EqualError(t, err, tc.expectedErr)
 		})
 	}
 }
 
+// TestLexReader_Large ensures tokens and their positions are the same as lex across many buffer refills.
+func TestLexReader_Large(t *testing.T) {
+	var source strings.Builder
+	source.WriteString("(module\n")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&source, "  (func $f%d (param i32) (result i32) ;; function %d\n", i, i)
+		fmt.Fprintf(&source, "    (; 関数 (; %d ;) ;) local.get 0 i32.const %d i32.add)\n", i, i)
+	}
+	source.WriteString(")")
+	expected := lexTokens(t, source.String())
+
+	for _, bufferSize := range []int{1, 7, lexReaderBufferSize} {
+		p := &collectTokenParser{}
+		line, col, err := lexReader(p.parse, strings.NewReader(source.String()), bufferSize)
+		require.NoError(t, err, "%d:%d: %s", line, col, err)
+		require.Equal(t, uint32(20002), line)
+		require.Equal(t, uint32(2), col)
+		require.Equal(t, expected, p.tokens)
+	}
+
+	t.Run("error position", func(t *testing.T) {
+		invalid := source.String() + "\n  ;; end\n  {"
+		expectedLine, expectedCol, _ := lex(parseNoop, []byte(invalid))
+		line, col, err := lexReader(parseNoop, strings.NewReader(invalid), 7)
+		require.Equal(t, uint32(20004), expectedLine)
+		require.Equal(t, uint32(3), expectedCol)
+		require.Equal(t, expectedLine, line)
+		require.Equal(t, expectedCol, col)
+		require.EqualError(t, err, "unexpected character {")
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		readErr := errors.New("ice cream")
+		line, col, err := lexReader(parseNoop, iotest.TimeoutReader(strings.NewReader(source.String())), 7)
+		require.Equal(t, iotest.ErrTimeout, err)
+		require.Equal(t, uint32(1), line)
+		require.Equal(t, uint32(1), col)
+
+		_, _, err = lexReader(parseNoop, iotest.ErrReader(readErr), 7)
+		require.Equal(t, readErr, err)
+	})
+}
+
+func TestLex_MaxDepth(t *testing.T) {
+	nested := func(depth int) string {
+		return strings.Repeat("(", depth) + strings.Repeat(")", depth)
+	}
+
+	t.Run("default", func(t *testing.T) {
+		_, _, err := lex(parseNoop, []byte(nested(DefaultMaxDepth)))
+		require.NoError(t, err)
+
+		line, col, err := lex(parseNoop, []byte(nested(DefaultMaxDepth+1)))
+		require.Equal(t, uint32(1), line)
+		require.Equal(t, uint32(DefaultMaxDepth+1), col)
+		require.EqualError(t, err, fmt.Sprintf("found '(' nested deeper than the maximum depth of %d", DefaultMaxDepth))
+
+		line, col, err = lexReader(parseNoop, strings.NewReader(nested(DefaultMaxDepth+1)), 7)
+		require.Equal(t, uint32(1), line)
+		require.Equal(t, uint32(DefaultMaxDepth+1), col)
+		require.EqualError(t, err, fmt.Sprintf("found '(' nested deeper than the maximum depth of %d", DefaultMaxDepth))
+	})
+
+	t.Run("override", func(t *testing.T) {
+		_, _, err := lexMaxDepth(parseNoop, []byte("(()) (())"), 2)
+		require.NoError(t, err)
+
+		line, col, err := lexMaxDepth(parseNoop, []byte("(())\n (((\n)))"), 2)
+		require.Equal(t, uint32(2), line)
+		require.Equal(t, uint32(4), col)
+		require.EqualError(t, err, "found '(' nested deeper than the maximum depth of 2")
+	})
+
+	t.Run("block comments don't nest parens", func(t *testing.T) {
+		_, _, err := lexMaxDepth(parseNoop, []byte("(( (; ((( ;) ))"), 2)
+		require.NoError(t, err)
+	})
+}
+
 func lexTokens(t *testing.T, input string) []*token {
 	p := &collectTokenParser{}
 	line, col, err := lex(p.parse, []byte(input))
@@ -596,6 +689,14 @@ func lexTokens(t *testing.T, input string) []*token {
 	return p.tokens
 }
 
+// lexReaderTokens is like lexTokens, except it reads the input a byte at a time with lexReader.
+func lexReaderTokens(t *testing.T, input string) []*token {
+	p := &collectTokenParser{}
+	line, col, err := lexReader(p.parse, iotest.OneByteReader(strings.NewReader(input)), 1)
+	require.NoError(t, err, "%d:%d: %s", line, col, err)
+	return p.tokens
+}
+
 type errorOnTokenParser struct{ tok tokenType }
 
 func (e *errorOnTokenParser) parse(tok tokenType, tokenBytes []byte, line, col uint32) (tokenParser, error) {
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"io"
+	"sync"
+
+	"github.com/tetratelabs/wazero/internal/wasm"
+)
+
+// ParseProgress is how far a parse got, as of the start of the module field being parsed.
+type ParseProgress struct {
+	// Section is the section of the module field being parsed, e.g. wasm.SectionIDFunction for a "func". This is
+	// wasm.SectionIDCustom until the first module field.
+	Section wasm.SectionID
+
+	// Index is the index of the module field in its Section, which is also the count of those parsed before it.
+	//
+	// Note: An import counts towards wasm.SectionIDImport, whatever it imports, as in the binary format.
+	Index uint32
+
+	// Line is the line in the source of the module field being parsed, starting at one.
+	Line uint32
+
+	// BytesRead is the count of bytes read from the source. This is only counted by DecodeModuleReaderProgress, as the
+	// size of a source in memory is known, and can be compared against Line instead.
+	BytesRead uint64
+}
+
+// Progress is updated by the parser as it goes, so that a tool can render the progress of a long parse, e.g. by
+// polling Load from another goroutine. It is updated once per module field, so is too coarse to locate an error: see
+// FormatError for that.
+//
+// Note: The zero value is ready to use, and is safe for concurrent use.
+type Progress struct {
+	mux      sync.Mutex
+	progress ParseProgress
+}
+
+// Load returns how far the parse got.
+func (p *Progress) Load() ParseProgress {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.progress
+}
+
+// beginField records the start of a module field at the given line.
+func (p *Progress) beginField(section wasm.SectionID, index, line uint32) {
+	p.mux.Lock()
+	p.progress.Section, p.progress.Index, p.progress.Line = section, index, line
+	p.mux.Unlock()
+}
+
+// addBytesRead adds n to the count of bytes read from the source.
+func (p *Progress) addBytesRead(n int) {
+	p.mux.Lock()
+	p.progress.BytesRead += uint64(n)
+	p.mux.Unlock()
+}
+
+// progressReader adds the count of bytes read from r to progress.
+type progressReader struct {
+	r        io.Reader
+	progress *Progress
+}
+
+// Read implements io.Reader
+func (r *progressReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.progress.addBytesRead(n)
+	return
+}
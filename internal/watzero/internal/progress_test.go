@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/tetratelabs/wazero/internal/testing/require"
+	"github.com/tetratelabs/wazero/internal/wasm"
+)
+
+// observingReader records each distinct Progress seen before reading, as a tool polling it during the parse would.
+type observingReader struct {
+	r        io.Reader
+	progress *Progress
+	observed []ParseProgress
+}
+
+// Read implements io.Reader
+func (o *observingReader) Read(p []byte) (int, error) {
+	current := o.progress.Load()
+	current.BytesRead = 0 // as it changes each read.
+	if last := len(o.observed) - 1; last < 0 || o.observed[last] != current {
+		o.observed = append(o.observed, current)
+	}
+	return o.r.Read(p)
+}
+
+func TestDecodeModuleReaderProgress(t *testing.T) {
+	source := `(module
+  (type (func))
+  (import "a" "b" (func))
+  (func)
+  (func)
+  (memory 1)
+  (global i32 (i32.const 0))
+  (export "f" (func 1))
+  (start 1)
+  (data (i32.const 0) "x")
+)`
+	progress := &Progress{}
+	r := &observingReader{r: iotest.OneByteReader(strings.NewReader(source)), progress: progress}
+	_, err := DecodeModuleReaderProgress(r, wasm.Features20220419, wasm.MemorySizer, progress)
+	require.NoError(t, err)
+
+	require.Equal(t, []ParseProgress{
+		{},
+		{Section: wasm.SectionIDType, Index: 0, Line: 2},
+		{Section: wasm.SectionIDImport, Index: 0, Line: 3},
+		{Section: wasm.SectionIDFunction, Index: 0, Line: 4},
+		{Section: wasm.SectionIDFunction, Index: 1, Line: 5},
+		{Section: wasm.SectionIDMemory, Index: 0, Line: 6},
+		{Section: wasm.SectionIDGlobal, Index: 0, Line: 7},
+		{Section: wasm.SectionIDExport, Index: 0, Line: 8},
+		{Section: wasm.SectionIDStart, Index: 0, Line: 9},
+		{Section: wasm.SectionIDData, Index: 0, Line: 10},
+	}, r.observed)
+
+	// Once done, the progress remains at the last module field, and all of the source was read.
+	require.Equal(t, ParseProgress{
+		Section:   wasm.SectionIDData,
+		Line:      10,
+		BytesRead: uint64(len(source)),
+	}, progress.Load())
+}
+
+func TestDecodeModuleReaderProgress_Error(t *testing.T) {
+	source := "(module\n(func)\n(func\ni32.nope))"
+	progress := &Progress{}
+	_, err := DecodeModuleReaderProgress(strings.NewReader(source), wasm.Features20220419, wasm.MemorySizer, progress)
+	require.EqualError(t, err, "4:1: unsupported instruction: i32.nope in module.func[1]")
+
+	// The progress is of the field that failed, as is the context of the error.
+	require.Equal(t, ParseProgress{Section: wasm.SectionIDFunction, Index: 1, Line: 3, BytesRead: uint64(len(source))}, progress.Load())
+}
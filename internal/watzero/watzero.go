@@ -1,6 +1,8 @@
 package watzero
 
 import (
+	"io"
+
 	internalwasm "github.com/tetratelabs/wazero/internal/wasm"
 	"github.com/tetratelabs/wazero/internal/wasm/binary"
 	"github.com/tetratelabs/wazero/internal/watzero/internal"
@@ -21,3 +23,43 @@ func Wat2Wasm(wat string) ([]byte, error) {
 		return binary.EncodeModule(m), nil
 	}
 }
+
+// DefaultMaxDepth is the maximum nesting depth of parens Wat2Wasm allows, e.g. 2 for "(module (func))".
+const DefaultMaxDepth = internal.DefaultMaxDepth
+
+// Wat2WasmMaxDepth is like Wat2Wasm, except parens may nest at most maxDepth deep, rather than DefaultMaxDepth, e.g.
+// to convert text from an untrusted source with a lower limit. A '(' nested deeper is an error at its position.
+func Wat2WasmMaxDepth(wat string, maxDepth int) ([]byte, error) {
+	if m, err := internal.DecodeModuleMaxDepth([]byte(wat), internalwasm.Features20220419, internalwasm.MemorySizer, maxDepth); err != nil {
+		return nil, err
+	} else {
+		return binary.EncodeModule(m), nil
+	}
+}
+
+// Wat2WasmReader is like Wat2Wasm, except it reads the text format from r as it is parsed, so that a large module
+// needn't be loaded whole.
+func Wat2WasmReader(r io.Reader) ([]byte, error) {
+	if m, err := internal.DecodeModuleReader(r, internalwasm.Features20220419, internalwasm.MemorySizer); err != nil {
+		return nil, err
+	} else {
+		return binary.EncodeModule(m), nil
+	}
+}
+
+// Progress is updated by Wat2WasmReaderProgress as it parses, and is safe to Load from another goroutine meanwhile, e.g.
+// to render a progress bar.
+type Progress = internal.Progress
+
+// ParseProgress is what Progress Load returns: the section, index and line of the module field being parsed, and the
+// count of bytes read.
+type ParseProgress = internal.ParseProgress
+
+// Wat2WasmReaderProgress is like Wat2WasmReader, except it updates progress as it parses.
+func Wat2WasmReaderProgress(r io.Reader, progress *Progress) ([]byte, error) {
+	if m, err := internal.DecodeModuleReaderProgress(r, internalwasm.Features20220419, internalwasm.MemorySizer, progress); err != nil {
+		return nil, err
+	} else {
+		return binary.EncodeModule(m), nil
+	}
+}
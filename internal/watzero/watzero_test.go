@@ -2,6 +2,7 @@ package watzero
 
 import (
 	_ "embed"
+	"strings"
 	"testing"
 
 	"github.com/tetratelabs/wazero/internal/testing/require"
@@ -99,3 +100,26 @@ func TestWat2Wasm(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, binary.EncodeModule(example), wasm)
 }
+
+func TestWat2WasmMaxDepth(t *testing.T) {
+	wasm, err := Wat2WasmMaxDepth(exampleWat, DefaultMaxDepth)
+	require.NoError(t, err)
+	require.Equal(t, binary.EncodeModule(example), wasm)
+
+	_, err = Wat2WasmMaxDepth("(module (func))", 1)
+	require.EqualError(t, err, "1:9: found '(' nested deeper than the maximum depth of 1 in module")
+}
+
+func TestWat2WasmReader(t *testing.T) {
+	wasm, err := Wat2WasmReader(strings.NewReader(exampleWat))
+	require.NoError(t, err)
+	require.Equal(t, binary.EncodeModule(example), wasm)
+}
+
+func TestWat2WasmReaderProgress(t *testing.T) {
+	progress := &Progress{}
+	wasm, err := Wat2WasmReaderProgress(strings.NewReader(exampleWat), progress)
+	require.NoError(t, err)
+	require.Equal(t, binary.EncodeModule(example), wasm)
+	require.Equal(t, uint64(len(exampleWat)), progress.Load().BytesRead)
+}
@@ -138,6 +138,10 @@ type compiler struct {
 	}
 	pc     uint64
 	result CompilationResult
+	// needsStackTypes is true if CompilationResult.StackTypes should be recorded.
+	needsStackTypes bool
+	// stackTypes are converted to CompilationResult.StackTypes once all operations are emitted.
+	stackTypes map[Operation][]UnsignedType
 
 	// body holds the code for the function's body where Wasm instructions are stored.
 	body []byte
@@ -210,9 +214,18 @@ type CompilationResult struct {
 	NeedsAccessToDataInstances bool
 	// NeedsAccessToDataInstances is true if the function needs access to element instances via table.init or elem.drop instructions.
 	NeedsAccessToElementInstances bool
+	// StackTypes maps the index in Operations of each operation where a snapshot can be taken to the types of the values on the stack of the
+	// function, including params and locals, before the operation executes. These operations are OperationNop,
+	// OperationBr of the br instruction, OperationBrIf, OperationBrTable, OperationCall and OperationCallIndirect.
+	//
+	// Note: Reference types are opaque 64-bit pointers here, so they are recorded as UnsignedTypeI64.
+	// Note: This is nil unless CompileFunctions was called with recordStackTypes, as only the interpreter snapshots.
+	StackTypes map[int][]UnsignedType
 }
 
-func CompileFunctions(_ context.Context, enabledFeatures wasm.Features, module *wasm.Module) ([]*CompilationResult, error) {
+// CompileFunctions lowers the functions of the module into wazeroir operations. recordStackTypes is true to record
+// CompilationResult.StackTypes, which only engines that snapshot need.
+func CompileFunctions(_ context.Context, enabledFeatures wasm.Features, module *wasm.Module, recordStackTypes bool) ([]*CompilationResult, error) {
 	// Note: If you use the context.Context param, don't forget to coerce nil to context.Background()!
 
 	functions, globals, mem, tables, err := module.AllDeclarations()
@@ -232,7 +245,7 @@ func CompileFunctions(_ context.Context, enabledFeatures wasm.Features, module *
 		typeID := module.FunctionSection[funcIndex]
 		sig := module.TypeSection[typeID]
 		code := module.CodeSection[funcIndex]
-		r, err := compile(enabledFeatures, sig, code.Body, code.LocalTypes, module.TypeSection, functions, globals)
+		r, err := compile(enabledFeatures, sig, code.Body, code.LocalTypes, module.TypeSection, functions, globals, recordStackTypes)
 		if err != nil {
 			return nil, fmt.Errorf("failed to lower func[%d/%d] to wazeroir: %w", funcIndex, len(functions)-1, err)
 		}
@@ -257,9 +270,11 @@ func compile(enabledFeatures wasm.Features,
 	localTypes []wasm.ValueType,
 	types []*wasm.FunctionType,
 	functions []uint32, globals []*wasm.GlobalType,
+	recordStackTypes bool,
 ) (*CompilationResult, error) {
 	c := compiler{
 		enabledFeatures: enabledFeatures,
+		needsStackTypes: recordStackTypes,
 		controlFrames:   &controlFrames{},
 		result:          CompilationResult{LabelCallers: map[string]uint32{}},
 		body:            body,
@@ -297,6 +312,15 @@ func compile(enabledFeatures wasm.Features,
 			return nil, fmt.Errorf("handling instruction: %w", err)
 		}
 	}
+
+	if c.stackTypes != nil {
+		c.result.StackTypes = make(map[int][]UnsignedType, len(c.stackTypes))
+		for i, op := range c.result.Operations {
+			if stackTypes, ok := c.stackTypes[op]; ok {
+				c.result.StackTypes[i] = stackTypes
+			}
+		}
+	}
 	return &c.result, nil
 }
 
@@ -311,6 +335,12 @@ func (c *compiler) handleInstruction() error {
 		)
 	}
 
+	// Capture the stack types before applyToStack modifies them, if a snapshot can be taken at this instruction.
+	var stackTypes []UnsignedType
+	if c.needsStackTypes && isSnapshotPoint(op) && !c.unreachableState.on {
+		stackTypes = append([]UnsignedType{}, c.stack...)
+	}
+
 	// Modify the stack according the current instruction.
 	// Note that some instructions will read "index" in
 	// applyToStack and advance c.pc inside the function.
@@ -328,8 +358,10 @@ operatorSwitch:
 		)
 		c.markUnreachable()
 	case wasm.OpcodeNop:
+		nopOp := &OperationNop{}
+		c.recordStackTypes(nopOp, stackTypes)
 		c.emit(
-			&OperationNop{},
+			nopOp,
 		)
 	case wasm.OpcodeBlock:
 		bt, num, err := wasm.DecodeBlockType(c.types,
@@ -594,9 +626,11 @@ operatorSwitch:
 		dropOp := &OperationDrop{Depth: c.getFrameDropRange(targetFrame, false)}
 		target := targetFrame.asBranchTarget()
 		c.result.LabelCallers[target.Label.String()]++
+		brOp := &OperationBr{Target: target}
+		c.recordStackTypes(brOp, dropStackTypes(stackTypes, dropOp.Depth)) // brOp executes after dropOp.
 		c.emit(
 			dropOp,
-			&OperationBr{Target: target},
+			brOp,
 		)
 		// Br operation is stack-polymorphic, and mark the state as unreachable.
 		// That means subsequent instructions in the current control frame are "unreachable"
@@ -617,11 +651,13 @@ operatorSwitch:
 
 		continuationLabel := &Label{FrameID: c.nextID(), Kind: LabelKindHeader}
 		c.result.LabelCallers[continuationLabel.String()]++
+		brIfOp := &OperationBrIf{
+			Then: &BranchTargetDrop{ToDrop: drop, Target: target},
+			Else: continuationLabel.asBranchTargetDrop(),
+		}
+		c.recordStackTypes(brIfOp, stackTypes)
 		c.emit(
-			&OperationBrIf{
-				Then: &BranchTargetDrop{ToDrop: drop, Target: target},
-				Else: continuationLabel.asBranchTargetDrop(),
-			},
+			brIfOp,
 			// Start emitting else block operations.
 			&OperationLabel{
 				Label: continuationLabel,
@@ -663,13 +699,15 @@ operatorSwitch:
 		defaultTarget := defaultTargetFrame.asBranchTarget()
 		c.result.LabelCallers[defaultTarget.Label.String()]++
 
-		c.emit(
-			&OperationBrTable{
-				Targets: targets,
-				Default: &BranchTargetDrop{
-					ToDrop: defaultTargetDrop, Target: defaultTarget,
-				},
+		brTableOp := &OperationBrTable{
+			Targets: targets,
+			Default: &BranchTargetDrop{
+				ToDrop: defaultTargetDrop, Target: defaultTarget,
 			},
+		}
+		c.recordStackTypes(brTableOp, stackTypes)
+		c.emit(
+			brTableOp,
 		)
 		// Br operation is stack-polymorphic, and mark the state as unreachable.
 		// That means subsequent instructions in the current control frame are "unreachable"
@@ -693,8 +731,10 @@ operatorSwitch:
 		if index == nil {
 			return fmt.Errorf("index does not exist for function call")
 		}
+		callOp := &OperationCall{FunctionIndex: *index}
+		c.recordStackTypes(callOp, stackTypes)
 		c.emit(
-			&OperationCall{FunctionIndex: *index},
+			callOp,
 		)
 	case wasm.OpcodeCallIndirect:
 		if index == nil {
@@ -705,8 +745,10 @@ operatorSwitch:
 			return fmt.Errorf("read target for br_table: %w", err)
 		}
 		c.pc += n
+		callIndirectOp := &OperationCallIndirect{TypeIndex: *index, TableIndex: tableIndex}
+		c.recordStackTypes(callIndirectOp, stackTypes)
 		c.emit(
-			&OperationCallIndirect{TypeIndex: *index, TableIndex: tableIndex},
+			callIndirectOp,
 		)
 	case wasm.OpcodeDrop:
 		c.emit(
@@ -2933,6 +2975,57 @@ func (c *compiler) emit(ops ...Operation) {
 	}
 }
 
+// isSnapshotPoint returns true if a snapshot can be taken at an operation lowered from the given instruction. See
+// CompilationResult.StackTypes
+func isSnapshotPoint(op wasm.Opcode) bool {
+	switch op {
+	case wasm.OpcodeNop, wasm.OpcodeBr, wasm.OpcodeBrIf, wasm.OpcodeBrTable, wasm.OpcodeCall, wasm.OpcodeCallIndirect:
+		return true
+	}
+	return false
+}
+
+// recordStackTypes records the stack types before the given operation executes, unless they aren't recorded or it won't
+// be emitted.
+func (c *compiler) recordStackTypes(op Operation, stackTypes []UnsignedType) {
+	if !c.needsStackTypes || c.unreachableState.on {
+		return
+	}
+	if c.stackTypes == nil {
+		c.stackTypes = map[Operation][]UnsignedType{}
+	}
+	c.stackTypes[op] = stackTypes
+}
+
+// dropStackTypes returns the stack types after OperationDrop with the given range. The range is in uint64 words from
+// the top of the stack, so UnsignedTypeV128 counts twice.
+func dropStackTypes(stackTypes []UnsignedType, r *InclusiveRange) []UnsignedType {
+	if r == nil {
+		return stackTypes
+	}
+	ret := make([]UnsignedType, 0, len(stackTypes))
+	var kept []UnsignedType // types above the dropped range, in reverse order.
+	depth := 0
+	for i := len(stackTypes) - 1; i >= 0; i-- {
+		t := stackTypes[i]
+		if depth < r.Start {
+			kept = append(kept, t)
+		} else if depth > r.End {
+			ret = append(ret, stackTypes[:i+1]...)
+			break
+		}
+		if t == UnsignedTypeV128 {
+			depth += 2
+		} else {
+			depth++
+		}
+	}
+	for i := len(kept) - 1; i >= 0; i-- {
+		ret = append(ret, kept[i])
+	}
+	return ret
+}
+
 // Emit const expression with default values of the given type.
 func (c *compiler) emitDefaultValue(t wasm.ValueType) {
 	switch t {
@@ -120,7 +120,7 @@ func TestCompile(t *testing.T) {
 				enabledFeatures = wasm.Features20220419
 			}
 
-			res, err := CompileFunctions(ctx, enabledFeatures, tc.module)
+			res, err := CompileFunctions(ctx, enabledFeatures, tc.module, false)
 			require.NoError(t, err)
 			require.Equal(t, tc.expected, res[0])
 		})
@@ -250,7 +250,7 @@ func TestCompile_BulkMemoryOperations(t *testing.T) {
 		TableTypes:                 []wasm.RefType{},
 	}
 
-	res, err := CompileFunctions(ctx, wasm.FeatureBulkMemoryOperations, module)
+	res, err := CompileFunctions(ctx, wasm.FeatureBulkMemoryOperations, module, false)
 	require.NoError(t, err)
 	require.Equal(t, expected, res[0])
 }
@@ -535,7 +535,7 @@ func TestCompile_MultiValue(t *testing.T) {
 			if enabledFeatures == 0 {
 				enabledFeatures = wasm.Features20220419
 			}
-			res, err := CompileFunctions(ctx, enabledFeatures, tc.module)
+			res, err := CompileFunctions(ctx, enabledFeatures, tc.module, false)
 			require.NoError(t, err)
 			require.Equal(t, tc.expected, res[0])
 		})
@@ -566,7 +566,7 @@ func TestCompile_NonTrappingFloatToIntConversion(t *testing.T) {
 		TableTypes:   []wasm.RefType{},
 	}
 
-	res, err := CompileFunctions(ctx, wasm.FeatureNonTrappingFloatToIntConversion, module)
+	res, err := CompileFunctions(ctx, wasm.FeatureNonTrappingFloatToIntConversion, module, false)
 	require.NoError(t, err)
 	require.Equal(t, expected, res[0])
 }
@@ -591,7 +591,7 @@ func TestCompile_SignExtensionOps(t *testing.T) {
 		TableTypes:   []wasm.RefType{},
 	}
 
-	res, err := CompileFunctions(ctx, wasm.FeatureSignExtensionOps, module)
+	res, err := CompileFunctions(ctx, wasm.FeatureSignExtensionOps, module, false)
 	require.NoError(t, err)
 	require.Equal(t, expected, res[0])
 }
@@ -600,7 +600,7 @@ func requireCompilationResult(t *testing.T, enabledFeatures wasm.Features, expec
 	if enabledFeatures == 0 {
 		enabledFeatures = wasm.Features20220419
 	}
-	res, err := CompileFunctions(ctx, enabledFeatures, module)
+	res, err := CompileFunctions(ctx, enabledFeatures, module, false)
 	require.NoError(t, err)
 	require.Equal(t, expected, res[0])
 }
@@ -647,7 +647,7 @@ func TestCompile_CallIndirectNonZeroTableIndex(t *testing.T) {
 		Types: []*wasm.FunctionType{v_v, v_v, v_v},
 	}
 
-	res, err := CompileFunctions(ctx, wasm.FeatureBulkMemoryOperations, module)
+	res, err := CompileFunctions(ctx, wasm.FeatureBulkMemoryOperations, module, false)
 	require.NoError(t, err)
 	require.Equal(t, expected, res[0])
 }
@@ -737,7 +737,7 @@ func TestCompile_Refs(t *testing.T) {
 				FunctionSection: []wasm.Index{0},
 				CodeSection:     []*wasm.Code{{Body: tc.body}},
 			}
-			res, err := CompileFunctions(ctx, wasm.Features20220419, module)
+			res, err := CompileFunctions(ctx, wasm.Features20220419, module, false)
 			require.NoError(t, err)
 			require.Equal(t, tc.expected, res[0].Operations)
 		})
@@ -806,7 +806,7 @@ func TestCompile_TableGetOrSet(t *testing.T) {
 				CodeSection:     []*wasm.Code{{Body: tc.body}},
 				TableSection:    []*wasm.Table{{}},
 			}
-			res, err := CompileFunctions(ctx, wasm.Features20220419, module)
+			res, err := CompileFunctions(ctx, wasm.Features20220419, module, false)
 			require.NoError(t, err)
 			require.Equal(t, tc.expected, res[0].Operations)
 		})
@@ -875,7 +875,7 @@ func TestCompile_TableGrowFillSize(t *testing.T) {
 				CodeSection:     []*wasm.Code{{Body: tc.body}},
 				TableSection:    []*wasm.Table{{}},
 			}
-			res, err := CompileFunctions(ctx, wasm.Features20220419, module)
+			res, err := CompileFunctions(ctx, wasm.Features20220419, module, false)
 			require.NoError(t, err)
 			require.Equal(t, tc.expected, res[0].Operations)
 			require.True(t, res[0].HasTable)
@@ -1093,7 +1093,7 @@ func TestCompile_Locals(t *testing.T) {
 	for _, tt := range tests {
 		tc := tt
 		t.Run(tc.name, func(t *testing.T) {
-			res, err := CompileFunctions(ctx, wasm.Features20220419, tc.mod)
+			res, err := CompileFunctions(ctx, wasm.Features20220419, tc.mod, false)
 			require.NoError(t, err)
 			msg := fmt.Sprintf("\nhave:\n\t%s\nwant:\n\t%s", Format(res[0].Operations), Format(tc.expected))
 			require.Equal(t, tc.expected, res[0].Operations, msg)
@@ -2439,7 +2439,7 @@ func TestCompile_Vec(t *testing.T) {
 				MemorySection:   &wasm.Memory{},
 				CodeSection:     []*wasm.Code{{Body: tc.body}},
 			}
-			res, err := CompileFunctions(ctx, wasm.Features20220419, module)
+			res, err := CompileFunctions(ctx, wasm.Features20220419, module, false)
 			require.NoError(t, err)
 
 			var actual Operation
@@ -2457,3 +2457,78 @@ func TestCompile_Vec(t *testing.T) {
 		})
 	}
 }
+
+func TestCompile_StackTypes(t *testing.T) {
+	module := &wasm.Module{
+		TypeSection:     []*wasm.FunctionType{i32_i32},
+		FunctionSection: []wasm.Index{0},
+		CodeSection: []*wasm.Code{{Body: []byte{
+			wasm.OpcodeLoop, 0x40, // (loop
+			wasm.OpcodeNop,         // nop
+			wasm.OpcodeLocalGet, 0, // (local.get 0)
+			wasm.OpcodeCall, 0, // (call 0)
+			wasm.OpcodeLocalGet, 0, // (local.get 0)
+			wasm.OpcodeBrIf, 0, // (br_if 0)
+			wasm.OpcodeDrop,  // drop
+			wasm.OpcodeBr, 0, // (br 0)
+			wasm.OpcodeEnd,         // )
+			wasm.OpcodeLocalGet, 0, // (local.get 0)
+			wasm.OpcodeEnd,
+		}}},
+	}
+
+	res, err := CompileFunctions(ctx, wasm.Features20220419, module, false)
+	require.NoError(t, err)
+	require.Nil(t, res[0].StackTypes)
+
+	res, err = CompileFunctions(ctx, wasm.Features20220419, module, true)
+	require.NoError(t, err)
+
+	kinds := map[OperationKind][]UnsignedType{}
+	for i, stackTypes := range res[0].StackTypes {
+		kinds[res[0].Operations[i].Kind()] = stackTypes
+	}
+	require.Equal(t, map[OperationKind][]UnsignedType{
+		OperationKindNop:  {UnsignedTypeI32},                                   // [$0]
+		OperationKindCall: {UnsignedTypeI32, UnsignedTypeI32},                  // [$0, $0]
+		OperationKindBrIf: {UnsignedTypeI32, UnsignedTypeI32, UnsignedTypeI32}, // [$0, result, $0]
+		OperationKindBr:   {UnsignedTypeI32},                                   // [$0] after dropping the result
+	}, kinds)
+}
+
+func TestDropStackTypes(t *testing.T) {
+	stackTypes := []UnsignedType{UnsignedTypeI32, UnsignedTypeV128, UnsignedTypeF64}
+
+	tests := []struct {
+		name     string
+		r        *InclusiveRange
+		expected []UnsignedType
+	}{
+		{
+			name:     "nil",
+			expected: stackTypes,
+		},
+		{
+			name:     "top",
+			r:        &InclusiveRange{Start: 0, End: 0},
+			expected: []UnsignedType{UnsignedTypeI32, UnsignedTypeV128},
+		},
+		{
+			name:     "v128 below the top",
+			r:        &InclusiveRange{Start: 1, End: 2},
+			expected: []UnsignedType{UnsignedTypeI32, UnsignedTypeF64},
+		},
+		{
+			name:     "all but the top",
+			r:        &InclusiveRange{Start: 1, End: 3},
+			expected: []UnsignedType{UnsignedTypeF64},
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, dropStackTypes(stackTypes, tc.r))
+		})
+	}
+}
@@ -125,7 +125,7 @@ func NewRuntime() Runtime {
 // NewRuntimeWithConfig returns a runtime with the given configuration.
 func NewRuntimeWithConfig(rConfig RuntimeConfig) Runtime {
 	config := rConfig.(*runtimeConfig)
-	store, ns := wasm.NewStore(config.enabledFeatures, config.newEngine(config.enabledFeatures))
+	store, ns := wasm.NewStore(config.enabledFeatures, config.newEngine(config.enabledFeatures, config.callStackCeiling))
 	return &runtime{
 		store:           store,
 		ns:              &namespace{store: store, ns: ns},
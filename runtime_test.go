@@ -1,17 +1,26 @@
 package wazero
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
 	"errors"
+	"io"
 	"math"
+	"os"
+	goruntime "runtime"
+	"strings"
 	"testing"
 
 	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/internal/engine/interpreter"
 	"github.com/tetratelabs/wazero/internal/leb128"
+	"github.com/tetratelabs/wazero/internal/platform"
+	internalsys "github.com/tetratelabs/wazero/internal/sys"
 	"github.com/tetratelabs/wazero/internal/testing/require"
 	"github.com/tetratelabs/wazero/internal/wasm"
 	binaryformat "github.com/tetratelabs/wazero/internal/wasm/binary"
+	"github.com/tetratelabs/wazero/internal/wasmruntime"
 	"github.com/tetratelabs/wazero/internal/watzero"
 	"github.com/tetratelabs/wazero/sys"
 )
@@ -506,6 +515,40 @@ func TestRuntime_InstantiateModuleFromBinary_ErrorOnStart(t *testing.T) {
 	}
 }
 
+func TestRuntime_WithMaxCallDepth(t *testing.T) {
+	callWasm, err := watzero.Wat2Wasm(`(module
+  (func $leaf)
+  (func $nested call $leaf)
+  (func $recurse call $recurse)
+  (export "nested" (func $nested))
+  (export "recurse" (func $recurse))
+)`)
+	require.NoError(t, err)
+
+	configs := map[string]RuntimeConfig{"interpreter": NewRuntimeConfigInterpreter()}
+	if platform.CompilerSupported() {
+		configs["compiler"] = NewRuntimeConfigCompiler()
+	}
+
+	for n, c := range configs {
+		config := c
+
+		t.Run(n, func(t *testing.T) {
+			r := NewRuntimeWithConfig(config.WithMaxCallDepth(32))
+			defer r.Close(testCtx)
+
+			m, err := r.InstantiateModuleFromBinary(testCtx, callWasm)
+			require.NoError(t, err)
+
+			_, err = m.ExportedFunction("nested").Call(testCtx)
+			require.NoError(t, err)
+
+			_, err = m.ExportedFunction("recurse").Call(testCtx)
+			require.ErrorIs(t, err, wasmruntime.ErrRuntimeCallStackOverflow)
+		})
+	}
+}
+
 // TestRuntime_InstantiateModule_WithName tests that we can pre-validate (cache) a module and instantiate it under
 // different names. This pattern is used in wapc-go.
 func TestRuntime_InstantiateModule_WithName(t *testing.T) {
@@ -532,6 +575,965 @@ func TestRuntime_InstantiateModule_WithName(t *testing.T) {
 	require.Equal(t, internal.Module("2"), m2)
 }
 
+// TestRuntime_InstantiateModule_CloseReleases ensures an instantiate/close loop, as done when resuming snapshots, doesn't
+// grow the heap unboundedly. Each instance has a page of memory, so leaking instances would exceed the bound quickly.
+func TestRuntime_InstantiateModule_CloseReleases(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	bin, err := watzero.Wat2Wasm(`(module
+	(memory 1)
+	(global (mut i32) (i32.const 0))
+	(func $entry)
+	(export "entry" (func $entry))
+)`)
+	require.NoError(t, err)
+	code, err := r.CompileModule(testCtx, bin, NewCompileConfig())
+	require.NoError(t, err)
+
+	const iterations = 2000
+	instantiateCallClose := func() {
+		m, err := r.InstantiateModule(testCtx, code, NewModuleConfig())
+		require.NoError(t, err)
+		_, err = m.ExportedFunction("entry").Call(testCtx)
+		require.NoError(t, err)
+		require.NoError(t, m.Close(testCtx))
+	}
+
+	instantiateCallClose() // warm up any lazily initialized state.
+	var before, after goruntime.MemStats
+	goruntime.GC()
+	goruntime.ReadMemStats(&before)
+	for i := 0; i < iterations; i++ {
+		instantiateCallClose()
+	}
+	goruntime.GC()
+	goruntime.ReadMemStats(&after)
+
+	// Leaking the memory of each instance alone would be iterations * 64 KiB, so 125 MiB.
+	const bound = 16 << 20
+	require.True(t, after.HeapAlloc < before.HeapAlloc+bound, "heap grew from %d to %d", before.HeapAlloc, after.HeapAlloc)
+	require.Nil(t, r.(*runtime).ns.Module(""))
+	require.Equal(t, uint32(1), r.(*runtime).store.Engine.CompiledModuleCount())
+}
+
+// TestRuntime_Resume_GrownMemory ensures resuming restores the size the guest grew its memory to before the snapshot,
+// rather than the declared minimum of the new instance.
+func TestRuntime_Resume_GrownMemory(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	i32 := wasm.ValueTypeI32
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection:     []*wasm.FunctionType{{Results: []wasm.ValueType{i32}, ResultNumInUint64: 1}},
+		FunctionSection: []wasm.Index{0},
+		CodeSection: []*wasm.Code{{Body: []byte{
+			wasm.OpcodeI32Const, 2, wasm.OpcodeMemoryGrow, 0, wasm.OpcodeDrop,
+			wasm.OpcodeNop, // snapshots, then traps.
+			wasm.OpcodeMemorySize, 0,
+			wasm.OpcodeEnd,
+		}}},
+		MemorySection: &wasm.Memory{Min: 1, Cap: 1, Max: 4, IsMaxEncoded: true},
+		ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "grow", Index: 0}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	snapshot := &wasm.Snapshot{}
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "trap_after_snapshot", true)
+	ctx = context.WithValue(ctx, "export_snapshot", false)
+
+	m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName("snapshot"))
+	require.NoError(t, err)
+	_, err = m.ExportedFunction("grow").Call(ctx)
+	require.Equal(t, &wasm.SnapshotError{Reason: wasm.SnapshotReasonCooperative, Resumable: true}, err)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+	require.NoError(t, m.Close(ctx))
+
+	grownSize := 3 * wasm.MemoryPageSize
+	require.Equal(t, uint32(3), snapshot.Memory.Min)
+	require.Equal(t, uint64(grownSize), snapshot.MemorySize())
+
+	// A new instance starts at the declared minimum, until resumed.
+	m, err = r.InstantiateModule(ctx, code, NewModuleConfig().WithName("resumed"))
+	require.NoError(t, err)
+	defer m.Close(ctx)
+	require.Equal(t, wasm.MemoryPageSize, m.Memory().Size(ctx))
+
+	results, _, err := m.ExportedFunction("grow").(*wasm.FunctionInstance).Resume(ctx, snapshot)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3}, results)
+	require.Equal(t, grownSize, m.Memory().Size(ctx))
+}
+
+func TestRuntime_Resume_ArgsEnviron(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection:     []*wasm.FunctionType{{}},
+		FunctionSection: []wasm.Index{0},
+		CodeSection:     []*wasm.Code{{Body: []byte{wasm.OpcodeNop, wasm.OpcodeEnd}}}, // snapshots, then traps.
+		ExportSection:   []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 0}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	snapshot := &wasm.Snapshot{}
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "trap_after_snapshot", true)
+	ctx = context.WithValue(ctx, "export_snapshot", false)
+
+	m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName("snapshot").WithArgs("wasi", "a"))
+	require.NoError(t, err)
+	_, err = m.ExportedFunction("run").Call(ctx)
+	require.Equal(t, &wasm.SnapshotError{Reason: wasm.SnapshotReasonCooperative, Resumable: true}, err)
+	require.NoError(t, m.Close(ctx))
+
+	require.Equal(t, []string{"wasi", "a"}, snapshot.Args)
+	require.Equal(t, []string{}, snapshot.Environ) // captured, though empty.
+
+	// The captured args and environ win over those configured for the resumed instance.
+	m, err = r.InstantiateModule(ctx, code, NewModuleConfig().WithName("resumed").WithArgs("other").WithEnv("a", "b"))
+	require.NoError(t, err)
+	defer m.Close(ctx)
+
+	_, _, err = m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(ctx, snapshot)
+	require.NoError(t, err)
+	sysCtx := m.(*wasm.CallContext).Sys
+	require.Equal(t, []string{"wasi", "a"}, sysCtx.Args())
+	require.Equal(t, uint32(7), sysCtx.ArgsSize())
+	require.Equal(t, 0, len(sysCtx.Environ()))
+}
+
+// TestRuntime_SnapshotOptions ensures snapshots configured with wasm.WithSnapshotOptions aren't affected by another
+// package setting the same string keys the engine used to read.
+func TestRuntime_SnapshotOptions(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection:     []*wasm.FunctionType{{}},
+		FunctionSection: []wasm.Index{0},
+		CodeSection:     []*wasm.Code{{Body: []byte{wasm.OpcodeNop, wasm.OpcodeEnd}}}, // snapshots, then traps.
+		ExportSection:   []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 0}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	ctx := context.WithValue(testCtx, "snapshot", "unrelated")
+	ctx = context.WithValue(ctx, "always_snapshot", true)
+	snapshot := &wasm.Snapshot{}
+	ctx = wasm.WithSnapshotOptions(ctx, wasm.SnapshotOptions{Snapshot: snapshot, Cooperative: true, TrapAfter: true})
+
+	m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName("snapshot"))
+	require.NoError(t, err)
+	_, err = m.ExportedFunction("run").Call(ctx)
+	require.Equal(t, &wasm.SnapshotError{Reason: wasm.SnapshotReasonCooperative, Resumable: true}, err)
+	require.NoError(t, m.Close(ctx))
+	require.True(t, snapshot.Valid)
+
+	m, err = r.InstantiateModule(ctx, code, NewModuleConfig().WithName("resumed"))
+	require.NoError(t, err)
+	defer m.Close(ctx)
+	_, next, err := m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(ctx, snapshot)
+	require.NoError(t, err)
+	require.Nil(t, next)
+}
+
+func TestRuntime_Resume_InstructionCount(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection:     []*wasm.FunctionType{{}},
+		FunctionSection: []wasm.Index{0},
+		CodeSection:     []*wasm.Code{{Body: []byte{wasm.OpcodeNop, wasm.OpcodeNop, wasm.OpcodeEnd}}},
+		ExportSection:   []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 0}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	snapshot := &wasm.Snapshot{}
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "trap_after_snapshot", true)
+	ctx = context.WithValue(ctx, "export_snapshot", false)
+
+	m, err := r.InstantiateModule(ctx, code, NewModuleConfig())
+	require.NoError(t, err)
+	defer m.Close(ctx)
+	fn := m.ExportedFunction("run").(*wasm.FunctionInstance)
+
+	_, err = fn.Call(ctx)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+	require.Equal(t, uint64(1), snapshot.InstructionCount)
+
+	// The count continues from the snapshot, rather than restarting at zero.
+	_, next, err := fn.Resume(ctx, snapshot)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+	require.Equal(t, uint64(2), next.InstructionCount)
+	require.Equal(t, uint64(1), snapshot.InstructionCount) // not modified
+}
+
+func TestRuntime_Resume_StdoutWritten(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	// write is like WASI fd_write to stdout.
+	write := func(ctx context.Context, m api.Module) {
+		sysCtx := m.(*wasm.CallContext).Sys
+		_, err := internalsys.FdWriter(ctx, sysCtx, internalsys.FdStdout).Write([]byte("hi"))
+		require.NoError(t, err)
+	}
+	_, err := r.NewModuleBuilder("env").ExportFunction("write", write).Instantiate(testCtx, r)
+	require.NoError(t, err)
+
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection:     []*wasm.FunctionType{{}},
+		ImportSection:   []*wasm.Import{{Type: wasm.ExternTypeFunc, Module: "env", Name: "write", DescFunc: 0}},
+		FunctionSection: []wasm.Index{0},
+		CodeSection: []*wasm.Code{{Body: []byte{
+			wasm.OpcodeCall, 0,
+			wasm.OpcodeNop, // snapshots, then traps.
+			wasm.OpcodeCall, 0,
+			wasm.OpcodeEnd,
+		}}},
+		ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 1}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	snapshot := &wasm.Snapshot{}
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "trap_after_snapshot", true)
+	ctx = context.WithValue(ctx, "export_snapshot", false)
+
+	var stdout bytes.Buffer
+	m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName("snapshot").WithStdout(&stdout))
+	require.NoError(t, err)
+	_, err = m.ExportedFunction("run").Call(ctx)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+	require.NoError(t, m.Close(ctx))
+
+	require.Equal(t, "hi", stdout.String())
+	require.Equal(t, uint64(2), snapshot.StdoutWritten)
+	require.Zero(t, snapshot.StderrWritten)
+
+	// Resume with a different stdout, which only receives the output after the snapshot, while the count continues.
+	var resumedStdout bytes.Buffer
+	m, err = r.InstantiateModule(ctx, code, NewModuleConfig().WithName("resumed").WithStdout(&resumedStdout))
+	require.NoError(t, err)
+	defer m.Close(ctx)
+
+	_, _, err = m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(ctx, snapshot)
+	require.NoError(t, err)
+	require.Equal(t, "hi", resumedStdout.String())
+	stdoutWritten, _ := m.(*wasm.CallContext).Sys.Written()
+	require.Equal(t, uint64(4), stdoutWritten)
+}
+
+func TestRuntime_Resume_StdinRead(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	// read is like WASI fd_read of two bytes from stdin.
+	var read []string
+	readFn := func(ctx context.Context, m api.Module) {
+		sysCtx := m.(*wasm.CallContext).Sys
+		buf := make([]byte, 2)
+		n, err := internalsys.FdReader(ctx, sysCtx, internalsys.FdStdin).Read(buf)
+		require.NoError(t, err)
+		read = append(read, string(buf[:n]))
+	}
+	_, err := r.NewModuleBuilder("env").ExportFunction("read", readFn).Instantiate(testCtx, r)
+	require.NoError(t, err)
+
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection:     []*wasm.FunctionType{{}},
+		ImportSection:   []*wasm.Import{{Type: wasm.ExternTypeFunc, Module: "env", Name: "read", DescFunc: 0}},
+		FunctionSection: []wasm.Index{0},
+		CodeSection: []*wasm.Code{{Body: []byte{
+			wasm.OpcodeCall, 0,
+			wasm.OpcodeNop, // snapshots, then traps.
+			wasm.OpcodeCall, 0,
+			wasm.OpcodeEnd,
+		}}},
+		ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 1}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	snapshot := &wasm.Snapshot{}
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "trap_after_snapshot", true)
+	ctx = context.WithValue(ctx, "export_snapshot", false)
+
+	m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName("snapshot").WithStdin(strings.NewReader("abcd")))
+	require.NoError(t, err)
+	_, err = m.ExportedFunction("run").Call(ctx)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+	require.NoError(t, m.Close(ctx))
+	require.Equal(t, uint64(2), snapshot.StdinRead)
+
+	t.Run("not seekable", func(t *testing.T) {
+		m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName("pipe").WithStdin(bytes.NewBufferString("abcd")))
+		require.NoError(t, err)
+		defer m.Close(ctx)
+
+		_, _, err = m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(ctx, snapshot)
+		require.EqualError(t, err, "cannot skip 2 bytes of stdin read before the snapshot: *bytes.Buffer is not an io.Seeker")
+	})
+
+	// Resume with a fresh stdin, which skips the bytes read before the snapshot.
+	m, err = r.InstantiateModule(ctx, code, NewModuleConfig().WithName("resumed").WithStdin(strings.NewReader("abcd")))
+	require.NoError(t, err)
+	defer m.Close(ctx)
+
+	_, _, err = m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(ctx, snapshot)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ab", "cd"}, read)
+	require.Equal(t, uint64(4), m.(*wasm.CallContext).Sys.StdinRead())
+}
+
+func TestRuntime_Resume_RandClocks(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	// read is like WASI random_get of two bytes, followed by clock_time_get of the monotonic clock.
+	var rand []string
+	var nanotimes []int64
+	readFn := func(ctx context.Context, m api.Module) {
+		sysCtx := m.(*wasm.CallContext).Sys
+		buf := make([]byte, 2)
+		_, err := io.ReadFull(sysCtx.RandSource(), buf)
+		require.NoError(t, err)
+		rand = append(rand, string(buf))
+		nanotimes = append(nanotimes, sysCtx.Nanotime(ctx))
+	}
+	_, err := r.NewModuleBuilder("env").ExportFunction("read", readFn).Instantiate(testCtx, r)
+	require.NoError(t, err)
+
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection:     []*wasm.FunctionType{{}},
+		ImportSection:   []*wasm.Import{{Type: wasm.ExternTypeFunc, Module: "env", Name: "read", DescFunc: 0}},
+		FunctionSection: []wasm.Index{0},
+		CodeSection: []*wasm.Code{{Body: []byte{
+			wasm.OpcodeCall, 0,
+			wasm.OpcodeCall, 0,
+			wasm.OpcodeNop, // snapshots, then traps.
+			wasm.OpcodeCall, 0,
+			wasm.OpcodeEnd,
+		}}},
+		ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 1}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	snapshot := &wasm.Snapshot{}
+	ctx := wasm.WithSnapshotOptions(testCtx, wasm.SnapshotOptions{Snapshot: snapshot, Cooperative: true, TrapAfter: true})
+
+	m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName("snapshot").WithRandSource(strings.NewReader("abcdef")))
+	require.NoError(t, err)
+	_, err = m.ExportedFunction("run").Call(ctx)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+	require.NoError(t, m.Close(ctx))
+	require.Equal(t, uint64(4), snapshot.RandRead)
+	require.Equal(t, nanotimes[1], snapshot.Nanotime)
+	require.Zero(t, snapshot.Walltime) // not read
+
+	// Resume with a fresh random source and clocks, which continue from those before the snapshot.
+	m, err = r.InstantiateModule(ctx, code, NewModuleConfig().WithName("resumed").WithRandSource(strings.NewReader("abcdef")))
+	require.NoError(t, err)
+	defer m.Close(ctx)
+
+	_, _, err = m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(ctx, snapshot)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ab", "cd", "ef"}, rand)
+	require.Equal(t, uint64(6), m.(*wasm.CallContext).Sys.RandRead())
+	require.True(t, nanotimes[2] > nanotimes[1], "%d isn't after %d", nanotimes[2], nanotimes[1])
+}
+
+func TestRuntime_Resume_InPlace(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	code, snapshot := compileCounterSnapshot(t, r)
+
+	m, err := r.InstantiateModule(testCtx, code, NewModuleConfig().WithName("counter"))
+	require.NoError(t, err)
+	defer m.Close(testCtx)
+	run := m.ExportedFunction("run")
+
+	// The module runs past the state of the snapshot, which resuming resets in place each time.
+	for _, expected := range []uint64{1, 2} {
+		results, err := run.Call(testCtx)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{expected}, results)
+	}
+	for i := 0; i < 3; i++ {
+		results, next, err := run.(*wasm.FunctionInstance).Resume(testCtx, snapshot)
+		require.NoError(t, err)
+		require.Nil(t, next)
+		require.Equal(t, []uint64{1}, results)
+	}
+	require.Equal(t, uint64(1), snapshot.Globals[0].Val)
+}
+
+// BenchmarkRuntime_Resume compares resuming a snapshot in a newly instantiated module, as a process restarting from a
+// checkpoint would, with resuming it in place, resetting the same module each time.
+func BenchmarkRuntime_Resume(b *testing.B) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	code, snapshot := compileCounterSnapshot(b, r)
+
+	b.Run("reinstantiate", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m, err := r.InstantiateModule(testCtx, code, NewModuleConfig().WithName("counter"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, _, err = m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(testCtx, snapshot); err != nil {
+				b.Fatal(err)
+			}
+			if err = m.Close(testCtx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("reset in place", func(b *testing.B) {
+		m, err := r.InstantiateModule(testCtx, code, NewModuleConfig().WithName("counter"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer m.Close(testCtx)
+		run := m.ExportedFunction("run").(*wasm.FunctionInstance)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, _, err = run.Resume(testCtx, snapshot); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// compileCounterSnapshot compiles a module whose "run" increments a global and returns it, and takes a snapshot of it
+// after the first increment.
+func compileCounterSnapshot(t testing.TB, r Runtime) (CompiledModule, *wasm.Snapshot) {
+	i32 := wasm.ValueTypeI32
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection:     []*wasm.FunctionType{{Results: []wasm.ValueType{i32}, ResultNumInUint64: 1}},
+		FunctionSection: []wasm.Index{0},
+		CodeSection: []*wasm.Code{{Body: []byte{
+			wasm.OpcodeGlobalGet, 0, wasm.OpcodeI32Const, 1, wasm.OpcodeI32Add, wasm.OpcodeGlobalSet, 0,
+			wasm.OpcodeNop, // snapshots, then traps.
+			wasm.OpcodeGlobalGet, 0,
+			wasm.OpcodeEnd,
+		}}},
+		GlobalSection: []*wasm.Global{
+			{Type: &wasm.GlobalType{ValType: i32, Mutable: true}, Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeI32Const, Data: []byte{0}}},
+		},
+		MemorySection: &wasm.Memory{Min: 1, Cap: 1, Max: 1, IsMaxEncoded: true},
+		ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 0}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	snapshot := &wasm.Snapshot{}
+	ctx := wasm.WithSnapshotOptions(testCtx, wasm.SnapshotOptions{Snapshot: snapshot, Cooperative: true, TrapAfter: true})
+	m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName("snapshot"))
+	require.NoError(t, err)
+	defer m.Close(ctx)
+	_, err = m.ExportedFunction("run").Call(ctx)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+	return code, snapshot
+}
+
+func TestRuntime_Resume_Yield(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	_, err := r.NewModuleBuilder(wasm.YieldModuleName).
+		ExportFunction(wasm.YieldFunctionName, wasm.Yield).
+		Instantiate(testCtx, r)
+	require.NoError(t, err)
+
+	i32 := wasm.ValueTypeI32
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection: []*wasm.FunctionType{{Params: []wasm.ValueType{i32}, Results: []wasm.ValueType{i32}}, {Results: []wasm.ValueType{i32}}},
+		ImportSection: []*wasm.Import{
+			{Type: wasm.ExternTypeFunc, Module: wasm.YieldModuleName, Name: wasm.YieldFunctionName, DescFunc: 0},
+		},
+		FunctionSection: []wasm.Index{1},
+		CodeSection: []*wasm.Code{{Body: []byte{
+			wasm.OpcodeI32Const, 7,
+			wasm.OpcodeCall, 0, // yields 7, then yields what it was resumed with.
+			wasm.OpcodeCall, 0,
+			wasm.OpcodeEnd,
+		}}},
+		ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 1}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	t.Run("no snapshot", func(t *testing.T) {
+		m, err := r.InstantiateModule(testCtx, code, NewModuleConfig().WithName("no snapshot"))
+		require.NoError(t, err)
+		defer m.Close(testCtx)
+
+		_, err = m.ExportedFunction("run").Call(testCtx)
+		require.Contains(t, err.Error(), "yield(7) requires a snapshot to be configured")
+	})
+
+	snapshot := &wasm.Snapshot{}
+	// Yield returns control even though trap_after_snapshot isn't set.
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "export_snapshot", false)
+
+	m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName("snapshot"))
+	require.NoError(t, err)
+	_, err = m.ExportedFunction("run").Call(ctx)
+	require.Equal(t, &wasm.SnapshotError{Reason: wasm.SnapshotReasonYield, Resumable: true, YieldTag: 7}, err)
+	require.NoError(t, m.Close(ctx))
+
+	// Resume with a value, which the guest yields in turn.
+	snapshot.ResumeValue = 8
+	m, err = r.InstantiateModule(ctx, code, NewModuleConfig().WithName("resumed"))
+	require.NoError(t, err)
+	_, next, err := m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(ctx, snapshot)
+	require.Equal(t, &wasm.SnapshotError{Reason: wasm.SnapshotReasonYield, Resumable: true, YieldTag: 8}, err)
+	require.Equal(t, uint32(8), next.YieldTag)
+	require.Equal(t, uint32(7), snapshot.YieldTag) // the snapshot resumed isn't modified.
+	require.NoError(t, m.Close(ctx))
+
+	next.ResumeValue = 20
+	m, err = r.InstantiateModule(ctx, code, NewModuleConfig().WithName("finished"))
+	require.NoError(t, err)
+	defer m.Close(ctx)
+	results, next, err := m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(ctx, next)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{20}, results)
+	require.Nil(t, next)
+}
+
+// TestRuntime_Resume_NestedLoops ensures a snapshot taken inside nested loops resumes through branches to each label,
+// including one from the inner loop to the outer, as those resolve to the pc of their target when compiled.
+func TestRuntime_Resume_NestedLoops(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	i32 := wasm.ValueTypeI32
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection:     []*wasm.FunctionType{{Results: []wasm.ValueType{i32}, ResultNumInUint64: 1}},
+		FunctionSection: []wasm.Index{0},
+		CodeSection: []*wasm.Code{{LocalTypes: []wasm.ValueType{i32, i32}, Body: []byte{
+			wasm.OpcodeLoop, 0x40, // $outer
+			wasm.OpcodeLocalGet, 0, wasm.OpcodeI32Const, 1, wasm.OpcodeI32Add, wasm.OpcodeLocalSet, 0, // i++
+			wasm.OpcodeI32Const, 0, wasm.OpcodeLocalSet, 1, // j = 0
+			wasm.OpcodeLoop, 0x40, // $inner
+			wasm.OpcodeNop,                                                                            // snapshots, then traps.
+			wasm.OpcodeLocalGet, 1, wasm.OpcodeI32Const, 1, wasm.OpcodeI32Add, wasm.OpcodeLocalTee, 1, // j++
+			wasm.OpcodeI32Const, 3, wasm.OpcodeI32LtU, wasm.OpcodeBrIf, 0, // br_if $inner (j < 3)
+			wasm.OpcodeLocalGet, 0, wasm.OpcodeI32Const, 4, wasm.OpcodeI32LtU, wasm.OpcodeBrIf, 1, // br_if $outer (i < 4)
+			wasm.OpcodeEnd,
+			wasm.OpcodeEnd,
+			wasm.OpcodeLocalGet, 0, wasm.OpcodeI32Const, 10, wasm.OpcodeI32Mul,
+			wasm.OpcodeLocalGet, 1, wasm.OpcodeI32Add, // i*10 + j
+			wasm.OpcodeEnd,
+		}}},
+		ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 0}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	m, err := r.InstantiateModule(testCtx, code, NewModuleConfig().WithName("loops"))
+	require.NoError(t, err)
+	defer m.Close(testCtx)
+	run := m.ExportedFunction("run")
+
+	results, err := run.Call(testCtx)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{43}, results)
+
+	snapshot := &wasm.Snapshot{}
+	ctx := wasm.WithSnapshotOptions(testCtx, wasm.SnapshotOptions{Snapshot: snapshot, Cooperative: true, TrapAfter: true})
+	_, err = run.Call(ctx)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+	nop := snapshot.Frames[0]
+
+	// Each iteration of the inner loop snapshots at the same nop, in place of the state the module runs past.
+	snapshots := 1
+	for {
+		var next *wasm.Snapshot
+		if results, next, err = run.(*wasm.FunctionInstance).Resume(ctx, snapshot); err == nil {
+			break
+		}
+		require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+		require.Equal(t, nop, next.Frames[0])
+		snapshot, snapshots = next, snapshots+1
+	}
+	require.Equal(t, 12, snapshots) // 4 outer iterations of 3 inner.
+	require.Equal(t, []uint64{43}, results)
+}
+
+// TestRuntime_Resume_Interrupted ensures a resumed call interrupted as its context is done returns the snapshot at the
+// interruption point, so that it can be resumed again.
+func TestRuntime_Resume_Interrupted(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	i32 := wasm.ValueTypeI32
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection:     []*wasm.FunctionType{{Results: []wasm.ValueType{i32}, ResultNumInUint64: 1}},
+		FunctionSection: []wasm.Index{0},
+		CodeSection: []*wasm.Code{{LocalTypes: []wasm.ValueType{i32}, Body: []byte{
+			wasm.OpcodeNop, // snapshots, then traps.
+			wasm.OpcodeLoop, 0x40,
+			wasm.OpcodeLocalGet, 0, wasm.OpcodeI32Const, 1, wasm.OpcodeI32Add, wasm.OpcodeLocalTee, 0, // i++
+			wasm.OpcodeI32Const, 3, wasm.OpcodeI32LtU, wasm.OpcodeBrIf, 0, // br_if 0 (i < 3), where it is interrupted.
+			wasm.OpcodeEnd,
+			wasm.OpcodeLocalGet, 0,
+			wasm.OpcodeEnd,
+		}}},
+		ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 0}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	m, err := r.InstantiateModule(testCtx, code, NewModuleConfig().WithName("loop"))
+	require.NoError(t, err)
+	defer m.Close(testCtx)
+	run := m.ExportedFunction("run").(*wasm.FunctionInstance)
+
+	snapshot := &wasm.Snapshot{}
+	ctx := wasm.WithSnapshotOptions(testCtx, wasm.SnapshotOptions{Snapshot: snapshot, Cooperative: true, TrapAfter: true})
+	_, err = run.Call(ctx)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, next, err := run.Resume(canceled, snapshot)
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotNil(t, next)
+	require.True(t, next.Valid)
+	require.Equal(t, wasm.SnapshotReasonInterrupt, next.Reason)
+
+	results, _, err := run.Resume(testCtx, next)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3}, results)
+}
+
+// TestRuntime_Resume_MultiValue ensures a snapshot taken between a call returning multiple values and the
+// instructions consuming them restores each value with its type.
+func TestRuntime_Resume_MultiValue(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter().WithWasmCore2())
+	defer r.Close(testCtx)
+
+	i32, i64 := wasm.ValueTypeI32, wasm.ValueTypeI64
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection: []*wasm.FunctionType{
+			{Results: []wasm.ValueType{i32, i64}, ResultNumInUint64: 2},
+			{},
+		},
+		FunctionSection: []wasm.Index{0, 1},
+		CodeSection: []*wasm.Code{
+			{Body: []byte{
+				wasm.OpcodeI32Const, 7,
+				wasm.OpcodeI64Const, 0x80, 0x80, 0x80, 0x80, 0x10, // 1<<32, which doesn't fit in 32 bits.
+				wasm.OpcodeEnd,
+			}},
+			{LocalTypes: []wasm.ValueType{i64}, Body: []byte{
+				wasm.OpcodeI32Const, 0, // the address of the i32 store.
+				wasm.OpcodeCall, 0,
+				wasm.OpcodeNop, // snapshots with both results on the stack, then traps.
+				wasm.OpcodeLocalSet, 0,
+				wasm.OpcodeI32Store, 0x2, 0x0,
+				wasm.OpcodeI32Const, 8,
+				wasm.OpcodeLocalGet, 0,
+				wasm.OpcodeI64Store, 0x3, 0x0,
+				wasm.OpcodeEnd,
+			}},
+		},
+		MemorySection: &wasm.Memory{Min: 1, Cap: 1, Max: 1},
+		ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 1}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	snapshot := &wasm.Snapshot{}
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "trap_after_snapshot", true)
+	ctx = context.WithValue(ctx, "export_snapshot", false)
+
+	m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName("snapshot"))
+	require.NoError(t, err)
+	_, err = m.ExportedFunction("run").Call(ctx)
+	require.Equal(t, &wasm.SnapshotError{Reason: wasm.SnapshotReasonCooperative, Resumable: true}, err)
+	require.NoError(t, m.Close(ctx))
+
+	// The local, the address, then both results.
+	require.Equal(t, []uint64{0, 0, 7, 1 << 32}, snapshot.Stack)
+	require.Equal(t, []wasm.ValueType{i64, i32, i32, i64}, snapshot.StackTypes)
+
+	m, err = r.InstantiateModule(ctx, code, NewModuleConfig().WithName("resumed"))
+	require.NoError(t, err)
+	defer m.Close(ctx)
+
+	_, _, err = m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(ctx, snapshot)
+	require.NoError(t, err)
+	v32, ok := m.Memory().ReadUint32Le(ctx, 0)
+	require.True(t, ok)
+	require.Equal(t, uint32(7), v32)
+	v64, ok := m.Memory().ReadUint64Le(ctx, 8)
+	require.True(t, ok)
+	require.Equal(t, uint64(1<<32), v64)
+}
+
+func TestRuntime_FuelRemaining(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	_, err := r.NewModuleBuilder(wasm.YieldModuleName).
+		ExportFunction(wasm.FuelRemainingFunctionName, wasm.FuelRemaining).
+		Instantiate(testCtx, r)
+	require.NoError(t, err)
+
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection: []*wasm.FunctionType{{Results: []wasm.ValueType{wasm.ValueTypeI64}}},
+		ImportSection: []*wasm.Import{
+			{Type: wasm.ExternTypeFunc, Module: wasm.YieldModuleName, Name: wasm.FuelRemainingFunctionName, DescFunc: 0},
+		},
+		FunctionSection: []wasm.Index{0},
+		CodeSection:     []*wasm.Code{{Body: []byte{wasm.OpcodeCall, 0, wasm.OpcodeEnd}}},
+		ExportSection:   []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 1}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	m, err := r.InstantiateModule(testCtx, code, NewModuleConfig())
+	require.NoError(t, err)
+	defer m.Close(testCtx)
+
+	results, err := m.ExportedFunction("run").Call(testCtx)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{api.EncodeI64(wasm.FuelUnmetered)}, results)
+}
+
+// TestRuntime_CheckpointGeneration ensures the guest reads the generation of the snapshot it was resumed from, which
+// each export increments.
+func TestRuntime_CheckpointGeneration(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	_, err := r.NewModuleBuilder(wasm.YieldModuleName).
+		ExportFunction(wasm.YieldFunctionName, wasm.Yield).
+		ExportFunction(wasm.CheckpointGenerationFunctionName, wasm.CheckpointGeneration).
+		Instantiate(testCtx, r)
+	require.NoError(t, err)
+
+	i32, i64 := wasm.ValueTypeI32, wasm.ValueTypeI64
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection: []*wasm.FunctionType{{Params: []wasm.ValueType{i32}, Results: []wasm.ValueType{i32}}, {Results: []wasm.ValueType{i64}}},
+		ImportSection: []*wasm.Import{
+			{Type: wasm.ExternTypeFunc, Module: wasm.YieldModuleName, Name: wasm.YieldFunctionName, DescFunc: 0},
+			{Type: wasm.ExternTypeFunc, Module: wasm.YieldModuleName, Name: wasm.CheckpointGenerationFunctionName, DescFunc: 1},
+		},
+		FunctionSection: []wasm.Index{1},
+		CodeSection: []*wasm.Code{{Body: []byte{
+			wasm.OpcodeI32Const, 0, wasm.OpcodeCall, 0, wasm.OpcodeDrop, // yields, exporting the snapshot.
+			wasm.OpcodeCall, 1,
+			wasm.OpcodeEnd,
+		}}},
+		ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 2}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	// The snapshot is exported to the current directory.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd) //nolint
+
+	snapshot := &wasm.Snapshot{}
+	ctx := wasm.WithSnapshotOptions(testCtx, wasm.SnapshotOptions{Snapshot: snapshot, Export: true})
+	m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName("exported"))
+	require.NoError(t, err)
+	_, err = m.ExportedFunction("run").Call(ctx)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+	require.Equal(t, uint64(1), snapshot.Generation)
+	require.Equal(t, uint64(1), m.(*wasm.CallContext).Generation())
+	require.NoError(t, m.Close(ctx))
+
+	f, err := os.Open("snapshot.bin")
+	require.NoError(t, err)
+	defer f.Close()
+	exported, err := interpreter.ReadSnapshot(f)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), exported.Generation)
+
+	// Resumed without exporting, the guest reads the generation of the snapshot.
+	m, err = r.InstantiateModule(testCtx, code, NewModuleConfig().WithName("resumed"))
+	require.NoError(t, err)
+	defer m.Close(testCtx)
+	results, _, err := m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(testCtx, exported)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1}, results)
+}
+
+// TestRuntime_Snapshot_ScrubHeapEnd ensures the memory past the end of the guest's heap is zero in the snapshot when
+// wasm.Snapshot ScrubHeapEnd is set, so that its pages are sparse.
+func TestRuntime_Snapshot_ScrubHeapEnd(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	i32 := wasm.ValueTypeI32
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection:     []*wasm.FunctionType{{}},
+		FunctionSection: []wasm.Index{0},
+		CodeSection: []*wasm.Code{{Body: []byte{
+			wasm.OpcodeI32Const, 1, wasm.OpcodeI32Const, 1, wasm.OpcodeI32Store, 0x2, 0x0, // within the heap
+			wasm.OpcodeI32Const, 0x80, 0x80, 0x04, wasm.OpcodeI32Const, 1, wasm.OpcodeI32Store, 0x2, 0x0, // past its end
+			wasm.OpcodeNop, // snapshots, then traps.
+			wasm.OpcodeEnd,
+		}}},
+		MemorySection: &wasm.Memory{Min: 2, Cap: 2, Max: 2, IsMaxEncoded: true},
+		GlobalSection: []*wasm.Global{
+			{Type: &wasm.GlobalType{ValType: i32}, Init: &wasm.ConstantExpression{Opcode: wasm.OpcodeI32Const, Data: leb128.EncodeInt32(int32(wasm.MemoryPageSize))}},
+		},
+		ExportSection: []*wasm.Export{
+			{Type: wasm.ExternTypeFunc, Name: "run", Index: 0},
+			{Type: wasm.ExternTypeGlobal, Name: "heap_end", Index: 0},
+		},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		scrubHeapEnd  wasm.HeapEnd
+		expectedPages int
+	}{
+		{name: "not set", expectedPages: 2},
+		{name: "heap end", scrubHeapEnd: wasm.HeapEndGlobal("heap_end"), expectedPages: 1},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+
+		t.Run(tc.name, func(t *testing.T) {
+			snapshot := &wasm.Snapshot{ScrubHeapEnd: tc.scrubHeapEnd}
+			ctx := wasm.WithSnapshotOptions(testCtx, wasm.SnapshotOptions{Snapshot: snapshot, Cooperative: true, TrapAfter: true})
+			m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName(tc.name))
+			require.NoError(t, err)
+			defer m.Close(ctx)
+
+			_, err = m.ExportedFunction("run").Call(ctx)
+			require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+			require.Equal(t, tc.expectedPages, snapshot.NonZeroPageCount())
+		})
+	}
+}
+
+func TestRuntime_Coverage(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	_, err := r.NewModuleBuilder(wasm.YieldModuleName).
+		ExportFunction(wasm.YieldFunctionName, wasm.Yield).
+		Instantiate(testCtx, r)
+	require.NoError(t, err)
+
+	i32 := wasm.ValueTypeI32
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection: []*wasm.FunctionType{{Params: []wasm.ValueType{i32}, Results: []wasm.ValueType{i32}}, {Results: []wasm.ValueType{i32}}},
+		ImportSection: []*wasm.Import{
+			{Type: wasm.ExternTypeFunc, Module: wasm.YieldModuleName, Name: wasm.YieldFunctionName, DescFunc: 0},
+		},
+		FunctionSection: []wasm.Index{1},
+		CodeSection: []*wasm.Code{{Body: []byte{
+			wasm.OpcodeI32Const, 7,
+			wasm.OpcodeCall, 0, // yields 7, then returns what it was resumed with.
+			wasm.OpcodeEnd,
+		}}},
+		ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 1}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	coverage := &wasm.Coverage{}
+	snapshot := &wasm.Snapshot{}
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "export_snapshot", false)
+	ctx = context.WithValue(ctx, "coverage", coverage)
+
+	m, err := r.InstantiateModule(ctx, code, NewModuleConfig().WithName("yields"))
+	require.NoError(t, err)
+	_, err = m.ExportedFunction("run").Call(ctx)
+	require.Equal(t, &wasm.SnapshotError{Reason: wasm.SnapshotReasonYield, Resumable: true, YieldTag: 7}, err)
+	require.NoError(t, m.Close(ctx))
+
+	// The const and the call to yield executed once.
+	yielded := coverage.Snapshot()
+	require.Equal(t, map[uint64]uint64{wasm.CoverageKey(1, 0): 1, wasm.CoverageKey(1, 1): 1}, yielded)
+
+	// Resuming carries the counts forward, without counting the call to yield again.
+	m, err = r.InstantiateModule(ctx, code, NewModuleConfig().WithName("resumed"))
+	require.NoError(t, err)
+	defer m.Close(ctx)
+	_, _, err = m.ExportedFunction("run").(*wasm.FunctionInstance).Resume(ctx, snapshot)
+	require.NoError(t, err)
+
+	resumed := coverage.Snapshot()
+	require.True(t, len(resumed) > len(yielded))
+	for key, count := range resumed {
+		funcIdx, pc := wasm.UnpackCoverageKey(key)
+		require.Equal(t, uint64(1), count, "function[%d] pc %d", funcIdx, pc)
+	}
+	require.Equal(t, map[uint64]uint64{wasm.CoverageKey(1, 0): 1, wasm.CoverageKey(1, 1): 1}, yielded) // a copy
+}
+
+func TestRuntime_CallDepth(t *testing.T) {
+	r := NewRuntimeWithConfig(NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	var depths []int
+	var guest api.Module
+	record := func() {
+		depths = append(depths, guest.(*wasm.CallContext).CallDepth())
+	}
+	_, err := r.NewModuleBuilder("env").ExportFunction("record", record).Instantiate(testCtx, r)
+	require.NoError(t, err)
+
+	code, err := r.CompileModule(testCtx, binaryformat.EncodeModule(&wasm.Module{
+		TypeSection:     []*wasm.FunctionType{{}},
+		ImportSection:   []*wasm.Import{{Type: wasm.ExternTypeFunc, Module: "env", Name: "record", DescFunc: 0}},
+		FunctionSection: []wasm.Index{0, 0},
+		CodeSection: []*wasm.Code{
+			{Body: []byte{wasm.OpcodeCall, 0, wasm.OpcodeCall, 2, wasm.OpcodeNop, wasm.OpcodeEnd}}, // snapshots at the nop.
+			{Body: []byte{wasm.OpcodeCall, 0, wasm.OpcodeEnd}},
+		},
+		ExportSection: []*wasm.Export{{Type: wasm.ExternTypeFunc, Name: "run", Index: 1}},
+	}), NewCompileConfig())
+	require.NoError(t, err)
+
+	snapshot := &wasm.Snapshot{}
+	ctx := context.WithValue(testCtx, "snapshot", snapshot)
+	ctx = context.WithValue(ctx, "always_snapshot", false)
+	ctx = context.WithValue(ctx, "trap_after_snapshot", true)
+	ctx = context.WithValue(ctx, "export_snapshot", false)
+
+	guest, err = r.InstantiateModule(ctx, code, NewModuleConfig())
+	require.NoError(t, err)
+	defer guest.Close(ctx)
+
+	require.Equal(t, 0, guest.(*wasm.CallContext).CallDepth())
+	_, err = guest.ExportedFunction("run").Call(ctx)
+	require.ErrorIs(t, err, wasmruntime.ErrRuntimeSnapshot)
+
+	// The host function is a frame, and the frames left by the snapshot are released.
+	require.Equal(t, []int{2, 3}, depths)
+	require.Equal(t, 1, len(snapshot.Frames))
+	require.Equal(t, 0, guest.(*wasm.CallContext).CallDepth())
+}
+
 func TestRuntime_InstantiateModule_ExitError(t *testing.T) {
 	r := NewRuntime()
 	defer r.Close(testCtx)
@@ -605,7 +1607,7 @@ func TestRuntime_CloseWithExitCode(t *testing.T) {
 func TestRuntime_Close_ClosesCompiledModules(t *testing.T) {
 	engine := &mockEngine{name: "mock", cachedModules: map[*wasm.Module]struct{}{}}
 	conf := *engineLessConfig
-	conf.newEngine = func(wasm.Features) wasm.Engine {
+	conf.newEngine = func(wasm.Features, int) wasm.Engine {
 		return engine
 	}
 	r := NewRuntimeWithConfig(&conf)
@@ -41,6 +41,12 @@ const (
 //	* Since the `out` pointer nests Errno, the result is always ErrnoSuccess.
 //	* importPollOneoff shows this signature in the WebAssembly 1.0 Text Format.
 //	* This is similar to `poll` in POSIX.
+//	* A snapshot is never taken while polling, as the interpreter only snapshots
+//	  between wasm instructions. One taken at the call, e.g. when the context
+//	  is canceled, captures the subscriptions in memory and their pointer in
+//	  the args on the stack, so resuming calls this again with the same ones.
+//	  A relative clock subscription waits its whole timeout again. A snapshot
+//	  with a frame in this, or any host function, fails to resume.
 // See https://github.com/WebAssembly/WASI/blob/snapshot-01/phases/snapshot/docs.md#poll_oneoff
 // See https://linux.die.net/man/3/poll
 func (a *wasi) PollOneoff(ctx context.Context, mod api.Module, in, out, nsubscriptions, resultNevents uint32) Errno {
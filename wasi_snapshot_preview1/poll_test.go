@@ -1,11 +1,15 @@
 package wasi_snapshot_preview1
 
 import (
+	"context"
+	"fmt"
 	"testing"
 
+	"github.com/tetratelabs/wazero"
 	internalsys "github.com/tetratelabs/wazero/internal/sys"
 	"github.com/tetratelabs/wazero/internal/testing/require"
 	"github.com/tetratelabs/wazero/internal/wasm"
+	"github.com/tetratelabs/wazero/internal/watzero"
 )
 
 func Test_PollOneoff(t *testing.T) {
@@ -162,3 +166,66 @@ func Test_PollOneoff_Errors(t *testing.T) {
 		})
 	}
 }
+
+// Test_PollOneoff_Snapshot ensures a snapshot taken when the guest is about to call poll_oneoff, e.g. as its context
+// was canceled, polls the same subscriptions once resumed, as they are read from the memory of the snapshot.
+func Test_PollOneoff_Snapshot(t *testing.T) {
+	r := wazero.NewRuntimeWithConfig(wazero.NewRuntimeConfigInterpreter())
+	defer r.Close(testCtx)
+
+	_, err := Instantiate(testCtx, r)
+	require.NoError(t, err)
+
+	binary, err := watzero.Wat2Wasm(fmt.Sprintf(`(module
+  %s
+  (memory 1 1)
+  (func $poll (result i32)
+    i32.const 0   ;; in
+    i32.const 128 ;; out
+    i32.const 1   ;; nsubscriptions
+    i32.const 512 ;; resultNevents
+    call $wasi.poll_oneoff
+  )
+  (export "poll" (func $poll))
+)`, importPollOneoff))
+	require.NoError(t, err)
+	code, err := r.CompileModule(testCtx, binary, wazero.NewCompileConfig())
+	require.NoError(t, err)
+
+	subscription := []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, // userdata
+		eventTypeClock, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, // event type and padding
+		clockIDMonotonic, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, // clockID
+		0x01, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, // timeout (ns)
+		0x01, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, // precision (ns)
+		0x00, 0x00, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, // flags (relative)
+	}
+
+	// Interrupt the guest at the call, as it is canceled before polling.
+	snapshot := &wasm.Snapshot{}
+	ctx, cancel := context.WithCancel(wasm.WithSnapshotOptions(testCtx, wasm.SnapshotOptions{Snapshot: snapshot}))
+	cancel()
+	mod, err := r.InstantiateModule(ctx, code, wazero.NewModuleConfig().WithName("interrupted"))
+	require.NoError(t, err)
+	require.True(t, mod.Memory().Write(ctx, 0, subscription))
+	_, err = mod.ExportedFunction("poll").Call(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, wasm.SnapshotReasonInterrupt, snapshot.Reason)
+	require.NoError(t, mod.Close(testCtx))
+
+	mod, err = r.InstantiateModule(testCtx, code, wazero.NewModuleConfig().WithName("resumed"))
+	require.NoError(t, err)
+	defer mod.Close(testCtx)
+	results, _, err := mod.ExportedFunction("poll").(*wasm.FunctionInstance).Resume(testCtx, snapshot)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{uint64(ErrnoSuccess)}, results)
+
+	event, ok := mod.Memory().Read(testCtx, 128, 16)
+	require.True(t, ok)
+	require.Equal(t, []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, // userdata
+		byte(ErrnoSuccess), 0x0, // errno is 16 bit
+		eventTypeClock, 0x0, 0x0, 0x0, // 4 bytes for type enum
+		0x0, 0x0, // padding
+	}, event)
+}
@@ -1220,7 +1220,8 @@ func (a *wasi) RandomGet(ctx context.Context, mod api.Module, buf uint32, bufLen
 		return ErrnoFault
 	}
 
-	// We can ignore the returned n as it only != byteCount on error
+	// io.ReadAtLeast retries short reads, which io.Reader allows, so we can ignore the returned n as it only != byteCount
+	// on error.
 	if _, err := io.ReadAtLeast(randSource, randomBytes, int(bufLen)); err != nil {
 		return ErrnoIo
 	}
@@ -1804,6 +1804,43 @@ func Test_RandomGet_Errors(t *testing.T) {
 	}
 }
 
+// Test_RandomGet_ShortReads ensures a RandSource returning fewer bytes than asked, as io.Reader allows, still fills the
+// buffer.
+func Test_RandomGet_ShortReads(t *testing.T) {
+	expectedMemory := []byte{
+		'?',           // `offset` is after this
+		1, 2, 3, 4, 5, // read one byte per call
+		'?', // stopped after encoding
+	}
+
+	sysCtx, err := internalsys.NewContext(
+		math.MaxUint32,
+		nil,
+		nil,
+		new(bytes.Buffer),
+		nil,
+		nil,
+		iotest.OneByteReader(bytes.NewReader([]byte{1, 2, 3, 4, 5, 6})),
+		nil, 0,
+		nil, 0,
+		nil, // nanosleep
+		nil,
+	)
+	require.NoError(t, err)
+
+	mod, _ := instantiateModule(testCtx, t, functionRandomGet, importRandomGet, sysCtx)
+	defer mod.Close(testCtx)
+
+	maskMemory(t, testCtx, mod, len(expectedMemory))
+
+	errno := a.RandomGet(testCtx, mod, uint32(1), uint32(5)) // arbitrary offset and length
+	require.Zero(t, errno, ErrnoName(errno))
+
+	actual, ok := mod.Memory().Read(testCtx, 0, uint32(len(expectedMemory)))
+	require.True(t, ok)
+	require.Equal(t, expectedMemory, actual)
+}
+
 func Test_RandomGet_SourceError(t *testing.T) {
 	tests := []struct {
 		name       string